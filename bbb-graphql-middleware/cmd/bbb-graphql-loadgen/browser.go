@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	evanphxjsonpatch "github.com/evanphx/json-patch"
+	"github.com/google/uuid"
+	"nhooyr.io/websocket"
+)
+
+// options of the simulated browsers
+type options struct {
+	url                string
+	cookie             string
+	clientType         string
+	mobileRatio        float64
+	subscriptions      int
+	streams            int
+	mutationsPerMinute float64
+	jsonPatch          bool
+}
+
+// browser simulates a client: it connects, starts the subscriptions and streams and sends mutations
+type browser struct {
+	sessionToken string
+	options      *options
+	report       *report
+
+	conn       *websocket.Conn
+	writeMutex sync.Mutex
+
+	mutex            sync.Mutex
+	subscriptions    map[string]*subscriptionState
+	pendingMutations map[string]time.Time
+	nextId           int
+}
+
+type subscriptionState struct {
+	sentAt     time.Time
+	receivedAt time.Time // first data, zero until it's received
+	data       []byte    // last data, patches are applied to it
+	lastTickAt int64     // most recent update received
+}
+
+type receivedMessage struct {
+	Id      string          `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type receivedError struct {
+	Message    string `json:"message"`
+	MessageId  string `json:"messageId"`
+	Extensions struct {
+		Code string `json:"code"`
+	} `json:"extensions"`
+}
+
+type row struct {
+	TickAt int64 `json:"tickAt"`
+}
+
+func newBrowser(sessionToken string, options *options, report *report) *browser {
+	return &browser{
+		sessionToken:     sessionToken,
+		options:          options,
+		report:           report,
+		subscriptions:    make(map[string]*subscriptionState),
+		pendingMutations: make(map[string]time.Time),
+	}
+}
+
+func (b *browser) run(ctx context.Context) {
+	startedAt := time.Now()
+
+	dialOptions := &websocket.DialOptions{
+		Subprotocols: []string{"graphql-transport-ws"},
+		HTTPHeader:   http.Header{},
+	}
+	if b.options.cookie != "" {
+		dialOptions.HTTPHeader.Set("Cookie", b.options.cookie)
+	}
+
+	conn, _, err := websocket.Dial(ctx, b.options.url, dialOptions)
+	if err != nil {
+		b.report.connectionsFailed.Add(1)
+		b.report.addError("dial_failed")
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "loadgen finished")
+	conn.SetReadLimit(9999999)
+	b.conn = conn
+
+	capabilities := map[string]interface{}{}
+	if b.options.jsonPatch {
+		capabilities["patchFormats"] = []string{"json-patch"}
+	}
+	connectionInit := map[string]interface{}{
+		"type": "connection_init",
+		"payload": map[string]interface{}{
+			"headers": map[string]string{
+				"X-Session-Token":     b.sessionToken,
+				"X-ClientSessionUUID": uuid.New().String(),
+				"X-ClientType":        b.options.clientType,
+				"X-ClientIsMobile":    strconv.FormatBool(rand.Float64() < b.options.mobileRatio),
+			},
+			"capabilities": capabilities,
+		},
+	}
+	if err := b.send(ctx, connectionInit); err != nil {
+		b.report.connectionsFailed.Add(1)
+		b.report.addError("write_failed")
+		return
+	}
+
+	for {
+		message, err := b.read(ctx)
+		if err != nil {
+			b.report.connectionsFailed.Add(1)
+			b.recordReadError(err)
+			return
+		}
+		if message.Type == "connection_ack" {
+			break
+		}
+		b.handleMessage(message)
+	}
+	b.report.connect.add(time.Since(startedAt))
+	b.report.connected.Add(1)
+
+	b.startSubscriptions(ctx)
+	if b.options.mutationsPerMinute > 0 {
+		go b.sendMutations(ctx)
+	}
+
+	for {
+		message, err := b.read(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				b.recordReadError(err)
+			}
+			return
+		}
+		b.handleMessage(message)
+	}
+}
+
+func (b *browser) read(ctx context.Context) (receivedMessage, error) {
+	var message receivedMessage
+	_, data, err := b.conn.Read(ctx)
+	if err != nil {
+		return message, err
+	}
+	b.report.messagesReceived.Add(1)
+	b.report.bytesReceived.Add(int64(len(data)))
+
+	if err := json.Unmarshal(data, &message); err != nil {
+		b.report.addError("invalid_message")
+	}
+	return message, nil
+}
+
+func (b *browser) send(ctx context.Context, message interface{}) error {
+	messageJson, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	b.writeMutex.Lock()
+	defer b.writeMutex.Unlock()
+	return b.conn.Write(ctx, websocket.MessageText, messageJson)
+}
+
+func (b *browser) recordReadError(err error) {
+	if status := websocket.CloseStatus(err); status != -1 {
+		b.report.addError(fmt.Sprintf("close_%d", status))
+		return
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	b.report.addError("connection_lost")
+}
+
+func (b *browser) newId() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.nextId++
+	return strconv.Itoa(b.nextId)
+}
+
+func (b *browser) startSubscriptions(ctx context.Context) {
+	for i := 1; i <= b.options.subscriptions; i++ {
+		operationName := fmt.Sprintf("LoadgenUsers%d", i)
+		b.subscribe(ctx, operationName,
+			fmt.Sprintf("subscription %s { user(limit: 500) { id createdAt tickAt } }", operationName),
+			nil)
+	}
+
+	for i := 1; i <= b.options.streams; i++ {
+		operationName := fmt.Sprintf("LoadgenChatStream%d", i)
+		b.subscribe(ctx, operationName,
+			fmt.Sprintf("subscription %s($createdAt: timestamptz) { chat_message_public_stream(batch_size: 10, cursor: {initial_value: {createdAt: $createdAt}, ordering: ASC}) { id createdAt tickAt } }", operationName),
+			map[string]interface{}{"createdAt": time.Now().UTC().Format(time.RFC3339Nano)})
+	}
+}
+
+func (b *browser) subscribe(ctx context.Context, operationName string, query string, variables map[string]interface{}) {
+	id := b.newId()
+
+	b.mutex.Lock()
+	b.subscriptions[id] = &subscriptionState{sentAt: time.Now()}
+	b.mutex.Unlock()
+
+	payload := map[string]interface{}{
+		"operationName": operationName,
+		"query":         query,
+	}
+	if variables != nil {
+		payload["variables"] = variables
+	}
+	if err := b.send(ctx, map[string]interface{}{"id": id, "type": "subscribe", "payload": payload}); err != nil {
+		b.report.addError("write_failed")
+	}
+}
+
+// sendMutations sends mutations at random intervals (exponential) averaging mutationsPerMinute
+func (b *browser) sendMutations(ctx context.Context) {
+	meanInterval := float64(time.Minute) / b.options.mutationsPerMinute
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(rand.ExpFloat64() * meanInterval)):
+		}
+
+		id := b.newId()
+		b.mutex.Lock()
+		b.pendingMutations[id] = time.Now()
+		b.mutex.Unlock()
+
+		b.report.mutationsSent.Add(1)
+		err := b.send(ctx, map[string]interface{}{
+			"id":   id,
+			"type": "subscribe",
+			"payload": map[string]interface{}{
+				"operationName": "LoadgenSetAway",
+				"query":         "mutation LoadgenSetAway($away: Boolean!) { userSetAway(away: $away) }",
+				"variables":     map[string]interface{}{"away": rand.Intn(2) == 1},
+			},
+		})
+		if err != nil && ctx.Err() == nil {
+			b.report.addError("write_failed")
+		}
+	}
+}
+
+func (b *browser) handleMessage(message receivedMessage) {
+	receivedAt := time.Now()
+
+	switch message.Type {
+	case "next":
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		if sentAt, isMutation := b.pendingMutations[message.Id]; isMutation {
+			delete(b.pendingMutations, message.Id)
+			b.report.mutation.add(receivedAt.Sub(sentAt))
+			b.report.mutationsSucceed.Add(1)
+			return
+		}
+
+		subscription, exists := b.subscriptions[message.Id]
+		if !exists {
+			return
+		}
+		b.handleData(subscription, message.Payload, receivedAt)
+	case "error":
+		var errs []receivedError
+		_ = json.Unmarshal(message.Payload, &errs)
+
+		code := "operation_error"
+		if len(errs) > 0 && errs[0].MessageId != "" {
+			code = errs[0].MessageId
+		} else if len(errs) > 0 && errs[0].Extensions.Code != "" {
+			code = errs[0].Extensions.Code
+		}
+		b.report.addError(code)
+
+		b.mutex.Lock()
+		delete(b.pendingMutations, message.Id)
+		b.mutex.Unlock()
+	case "complete":
+		b.mutex.Lock()
+		delete(b.pendingMutations, message.Id)
+		b.mutex.Unlock()
+	case "ping":
+		_ = b.send(context.Background(), map[string]string{"type": "pong"})
+	}
+}
+
+// handleData applies the patch (when received) and measures the time until the first data and the fan-out latency
+func (b *browser) handleData(subscription *subscriptionState, payload json.RawMessage, receivedAt time.Time) {
+	var nextPayload struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &nextPayload); err != nil {
+		b.report.addError("invalid_data")
+		return
+	}
+
+	var data []byte
+	if patch, isPatch := nextPayload.Data["patch"]; isPatch {
+		decodedPatch, err := evanphxjsonpatch.DecodePatch(patch)
+		if err == nil {
+			data, err = decodedPatch.Apply(subscription.data)
+		}
+		if err != nil {
+			b.report.addError("invalid_patch")
+			return
+		}
+	} else {
+		for _, value := range nextPayload.Data {
+			data = value
+		}
+	}
+	subscription.data = data
+
+	var rows []row
+	_ = json.Unmarshal(data, &rows)
+	var lastTickAt int64
+	for _, r := range rows {
+		lastTickAt = max(lastTickAt, r.TickAt)
+	}
+
+	if subscription.receivedAt.IsZero() {
+		subscription.receivedAt = receivedAt
+		subscription.lastTickAt = lastTickAt
+		b.report.firstData.add(receivedAt.Sub(subscription.sentAt))
+		return
+	}
+
+	if lastTickAt > subscription.lastTickAt {
+		subscription.lastTickAt = lastTickAt
+		b.report.fanOut.add(receivedAt.Sub(time.Unix(0, lastTickAt)))
+	}
+}
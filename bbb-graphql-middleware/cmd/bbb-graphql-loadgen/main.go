@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bbb-graphql-middleware/internal/testsupport"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bbb-graphql-loadgen simulates browsers connected to a running bbb-graphql-middleware: each one sends the
+// connection_init, starts subscriptions and streams and sends mutations, measuring the latencies the users would see.
+// With -standin it also serves stand-ins for Hasura, the auth hook, the session variables hook and graphql-actions,
+// so the middleware can be load tested without a BigBlueButton server (it must be configured to use them)
+func main() {
+	opts := &options{}
+	flag.StringVar(&opts.url, "url", "ws://127.0.0.1:8378/graphql", "websocket url of the middleware")
+	flag.StringVar(&opts.cookie, "cookie", "", "Cookie header sent to the middleware")
+	flag.StringVar(&opts.clientType, "client-type", "HTML5", "X-ClientType sent on connection_init")
+	flag.Float64Var(&opts.mobileRatio, "mobile-ratio", 0.2, "ratio of the browsers that identify themselves as mobile")
+	flag.IntVar(&opts.subscriptions, "subscriptions", 10, "subscriptions started by each browser")
+	flag.IntVar(&opts.streams, "streams", 2, "streams started by each browser")
+	flag.Float64Var(&opts.mutationsPerMinute, "mutations-per-minute", 2, "average mutations sent by each browser per minute")
+	flag.BoolVar(&opts.jsonPatch, "json-patch", true, "request json-patch for the subscriptions (declared in connection_init capabilities)")
+	browsers := flag.Int("browsers", 100, "number of simulated browsers")
+	meetings := flag.Int("meetings", 1, "number of meetings the browsers are spread across")
+	ramp := flag.Duration("ramp", 10*time.Second, "time to connect all the browsers (keep it below server.max_connections_per_second)")
+	duration := flag.Duration("duration", time.Minute, "duration of the test, including the ramp")
+	tokenPrefix := flag.String("token-prefix", "loadgen", "prefix of the session tokens, they are <prefix><meeting>-<user>")
+	standinListen := flag.String("standin", "", "address to serve the stand-in Hasura and hooks (e.g. 127.0.0.1:8185)")
+	standinRows := flag.Int("standin-rows", 100, "rows returned by the stand-in Hasura for each subscription")
+	standinInterval := flag.Duration("standin-interval", time.Second, "interval between the updates sent by the stand-in Hasura")
+	flag.Parse()
+
+	log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+
+	if *browsers < 1 || *meetings < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *standinListen != "" {
+		hooks := testsupport.NewHooks()
+		hasura := testsupport.NewLoadHasura(*standinRows)
+		fakeHasura := testsupport.NewFakeHasura(hasura)
+		go hasura.StartUpdates(ctx, *standinInterval)
+
+		mux := http.NewServeMux()
+		mux.Handle("/v1/graphql", fakeHasura)
+		mux.Handle("/auth", hooks.AuthHookHandler())
+		mux.Handle("/session-vars", hooks.SessionVarsHandler())
+		mux.Handle("/graphql-actions", hooks.GraphqlActionsHandler())
+
+		listener, err := net.Listen("tcp", *standinListen)
+		if err != nil {
+			log.Fatalf("stand-in failed to listen: %v", err)
+		}
+		server := &http.Server{Handler: mux}
+		go func() {
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("stand-in failed: %v", err)
+			}
+		}()
+		defer server.Close()
+
+		log.Infof("stand-ins listening on %s", *standinListen)
+		fmt.Printf("# the middleware config must point to the stand-ins:\n"+
+			"hasura:\n  url: ws://%[1]s/v1/graphql\n"+
+			"auth_hook:\n  url: http://%[1]s/auth\n"+
+			"session_vars_hook:\n  url: http://%[1]s/session-vars\n"+
+			"graphql-actions:\n  url: http://%[1]s/graphql-actions\n", *standinListen)
+		defer func() {
+			log.Infof("stand-in Hasura: %d connections, %d subscribes, %d updates, %d updates dropped",
+				fakeHasura.ConnectionsCount(), hasura.Subscribes.Load(), hasura.Ticks.Load(), hasura.DroppedTicks.Load())
+		}()
+	}
+
+	r := newReport()
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				log.Info(r.progress())
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	rampInterval := *ramp / time.Duration(*browsers)
+	for i := 0; i < *browsers; i++ {
+		sessionToken := fmt.Sprintf("%s%d-user%d", *tokenPrefix, i%*meetings+1, i+1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newBrowser(sessionToken, opts, r).run(ctx)
+		}()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(rampInterval):
+		}
+	}
+	wg.Wait()
+
+	fmt.Print(r)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxSamples limits the memory used by each latency, the percentiles are computed over a uniform sample (reservoir)
+const maxSamples = 100000
+
+type latencies struct {
+	mutex   sync.Mutex
+	count   int64
+	max     time.Duration
+	samples []time.Duration
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.count++
+	if d > l.max {
+		l.max = d
+	}
+	if len(l.samples) < maxSamples {
+		l.samples = append(l.samples, d)
+	} else if i := rand.Int63n(l.count); i < maxSamples {
+		l.samples[i] = d
+	}
+}
+
+func (l *latencies) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.count == 0 {
+		return "no samples"
+	}
+
+	sorted := make([]time.Duration, len(l.samples))
+	copy(sorted, l.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))].Round(time.Microsecond)
+	}
+
+	return fmt.Sprintf("n=%d p50=%v p90=%v p99=%v max=%v",
+		l.count, percentile(0.5), percentile(0.9), percentile(0.99), l.max.Round(time.Microsecond))
+}
+
+// report aggregates what all the simulated browsers measured
+type report struct {
+	startedAt time.Time
+
+	connect   latencies // dial until connection_ack
+	firstData latencies // subscribe until the first data
+	fanOut    latencies // update in Hasura until it is received by the browser
+	mutation  latencies // mutation sent until its result
+
+	connected         atomic.Int64
+	connectionsFailed atomic.Int64
+	messagesReceived  atomic.Int64
+	bytesReceived     atomic.Int64
+	mutationsSent     atomic.Int64
+	mutationsSucceed  atomic.Int64
+
+	errorsMutex sync.Mutex
+	errors      map[string]int64 // by error code
+}
+
+func newReport() *report {
+	return &report{startedAt: time.Now(), errors: make(map[string]int64)}
+}
+
+func (r *report) addError(code string) {
+	r.errorsMutex.Lock()
+	defer r.errorsMutex.Unlock()
+	r.errors[code]++
+}
+
+func (r *report) progress() string {
+	elapsed := time.Since(r.startedAt).Seconds()
+	return fmt.Sprintf("connected=%d failed=%d received=%d msgs (%.0f msgs/s) mutations=%d/%d",
+		r.connected.Load(), r.connectionsFailed.Load(),
+		r.messagesReceived.Load(), float64(r.messagesReceived.Load())/elapsed,
+		r.mutationsSucceed.Load(), r.mutationsSent.Load())
+}
+
+func (r *report) String() string {
+	elapsed := time.Since(r.startedAt).Seconds()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "duration:             %.1fs\n", elapsed)
+	fmt.Fprintf(&b, "connections:          %d connected, %d failed\n", r.connected.Load(), r.connectionsFailed.Load())
+	fmt.Fprintf(&b, "connect latency:      %v\n", &r.connect)
+	fmt.Fprintf(&b, "time to first data:   %v\n", &r.firstData)
+	fmt.Fprintf(&b, "fan-out latency:      %v\n", &r.fanOut)
+	fmt.Fprintf(&b, "mutation latency:     %v\n", &r.mutation)
+	fmt.Fprintf(&b, "mutations:            %d sent, %d succeed\n", r.mutationsSent.Load(), r.mutationsSucceed.Load())
+	fmt.Fprintf(&b, "throughput:           %.0f msgs/s, %.1f KiB/s\n",
+		float64(r.messagesReceived.Load())/elapsed, float64(r.bytesReceived.Load())/1024/elapsed)
+
+	r.errorsMutex.Lock()
+	defer r.errorsMutex.Unlock()
+	codes := make([]string, 0, len(r.errors))
+	for code := range r.errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	if len(codes) == 0 {
+		fmt.Fprintf(&b, "errors:               none\n")
+	} else {
+		fmt.Fprintf(&b, "errors:\n")
+		for _, code := range codes {
+			fmt.Fprintf(&b, "  %-40s %d\n", code, r.errors[code])
+		}
+	}
+	return b.String()
+}
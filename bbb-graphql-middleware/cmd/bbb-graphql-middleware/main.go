@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/audit"
+	"bbb-graphql-middleware/internal/common"
+	"bbb-graphql-middleware/internal/hasura"
+	"bbb-graphql-middleware/internal/hasura/schema"
+	"bbb-graphql-middleware/internal/health"
+	"bbb-graphql-middleware/internal/listener"
+	"bbb-graphql-middleware/internal/msgpatch"
+	"bbb-graphql-middleware/internal/tap"
+	"bbb-graphql-middleware/internal/tracing"
+	"bbb-graphql-middleware/internal/websrv"
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+func main() {
+	cfg := config.GetConfig()
+
+	// Configure logger
+	if logLevelFromConfig, err := log.ParseLevel(cfg.LogLevel); err == nil {
+		log.SetLevel(logLevelFromConfig)
+		if logLevelFromConfig > log.InfoLevel {
+			log.SetReportCaller(true)
+		}
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+	log.SetFormatter(&log.JSONFormatter{})
+	log := log.WithField("_routine", "main")
+
+	common.InitUniqueID()
+	log = log.WithField("graphql-middleware-uid", common.GetUniqueID())
+
+	log.Infof("Logger level=%v", log.Logger.Level)
+
+	// Export the spans of the connections and operations (OTLP or file)
+	if tracing.Enabled() {
+		shutdownTracing := tracing.Start()
+		defer shutdownTracing(context.Background())
+	}
+
+	// Listen msgs from akka (for example to invalidate connection)
+	go websrv.StartRedisListener()
+
+	if cfg.Server.JsonPatchDisabled {
+		log.Infof("Json Patch Disabled!")
+	}
+
+	// Write the audit log of mutations and forced disconnections
+	if audit.Enabled() {
+		go audit.StartAuditWriter()
+	}
+
+	// Load the Hasura schema to validate the operations before forwarding them
+	if schema.ValidationEnabled() {
+		go schema.StartSchemaLoader()
+	}
+
+	// Routine to check for idle connections and close them
+	go websrv.InvalidateIdleBrowserConnectionsRoutine()
+
+	// Routine to check the Hasura endpoints (unhealthy ones stop receiving connections)
+	go hasura.StartEndpointsHealthChecker()
+
+	// Routine to check Redis, Hasura, graphql-actions and the auth hook (reported by /healthz and /readyz)
+	go health.StartHealthChecker()
+
+	// Routine to update the metrics of the channels (queued messages and frozen)
+	go websrv.UpdateChannelMetricsRoutine()
+
+	// Websocket listener
+
+	rateLimiter := common.NewCustomRateLimiter(cfg.Server.MaxConnectionsPerSecond)
+	http.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
+		defer cancel()
+
+		common.HttpConnectionGauge.Inc()
+		common.HttpConnectionCounter.Inc()
+		defer common.HttpConnectionGauge.Dec()
+
+		if err := rateLimiter.Wait(ctx); err != nil {
+			if !errors.Is(err, context.Canceled) {
+				http.Error(w, "Request cancelled or rate limit exceeded", http.StatusTooManyRequests)
+			}
+
+			return
+		}
+
+		websrv.ConnectionHandler(w, r)
+	})
+
+	http.HandleFunc("/graphql-reconnection", websrv.ReconnectionHandler)
+
+	// Add Prometheus metrics endpoint
+	http.Handle("/metrics", promhttp.Handler())
+
+	// Operations with the most expensive json-patch processing (protected by admin.token)
+	http.HandleFunc("/debug/jsonpatch", msgpatch.DebugHandler)
+
+	// Live mirror of the frames of a connection (protected by admin.token)
+	http.HandleFunc("/admin/tap", tap.Handler)
+
+	// Liveness and readiness (503 when a dependency is down, draining or close to max_connections)
+	http.HandleFunc("/healthz", health.HealthzHandler)
+	http.HandleFunc("/readyz", health.ReadyzHandler)
+
+	// Stop accepting new connections before a deploy (protected by admin.token)
+	http.HandleFunc("/admin/drain", health.DrainHandler)
+
+	// Serve the endpoints on the configured listeners (listen_host:listen_port when none)
+	log.Fatal(listener.ServeAll())
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// normalizer turns frames into canonical JSON (sorted keys) without the ignored keys, so they can be compared
+type normalizer struct {
+	ignoredKeys map[string]bool
+}
+
+func newNormalizer(ignoredKeys []string) *normalizer {
+	n := &normalizer{ignoredKeys: make(map[string]bool)}
+	for _, key := range ignoredKeys {
+		if key = strings.TrimSpace(key); key != "" {
+			n.ignoredKeys[key] = true
+		}
+	}
+	return n
+}
+
+func (n *normalizer) normalize(message []byte) string {
+	var value interface{}
+	if err := json.Unmarshal(message, &value); err != nil {
+		return string(message)
+	}
+	canonical, _ := json.Marshal(n.removeIgnoredKeys(value))
+	return string(canonical)
+}
+
+func (n *normalizer) removeIgnoredKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, fieldValue := range v {
+			if n.ignoredKeys[key] {
+				delete(v, key)
+				continue
+			}
+			v[key] = n.removeIgnoredKeys(fieldValue)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = n.removeIgnoredKeys(item)
+		}
+	}
+	return value
+}
+
+// group splits the frames by operation id, as the order between different operations is not deterministic
+func (n *normalizer) group(messages [][]byte) map[string][]string {
+	groups := make(map[string][]string)
+	for _, message := range messages {
+		var info messageInfo
+		_ = json.Unmarshal(message, &info)
+		groups[info.Id] = append(groups[info.Id], n.normalize(message))
+	}
+	return groups
+}
+
+// printDiff prints the frames of each operation that are only in the capture (-) or only in the replay (+)
+// and returns the number of differences
+func printDiff(title string, expected map[string][]string, actual map[string][]string) int {
+	ids := make(map[string]bool)
+	for id := range expected {
+		ids[id] = true
+	}
+	for id := range actual {
+		ids[id] = true
+	}
+
+	sortedIds := make([]string, 0, len(ids))
+	for id := range ids {
+		sortedIds = append(sortedIds, id)
+	}
+	sort.Strings(sortedIds)
+
+	fmt.Printf("=== %s\n", title)
+	differences := 0
+	for _, id := range sortedIds {
+		lines := diffLines(expected[id], actual[id])
+		hasDifferences := false
+		for _, line := range lines {
+			if line[0] != ' ' {
+				hasDifferences = true
+				differences++
+			}
+		}
+		if !hasDifferences {
+			continue
+		}
+
+		if id == "" {
+			fmt.Println("--- connection messages")
+		} else {
+			fmt.Printf("--- operation %s\n", id)
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+	}
+	return differences
+}
+
+// diffLines is a LCS based diff
+func diffLines(expected []string, actual []string) []string {
+	lcs := make([][]int, len(expected)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(actual)+1)
+	}
+	for i := len(expected) - 1; i >= 0; i-- {
+		for j := len(actual) - 1; j >= 0; j-- {
+			if expected[i] == actual[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(expected) && j < len(actual) {
+		switch {
+		case expected[i] == actual[j]:
+			lines = append(lines, "  "+expected[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, "- "+expected[i])
+			i++
+		default:
+			lines = append(lines, "+ "+actual[j])
+			j++
+		}
+	}
+	for ; i < len(expected); i++ {
+		lines = append(lines, "- "+expected[i])
+	}
+	for ; j < len(actual); j++ {
+		lines = append(lines, "+ "+actual[j])
+	}
+	return lines
+}
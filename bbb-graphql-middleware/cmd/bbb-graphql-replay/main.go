@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bbb-graphql-middleware/internal/capture"
+	"bbb-graphql-middleware/internal/testsupport"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"nhooyr.io/websocket"
+)
+
+// bbb-graphql-replay sends the browser frames of a capture (created by bbb-graphql-middleware) to a running
+// middleware and diffs what the middleware answers against what was captured.
+// With -hasura-listen it also serves a stand-in Hasura that answers with the captured Hasura frames,
+// so the middleware must be configured to use it (hasura.url)
+func main() {
+	capturePath := flag.String("capture", "", "capture file (JSONL) created by bbb-graphql-middleware")
+	middlewareUrl := flag.String("url", "ws://127.0.0.1:8378/graphql", "websocket url of the middleware")
+	hasuraListen := flag.String("hasura-listen", "", "address to serve a stand-in Hasura replaying the captured Hasura frames (e.g. 127.0.0.1:8185)")
+	speed := flag.Float64("speed", 1, "replay speed relative to the capture (0 sends the frames without delays)")
+	settle := flag.Duration("settle", 3*time.Second, "time to wait for responses after the last frame is sent")
+	cookie := flag.String("cookie", "", "Cookie header sent to the middleware (required by the auth hook in some setups)")
+	sessionToken := flag.String("session-token", "", "X-Session-Token sent on connection_init (the one of the capture is redacted)")
+	ignoredKeys := flag.String("ignore", "resumeToken", "comma-separated JSON keys ignored in the diff")
+	flag.Parse()
+
+	log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+
+	if *capturePath == "" || *sessionToken == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	frames, err := capture.ReadFrames(*capturePath)
+	if err != nil {
+		log.Fatalf("failed to read capture: %v", err)
+	}
+	if len(frames) == 0 {
+		log.Fatalf("capture %s is empty", *capturePath)
+	}
+
+	var standIn *standInHasura
+	if *hasuraListen != "" {
+		standIn = newStandInHasura(frames, *speed)
+		listener, err := net.Listen("tcp", *hasuraListen)
+		if err != nil {
+			log.Fatalf("stand-in Hasura failed to listen: %v", err)
+		}
+		server := &http.Server{Handler: testsupport.NewFakeHasura(standIn)}
+		go func() {
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("stand-in Hasura failed: %v", err)
+			}
+		}()
+		defer server.Close()
+		log.Infof("stand-in Hasura listening on %s", *hasuraListen)
+	}
+
+	received, err := replayBrowserFrames(frames, *middlewareUrl, *cookie, *sessionToken, *speed, *settle)
+	if err != nil {
+		log.Fatalf("replay failed: %v", err)
+	}
+
+	normalizer := newNormalizer(strings.Split(*ignoredKeys, ","))
+	differences := 0
+
+	differences += printDiff(
+		"middleware -> browser",
+		normalizer.group(framesOf(frames, capture.MiddlewareToBrowser)),
+		normalizer.group(received))
+
+	if standIn != nil {
+		differences += printDiff(
+			"middleware -> hasura",
+			normalizer.group(framesOf(frames, capture.MiddlewareToHasura)),
+			normalizer.group(standIn.receivedMessages()))
+	}
+
+	if differences > 0 {
+		fmt.Printf("%d difference(s) found\n", differences)
+		os.Exit(1)
+	}
+	fmt.Println("no differences found")
+}
+
+func framesOf(frames []capture.Frame, direction capture.Direction) [][]byte {
+	var messages [][]byte
+	for _, frame := range frames {
+		if frame.Direction == direction {
+			messages = append(messages, frame.Message)
+		}
+	}
+	return messages
+}
+
+// replayBrowserFrames sends the captured browser frames keeping their original interval (scaled by speed)
+// and returns the frames received from the middleware
+func replayBrowserFrames(frames []capture.Frame, middlewareUrl string, cookie string, sessionToken string, speed float64, settle time.Duration) ([][]byte, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dialOptions := &websocket.DialOptions{
+		Subprotocols: []string{"graphql-transport-ws"},
+		HTTPHeader:   http.Header{},
+	}
+	if cookie != "" {
+		dialOptions.HTTPHeader.Set("Cookie", cookie)
+	}
+
+	conn, _, err := websocket.Dial(ctx, middlewareUrl, dialOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "replay finished")
+	conn.SetReadLimit(9999999)
+
+	var received [][]byte
+	var receivedMutex sync.Mutex
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		for {
+			_, message, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			receivedMutex.Lock()
+			received = append(received, message)
+			receivedMutex.Unlock()
+		}
+	}()
+
+	browserFrames := make([]capture.Frame, 0)
+	for _, frame := range frames {
+		if frame.Direction == capture.BrowserToMiddleware {
+			browserFrames = append(browserFrames, frame)
+		}
+	}
+
+	startedAt := time.Now()
+	for i, frame := range browserFrames {
+		if speed > 0 {
+			offset := time.Duration(float64(frame.Time.Sub(browserFrames[0].Time)) / speed)
+			time.Sleep(time.Until(startedAt.Add(offset)))
+		}
+
+		if err := conn.Write(ctx, websocket.MessageText, withSessionToken(frame.Message, sessionToken)); err != nil {
+			return nil, fmt.Errorf("failed to send frame %d: %v", i+1, err)
+		}
+	}
+
+	time.Sleep(settle)
+	cancel()
+	<-readerDone
+
+	receivedMutex.Lock()
+	defer receivedMutex.Unlock()
+	return received, nil
+}
+
+// withSessionToken sets the session token in the headers of the connection_init (other frames are sent as captured)
+func withSessionToken(message []byte, sessionToken string) []byte {
+	var connectionInit map[string]interface{}
+	if err := json.Unmarshal(message, &connectionInit); err != nil || connectionInit["type"] != "connection_init" {
+		return message
+	}
+
+	payload, _ := connectionInit["payload"].(map[string]interface{})
+	headers, _ := payload["headers"].(map[string]interface{})
+	if headers == nil {
+		return message
+	}
+	headers["X-Session-Token"] = sessionToken
+
+	connectionInitJson, err := json.Marshal(connectionInit)
+	if err != nil {
+		return message
+	}
+	return connectionInitJson
+}
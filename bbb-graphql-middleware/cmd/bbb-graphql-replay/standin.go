@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bbb-graphql-middleware/internal/capture"
+	"bbb-graphql-middleware/internal/testsupport"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type scheduledFrame struct {
+	offset  time.Duration // time after the subscribe (or connection_init) was received
+	message []byte
+}
+
+// capturedHasuraConnection holds the frames Hasura sent in one of the captured connections, by operation id
+type capturedHasuraConnection struct {
+	connectionFrames []scheduledFrame // frames without id (connection_ack, ka)
+	operationFrames  map[string][]scheduledFrame
+}
+
+// standInHasura is the testsupport.FakeHasuraHandler answering the middleware with the captured Hasura frames
+// The Nth connection received replays the Nth captured Hasura connection
+type standInHasura struct {
+	speed       float64
+	connections []*capturedHasuraConnection
+
+	mutex    sync.Mutex
+	received [][]byte
+}
+
+type messageInfo struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+}
+
+func newStandInHasura(frames []capture.Frame, speed float64) *standInHasura {
+	s := &standInHasura{speed: speed}
+
+	connectionsById := make(map[string]*capturedHasuraConnection)
+	startTimes := make(map[string]time.Time)                // connection_init sent by the middleware
+	subscribeTimes := make(map[string]map[string]time.Time) // subscribe sent by the middleware, by operation id
+
+	for _, frame := range frames {
+		if frame.HasuraConnectionId == "" {
+			continue
+		}
+
+		connection, exists := connectionsById[frame.HasuraConnectionId]
+		if !exists {
+			connection = &capturedHasuraConnection{operationFrames: make(map[string][]scheduledFrame)}
+			connectionsById[frame.HasuraConnectionId] = connection
+			s.connections = append(s.connections, connection)
+			startTimes[frame.HasuraConnectionId] = frame.Time
+			subscribeTimes[frame.HasuraConnectionId] = make(map[string]time.Time)
+		}
+
+		var info messageInfo
+		_ = json.Unmarshal(frame.Message, &info)
+
+		switch frame.Direction {
+		case capture.MiddlewareToHasura:
+			if info.Type == "subscribe" {
+				subscribeTimes[frame.HasuraConnectionId][info.Id] = frame.Time
+			}
+		case capture.HasuraToMiddleware:
+			if info.Id == "" {
+				connection.connectionFrames = append(connection.connectionFrames, scheduledFrame{
+					offset:  frame.Time.Sub(startTimes[frame.HasuraConnectionId]),
+					message: frame.Message,
+				})
+				continue
+			}
+
+			subscribedAt, subscribed := subscribeTimes[frame.HasuraConnectionId][info.Id]
+			if !subscribed {
+				subscribedAt = frame.Time
+			}
+			connection.operationFrames[info.Id] = append(connection.operationFrames[info.Id], scheduledFrame{
+				offset:  frame.Time.Sub(subscribedAt),
+				message: frame.Message,
+			})
+		}
+	}
+
+	return s
+}
+
+func (s *standInHasura) receivedMessages() [][]byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.received
+}
+
+func (s *standInHasura) HandleMessage(c *testsupport.FakeHasuraConnection, message testsupport.HasuraMessage) {
+	s.mutex.Lock()
+	s.received = append(s.received, message.Raw)
+	s.mutex.Unlock()
+
+	captured := &capturedHasuraConnection{operationFrames: make(map[string][]scheduledFrame)}
+	if len(s.connections) > 0 {
+		captured = s.connections[min(c.Index, len(s.connections)-1)]
+	}
+
+	switch message.Type {
+	case "connection_init":
+		if len(captured.connectionFrames) == 0 {
+			_ = c.Send([]byte(`{"type":"connection_ack"}`))
+		}
+		go s.sendScheduled(c, captured.connectionFrames)
+	case "subscribe":
+		go s.sendScheduled(c, captured.operationFrames[message.Id])
+	}
+}
+
+func (s *standInHasura) sendScheduled(c *testsupport.FakeHasuraConnection, frames []scheduledFrame) {
+	startedAt := time.Now()
+	for _, frame := range frames {
+		if s.speed > 0 {
+			select {
+			case <-c.Context().Done():
+				return
+			case <-time.After(time.Until(startedAt.Add(time.Duration(float64(frame.offset) / s.speed)))):
+			}
+		}
+		_ = c.Send(frame.message)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 )
 
@@ -18,32 +19,133 @@ var (
 var DefaultConfigPath = "/usr/share/bbb-graphql-middleware/config.yml"
 var OverrideConfigPath = "/etc/bigbluebutton/bbb-graphql-middleware.yml"
 
+// Environment variables to load the configs from other paths (e.g. to run the tests), an empty override path disables it
+const DefaultConfigPathEnv = "BBB_GRAPHQL_MIDDLEWARE_CONFIG"
+const OverrideConfigPathEnv = "BBB_GRAPHQL_MIDDLEWARE_OVERRIDE_CONFIG"
+
 type Config struct {
 	Server struct {
-		Host                                 string `yaml:"listen_host"`
-		Port                                 int    `yaml:"listen_port"`
-		MaxConnections                       int    `yaml:"max_connections"`
-		MaxConnectionsPerSecond              int    `yaml:"max_connections_per_second"`
-		MaxConnectionsPerSessionToken        int    `yaml:"max_connections_per_session_token"`
-		MaxConnectionQueriesPerMinute        int    `yaml:"max_connection_queries_per_minute"`
-		MaxConnectionMutationsPerMinute      int    `yaml:"max_connection_mutations_per_minute"`
-		MaxConnectionConcurrentSubscriptions int    `yaml:"max_connection_concurrent_subscriptions"`
-		MaxQueryLength                       int    `yaml:"max_query_length"`
-		MaxQueryDepth                        int    `yaml:"max_query_depth"`
-		MaxMutationLength                    int    `yaml:"max_mutation_length"`
-		AuthorizedCrossOrigin                string `yaml:"authorized_cross_origin"`
-		JsonPatchDisabled                    bool   `yaml:"json_patch_disabled"`
-		SubscriptionAllowedList              string `yaml:"subscriptions_allowed_list"`
-		SubscriptionsDeniedList              string `yaml:"subscriptions_denied_list"`
-		WebsocketIdleTimeoutSeconds          int    `yaml:"websocket_idle_timeout_seconds"`
+		Host                                 string         `yaml:"listen_host"`
+		Port                                 int            `yaml:"listen_port"`
+		MaxConnections                       int            `yaml:"max_connections"`
+		MaxConnectionsPerSecond              int            `yaml:"max_connections_per_second"`
+		MaxConnectionsPerSessionToken        int            `yaml:"max_connections_per_session_token"`
+		MaxConnectionQueriesPerMinute        int            `yaml:"max_connection_queries_per_minute"`
+		MaxConnectionMutationsPerMinute      int            `yaml:"max_connection_mutations_per_minute"`
+		MaxConnectionConcurrentSubscriptions int            `yaml:"max_connection_concurrent_subscriptions"`
+		MaxQueryLength                       int            `yaml:"max_query_length"`
+		MaxQueryDepth                        int            `yaml:"max_query_depth"`
+		MaxQueryCost                         map[string]int `yaml:"max_query_cost"`
+		QueryCostDefaultListSize             int            `yaml:"query_cost_default_list_size"`
+		MaxMutationLength                    int            `yaml:"max_mutation_length"`
+		AuthorizedCrossOrigin                string         `yaml:"authorized_cross_origin"`
+		JsonPatchDisabled                    bool           `yaml:"json_patch_disabled"`
+		WebsocketIdleTimeoutSeconds          int            `yaml:"websocket_idle_timeout_seconds"`
+		ConnectionInitWaitTimeoutSeconds     int            `yaml:"connection_init_wait_timeout_seconds"`
+		WebsocketPingIntervalSeconds         int            `yaml:"websocket_ping_interval_seconds"`
+		WebsocketPongTimeoutSeconds          int            `yaml:"websocket_pong_timeout_seconds"`
+		WebsocketMaxPingRttMs                int            `yaml:"websocket_max_ping_rtt_ms"`
+		WebsocketCompressionMode             string         `yaml:"websocket_compression_mode"`
+		WebsocketCompressionThreshold        int            `yaml:"websocket_compression_threshold"`
+		BinaryEncodingEnabled                bool           `yaml:"binary_encoding_enabled"`
+		SessionResumeGraceSeconds            int            `yaml:"session_resume_grace_seconds"`
+		// Deprecated: comma-separated operation names, translated to policy.rules when loading the config
+		SubscriptionsAllowedList string `yaml:"subscriptions_allowed_list"`
+		SubscriptionsDeniedList  string `yaml:"subscriptions_denied_list"`
 	} `yaml:"server"`
-	Redis struct {
+	Listeners []ListenerConfig `yaml:"listeners"`
+	Redis     struct {
 		Host     string `yaml:"host"`
 		Port     int32  `yaml:"port"`
 		Password string `yaml:"password"`
 	} `yaml:"redis"`
+	Policy struct {
+		DefaultEffect string       `yaml:"default_effect"`
+		Rules         []PolicyRule `yaml:"rules"`
+	} `yaml:"policy"`
+	Audit struct {
+		Enabled    bool   `yaml:"enabled"`
+		Sink       string `yaml:"sink"`
+		BufferSize int    `yaml:"buffer_size"`
+		File       struct {
+			Path      string `yaml:"path"`
+			MaxSizeMb int    `yaml:"max_size_mb"`
+			MaxFiles  int    `yaml:"max_files"`
+		} `yaml:"file"`
+		RedisStream struct {
+			Name   string `yaml:"name"`
+			MaxLen int64  `yaml:"max_len"`
+		} `yaml:"redis_stream"`
+		Redaction struct {
+			RedactFields []string `yaml:"redact_fields"`
+			HashFields   []string `yaml:"hash_fields"`
+			HashSalt     string   `yaml:"hash_salt"`
+		} `yaml:"redaction"`
+	} `yaml:"audit"`
+	Capture struct {
+		Directory     string   `yaml:"directory"`
+		Meetings      []string `yaml:"meetings"`
+		SessionTokens []string `yaml:"session_tokens"`
+		MaxFileSizeMb int      `yaml:"max_file_size_mb"`
+		RedactFields  []string `yaml:"redact_fields"`
+	} `yaml:"capture"`
+	UpstreamBackoff struct {
+		InitialDelayMs               int     `yaml:"initial_delay_ms"`
+		GraphqlActionsInitialDelayMs int     `yaml:"graphql_actions_initial_delay_ms"`
+		MaxDelayMs                   int     `yaml:"max_delay_ms"`
+		Multiplier                   float64 `yaml:"multiplier"`
+		Jitter                       float64 `yaml:"jitter"`
+		MaxConcurrentDials           int     `yaml:"max_concurrent_dials"`
+	} `yaml:"upstream_backoff"`
+	UpstreamStatus struct {
+		Enabled              bool `yaml:"enabled"`
+		NotifyAfterMs        int  `yaml:"notify_after_ms"`
+		FailingAfterAttempts int  `yaml:"failing_after_attempts"`
+	} `yaml:"upstream_status"`
+	Admin struct {
+		Token string `yaml:"token"`
+	} `yaml:"admin"`
+	Health struct {
+		CheckIntervalSeconds int     `yaml:"check_interval_seconds"`
+		CheckTimeoutSeconds  int     `yaml:"check_timeout_seconds"`
+		MaxConnectionsRatio  float64 `yaml:"max_connections_ratio"`
+	} `yaml:"health"`
+	Tap struct {
+		DefaultDurationSeconds int      `yaml:"default_duration_seconds"`
+		MaxDurationSeconds     int      `yaml:"max_duration_seconds"`
+		BufferSize             int      `yaml:"buffer_size"`
+		RedactFields           []string `yaml:"redact_fields"`
+	} `yaml:"tap"`
+	Tracing struct {
+		Enabled      bool    `yaml:"enabled"`
+		Exporter     string  `yaml:"exporter"`
+		OtlpEndpoint string  `yaml:"otlp_endpoint"`
+		OtlpInsecure bool    `yaml:"otlp_insecure"`
+		File         string  `yaml:"file"`
+		SampleRatio  float64 `yaml:"sample_ratio"`
+		ServiceName  string  `yaml:"service_name"`
+	} `yaml:"tracing"`
+	Cache struct {
+		TtlSeconds int `yaml:"ttl_seconds"`
+		Shards     int `yaml:"shards"`
+		MaxEntries int `yaml:"max_entries"`
+		MaxBytes   int `yaml:"max_bytes"`
+	} `yaml:"cache"`
 	Hasura struct {
-		Url string `yaml:"url"`
+		Url                          string   `yaml:"url"`
+		Endpoints                    []string `yaml:"endpoints"`
+		Balancing                    string   `yaml:"balancing"`
+		HealthCheckIntervalSeconds   int      `yaml:"health_check_interval_seconds"`
+		HealthCheckFailuresThreshold int      `yaml:"health_check_failures_threshold"`
+		SchemaValidation             struct {
+			Enabled                bool              `yaml:"enabled"`
+			Source                 string            `yaml:"source"`
+			IntrospectionUrl       string            `yaml:"introspection_url"`
+			IntrospectionRole      string            `yaml:"introspection_role"`
+			IntrospectionHeaders   map[string]string `yaml:"introspection_headers"`
+			File                   string            `yaml:"file"`
+			RefreshIntervalSeconds int               `yaml:"refresh_interval_seconds"`
+		} `yaml:"schema_validation"`
 	} `yaml:"hasura"`
 	GraphqlActions struct {
 		Url string `yaml:"url"`
@@ -67,6 +169,13 @@ func GetConfig() *Config {
 }
 
 func (c *Config) loadConfigs() {
+	if path, exists := os.LookupEnv(DefaultConfigPathEnv); exists {
+		DefaultConfigPath = path
+	}
+	if path, exists := os.LookupEnv(OverrideConfigPathEnv); exists {
+		OverrideConfigPath = path
+	}
+
 	// Load default config file
 	configDefault, err := loadConfigFile(DefaultConfigPath)
 	if err != nil {
@@ -89,10 +198,52 @@ func (c *Config) loadConfigs() {
 		}
 	}
 
+	migrateSubscriptionLists(&configDefault)
+
 	// Update the singleton instance with the merged config
 	*instance = configDefault
 }
 
+// migrateSubscriptionLists translates the deprecated server.subscriptions_allowed_list and
+// server.subscriptions_denied_list to policy rules, evaluated before the configured ones (as the lists were)
+func migrateSubscriptionLists(c *Config) {
+	var legacyRules []PolicyRule
+
+	if deniedList := splitOperationList(c.Server.SubscriptionsDeniedList); len(deniedList) > 0 {
+		log.Warnf("server.subscriptions_denied_list is deprecated, translated to the policy rule subscriptions_denied_list")
+		legacyRules = append(legacyRules, PolicyRule{
+			Name:           "subscriptions_denied_list",
+			Effect:         "deny",
+			Reason:         "subscription not allowed",
+			OperationTypes: []string{"subscription"},
+			Operations:     deniedList,
+		})
+	}
+
+	if allowedList := splitOperationList(c.Server.SubscriptionsAllowedList); len(allowedList) > 0 {
+		log.Warnf("server.subscriptions_allowed_list is deprecated, translated to the policy rule subscriptions_allowed_list")
+		legacyRules = append(legacyRules, PolicyRule{
+			Name:             "subscriptions_allowed_list",
+			Effect:           "deny",
+			Reason:           "subscription not allowed",
+			OperationTypes:   []string{"subscription"},
+			ExceptOperations: allowedList,
+		})
+	}
+
+	c.Policy.Rules = append(legacyRules, c.Policy.Rules...)
+}
+
+func splitOperationList(list string) []string {
+	var operations []string
+	for _, operation := range strings.Split(list, ",") {
+		if operation = strings.TrimSpace(operation); operation != "" {
+			operations = append(operations, operation)
+		}
+	}
+	return operations
+}
+
 func loadConfigFile(path string) (Config, error) {
 	var config Config
 	data, err := ioutil.ReadFile(filepath.Clean(path))
@@ -108,9 +259,32 @@ func loadConfigFile(path string) (Config, error) {
 	return config, nil
 }
 
-var AllowedSubscriptionsForNotInMeetingUsers = []string{
-	"getUserInfo",
-	"getMeetingEndData",
-	"PluginConfigurationQuery",
-	"getUserCurrent",
+// PolicyRule allows or denies operations; it matches when all the conditions informed match
+type PolicyRule struct {
+	Name             string   `yaml:"name"`
+	Effect           string   `yaml:"effect"`
+	Reason           string   `yaml:"reason"`
+	Operations       []string `yaml:"operations"`
+	ExceptOperations []string `yaml:"except_operations"`
+	OperationTypes   []string `yaml:"operation_types"`
+	Roles            []string `yaml:"roles"`
+	ClientTypes      []string `yaml:"client_types"`
+	Mobile           *bool    `yaml:"mobile"`
+	InMeeting        *bool    `yaml:"in_meeting"`
+}
+
+// ListenerConfig is an address (TCP, optionally TLS, or unix socket) serving some of the endpoints
+type ListenerConfig struct {
+	Name       string   `yaml:"name"`
+	Network    string   `yaml:"network"`
+	Address    string   `yaml:"address"`
+	SocketMode string   `yaml:"socket_mode"`
+	Paths      []string `yaml:"paths"`
+	Tls        struct {
+		CertFile              string `yaml:"cert_file"`
+		KeyFile               string `yaml:"key_file"`
+		ClientCaFile          string `yaml:"client_ca_file"`
+		ClientCert            string `yaml:"client_cert"`
+		ReloadIntervalSeconds int    `yaml:"reload_interval_seconds"`
+	} `yaml:"tls"`
 }
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMigrateSubscriptionLists(t *testing.T) {
+	var c Config
+	c.Server.SubscriptionsAllowedList = "getUsers, getMeeting,"
+	c.Server.SubscriptionsDeniedList = "getChat"
+	c.Policy.Rules = []PolicyRule{{Name: "configured", Effect: "hold"}}
+
+	migrateSubscriptionLists(&c)
+
+	if len(c.Policy.Rules) != 3 {
+		t.Fatalf("expected the 2 translated rules before the configured one, got %+v", c.Policy.Rules)
+	}
+
+	denied := c.Policy.Rules[0]
+	if denied.Effect != "deny" || !reflect.DeepEqual(denied.Operations, []string{"getChat"}) ||
+		!reflect.DeepEqual(denied.OperationTypes, []string{"subscription"}) {
+		t.Errorf("unexpected rule for the denied list: %+v", denied)
+	}
+
+	allowed := c.Policy.Rules[1]
+	if allowed.Effect != "deny" || !reflect.DeepEqual(allowed.ExceptOperations, []string{"getUsers", "getMeeting"}) ||
+		!reflect.DeepEqual(allowed.OperationTypes, []string{"subscription"}) || len(allowed.Operations) != 0 {
+		t.Errorf("unexpected rule for the allowed list: %+v", allowed)
+	}
+
+	if c.Policy.Rules[2].Name != "configured" {
+		t.Errorf("expected the configured rule to be kept last, got %+v", c.Policy.Rules[2])
+	}
+}
+
+func TestMigrateSubscriptionListsNotSet(t *testing.T) {
+	var c Config
+	c.Policy.Rules = []PolicyRule{{Name: "configured", Effect: "hold"}}
+
+	migrateSubscriptionLists(&c)
+
+	if len(c.Policy.Rules) != 1 {
+		t.Fatalf("expected no rule to be added, got %+v", c.Policy.Rules)
+	}
+}
@@ -2,12 +2,19 @@ package akka_apps
 
 import (
 	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/common"
+	"bbb-graphql-middleware/internal/tracing"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 )
 
 var sessionVarsHookUrl = config.GetConfig().SessionVarsHook.Url
@@ -15,7 +22,20 @@ var sessionVarsHookUrl = config.GetConfig().SessionVarsHook.Url
 var internalError = fmt.Errorf("server internal error")
 var internalErrorId = "internal_error"
 
-func AkkaAppsGetSessionVariablesFrom(browserConnectionId string, sessionToken string, clientSessionUUID string) (map[string]string, error, string) {
+func AkkaAppsGetSessionVariablesFrom(ctx context.Context, browserConnectionId string, sessionToken string, clientSessionUUID string) (map[string]string, error, string) {
+	ctx, span := tracing.Tracer.Start(ctx, "session_vars_hook", trace.WithSpanKind(trace.SpanKindClient))
+	startedAt := time.Now()
+	sessionVariables, err, errorId := getSessionVariables(ctx, browserConnectionId, sessionToken, clientSessionUUID)
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	common.SessionVarsHookDuration.With(prometheus.Labels{"result": result}).Observe(time.Since(startedAt).Seconds())
+	tracing.EndSpan(span, err)
+	return sessionVariables, err, errorId
+}
+
+func getSessionVariables(ctx context.Context, browserConnectionId string, sessionToken string, clientSessionUUID string) (map[string]string, error, string) {
 	logger := log.WithField("_routine", "AkkaAppsClient").
 		WithField("browserConnectionId", browserConnectionId).
 		WithField("sessionToken", sessionToken).
@@ -36,11 +56,12 @@ func AkkaAppsGetSessionVariablesFrom(browserConnectionId string, sessionToken st
 	log.Trace("Get user session vars from: " + sessionVarsHookUrl + "?sessionToken=" + sessionToken)
 
 	// Create a new HTTP request to the session_vars hook URL.
-	req, err := http.NewRequest("GET", sessionVarsHookUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", sessionVarsHookUrl, nil)
 	if err != nil {
 		log.Error(err)
 		return nil, internalError, internalErrorId
 	}
+	tracing.InjectHeaders(ctx, req.Header)
 
 	// Execute the HTTP request to obtain user session variables (like X-Hasura-Role)
 	req.Header.Set("x-session-token", sessionToken)
@@ -71,7 +92,7 @@ func AkkaAppsGetSessionVariablesFrom(browserConnectionId string, sessionToken st
 	}
 	if response != "authorized" {
 		logger.Errorf("not authorized: Response: %s, Message: %s, MessageId: %s", response, message, messageId)
-		return nil, fmt.Errorf(message), messageId
+		return nil, errors.New(message), messageId
 	}
 
 	// Normalize the response header keys.
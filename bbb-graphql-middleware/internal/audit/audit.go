@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/common"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+type EventType string
+
+const (
+	Mutation            EventType = "mutation"
+	ForcedReconnection  EventType = "forced_reconnection"
+	ForcedDisconnection EventType = "forced_disconnection"
+)
+
+type Outcome string
+
+const (
+	Success  Outcome = "success"
+	Rejected Outcome = "rejected" // refused by the middleware (limits, policy, validation)
+	Failed   Outcome = "failed"   // graphql-actions returned an error
+)
+
+// Record is a line of the audit log
+type Record struct {
+	Time                time.Time              `json:"time"`
+	Event               EventType              `json:"event"`
+	MeetingId           string                 `json:"meetingId,omitempty"`
+	UserId              string                 `json:"userId,omitempty"`
+	Role                string                 `json:"role,omitempty"`
+	SessionTokenHash    string                 `json:"sessionTokenHash,omitempty"`
+	BrowserConnectionId string                 `json:"browserConnectionId,omitempty"`
+	OperationName       string                 `json:"operationName,omitempty"`
+	Action              string                 `json:"action,omitempty"`
+	Inputs              map[string]interface{} `json:"inputs,omitempty"`
+	Outcome             Outcome                `json:"outcome,omitempty"`
+	Error               string                 `json:"error,omitempty"`
+	LatencyMs           float64                `json:"latencyMs,omitempty"`
+	Reason              string                 `json:"reason,omitempty"`
+	ReasonMessageId     string                 `json:"reasonMessageId,omitempty"`
+}
+
+type sink interface {
+	write(record []byte) error
+}
+
+var auditConfig = config.GetConfig().Audit
+var records chan Record
+
+func init() {
+	if auditConfig.Enabled {
+		records = make(chan Record, max(auditConfig.BufferSize, 1))
+	}
+}
+
+func Enabled() bool {
+	return auditConfig.Enabled
+}
+
+// StartAuditWriter writes the records to the configured sink
+func StartAuditWriter() {
+	log := log.WithField("_routine", "StartAuditWriter")
+
+	if !Enabled() {
+		return
+	}
+
+	var auditSink sink
+	var err error
+	switch auditConfig.Sink {
+	case "redis":
+		auditSink = newRedisStreamSink(auditConfig.RedisStream.Name, auditConfig.RedisStream.MaxLen)
+	case "file", "":
+		auditSink, err = newRotatingFileSink(auditConfig.File.Path, auditConfig.File.MaxSizeMb, auditConfig.File.MaxFiles)
+	default:
+		err = fmt.Errorf("unknown sink %s", auditConfig.Sink)
+	}
+	if err != nil {
+		log.Fatalf("failed to start the audit log: %v", err)
+	}
+
+	log.Infof("Audit log enabled (sink: %s)", auditConfig.Sink)
+
+	for record := range records {
+		recordJson, err := json.Marshal(record)
+		if err != nil {
+			log.Errorf("failed to marshal audit record: %v", err)
+			continue
+		}
+
+		if err := auditSink.write(recordJson); err != nil {
+			log.Errorf("failed to write audit record: %v", err)
+			common.AuditRecordsCounter.With(prometheus.Labels{"result": "error"}).Inc()
+			continue
+		}
+		common.AuditRecordsCounter.With(prometheus.Labels{"result": "written"}).Inc()
+	}
+}
+
+// Log enqueues the record without blocking the caller (it's dropped when the buffer is full)
+func Log(record Record) {
+	if !Enabled() {
+		return
+	}
+
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+
+	select {
+	case records <- record:
+	default:
+		common.AuditRecordsCounter.With(prometheus.Labels{"result": "dropped"}).Inc()
+	}
+}
+
+// NewRecord fills the record with the info of the connection
+func NewRecord(event EventType, bc *common.BrowserConnection) Record {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	return Record{
+		Time:                time.Now(),
+		Event:               event,
+		MeetingId:           bc.MeetingId,
+		UserId:              bc.UserId,
+		Role:                bc.BBBWebSessionVariables["x-hasura-role"],
+		SessionTokenHash:    HashValue(bc.SessionToken),
+		BrowserConnectionId: bc.Id,
+	}
+}
+
+// HashValue returns a salted hash of the value, allowing to correlate records without storing the value
+func HashValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(auditConfig.Redaction.HashSalt + value))
+	return hex.EncodeToString(hash[:16])
+}
+
+// redaction redacts or hashes the inputs matching audit.redaction.redact_fields and hash_fields
+var redaction = common.Redaction{
+	RedactFields: auditConfig.Redaction.RedactFields,
+	HashFields:   auditConfig.Redaction.HashFields,
+	Hash:         HashValue,
+}
+
+// RedactInputs returns a copy of the inputs with the configured fields redacted or hashed (at any level)
+func RedactInputs(inputs map[string]interface{}) map[string]interface{} {
+	if inputs == nil {
+		return nil
+	}
+	return redaction.RedactValue(inputs).(map[string]interface{})
+}
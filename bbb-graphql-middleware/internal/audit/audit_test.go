@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"bbb-graphql-middleware/internal/testsupport"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if reexecuted, exitCode := testsupport.RunWithTestConfig("../../config/config.yml", map[string]interface{}{
+		"log_level":                     "warn",
+		"audit.redaction.redact_fields": []string{"password", "*Token*"},
+		"audit.redaction.hash_fields":   []string{"email"},
+		"audit.redaction.hash_salt":     "salt",
+	}); reexecuted {
+		os.Exit(exitCode)
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestRedactInputs(t *testing.T) {
+	inputs := map[string]interface{}{
+		"userId":   "user1",
+		"password": "secret",
+		"settings": map[string]interface{}{
+			"guestToken": "abc",
+			"invites": []interface{}{
+				map[string]interface{}{"email": "a@b.c", "name": "A"},
+			},
+		},
+	}
+
+	redacted := RedactInputs(inputs)
+	redactedJson, _ := json.Marshal(redacted)
+
+	emailJson, _ := json.Marshal("a@b.c")
+	expected := map[string]interface{}{
+		"userId":   "user1",
+		"password": "[REDACTED]",
+		"settings": map[string]interface{}{
+			"guestToken": "[REDACTED]",
+			"invites": []interface{}{
+				map[string]interface{}{"email": "sha256:" + HashValue(string(emailJson)), "name": "A"},
+			},
+		},
+	}
+	expectedJson, _ := json.Marshal(expected)
+	if string(redactedJson) != string(expectedJson) {
+		t.Errorf("expected %s, got %s", expectedJson, redactedJson)
+	}
+
+	if inputs["password"] != "secret" {
+		t.Errorf("the inputs were changed")
+	}
+	if RedactInputs(nil) != nil {
+		t.Errorf("nil inputs should stay nil")
+	}
+}
+
+func TestHashValueIsSaltedAndStable(t *testing.T) {
+	if HashValue("") != "" {
+		t.Errorf("empty values should not be hashed")
+	}
+	if HashValue("token1") != HashValue("token1") || HashValue("token1") == HashValue("token2") {
+		t.Errorf("hash should be stable and distinct")
+	}
+
+	unsalted := sha256.Sum256([]byte("token1"))
+	if HashValue("token1") == hex.EncodeToString(unsalted[:16]) {
+		t.Errorf("hash_salt not applied")
+	}
+}
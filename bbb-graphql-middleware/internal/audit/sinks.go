@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"bbb-graphql-middleware/config"
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rotatingFileSink appends the records to a JSONL file, that is rotated when it reaches the max size
+// keeping at most maxFiles rotated files
+type rotatingFileSink struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func newRotatingFileSink(path string, maxSizeMb int, maxFiles int) (*rotatingFileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+
+	s := &rotatingFileSink{
+		path:     path,
+		maxBytes: int64(maxSizeMb) * 1024 * 1024,
+		maxFiles: maxFiles,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *rotatingFileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *rotatingFileSink) write(record []byte) error {
+	if s.maxBytes > 0 && s.size+int64(len(record))+1 > s.maxBytes && s.size > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(append(record, '\n'))
+	s.size += int64(n)
+	return err
+}
+
+// rotate renames the current file to <name>-<timestamp><ext> and removes the oldest rotated files
+func (s *rotatingFileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(s.path)
+	prefix := strings.TrimSuffix(s.path, ext) + "-"
+	rotatedPath := fmt.Sprintf("%s%s%s", prefix, time.Now().UTC().Format("20060102T150405.000000000"), ext)
+	if err := os.Rename(s.path, rotatedPath); err != nil {
+		return err
+	}
+
+	if s.maxFiles > 0 {
+		rotatedFiles, _ := filepath.Glob(prefix + "*" + ext)
+		sort.Strings(rotatedFiles)
+		for len(rotatedFiles) > s.maxFiles {
+			os.Remove(rotatedFiles[0])
+			rotatedFiles = rotatedFiles[1:]
+		}
+	}
+
+	return s.open()
+}
+
+// redisStreamSink adds the records to a Redis stream (trimmed to approximately maxLen entries)
+type redisStreamSink struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+func newRedisStreamSink(stream string, maxLen int64) *redisStreamSink {
+	return &redisStreamSink{
+		client: redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%d", config.GetConfig().Redis.Host, config.GetConfig().Redis.Port),
+			Password: config.GetConfig().Redis.Password,
+			DB:       0,
+		}),
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+func (s *redisStreamSink) write(record []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: s.maxLen > 0,
+		Values: map[string]interface{}{"record": record},
+	}).Err()
+}
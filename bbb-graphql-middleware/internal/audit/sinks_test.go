@@ -0,0 +1,77 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFileSinkRotatesAndPrunes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	s, err := newRotatingFileSink(path, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.file.Close()
+	s.maxBytes = 25 // two records of 11 bytes (with the newline) per file
+
+	for i := 0; i < 7; i++ {
+		if err := s.write([]byte(fmt.Sprintf(`{"n":"%02d"}`, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rotatedFiles, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "audit-*.jsonl"))
+	sort.Strings(rotatedFiles)
+	if len(rotatedFiles) != 2 {
+		t.Fatalf("expected the 2 newest rotated files kept, got %v", rotatedFiles)
+	}
+
+	// the oldest records were pruned with their files, the newest are in the current file
+	var contents []string
+	for _, file := range append(rotatedFiles, path) {
+		content, _ := os.ReadFile(file)
+		contents = append(contents, strings.TrimSpace(string(content)))
+	}
+	expected := []string{
+		`{"n":"02"}` + "\n" + `{"n":"03"}`,
+		`{"n":"04"}` + "\n" + `{"n":"05"}`,
+		`{"n":"06"}`,
+	}
+	for i := range expected {
+		if contents[i] != expected[i] {
+			t.Errorf("file %d: expected %q, got %q", i, expected[i], contents[i])
+		}
+	}
+}
+
+func TestRotatingFileSinkAppendsToTheExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte("{\"n\":\"00\"}\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newRotatingFileSink(path, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.file.Close()
+	s.maxBytes = 25
+
+	for i := 1; i < 5; i++ {
+		_ = s.write([]byte(fmt.Sprintf(`{"n":"%02d"}`, i)))
+	}
+
+	// the size of the existing file counts, and without max_files nothing is pruned
+	rotatedFiles, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "audit-*.jsonl"))
+	if len(rotatedFiles) != 2 {
+		t.Errorf("expected 2 rotated files, got %v", rotatedFiles)
+	}
+	content, _ := os.ReadFile(path)
+	if strings.TrimSpace(string(content)) != `{"n":"04"}` {
+		t.Errorf("unexpected current file %q", content)
+	}
+}
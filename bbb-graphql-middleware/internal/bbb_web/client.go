@@ -2,19 +2,38 @@ package bbb_web
 
 import (
 	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/common"
+	"bbb-graphql-middleware/internal/tracing"
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"io/ioutil"
 	"net/http"
 	"net/http/cookiejar"
 	"strings"
+	"time"
 )
 
 // authHookUrl is the authentication hook URL obtained from config file.
 var authHookUrl = config.GetConfig().AuthHook.Url
 
-func BBBWebCheckAuthorization(browserConnectionId string, sessionToken string, clientSessionUUID string, cookies []*http.Cookie) (string, string, error) {
+func BBBWebCheckAuthorization(ctx context.Context, browserConnectionId string, sessionToken string, clientSessionUUID string, cookies []*http.Cookie) (string, string, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "auth_hook", trace.WithSpanKind(trace.SpanKindClient))
+	startedAt := time.Now()
+	meetingId, userId, err := checkAuthorization(ctx, browserConnectionId, sessionToken, clientSessionUUID, cookies)
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	common.AuthHookDuration.With(prometheus.Labels{"result": result}).Observe(time.Since(startedAt).Seconds())
+	tracing.EndSpan(span, err)
+	return meetingId, userId, err
+}
+
+func checkAuthorization(ctx context.Context, browserConnectionId string, sessionToken string, clientSessionUUID string, cookies []*http.Cookie) (string, string, error) {
 	logger := log.WithField("_routine", "BBBWebClient").
 		WithField("browserConnectionId", browserConnectionId).
 		WithField("sessionToken", sessionToken).
@@ -36,10 +55,11 @@ func BBBWebCheckAuthorization(browserConnectionId string, sessionToken string, c
 	}
 
 	// Create a new HTTP request to the authentication hook URL.
-	req, err := http.NewRequest("GET", authHookUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", authHookUrl, nil)
 	if err != nil {
 		return "", "", err
 	}
+	tracing.InjectHeaders(ctx, req.Header)
 
 	// Add cookies to the request.
 	for _, cookie := range cookies {
@@ -91,7 +111,7 @@ func BBBWebCheckAuthorization(browserConnectionId string, sessionToken string, c
 
 	//Get userId and meetingId from response Header
 	for key, value := range normalizedResponse {
-		log.Debug("%s: %s\n", key, value)
+		log.Debugf("%s: %s\n", key, value)
 
 		if key == "x-userid" {
 			userId = value
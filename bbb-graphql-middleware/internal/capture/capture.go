@@ -0,0 +1,155 @@
+package capture
+
+import (
+	"bbb-graphql-middleware/config"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+type Direction string
+
+const (
+	BrowserToMiddleware Direction = "browser_to_middleware"
+	MiddlewareToBrowser Direction = "middleware_to_browser"
+	MiddlewareToHasura  Direction = "middleware_to_hasura"
+	HasuraToMiddleware  Direction = "hasura_to_middleware"
+)
+
+// Frame is a line of the capture file
+type Frame struct {
+	Time                time.Time       `json:"time"`
+	Direction           Direction       `json:"direction"`
+	BrowserConnectionId string          `json:"browserConnectionId"`
+	HasuraConnectionId  string          `json:"hasuraConnectionId,omitempty"`
+	Message             json.RawMessage `json:"message"`
+}
+
+// Recorder writes the frames of a browser connection to its capture file
+// A nil Recorder ignores the frames, so callers don't need to check if the capture is enabled
+type Recorder struct {
+	mutex               sync.Mutex
+	browserConnectionId string
+	redact              func(message []byte) json.RawMessage
+	file                *os.File
+	size                int64
+	maxSize             int64
+	logger              *log.Entry
+}
+
+func Enabled() bool {
+	captureConfig := config.GetConfig().Capture
+	return len(captureConfig.Meetings) > 0 || len(captureConfig.SessionTokens) > 0
+}
+
+// Start creates the capture file when the meeting or the session token was chosen to be captured
+// The frames are written after redact (that removes the session token and the fields of capture.redact_fields)
+// The config is read here (not on package init) so the replay tool can load captures without a config file
+func Start(meetingId string, sessionToken string, browserConnectionId string, redact func(message []byte) json.RawMessage, logger *log.Entry) *Recorder {
+	captureConfig := config.GetConfig().Capture
+	if !slices.Contains(captureConfig.Meetings, meetingId) && !slices.Contains(captureConfig.SessionTokens, sessionToken) {
+		return nil
+	}
+
+	directory := filepath.Join(captureConfig.Directory, filepath.Base(meetingId))
+	if err := os.MkdirAll(directory, 0750); err != nil {
+		logger.Errorf("failed to create capture directory: %v", err)
+		return nil
+	}
+
+	filePath := filepath.Join(directory, fmt.Sprintf("%s-%s.jsonl", time.Now().UTC().Format("20060102T150405"), browserConnectionId))
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		logger.Errorf("failed to create capture file: %v", err)
+		return nil
+	}
+
+	logger.Infof("capturing traffic to %s", filePath)
+
+	return &Recorder{
+		browserConnectionId: browserConnectionId,
+		redact:              redact,
+		file:                file,
+		maxSize:             int64(captureConfig.MaxFileSizeMb) * 1024 * 1024,
+		logger:              logger,
+	}
+}
+
+// Record appends the frame to the capture (frames must be JSON, the binary ones are captured after being decoded)
+func (r *Recorder) Record(direction Direction, hasuraConnectionId string, message []byte) {
+	if r == nil {
+		return
+	}
+
+	frame := Frame{
+		Time:                time.Now(),
+		Direction:           direction,
+		BrowserConnectionId: r.browserConnectionId,
+		HasuraConnectionId:  hasuraConnectionId,
+		Message:             r.redact(message),
+	}
+
+	frameJson, err := json.Marshal(frame)
+	if err != nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return
+	}
+
+	if r.maxSize > 0 && r.size+int64(len(frameJson)) > r.maxSize {
+		r.logger.Warnf("capture reached the max size, stopping it")
+		r.file.Close()
+		r.file = nil
+		return
+	}
+
+	n, err := r.file.Write(append(frameJson, '\n'))
+	r.size += int64(n)
+	if err != nil {
+		r.logger.Errorf("failed to write capture: %v", err)
+	}
+}
+
+func (r *Recorder) Close() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+// ReadFrames loads a capture file
+func ReadFrames(filePath string) ([]Frame, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []Frame
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for decoder.More() {
+		var frame Frame
+		if err := decoder.Decode(&frame); err != nil {
+			return nil, fmt.Errorf("invalid capture frame %d: %v", len(frames)+1, err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
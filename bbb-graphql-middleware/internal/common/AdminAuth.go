@@ -0,0 +1,19 @@
+package common
+
+import (
+	"bbb-graphql-middleware/config"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// IsAdminRequest checks the admin token of the request (header `Authorization: Bearer <token>`)
+// The admin endpoints are disabled when admin.token is not set
+func IsAdminRequest(r *http.Request) bool {
+	adminToken := config.GetConfig().Admin.Token
+	if adminToken == "" {
+		return false
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"encoding/json"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// SendErrorMessage returns the error of the operation to the browser, and completes it
+func SendErrorMessage(browserConnection *BrowserConnection, messageId string, errorMessage string) {
+	browserConnection.Logger.Error(errorMessage)
+
+	browserResponseData := map[string]interface{}{
+		"id":   messageId,
+		"type": "error",
+		"payload": []interface{}{
+			map[string]interface{}{
+				"message": errorMessage,
+			},
+		},
+	}
+	jsonDataError, _ := json.Marshal(browserResponseData)
+	browserConnection.FromHasuraToBrowserChannel.Send(jsonDataError)
+
+	sendCompleteMessage(browserConnection, messageId)
+}
+
+// SendValidationErrors returns the errors found validating the operation against the Hasura schema, and completes it
+func SendValidationErrors(browserConnection *BrowserConnection, messageId string, operationName string, validationErrors []gqlerrors.FormattedError) {
+	browserConnection.Logger.Errorf("Operation %s is not valid against the Hasura schema: %s", operationName, validationErrors[0].Message)
+
+	browserResponseData := map[string]interface{}{
+		"id":      messageId,
+		"type":    "error",
+		"payload": validationErrors,
+	}
+	jsonDataError, _ := json.Marshal(browserResponseData)
+	browserConnection.FromHasuraToBrowserChannel.Send(jsonDataError)
+
+	sendCompleteMessage(browserConnection, messageId)
+}
+
+func sendCompleteMessage(browserConnection *BrowserConnection, messageId string) {
+	browserResponseComplete := map[string]interface{}{
+		"id":   messageId,
+		"type": "complete",
+	}
+	jsonDataComplete, _ := json.Marshal(browserResponseComplete)
+	browserConnection.FromHasuraToBrowserChannel.Send(jsonDataComplete)
+}
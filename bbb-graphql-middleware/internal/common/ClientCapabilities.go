@@ -0,0 +1,106 @@
+package common
+
+import (
+	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/msgencoding"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const PatchFormatJsonPatch = "json-patch"
+
+// ClientCapabilities are the features declared by the client in `connection_init` (payload.capabilities),
+// the accepted ones are echoed in `connection_ack`
+type ClientCapabilities struct {
+	PatchFormats []string `json:"patchFormats,omitempty"` // formats of the data updates (json-patch)
+	Compression  []string `json:"compression,omitempty"`  // websocket compressions (permessage-deflate)
+	Coalescing   bool     `json:"coalescing,omitempty"`   // merge of updates of the same subscription (not supported yet)
+	Resume       bool     `json:"resume,omitempty"`       // session resume after a reconnect (X-Resume-Token)
+	Encodings    []string `json:"encodings,omitempty"`    // binary encodings of the frames (msgpack or cbor)
+}
+
+var jsonPatchDisabled = config.GetConfig().Server.JsonPatchDisabled
+
+// ParseClientCapabilities reads the capabilities of the connection_init payload, false when the client didn't declare them
+// (legacy clients, which opt in features ad hoc, e.g. `Patched_` operation names)
+func ParseClientCapabilities(initPayload map[string]interface{}) (ClientCapabilities, bool) {
+	capabilitiesAsMap, exists := initPayload["capabilities"].(map[string]interface{})
+	if !exists {
+		return ClientCapabilities{}, false
+	}
+
+	return ClientCapabilities{
+		PatchFormats: stringsFromInterface(capabilitiesAsMap["patchFormats"]),
+		Compression:  stringsFromInterface(capabilitiesAsMap["compression"]),
+		Coalescing:   capabilitiesAsMap["coalescing"] == true,
+		Resume:       capabilitiesAsMap["resume"] == true,
+		Encodings:    stringsFromInterface(capabilitiesAsMap["encodings"]),
+	}, true
+}
+
+// AcceptClientCapabilities returns the capabilities requested that are supported by this connection
+// (enabled in the config and, for compression and encodings, negotiated in the websocket handshake)
+func AcceptClientCapabilities(browserConnection *BrowserConnection, requested ClientCapabilities, resumeEnabled bool) ClientCapabilities {
+	var accepted ClientCapabilities
+
+	for _, patchFormat := range requested.PatchFormats {
+		if patchFormat == PatchFormatJsonPatch && !jsonPatchDisabled {
+			accepted.PatchFormats = []string{PatchFormatJsonPatch}
+		}
+	}
+	for _, compression := range requested.Compression {
+		if compression == browserConnection.WebsocketCompression {
+			accepted.Compression = []string{compression}
+		}
+	}
+	for _, encoding := range requested.Encodings {
+		if encoding != string(msgencoding.JSON) && encoding == string(browserConnection.WireEncoding) {
+			accepted.Encodings = []string{encoding}
+		}
+	}
+	accepted.Resume = requested.Resume && resumeEnabled
+
+	for _, capability := range accepted.names() {
+		ClientCapabilityAcceptedCounter.With(prometheus.Labels{"capability": capability}).Inc()
+	}
+	return accepted
+}
+
+// SupportsPatchFormat indicates if the format was negotiated for the subscriptions of this connection
+func (c *ClientCapabilities) SupportsPatchFormat(patchFormat string) bool {
+	for _, accepted := range c.PatchFormats {
+		if accepted == patchFormat {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ClientCapabilities) names() []string {
+	var names []string
+	names = append(names, c.PatchFormats...)
+	names = append(names, c.Compression...)
+	if c.Coalescing {
+		names = append(names, "coalescing")
+	}
+	if c.Resume {
+		names = append(names, "resume")
+	}
+	names = append(names, c.Encodings...)
+	return names
+}
+
+func stringsFromInterface(value interface{}) []string {
+	valuesAsInterface, isSlice := value.([]interface{})
+	if !isSlice {
+		return nil
+	}
+
+	values := make([]string, 0, len(valuesAsInterface))
+	for _, valueAsInterface := range valuesAsInterface {
+		if value, isString := valueAsInterface.(string); isString {
+			values = append(values, value)
+		}
+	}
+	return values
+}
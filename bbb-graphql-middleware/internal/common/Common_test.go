@@ -0,0 +1,17 @@
+package common
+
+import (
+	"bbb-graphql-middleware/internal/testsupport"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if reexecuted, exitCode := testsupport.RunWithTestConfig("../../config/config.yml", map[string]interface{}{
+		"log_level": "warn",
+	}); reexecuted {
+		os.Exit(exitCode)
+	}
+
+	os.Exit(m.Run())
+}
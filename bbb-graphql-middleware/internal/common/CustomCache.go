@@ -4,8 +4,6 @@ import (
 	"sync"
 )
 
-var GlobalCacheLocks = NewCacheLocks()
-
 type refMutex struct {
 	mutex    *sync.Mutex
 	refCount int
@@ -9,7 +9,7 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func ValidateIfShouldUseCustomJsonPatch(original []byte, modified []byte, idFieldName string) (bool, []byte) {
+func ValidateIfShouldUseCustomJsonPatch(original []byte, modified []byte, idFieldName string, operationName string) (bool, []byte) {
 	//Temporarily use CustomPatch only for UserList (testing feature)
 	if !bytes.Contains(modified, []byte("\"__typename\":\"user\"}]")) {
 		return false, nil
@@ -53,7 +53,11 @@ func ValidateIfShouldUseCustomJsonPatch(original []byte, modified []byte, idFiel
 		return false, nil
 	}
 
-	return true, CreateJsonPatchFromMaps(originalMap, modifiedMap, modified, "userId")
+	patch, recreated := CreateJsonPatchFromMaps(originalMap, modifiedMap, modified, "userId")
+	if !recreated {
+		JsonPatchCustomPatcherFallback(operationName)
+	}
+	return true, patch
 }
 
 func hasDuplicatedId(items []map[string]interface{}, idFieldName string) bool {
@@ -73,10 +77,13 @@ func CreateJsonPatch(original []byte, modified []byte, idFieldName string) []byt
 	originalMap := GetMapFromByte(original)
 	modifiedMap := GetMapFromByte(modified)
 
-	return CreateJsonPatchFromMaps(originalMap, modifiedMap, modified, idFieldName)
+	patch, _ := CreateJsonPatchFromMaps(originalMap, modifiedMap, modified, idFieldName)
+	return patch
 }
 
-func CreateJsonPatchFromMaps(original []map[string]interface{}, modified []map[string]interface{}, modifiedJson []byte, idFieldName string) []byte {
+// CreateJsonPatchFromMaps returns the patch and false when it was not able to recreate the data (so the patch
+// was created using the generic json-patch)
+func CreateJsonPatchFromMaps(original []map[string]interface{}, modified []map[string]interface{}, modifiedJson []byte, idFieldName string) ([]byte, bool) {
 	//CREATE PATCHES FOR OPERATION "REPLACE"
 	replacesPatches, originalWithReplaces := CreateReplacePatches(original, modified, idFieldName)
 
@@ -88,7 +95,7 @@ func CreateJsonPatchFromMaps(original []map[string]interface{}, modified []map[s
 
 	originalWithPatches, _ := ApplyPatch(original, mergedPatchJson)
 	if evanphxjsonpatch.Equal(originalWithPatches, modifiedJson) {
-		return mergedPatchJson
+		return mergedPatchJson, true
 	}
 
 	//CREATE PATCHES FOR OPERATION "MOVE"
@@ -97,12 +104,12 @@ func CreateJsonPatchFromMaps(original []map[string]interface{}, modified []map[s
 
 	originalWithPatches, _ = ApplyPatch(original, mergedPatchJson)
 	if evanphxjsonpatch.Equal(originalWithPatches, modifiedJson) {
-		return mergedPatchJson
+		return mergedPatchJson, true
 	} else {
 		log.Error("It was not able to recreate the target data using the patch: ", string(mergedPatchJson))
 		alternativePatch := PatchUsingMattbairdJsonpatch(original, modified)
 		alternativePatchJson, _ := json.Marshal(alternativePatch)
-		return alternativePatchJson
+		return alternativePatchJson, false
 	}
 }
 
@@ -0,0 +1,58 @@
+package common
+
+import (
+	"bbb-graphql-middleware/internal/msgencoding"
+	"context"
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+	"nhooyr.io/websocket"
+)
+
+// DisconnectWithError sends the reason to the browser (id -1) and closes the connection with the status code
+func DisconnectWithError(
+	browserConnectionWs *websocket.Conn,
+	browserConnectionContext context.Context,
+	browserConnectionContextCancel context.CancelFunc,
+	wsCloseStatusCode websocket.StatusCode,
+	reasonMessageId string,
+	reasonMessage string,
+	logger *logrus.Entry) {
+
+	//Chromium-based browsers can't read websocket close code/reason, so it will send this message before closing conn
+	browserResponseData := map[string]interface{}{
+		"id":   "-1", //The client recognizes this message ID as a signal to terminate the session
+		"type": "error",
+		"payload": []interface{}{
+			map[string]interface{}{
+				"messageId": reasonMessageId,
+				"message":   reasonMessage,
+			},
+		},
+	}
+	jsonData, _ := json.Marshal(browserResponseData)
+
+	logger.Tracef("sending to browser: %s", string(jsonData))
+	logger.Infof("deliberately disconnecting browser with error, reason: %s (%s)", reasonMessage, reasonMessageId)
+
+	wireEncoding := msgencoding.FromSubprotocol(browserConnectionWs.Subprotocol())
+	wsMessageType := websocket.MessageText
+	if wireEncoding != msgencoding.JSON {
+		wsMessageType = websocket.MessageBinary
+		jsonData, _ = msgencoding.FromJSON(jsonData, wireEncoding)
+	}
+
+	err := browserConnectionWs.Write(browserConnectionContext, wsMessageType, jsonData)
+	if err != nil {
+		logger.Debugf("Browser is disconnected, skipping writing of ws message: %v", err)
+	}
+
+	errCloseWs := browserConnectionWs.Close(wsCloseStatusCode, reasonMessage)
+	if errCloseWs != nil {
+		logger.Debugf("Error on close websocket: %v", errCloseWs)
+	}
+
+	browserConnectionContextCancel()
+
+	return
+}
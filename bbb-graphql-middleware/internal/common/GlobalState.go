@@ -4,6 +4,7 @@ import (
 	"bbb-graphql-middleware/config"
 	"github.com/google/uuid"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,96 +18,87 @@ func GetUniqueID() string {
 	return uniqueID
 }
 
-var PatchedMessageCache = make(map[uint32][]byte)
-var PatchedMessageCacheMutex sync.RWMutex
-
-func GetPatchedMessageCache(cacheKey uint32) ([]byte, bool) {
-	PatchedMessageCacheMutex.RLock()
-	defer PatchedMessageCacheMutex.RUnlock()
-
-	jsonDiffPatch, jsonDiffPatchExists := PatchedMessageCache[cacheKey]
-	return jsonDiffPatch, jsonDiffPatchExists
-}
-
-func StorePatchedMessageCache(cacheKey uint32, data []byte) {
-	PatchedMessageCacheMutex.Lock()
-	defer PatchedMessageCacheMutex.Unlock()
-
-	PatchedMessageCache[cacheKey] = data
-
-	//Remove the cache after 30 seconds
-	go RemovePatchedMessageCache(cacheKey, 30)
-}
-
-func RemovePatchedMessageCache(cacheKey uint32, delayInSecs time.Duration) {
-	time.Sleep(delayInSecs * time.Second)
-
-	PatchedMessageCacheMutex.Lock()
-	defer PatchedMessageCacheMutex.Unlock()
-	delete(PatchedMessageCache, cacheKey)
-}
-
-var HasuraMessageCache = make(map[uint32]HasuraMessage)
-var HasuraMessageKeyCache = make(map[uint32]string)
-var HasuraMessageCacheMutex sync.RWMutex
-
-func GetHasuraMessageCache(cacheKey uint32) (string, HasuraMessage, bool) {
-	HasuraMessageCacheMutex.RLock()
-	defer HasuraMessageCacheMutex.RUnlock()
-
-	hasuraMessageDataKey, _ := HasuraMessageKeyCache[cacheKey]
-	hasuraMessage, hasuraMessageExists := HasuraMessageCache[cacheKey]
-	return hasuraMessageDataKey, hasuraMessage, hasuraMessageExists
-}
-
-func StoreHasuraMessageCache(cacheKey uint32, dataKey string, hasuraMessage HasuraMessage) {
-	HasuraMessageCacheMutex.Lock()
-	defer HasuraMessageCacheMutex.Unlock()
-
-	HasuraMessageKeyCache[cacheKey] = dataKey
-	HasuraMessageCache[cacheKey] = hasuraMessage
-
-	//Remove the cache after 30 seconds
-	go RemoveHasuraMessageCache(cacheKey, 30)
-}
-
-func RemoveHasuraMessageCache(cacheKey uint32, delayInSecs time.Duration) {
-	time.Sleep(delayInSecs * time.Second)
-
-	HasuraMessageCacheMutex.Lock()
-	defer HasuraMessageCacheMutex.Unlock()
-	delete(HasuraMessageKeyCache, cacheKey)
-	delete(HasuraMessageCache, cacheKey)
-}
-
-var StreamCursorValueCache = make(map[uint32]interface{})
-var StreamCursorValueCacheMutex sync.RWMutex
-
-func GetStreamCursorValueCache(cacheKey uint32) (interface{}, bool) {
-	StreamCursorValueCacheMutex.RLock()
-	defer StreamCursorValueCacheMutex.RUnlock()
-
-	streamCursorValue, streamCursorValueExists := StreamCursorValueCache[cacheKey]
-	return streamCursorValue, streamCursorValueExists
-}
-
-func StoreStreamCursorValueCache(cacheKey uint32, streamCursorValue interface{}) {
-	StreamCursorValueCacheMutex.Lock()
-	defer StreamCursorValueCacheMutex.Unlock()
-
-	StreamCursorValueCache[cacheKey] = streamCursorValue
-
-	//Remove the cache after 30 seconds
-	go RemoveStreamCursorValueCache(cacheKey, 30)
-}
-
-func RemoveStreamCursorValueCache(cacheKey uint32, delayInSecs time.Duration) {
-	time.Sleep(delayInSecs * time.Second)
-
-	StreamCursorValueCacheMutex.Lock()
-	defer StreamCursorValueCacheMutex.Unlock()
-	delete(StreamCursorValueCache, cacheKey)
-}
+var cacheConfig = config.GetConfig().Cache
+
+// PatchedMessageCache stores the message (patched or not) to be sent to the browser, by previous and current data checksum
+var PatchedMessageCache = NewShardedTTLCache[[]byte](
+	"patched_message",
+	cacheConfig.Shards,
+	cacheConfig.MaxEntries,
+	cacheConfig.MaxBytes,
+	time.Duration(cacheConfig.TtlSeconds)*time.Second,
+	func(message []byte) int {
+		return len(message)
+	})
+
+type CachedHasuraMessage struct {
+	DataKey string
+	Message HasuraMessage
+}
+
+// HasuraMessageCache stores the parsed message received from Hasura, by data checksum
+var HasuraMessageCache = NewShardedTTLCache[CachedHasuraMessage](
+	"hasura_message",
+	cacheConfig.Shards,
+	cacheConfig.MaxEntries,
+	cacheConfig.MaxBytes,
+	time.Duration(cacheConfig.TtlSeconds)*time.Second,
+	func(cached CachedHasuraMessage) int {
+		size := len(cached.DataKey) + len(cached.Message.ID) + len(cached.Message.Type)
+		for key, data := range cached.Message.Payload.Data {
+			size += len(key) + len(data)
+		}
+		return size
+	})
+
+// StreamCursorValueCache stores the last cursor values found in a stream message, by data checksum and cursors
+var StreamCursorValueCache = NewShardedTTLCache[StreamCursorValues](
+	"stream_cursor_value",
+	cacheConfig.Shards,
+	cacheConfig.MaxEntries,
+	cacheConfig.MaxBytes,
+	time.Duration(cacheConfig.TtlSeconds)*time.Second,
+	func(streamCursorValues StreamCursorValues) int {
+		size := 0
+		for responseKey, values := range streamCursorValues {
+			size += len(responseKey)
+			for fieldName, value := range values {
+				size += len(fieldName)
+				if asString, isString := value.(string); isString {
+					size += len(asString)
+				} else {
+					size += 8
+				}
+			}
+		}
+		return size
+	})
+
+// OperationInfoCache stores the classification of the operations, by query and operationName checksum
+var OperationInfoCache = NewShardedTTLCache[OperationInfo](
+	"operation_info",
+	cacheConfig.Shards,
+	cacheConfig.MaxEntries,
+	cacheConfig.MaxBytes,
+	time.Duration(cacheConfig.TtlSeconds)*time.Second,
+	func(operationInfo OperationInfo) int {
+		size := len(operationInfo.query) + len(operationInfo.requestedName) + len(operationInfo.OperationName)
+		for _, rootField := range operationInfo.RootFields {
+			size += len(rootField)
+		}
+		return size
+	})
+
+// QueryCostCache stores the cost of the operations, by query, operationName and variables checksum
+var QueryCostCache = NewShardedTTLCache[cachedQueryCost](
+	"query_cost",
+	cacheConfig.Shards,
+	cacheConfig.MaxEntries,
+	cacheConfig.MaxBytes,
+	time.Duration(cacheConfig.TtlSeconds)*time.Second,
+	func(cached cachedQueryCost) int {
+		return len(cached.query) + len(cached.requestedName) + len(cached.variables)
+	})
 
 var MaxConnPerSessionToken = config.GetConfig().Server.MaxConnectionsPerSessionToken
 var MaxConnGlobal = config.GetConfig().Server.MaxConnections
@@ -128,7 +120,14 @@ func HasReachedMaxGlobalConnections() bool {
 		return true
 	}
 
-	return GlobalConnectionsCount >= GetMaxConnectionsGlobal()
+	return GetGlobalConnectionsCount() >= GetMaxConnectionsGlobal()
+}
+
+func GetGlobalConnectionsCount() int {
+	UserConnectionsCountMutex.RLock()
+	defer UserConnectionsCountMutex.RUnlock()
+
+	return GlobalConnectionsCount
 }
 
 func GetUserConnectionCount(sessionToken string) (int, bool) {
@@ -167,3 +166,14 @@ func RemoveUserConnection(sessionToken string) {
 		delete(UserConnectionsCount, sessionToken)
 	}
 }
+
+// draining indicates new browser connections are refused (e.g. before a restart), set through /admin/drain
+var draining atomic.Bool
+
+func SetDraining(value bool) {
+	draining.Store(value)
+}
+
+func IsDraining() bool {
+	return draining.Load()
+}
@@ -1,91 +1,136 @@
 package common
 
 import (
-	"encoding/json"
-	"fmt"
-	log "github.com/sirupsen/logrus"
-	"strings"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
 	"sync"
 	"time"
 )
 
-type JsonPatchBenchmarkingObj struct {
-	Started           time.Time
-	Count             int64
-	Completed         time.Time
-	TotalDuration     time.Duration
-	TotalDurationSecs float64
+// JsonPatchStats is the json-patch benchmarking of an operation, shown by the /debug/jsonpatch endpoint
+type JsonPatchStats struct {
+	OperationName          string  `json:"operationName"`
+	Messages               int64   `json:"messages"`               // data messages of subscriptions supporting json-patch
+	Patches                int64   `json:"patches"`                // messages sent as patch (instead of the full data)
+	CacheHits              int64   `json:"cacheHits"`              // messages whose result was already in the cache
+	CacheHitRate           float64 `json:"cacheHitRate"`           // cacheHits / messages
+	Generations            int64   `json:"generations"`            // patches created (cache misses with previous data)
+	TotalDurationMs        float64 `json:"totalDurationMs"`        // time spent creating the patches
+	MaxDurationMs          float64 `json:"maxDurationMs"`          // longest time to create a patch
+	DataBytes              int64   `json:"dataBytes"`              // size of the data of the patches created
+	PatchBytes             int64   `json:"patchBytes"`             // size of the patches created
+	SizeRatio              float64 `json:"sizeRatio"`              // patchBytes / dataBytes
+	CustomPatcherFallbacks int64   `json:"customPatcherFallbacks"` // custom patcher not able to recreate the data
 }
 
-var JsonPatchBenchmarkingEnabled = false
-var JsonPatchBenchmarking = make(map[string]JsonPatchBenchmarkingObj)
-var JsonPatchBenchmarkingMux = sync.Mutex{}
+var jsonPatchStats = make(map[string]*JsonPatchStats)
+var jsonPatchStatsMutex sync.Mutex
 
-func JsonPatchBenchmarkingStarted(index string) {
-	if !JsonPatchBenchmarkingEnabled {
-		return
+func getJsonPatchStats(operationName string) *JsonPatchStats {
+	stats, exists := jsonPatchStats[operationName]
+	if !exists {
+		stats = &JsonPatchStats{OperationName: operationName}
+		jsonPatchStats[operationName] = stats
 	}
+	return stats
+}
 
-	JsonPatchBenchmarkingMux.Lock()
-	defer JsonPatchBenchmarkingMux.Unlock()
-
-	if _, exists := JsonPatchBenchmarking[index]; !exists {
-		JsonPatchBenchmarking[index] = JsonPatchBenchmarkingObj{
-			Started: time.Now(),
-			Count:   0,
-		}
+// JsonPatchMessageProcessed records a data message of the operation, if it was found in the cache and sent as patch
+func JsonPatchMessageProcessed(operationName string, cacheHit bool, patched bool) {
+	cacheResult := "miss"
+	if cacheHit {
+		cacheResult = "hit"
 	}
+	GqlPatchCacheRequestsCounter.With(prometheus.Labels{"operationName": operationName, "result": cacheResult}).Inc()
 
-	updatedValues := JsonPatchBenchmarking[index]
-	updatedValues.Count++
-
-	JsonPatchBenchmarking[index] = updatedValues
+	patchResult := "full"
+	if patched {
+		patchResult = "patch"
+	}
+	GqlPatchCounter.With(prometheus.Labels{"operationName": operationName, "result": patchResult}).Inc()
+
+	jsonPatchStatsMutex.Lock()
+	defer jsonPatchStatsMutex.Unlock()
+	stats := getJsonPatchStats(operationName)
+	stats.Messages++
+	if cacheHit {
+		stats.CacheHits++
+	}
+	if patched {
+		stats.Patches++
+	}
 }
 
-func JsonPatchBenchmarkingCompleted(index string) {
-	if !JsonPatchBenchmarkingEnabled {
-		return
+// JsonPatchGenerated records the time spent creating the patch of the operation
+// and its size compared to the data (patchSize is 0 when the patch was not created, e.g. small data)
+func JsonPatchGenerated(operationName string, duration time.Duration, dataSize int, patchSize int) {
+	GqlPatchDuration.With(prometheus.Labels{"operationName": operationName}).Observe(duration.Seconds())
+	if patchSize > 0 && dataSize > 0 {
+		GqlPatchSizeRatio.With(prometheus.Labels{"operationName": operationName}).Observe(float64(patchSize) / float64(dataSize))
 	}
 
-	JsonPatchBenchmarkingMux.Lock()
-	defer JsonPatchBenchmarkingMux.Unlock()
-
-	if updatedValues, exists := JsonPatchBenchmarking[index]; exists {
-		updatedValues.Completed = time.Now()
-		updatedValues.TotalDurationSecs = time.Since(updatedValues.Started).Seconds()
-		updatedValues.TotalDuration = time.Since(updatedValues.Started)
-
-		JsonPatchBenchmarking[index] = updatedValues
+	jsonPatchStatsMutex.Lock()
+	defer jsonPatchStatsMutex.Unlock()
+	stats := getJsonPatchStats(operationName)
+	stats.Generations++
+	durationMs := float64(duration.Microseconds()) / 1000
+	stats.TotalDurationMs += durationMs
+	if durationMs > stats.MaxDurationMs {
+		stats.MaxDurationMs = durationMs
+	}
+	if patchSize > 0 {
+		stats.DataBytes += int64(dataSize)
+		stats.PatchBytes += int64(patchSize)
 	}
 }
 
-func JsonPatchBenchmarkingLogRoutine() {
-	JsonPatchBenchmarkingEnabled = true
-	log.Info("Activities Overview routine started!")
+// JsonPatchCustomPatcherFallback records the custom patcher was not able to recreate the data of the operation
+// (and the generic json-patch was used instead)
+func JsonPatchCustomPatcherFallback(operationName string) {
+	GqlPatchCustomFallbackCounter.With(prometheus.Labels{"operationName": operationName}).Inc()
+
+	jsonPatchStatsMutex.Lock()
+	defer jsonPatchStatsMutex.Unlock()
+	getJsonPatchStats(operationName).CustomPatcherFallbacks++
+}
 
-	for {
-		time.Sleep(5 * time.Second)
-		fmt.Println("===================================================")
+// GetJsonPatchTopOffenders returns the stats of the operations with the highest value of sortBy
+// (duration, messages, ratio or fallbacks)
+func GetJsonPatchTopOffenders(sortBy string, limit int) []JsonPatchStats {
+	jsonPatchStatsMutex.Lock()
+	result := make([]JsonPatchStats, 0, len(jsonPatchStats))
+	for _, stats := range jsonPatchStats {
+		result = append(result, *stats)
+	}
+	jsonPatchStatsMutex.Unlock()
 
-		//hasuraConnections := GetJsonPatchBenchmarking()["__HasuraConnection"].Started
-		topMessages := make(map[string]JsonPatchBenchmarkingObj)
-		JsonPatchBenchmarkingMux.Lock()
-		for index, item := range JsonPatchBenchmarking {
-			if strings.HasPrefix(index, "_") ||
-				item.Count > 380 ||
-				item.TotalDurationSecs > 1 {
-				topMessages[index] = item
-			}
+	for i := range result {
+		if result[i].Messages > 0 {
+			result[i].CacheHitRate = float64(result[i].CacheHits) / float64(result[i].Messages)
+		}
+		if result[i].DataBytes > 0 {
+			result[i].SizeRatio = float64(result[i].PatchBytes) / float64(result[i].DataBytes)
 		}
-		JsonPatchBenchmarkingMux.Unlock()
+	}
 
-		jsonIndentOverviewBytes, err := json.MarshalIndent(topMessages, "", "   ")
-		if err != nil {
-			log.Errorf("Error occurred during marshaling. Error: %s", err.Error())
+	value := func(stats JsonPatchStats) float64 {
+		switch sortBy {
+		case "messages":
+			return float64(stats.Messages)
+		case "ratio":
+			return stats.SizeRatio
+		case "fallbacks":
+			return float64(stats.CustomPatcherFallbacks)
+		default:
+			return stats.TotalDurationMs
 		}
-		fmt.Println(string(jsonIndentOverviewBytes))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return value(result[i]) > value(result[j])
+	})
 
-		jsonOverviewBytes, err := json.Marshal(topMessages)
-		fmt.Println(string(jsonOverviewBytes))
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
 	}
+	return result
 }
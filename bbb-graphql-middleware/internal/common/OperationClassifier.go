@@ -0,0 +1,176 @@
+package common
+
+import (
+	"fmt"
+	"github.com/graphql-go/graphql/language/ast"
+	"hash/crc32"
+	"strings"
+)
+
+// OperationInfo is the classification of a GraphQL operation, obtained from its parsed document
+type OperationInfo struct {
+	Type          QueryType
+	OperationName string   // name of the operation selected (by operationName or the only one in the document)
+	RootFields    []string // root fields of the operation (including the ones selected through fragments)
+	Error         error    // the document is invalid or the operation couldn't be selected
+	query         string
+	requestedName string
+}
+
+func (o OperationInfo) IsSubscription() bool {
+	return o.Type == Subscription || o.Type == Streaming || o.Type == SubscriptionAggregate
+}
+
+// ClassifyOperation parses the query and identifies the type of the operation chosen by operationName
+// The result is cached by query, as most of the clients send the same documents
+func ClassifyOperation(query string, operationName string) OperationInfo {
+	cacheKey := crc32.ChecksumIEEE([]byte(operationName + "\n" + query))
+
+	operationInfo := OperationInfoCache.GetOrCompute(cacheKey, func() OperationInfo {
+		return classifyOperation(query, operationName)
+	})
+
+	//Avoid using a wrong classification in case of checksum collision
+	if operationInfo.query != query || operationInfo.requestedName != operationName {
+		return classifyOperation(query, operationName)
+	}
+
+	return operationInfo
+}
+
+func classifyOperation(query string, operationName string) OperationInfo {
+	operationInfo := OperationInfo{
+		query:         query,
+		requestedName: operationName,
+	}
+
+	astDoc, err := ParseGraphQlQuery(query)
+	if err != nil {
+		operationInfo.Error = err
+		return operationInfo
+	}
+
+	op, err := SelectOperationDefinition(astDoc, operationName)
+	if err != nil {
+		operationInfo.Error = err
+		return operationInfo
+	}
+
+	if op.Name != nil {
+		operationInfo.OperationName = op.Name.Value
+	}
+
+	rootFields := CollectRootFields(astDoc, op)
+	for _, field := range rootFields {
+		operationInfo.RootFields = append(operationInfo.RootFields, field.Name.Value)
+	}
+
+	switch op.Operation {
+	case ast.OperationTypeMutation:
+		operationInfo.Type = Mutation
+	case ast.OperationTypeSubscription:
+		operationInfo.Type = Subscription
+		for _, field := range rootFields {
+			if isStreamedField(field) {
+				operationInfo.Type = Streaming
+				break
+			}
+			if isAggregateField(field) {
+				operationInfo.Type = SubscriptionAggregate
+			}
+		}
+	default:
+		operationInfo.Type = Query
+	}
+
+	return operationInfo
+}
+
+// SelectOperationDefinition returns the operation named operationName
+// When operationName is empty, the document must contain only one operation
+func SelectOperationDefinition(astDoc *ast.Document, operationName string) (*ast.OperationDefinition, error) {
+	var selectedOp *ast.OperationDefinition
+	numOfOperations := 0
+
+	for _, def := range astDoc.Definitions {
+		if op, ok := def.(*ast.OperationDefinition); ok {
+			numOfOperations++
+			if operationName == "" {
+				if selectedOp == nil {
+					selectedOp = op
+				}
+			} else if op.Name != nil && op.Name.Value == operationName {
+				selectedOp = op
+			}
+		}
+	}
+
+	if selectedOp == nil {
+		if operationName == "" {
+			return nil, fmt.Errorf("document does not contain any operation")
+		}
+		return nil, fmt.Errorf("unknown operation named \"%s\"", operationName)
+	}
+
+	if operationName == "" && numOfOperations > 1 {
+		return nil, fmt.Errorf("must provide operation name if query contains multiple operations")
+	}
+
+	return selectedOp, nil
+}
+
+// CollectRootFields returns the root fields of the operation, resolving fragment spreads and inline fragments
+func CollectRootFields(astDoc *ast.Document, op *ast.OperationDefinition) []*ast.Field {
+	return collectFields(op.SelectionSet, GetFragmentDefinitions(astDoc), make(map[string]bool))
+}
+
+func GetFragmentDefinitions(astDoc *ast.Document) map[string]*ast.FragmentDefinition {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, def := range astDoc.Definitions {
+		if fragment, ok := def.(*ast.FragmentDefinition); ok && fragment.Name != nil {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+	return fragments
+}
+
+func collectFields(selectionSet *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, visitedFragments map[string]bool) []*ast.Field {
+	var fields []*ast.Field
+	if selectionSet == nil {
+		return fields
+	}
+
+	for _, selection := range selectionSet.Selections {
+		switch sel := selection.(type) {
+		case *ast.Field:
+			fields = append(fields, sel)
+		case *ast.InlineFragment:
+			fields = append(fields, collectFields(sel.SelectionSet, fragments, visitedFragments)...)
+		case *ast.FragmentSpread:
+			fragmentName := sel.Name.Value
+			if fragment, exists := fragments[fragmentName]; exists && !visitedFragments[fragmentName] {
+				visitedFragments[fragmentName] = true
+				fields = append(fields, collectFields(fragment.SelectionSet, fragments, visitedFragments)...)
+			}
+		}
+	}
+
+	return fields
+}
+
+func isStreamedField(field *ast.Field) bool {
+	return strings.HasSuffix(field.Name.Value, "_stream") && getFieldArgument(field, "cursor") != nil
+}
+
+func isAggregateField(field *ast.Field) bool {
+	if !strings.HasSuffix(field.Name.Value, "_aggregate") || field.SelectionSet == nil {
+		return false
+	}
+
+	for _, selection := range field.SelectionSet.Selections {
+		if subField, ok := selection.(*ast.Field); ok && subField.Name.Value == "aggregate" {
+			return true
+		}
+	}
+	return false
+}
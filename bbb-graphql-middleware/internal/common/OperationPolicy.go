@@ -0,0 +1,187 @@
+package common
+
+import (
+	"bbb-graphql-middleware/config"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type PolicyEffect string
+
+const (
+	PolicyAllow PolicyEffect = "allow"
+	PolicyDeny  PolicyEffect = "deny"
+	PolicyHold  PolicyEffect = "hold"
+)
+
+// PolicyDecision is the result of the policy for an operation of a connection
+type PolicyDecision struct {
+	Effect PolicyEffect
+	Rule   string // name of the rule that matched (empty when the default effect was applied)
+	Reason string
+}
+
+type policyRule struct {
+	name             string
+	effect           PolicyEffect
+	reason           string
+	operations       []*policyPattern
+	exceptOperations []*policyPattern
+	operationTypes   []string
+	roles            []*policyPattern
+	clientTypes      []*policyPattern
+	mobile           *bool
+	inMeeting        *bool
+}
+
+// policyPattern is a glob, or a regex when it's between slashes
+type policyPattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+var policyRules []policyRule
+var policyDefaultEffect = PolicyAllow
+
+func init() {
+	policyConfig := config.GetConfig().Policy
+	if policyConfig.DefaultEffect != "" {
+		policyDefaultEffect = parsePolicyEffect(policyConfig.DefaultEffect)
+	}
+
+	for i, ruleConfig := range policyConfig.Rules {
+		rule := policyRule{
+			name:             ruleConfig.Name,
+			effect:           parsePolicyEffect(ruleConfig.Effect),
+			reason:           ruleConfig.Reason,
+			operations:       compilePolicyPatterns(ruleConfig.Operations),
+			exceptOperations: compilePolicyPatterns(ruleConfig.ExceptOperations),
+			operationTypes:   ruleConfig.OperationTypes,
+			roles:            compilePolicyPatterns(ruleConfig.Roles),
+			clientTypes:      compilePolicyPatterns(ruleConfig.ClientTypes),
+			mobile:           ruleConfig.Mobile,
+			inMeeting:        ruleConfig.InMeeting,
+		}
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("rule_%d", i+1)
+		}
+		policyRules = append(policyRules, rule)
+	}
+}
+
+func parsePolicyEffect(effect string) PolicyEffect {
+	switch PolicyEffect(strings.ToLower(effect)) {
+	case PolicyAllow:
+		return PolicyAllow
+	case PolicyDeny:
+		return PolicyDeny
+	case PolicyHold:
+		return PolicyHold
+	}
+
+	log.Fatalf("Invalid policy effect %s (expected allow, deny or hold)", effect)
+	return PolicyDeny
+}
+
+func compilePolicyPatterns(patterns []string) []*policyPattern {
+	var compiled []*policyPattern
+	for _, pattern := range patterns {
+		if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			regex, err := regexp.Compile(pattern[1 : len(pattern)-1])
+			if err != nil {
+				log.Fatalf("Invalid policy pattern %s: %v", pattern, err)
+			}
+			compiled = append(compiled, &policyPattern{regex: regex})
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Fatalf("Invalid policy pattern %s: %v", pattern, err)
+		}
+		compiled = append(compiled, &policyPattern{glob: pattern})
+	}
+	return compiled
+}
+
+func (p *policyPattern) matches(value string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(value)
+	}
+	matched, _ := path.Match(p.glob, value)
+	return matched
+}
+
+// matchesAny returns true when no pattern was informed (the condition is not used) or one of them matches
+func matchesAny(patterns []*policyPattern, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern.matches(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesOperationType(operationTypes []string, operation OperationInfo) bool {
+	if len(operationTypes) == 0 {
+		return true
+	}
+	for _, operationType := range operationTypes {
+		if operationType == string(operation.Type) ||
+			(operationType == string(Subscription) && operation.IsSubscription()) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUserInMeeting indicates if the user is currently in the meeting (based on the role provided by akka-apps)
+func IsUserInMeeting(bc *BrowserConnection) bool {
+	hasuraRole, exists := bc.BBBWebSessionVariables["x-hasura-role"]
+	return exists && hasuraRole == "bbb_client"
+}
+
+// EvaluateOperationPolicy returns the effect of the first rule of the policy that matches the operation and the connection
+func EvaluateOperationPolicy(bc *BrowserConnection, operation OperationInfo) PolicyDecision {
+	hasuraRole := bc.BBBWebSessionVariables["x-hasura-role"]
+	userInMeeting := IsUserInMeeting(bc)
+
+	bc.RLock()
+	clientType := bc.ClientType
+	clientIsMobile := bc.ClientIsMobile
+	bc.RUnlock()
+
+	decision := PolicyDecision{Effect: policyDefaultEffect}
+	for _, rule := range policyRules {
+		if !matchesOperationType(rule.operationTypes, operation) ||
+			!matchesAny(rule.operations, operation.OperationName) ||
+			(len(rule.exceptOperations) > 0 && matchesAny(rule.exceptOperations, operation.OperationName)) ||
+			!matchesAny(rule.roles, hasuraRole) ||
+			!matchesAny(rule.clientTypes, clientType) ||
+			(rule.mobile != nil && *rule.mobile != clientIsMobile) ||
+			(rule.inMeeting != nil && *rule.inMeeting != userInMeeting) {
+			continue
+		}
+
+		decision = PolicyDecision{
+			Effect: rule.effect,
+			Rule:   rule.name,
+			Reason: rule.reason,
+		}
+		break
+	}
+
+	if decision.Effect == PolicyDeny && decision.Reason == "" {
+		decision.Reason = "operation not allowed"
+	}
+
+	PolicyDecisionCounter.With(prometheus.Labels{"effect": string(decision.Effect), "rule": decision.Rule}).Inc()
+
+	return decision
+}
@@ -0,0 +1,35 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestEvaluateOperationPolicyExceptOperations(t *testing.T) {
+	previousRules, previousDefaultEffect := policyRules, policyDefaultEffect
+	t.Cleanup(func() { policyRules, policyDefaultEffect = previousRules, previousDefaultEffect })
+
+	//Rule translated from the deprecated server.subscriptions_allowed_list
+	policyRules = []policyRule{{
+		name:             "subscriptions_allowed_list",
+		effect:           PolicyDeny,
+		operationTypes:   []string{string(Subscription)},
+		exceptOperations: compilePolicyPatterns([]string{"getUsers"}),
+	}}
+	policyDefaultEffect = PolicyAllow
+
+	bc := &BrowserConnection{}
+	tests := []struct {
+		operation OperationInfo
+		expected  PolicyEffect
+	}{
+		{OperationInfo{Type: Subscription, OperationName: "getUsers"}, PolicyAllow},
+		{OperationInfo{Type: Subscription, OperationName: "getChat"}, PolicyDeny},
+		{OperationInfo{Type: Streaming, OperationName: "getChatStream"}, PolicyDeny},
+		{OperationInfo{Type: Query, OperationName: "getChat"}, PolicyAllow},
+	}
+	for _, test := range tests {
+		if decision := EvaluateOperationPolicy(bc, test.operation); decision.Effect != test.expected {
+			t.Errorf("%s %s: expected %s, got %s", test.operation.Type, test.operation.OperationName, test.expected, decision.Effect)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"bbb-graphql-middleware/config"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// FrameWriter writes complete websocket frames to the browser socket, bypassing the websocket library
+// Its writes are serialized with the ones of the library, so frames are never interleaved
+type FrameWriter interface {
+	WriteFrame(ctx context.Context, frame []byte) error
+}
+
+type PreCompressedFrame struct {
+	MessageType websocket.MessageType
+	Message     []byte
+	Frame       []byte
+}
+
+// PreCompressedFrameCache stores the permessage-deflate frames sent to browsers, by message checksum
+// Connections receiving the same message (e.g. the same user list) share the frame compressed once
+var PreCompressedFrameCache = NewShardedTTLCache[PreCompressedFrame](
+	"pre_compressed_frame",
+	cacheConfig.Shards,
+	cacheConfig.MaxEntries,
+	cacheConfig.MaxBytes,
+	time.Duration(cacheConfig.TtlSeconds)*time.Second,
+	func(frame PreCompressedFrame) int {
+		return len(frame.Message) + len(frame.Frame)
+	})
+
+// 512 is the threshold used by the websocket library for no_context_takeover
+var preCompressedFrameThreshold = newPreCompressedFrameThreshold()
+
+func newPreCompressedFrameThreshold() int {
+	if threshold := config.GetConfig().Server.WebsocketCompressionThreshold; threshold > 0 {
+		return threshold
+	}
+	return 512
+}
+
+var flateWriterPool = sync.Pool{
+	New: func() interface{} {
+		flateWriter, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return flateWriter
+	},
+}
+
+// WebsocketFrame returns the frame of a message sent to a browser using permessage-deflate without context takeover
+// Messages smaller than websocket_compression_threshold are not compressed
+func WebsocketFrame(messageType websocket.MessageType, message []byte) []byte {
+	if len(message) < preCompressedFrameThreshold {
+		return buildWebsocketFrame(messageType, false, message)
+	}
+
+	checksum := crc32.ChecksumIEEE(message)
+	cached := PreCompressedFrameCache.GetOrCompute(checksum, func() PreCompressedFrame {
+		return compressWebsocketFrame(messageType, message)
+	})
+	if cached.MessageType != messageType || !bytes.Equal(cached.Message, message) {
+		//Checksum collision, the message is compressed without being cached
+		return compressWebsocketFrame(messageType, message).Frame
+	}
+
+	return cached.Frame
+}
+
+func compressWebsocketFrame(messageType websocket.MessageType, message []byte) PreCompressedFrame {
+	var compressed bytes.Buffer
+	flateWriter := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(flateWriter)
+	flateWriter.Reset(&compressed)
+	_, _ = flateWriter.Write(message)
+	_ = flateWriter.Flush()
+
+	//The sync flush ends with 0x00 0x00 0xff 0xff, which permessage-deflate removes (RFC 7692 7.2.1)
+	payload := bytes.TrimSuffix(compressed.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+
+	return PreCompressedFrame{
+		MessageType: messageType,
+		Message:     message,
+		Frame:       buildWebsocketFrame(messageType, true, payload),
+	}
+}
+
+// buildWebsocketFrame returns a final (not fragmented) unmasked frame, as sent by servers
+func buildWebsocketFrame(messageType websocket.MessageType, compressed bool, payload []byte) []byte {
+	frame := make([]byte, 0, len(payload)+10)
+
+	firstByte := byte(0x80) | byte(messageType) //fin + opcode (text 1, binary 2)
+	if compressed {
+		firstByte |= 0x40 //rsv1 indicates a compressed message
+	}
+	frame = append(frame, firstByte)
+
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 65535:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	return append(frame, payload...)
+}
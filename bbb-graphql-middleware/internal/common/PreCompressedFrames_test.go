@@ -0,0 +1,96 @@
+package common
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"nhooyr.io/websocket"
+)
+
+// parseFrame returns the payload of a final unmasked frame (decompressed when rsv1 is set)
+func parseFrame(t *testing.T, frame []byte) (websocket.MessageType, []byte) {
+	t.Helper()
+
+	if frame[0]&0x80 == 0 || frame[1]&0x80 != 0 {
+		t.Fatalf("expected a final unmasked frame, got header %x", frame[:2])
+	}
+	messageType := websocket.MessageType(frame[0] & 0x0f)
+	compressed := frame[0]&0x40 != 0
+
+	payloadLength, payload := int(frame[1]), frame[2:]
+	switch payloadLength {
+	case 126:
+		payloadLength, payload = int(binary.BigEndian.Uint16(payload)), payload[2:]
+	case 127:
+		payloadLength, payload = int(binary.BigEndian.Uint64(payload)), payload[8:]
+	}
+	if payloadLength != len(payload) {
+		t.Fatalf("payload length %d, but the frame has %d bytes of payload", payloadLength, len(payload))
+	}
+
+	if !compressed {
+		return messageType, payload
+	}
+	reader := flate.NewReader(io.MultiReader(bytes.NewReader(payload), bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff})))
+	message, err := io.ReadAll(reader)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		t.Fatalf("failed to inflate the payload: %v", err)
+	}
+	return messageType, message
+}
+
+func TestWebsocketFrameSmallMessageIsNotCompressed(t *testing.T) {
+	message := []byte(`{"type":"next","id":"1","payload":{"data":{"user":[]}}}`)
+
+	frame := WebsocketFrame(websocket.MessageText, message)
+	if frame[0]&0x40 != 0 {
+		t.Fatalf("message smaller than the threshold was compressed")
+	}
+	messageType, payload := parseFrame(t, frame)
+	if messageType != websocket.MessageText || !bytes.Equal(payload, message) {
+		t.Fatalf("expected text frame with %s, got %v with %s", message, messageType, payload)
+	}
+}
+
+func TestWebsocketFrameIsCompressedOnceAndShared(t *testing.T) {
+	message := []byte(`{"type":"next","id":"1","payload":{"data":{"user":[` +
+		strings.Repeat(`{"userId":"u1","name":"User","color":"#0d47a1"},`, 100) + `{}]}}}`)
+
+	frame := WebsocketFrame(websocket.MessageText, message)
+	if frame[0]&0x40 == 0 {
+		t.Fatalf("message larger than the threshold was not compressed")
+	}
+	if len(frame) >= len(message) {
+		t.Errorf("compressed frame (%d bytes) is not smaller than the message (%d bytes)", len(frame), len(message))
+	}
+	messageType, payload := parseFrame(t, frame)
+	if messageType != websocket.MessageText || !bytes.Equal(payload, message) {
+		t.Fatalf("compressed frame doesn't contain the message")
+	}
+
+	// a copy of the message (as received by another connection) gets the same frame
+	sameFrame := WebsocketFrame(websocket.MessageText, append([]byte(nil), message...))
+	if &sameFrame[0] != &frame[0] {
+		t.Errorf("the frame was compressed again instead of shared")
+	}
+
+	// the same bytes sent as binary have their own frame
+	binaryFrame := WebsocketFrame(websocket.MessageBinary, message)
+	if messageType, payload := parseFrame(t, binaryFrame); messageType != websocket.MessageBinary || !bytes.Equal(payload, message) {
+		t.Fatalf("expected binary frame with the message, got %v", messageType)
+	}
+}
+
+func TestWebsocketFrameLengthEncodings(t *testing.T) {
+	for _, length := range []int{0, 125, 126, 65535, 65536} {
+		payload := bytes.Repeat([]byte{'a'}, length)
+		messageType, parsed := parseFrame(t, buildWebsocketFrame(websocket.MessageBinary, false, payload))
+		if messageType != websocket.MessageBinary || !bytes.Equal(parsed, payload) {
+			t.Errorf("length %d: frame doesn't contain the payload", length)
+		}
+	}
+}
@@ -27,6 +27,204 @@ var (
 		},
 		[]string{"reason"},
 	)
+	WsSentRawBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ws_sent_raw_bytes_total",
+			Help: "Total of bytes of messages sent to browsers, before compression",
+		},
+		[]string{"compression"},
+	)
+	WsSentWireBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ws_sent_wire_bytes_total",
+			Help: "Total of bytes written to browser sockets, including websocket framing and compression",
+		},
+		[]string{"compression"},
+	)
+	CacheRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_requests_total",
+			Help: "Total number of cache lookups, by result (hit or miss)",
+		},
+		[]string{"cache", "result"},
+	)
+	CacheEvictionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_evictions_total",
+			Help: "Total number of cache entries removed, by reason (expired, max_entries or max_bytes)",
+		},
+		[]string{"cache", "reason"},
+	)
+	CacheEntriesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cache_entries",
+			Help: "Number of entries stored in the cache",
+		},
+		[]string{"cache"},
+	)
+	CacheBytesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cache_bytes",
+			Help: "Estimated size (in bytes) of the entries stored in the cache",
+		},
+		[]string{"cache"},
+	)
+	ParkedBrowserSessionsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ws_parked_sessions",
+		Help: "Number of dropped browser sessions waiting to be resumed",
+	})
+	BrowserSessionResumeCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ws_session_resume_total",
+			Help: "Total of parked browser sessions by outcome (resumed, expired or not_found)",
+		},
+		[]string{"result"},
+	)
+	ClientCapabilityAcceptedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ws_client_capability_accepted_total",
+			Help: "Total of capabilities declared in connection_init and accepted by the middleware",
+		},
+		[]string{"capability"},
+	)
+	SchemaLoadCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gql_schema_load_total",
+			Help: "Total of attempts to load the Hasura schema by outcome (success or error)",
+		},
+		[]string{"result"},
+	)
+	SchemaValidationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gql_schema_validation_total",
+			Help: "Total of operations validated against the Hasura schema by outcome (valid, invalid or skipped)",
+		},
+		[]string{"result"},
+	)
+	PolicyDecisionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gql_policy_decision_total",
+			Help: "Total of operations evaluated by the policy, by effect and rule that matched",
+		},
+		[]string{"effect", "rule"},
+	)
+	AuditRecordsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "audit_records_total",
+			Help: "Total of audit records by result (written, error or dropped)",
+		},
+		[]string{"result"},
+	)
+	AuthHookDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "auth_hook_duration_seconds",
+			Help:    "Duration of the requests to the auth hook (bbb-web), by result (success or error)",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"result"},
+	)
+	SessionVarsHookDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "session_vars_hook_duration_seconds",
+			Help:    "Duration of the requests to the session variables hook (akka-apps), by result (success or error)",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"result"},
+	)
+	HasuraConnectionGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "hasura_connection_active",
+		Help: "Number of active websocket connections with Hasura",
+	})
+	HasuraReconnectionCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "hasura_connection_reconnects_total",
+		Help: "Total of connections with Hasura established to replace a previous one of the same browser connection",
+	})
+	HasuraEndpointHealthyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hasura_endpoint_healthy",
+			Help: "Whether the Hasura endpoint is healthy (1) or not (0)",
+		},
+		[]string{"endpoint"},
+	)
+	HasuraEndpointConnectionsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hasura_endpoint_connections",
+			Help: "Number of active websocket connections with the Hasura endpoint",
+		},
+		[]string{"endpoint"},
+	)
+	HasuraEndpointFailoverCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hasura_endpoint_failovers_total",
+			Help: "Total of connections with Hasura closed to move them from the unhealthy endpoint to a healthy one",
+		},
+		[]string{"endpoint"},
+	)
+	WsProtocolViolationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ws_protocol_violation_total",
+			Help: "Total of browser connections closed for not following graphql-transport-ws by close code",
+		},
+		[]string{"code"},
+	)
+	WsPingRtt = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "ws_ping_rtt_seconds",
+			Help:    "Round trip time of the pings sent to the browsers (until the pong is received)",
+			Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+	)
+	WsPongTimeoutCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ws_pong_timeout_total",
+		Help: "Total of browser connections closed because they didn't answer the ping",
+	})
+	WsPingRttExceededCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ws_ping_rtt_exceeded_total",
+		Help: "Total of browser connections closed because their ping round trip time exceeded the maximum",
+	})
+	UpstreamBackoffGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "upstream_backoff_connections",
+			Help: "Number of browser connections waiting to reconnect with the upstream after failures",
+		},
+		[]string{"upstream"},
+	)
+	UpstreamStatusNotificationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upstream_status_notifications_total",
+			Help: "Total of upstream status messages sent to browsers by status (reconnecting, failing or restored)",
+		},
+		[]string{"upstream", "status"},
+	)
+	UpstreamDialsInProgressGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "upstream_dials_in_progress",
+		Help: "Number of dials with upstreams in progress (limited by upstream_backoff.max_concurrent_dials)",
+	})
+	UpstreamDialsWaitingGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "upstream_dials_waiting",
+		Help: "Number of dials with upstreams waiting because max_concurrent_dials is reached",
+	})
+	ChannelQueuedMessagesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ws_channel_queued_messages",
+			Help: "Number of messages waiting in the channels of all browser connections",
+		},
+		[]string{"channel"},
+	)
+	ChannelMaxQueuedMessagesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ws_channel_queued_messages_max",
+			Help: "Highest number of messages waiting in the channel of a single browser connection",
+		},
+		[]string{"channel"},
+	)
+	ChannelFrozenGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ws_channel_frozen",
+			Help: "Number of browser connections with the channel frozen (e.g. waiting for Hasura to reconnect)",
+		},
+		[]string{"channel"},
+	)
 	GqlSubscribeCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "gql_subscription_total",
@@ -41,6 +239,94 @@ var (
 		},
 		[]string{"operationName"},
 	)
+	GqlMutationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gql_mutation_duration_seconds",
+			Help:    "Duration of the mutations sent to graphql-actions, until the response is received",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"operationName"},
+	)
+	GqlFirstDataDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gql_first_data_duration_seconds",
+			Help:    "Time from sending the subscribe to Hasura until the first data (next) is received",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"type", "operationName"},
+	)
+	GqlDeliveryDelay = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "gql_delivery_delay_seconds",
+		Help: "Time from reading a message from Hasura (or graphql-actions) until it's written to the browser",
+		Buckets: []float64{
+			0.0005,
+			0.001,
+			0.0025,
+			0.005,
+			0.01,
+			0.025,
+			0.05,
+			0.1,
+			0.25,
+			0.5,
+			1,
+		},
+	})
+	GqlPatchCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gql_patch_total",
+			Help: "Total of data messages of subscriptions supporting json-patch, by result (patch or full data)",
+		},
+		[]string{"operationName", "result"},
+	)
+	GqlPatchCacheRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gql_patch_cache_requests_total",
+			Help: "Total of data messages of subscriptions supporting json-patch found in the cache, by result (hit or miss)",
+		},
+		[]string{"operationName", "result"},
+	)
+	GqlPatchDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "gql_patch_duration_seconds",
+			Help: "Time spent creating the json-patch of the data (on cache misses)",
+			Buckets: []float64{
+				0.0001,
+				0.0005,
+				0.001,
+				0.005,
+				0.01,
+				0.05,
+				0.1,
+				0.5,
+				1,
+			},
+		},
+		[]string{"operationName"},
+	)
+	GqlPatchSizeRatio = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "gql_patch_size_ratio",
+			Help: "Size of the json-patch created relative to the size of the full data",
+			Buckets: []float64{
+				0.05,
+				0.1,
+				0.25,
+				0.5,
+				0.75,
+				1,
+				2,
+			},
+		},
+		[]string{"operationName"},
+	)
+	GqlPatchCustomFallbackCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gql_patch_custom_fallback_total",
+			Help: "Total of patches the custom patcher was not able to create (so the generic json-patch was used)",
+		},
+		[]string{"operationName"},
+	)
 	GqlReceivedDataCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "gql_received_data_total",
@@ -85,9 +371,48 @@ func init() {
 	prometheus.MustRegister(HttpConnectionCounter)
 	prometheus.MustRegister(WsConnectionAcceptedCounter)
 	prometheus.MustRegister(WsConnectionRejectedCounter)
+	prometheus.MustRegister(WsSentRawBytesCounter)
+	prometheus.MustRegister(WsSentWireBytesCounter)
+	prometheus.MustRegister(CacheRequestsCounter)
+	prometheus.MustRegister(CacheEvictionsCounter)
+	prometheus.MustRegister(CacheEntriesGauge)
+	prometheus.MustRegister(CacheBytesGauge)
+	prometheus.MustRegister(ParkedBrowserSessionsGauge)
+	prometheus.MustRegister(BrowserSessionResumeCounter)
+	prometheus.MustRegister(ClientCapabilityAcceptedCounter)
+	prometheus.MustRegister(SchemaLoadCounter)
+	prometheus.MustRegister(SchemaValidationCounter)
+	prometheus.MustRegister(PolicyDecisionCounter)
+	prometheus.MustRegister(AuditRecordsCounter)
+	prometheus.MustRegister(AuthHookDuration)
+	prometheus.MustRegister(SessionVarsHookDuration)
+	prometheus.MustRegister(HasuraConnectionGauge)
+	prometheus.MustRegister(HasuraReconnectionCounter)
+	prometheus.MustRegister(HasuraEndpointHealthyGauge)
+	prometheus.MustRegister(HasuraEndpointConnectionsGauge)
+	prometheus.MustRegister(HasuraEndpointFailoverCounter)
+	prometheus.MustRegister(WsProtocolViolationCounter)
+	prometheus.MustRegister(WsPingRtt)
+	prometheus.MustRegister(WsPongTimeoutCounter)
+	prometheus.MustRegister(WsPingRttExceededCounter)
+	prometheus.MustRegister(UpstreamBackoffGauge)
+	prometheus.MustRegister(UpstreamStatusNotificationCounter)
+	prometheus.MustRegister(UpstreamDialsInProgressGauge)
+	prometheus.MustRegister(UpstreamDialsWaitingGauge)
+	prometheus.MustRegister(ChannelQueuedMessagesGauge)
+	prometheus.MustRegister(ChannelMaxQueuedMessagesGauge)
+	prometheus.MustRegister(ChannelFrozenGauge)
 	prometheus.MustRegister(GqlSubscribeCounter)
 	prometheus.MustRegister(GqlReceivedDataCounter)
 	prometheus.MustRegister(GqlMutationsCounter)
+	prometheus.MustRegister(GqlMutationDuration)
+	prometheus.MustRegister(GqlFirstDataDuration)
+	prometheus.MustRegister(GqlDeliveryDelay)
+	prometheus.MustRegister(GqlPatchCounter)
+	prometheus.MustRegister(GqlPatchCacheRequestsCounter)
+	prometheus.MustRegister(GqlPatchDuration)
+	prometheus.MustRegister(GqlPatchSizeRatio)
+	prometheus.MustRegister(GqlPatchCustomFallbackCounter)
 	prometheus.MustRegister(GqlReceivedDataPayloadSize)
 	if PrometheusAdvancedMetricsEnabled {
 		prometheus.MustRegister(GqlReceivedDataPayloadLength)
@@ -0,0 +1,198 @@
+package common
+
+import (
+	"bbb-graphql-middleware/config"
+	"encoding/json"
+	"github.com/graphql-go/graphql/language/ast"
+	"hash/crc32"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxCost avoids overflow when list multipliers are nested
+const maxCost = math.MaxInt32
+
+// queryCostDefaultListSize multiplies the fields that may return a list without `limit` (or `batch_size`),
+// otherwise removing the limit from a query would reduce its cost
+var queryCostDefaultListSize = newQueryCostDefaultListSize()
+
+func newQueryCostDefaultListSize() int {
+	if listSize := config.GetConfig().Server.QueryCostDefaultListSize; listSize > 0 {
+		return listSize
+	}
+	return 1
+}
+
+// QueryCost is the estimated cost of an operation, computed without the schema:
+// each field costs 1 plus the cost of its children multiplied by the `limit` (or `batch_size`) of the field,
+// or by server.query_cost_default_list_size when the field may return an unbounded list
+type QueryCost struct {
+	Cost       int
+	Depth      int
+	Fields     int
+	Aliases    int
+	RootFields int
+}
+
+type queryCostAnalyzer struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	result    QueryCost
+}
+
+type cachedQueryCost struct {
+	cost          QueryCost
+	err           error
+	query         string
+	requestedName string
+	variables     string
+}
+
+// AnalyzeQueryCost computes the cost of the operation chosen by operationName, resolving the named fragments of the document
+// The result is cached by query, operationName and variables (the limits can be set through variables)
+func AnalyzeQueryCost(query string, operationName string, variables map[string]interface{}) (QueryCost, error) {
+	variablesJson, _ := json.Marshal(variables)
+	cacheKey := crc32.ChecksumIEEE([]byte(operationName + "\n" + string(variablesJson) + "\n" + query))
+
+	cached := QueryCostCache.GetOrCompute(cacheKey, func() cachedQueryCost {
+		cost, err := analyzeQueryCost(query, operationName, variables)
+		return cachedQueryCost{cost: cost, err: err, query: query, requestedName: operationName, variables: string(variablesJson)}
+	})
+
+	//Avoid using a wrong cost in case of checksum collision
+	if cached.query != query || cached.requestedName != operationName || cached.variables != string(variablesJson) {
+		return analyzeQueryCost(query, operationName, variables)
+	}
+
+	return cached.cost, cached.err
+}
+
+func analyzeQueryCost(query string, operationName string, variables map[string]interface{}) (QueryCost, error) {
+	astDoc, err := ParseGraphQlQuery(query)
+	if err != nil {
+		return QueryCost{}, err
+	}
+
+	op, err := SelectOperationDefinition(astDoc, operationName)
+	if err != nil {
+		return QueryCost{}, err
+	}
+
+	analyzer := queryCostAnalyzer{
+		fragments: GetFragmentDefinitions(astDoc),
+		variables: variables,
+	}
+	analyzer.result.RootFields = len(CollectRootFields(astDoc, op))
+	analyzer.result.Cost, analyzer.result.Depth = analyzer.selectionSetCost(op.SelectionSet, 0, make(map[string]bool))
+
+	return analyzer.result, nil
+}
+
+// selectionSetCost returns the cost and the depth of the selection set
+// fragmentsInPath avoids infinite recursion with cyclic fragments (they are invalid anyway)
+func (a *queryCostAnalyzer) selectionSetCost(selectionSet *ast.SelectionSet, currentDepth int, fragmentsInPath map[string]bool) (int, int) {
+	if selectionSet == nil {
+		return 0, currentDepth
+	}
+
+	currentDepth++
+	maxDepth := currentDepth
+	cost := 0
+
+	for _, selection := range selectionSet.Selections {
+		var selectionCost, selectionDepth int
+
+		switch sel := selection.(type) {
+		case *ast.Field:
+			a.result.Fields++
+			if sel.Alias != nil && sel.Alias.Value != "" {
+				a.result.Aliases++
+			}
+
+			childrenCost, childrenDepth := a.selectionSetCost(sel.SelectionSet, currentDepth, fragmentsInPath)
+			selectionCost = saturatedAdd(1, saturatedMultiply(a.listSize(sel), childrenCost))
+			selectionDepth = childrenDepth
+		case *ast.InlineFragment:
+			selectionCost, selectionDepth = a.selectionSetCost(sel.SelectionSet, currentDepth-1, fragmentsInPath)
+		case *ast.FragmentSpread:
+			fragmentName := sel.Name.Value
+			fragment, exists := a.fragments[fragmentName]
+			if !exists || fragmentsInPath[fragmentName] {
+				continue
+			}
+
+			fragmentsInPath[fragmentName] = true
+			selectionCost, selectionDepth = a.selectionSetCost(fragment.SelectionSet, currentDepth-1, fragmentsInPath)
+			delete(fragmentsInPath, fragmentName)
+		}
+
+		cost = saturatedAdd(cost, selectionCost)
+		if selectionDepth > maxDepth {
+			maxDepth = selectionDepth
+		}
+	}
+
+	return cost, maxDepth
+}
+
+// listSize returns the number of items the field can return, based on its `limit` or `batch_size` argument
+// Without the schema, fields with sub-fields are considered lists, except the single objects of Hasura
+// (`_by_pk`, `_aggregate` and `aggregate`)
+func (a *queryCostAnalyzer) listSize(field *ast.Field) int {
+	size := 1
+	bounded := false
+	for _, argumentName := range []string{"limit", "batch_size"} {
+		if argument := getFieldArgument(field, argumentName); argument != nil {
+			if argumentSize, resolved := a.intArgumentValue(argument.Value); resolved {
+				bounded = true
+				if argumentSize > size {
+					size = argumentSize
+				}
+			}
+		}
+	}
+
+	if !bounded && field.SelectionSet != nil && !isSingleObjectField(field.Name.Value) {
+		return queryCostDefaultListSize
+	}
+	return size
+}
+
+func isSingleObjectField(fieldName string) bool {
+	return fieldName == "aggregate" ||
+		strings.HasSuffix(fieldName, "_by_pk") ||
+		strings.HasSuffix(fieldName, "_aggregate")
+}
+
+// intArgumentValue returns the value of the argument, false when it's not an int (e.g. a variable not informed)
+func (a *queryCostAnalyzer) intArgumentValue(value ast.Value) (int, bool) {
+	switch v := value.(type) {
+	case *ast.IntValue:
+		if asInt, err := strconv.Atoi(v.Value); err == nil {
+			return asInt, true
+		}
+	case *ast.Variable:
+		switch variableValue := a.variables[v.Name.Value].(type) {
+		case float64:
+			return int(math.Min(variableValue, maxCost)), true
+		case int:
+			return variableValue, true
+		}
+	}
+	return 0, false
+}
+
+func saturatedAdd(a int, b int) int {
+	if a > maxCost-b {
+		return maxCost
+	}
+	return a + b
+}
+
+func saturatedMultiply(a int, b int) int {
+	if a != 0 && b > maxCost/a {
+		return maxCost
+	}
+	return a * b
+}
@@ -0,0 +1,142 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func setQueryCostDefaultListSize(t *testing.T, listSize int) {
+	t.Helper()
+
+	previous, previousCache := queryCostDefaultListSize, QueryCostCache
+	t.Cleanup(func() { queryCostDefaultListSize, QueryCostCache = previous, previousCache })
+	// the costs cached were computed with the previous list size
+	queryCostDefaultListSize = listSize
+	QueryCostCache = NewShardedTTLCache[cachedQueryCost]("test_query_cost", 1, 0, 0, time.Minute, func(cachedQueryCost) int { return 0 })
+}
+
+func TestAnalyzeQueryCost(t *testing.T) {
+	setQueryCostDefaultListSize(t, 10)
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		expected  QueryCost
+	}{
+		{
+			name:     "limit multiplies the children",
+			query:    `subscription S { user(limit: 5) { userId } }`,
+			expected: QueryCost{Cost: 6, Depth: 2, Fields: 2, RootFields: 1},
+		},
+		{
+			name:     "list without limit uses the default list size",
+			query:    `subscription S { user { userId } }`,
+			expected: QueryCost{Cost: 11, Depth: 2, Fields: 2, RootFields: 1},
+		},
+		{
+			name:     "limit variable not informed uses the default list size",
+			query:    `subscription S($limit: Int) { user(limit: $limit) { userId } }`,
+			expected: QueryCost{Cost: 11, Depth: 2, Fields: 2, RootFields: 1},
+		},
+		{
+			name:      "limit variable",
+			query:     `subscription S($limit: Int) { user(limit: $limit) { userId } }`,
+			variables: map[string]interface{}{"limit": float64(3)},
+			expected:  QueryCost{Cost: 4, Depth: 2, Fields: 2, RootFields: 1},
+		},
+		{
+			name:     "batch_size multiplies the children",
+			query:    `subscription S { chat_message_stream(batch_size: 10, cursor: {initial_value: {createdAt: "2024-01-01"}}) { messageId } }`,
+			expected: QueryCost{Cost: 11, Depth: 2, Fields: 2, RootFields: 1},
+		},
+		{
+			name:     "nested lists",
+			query:    `subscription S { user(limit: 5) { userId meeting { name } } }`,
+			expected: QueryCost{Cost: 61, Depth: 3, Fields: 4, RootFields: 1},
+		},
+		{
+			name:     "single objects are not multiplied",
+			query:    `subscription S { user_by_pk(userId: "1") { name meeting_aggregate { aggregate { count } } } }`,
+			expected: QueryCost{Cost: 5, Depth: 4, Fields: 5, RootFields: 1},
+		},
+		{
+			name:     "named fragments",
+			query:    `subscription S { user(limit: 2) { ...UserFields } } fragment UserFields on user { userId name }`,
+			expected: QueryCost{Cost: 5, Depth: 2, Fields: 3, RootFields: 1},
+		},
+		{
+			name:     "nested named fragments",
+			query:    `subscription S { ...Root } fragment Root on subscription_root { user(limit: 2) { ...UserFields } } fragment UserFields on user { userId }`,
+			expected: QueryCost{Cost: 3, Depth: 2, Fields: 2, RootFields: 1},
+		},
+		{
+			name:     "inline fragments",
+			query:    `subscription S { user(limit: 2) { ... on user { userId name } } }`,
+			expected: QueryCost{Cost: 5, Depth: 2, Fields: 3, RootFields: 1},
+		},
+		{
+			name:     "cyclic fragments are ignored",
+			query:    `subscription S { user(limit: 2) { ...A } } fragment A on user { userId ...A }`,
+			expected: QueryCost{Cost: 3, Depth: 2, Fields: 2, RootFields: 1},
+		},
+		{
+			name:     "aliases",
+			query:    `subscription S { a: user(limit: 2) { userId } b: user(limit: 3) { id: userId } }`,
+			expected: QueryCost{Cost: 7, Depth: 2, Fields: 4, Aliases: 3, RootFields: 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cost, err := AnalyzeQueryCost(test.query, "S", test.variables)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cost != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, cost)
+			}
+		})
+	}
+}
+
+func TestAnalyzeQueryCostRemovingLimitDoesNotReduceCost(t *testing.T) {
+	setQueryCostDefaultListSize(t, 10)
+
+	limited, _ := AnalyzeQueryCost(`subscription S { user(limit: 5) { userId name } }`, "S", nil)
+	unlimited, _ := AnalyzeQueryCost(`subscription S { user { userId name } }`, "S", nil)
+	if unlimited.Cost <= limited.Cost {
+		t.Errorf("expected the query without limit (%d) to cost more than with limit (%d)", unlimited.Cost, limited.Cost)
+	}
+}
+
+func TestAnalyzeQueryCostDefaultListSizeDisabled(t *testing.T) {
+	setQueryCostDefaultListSize(t, 1)
+
+	cost, err := AnalyzeQueryCost(`subscription S { user { userId } }`, "S", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost.Cost != 2 {
+		t.Errorf("expected the list without limit to count as a single item, got %d", cost.Cost)
+	}
+}
+
+func TestAnalyzeQueryCostIsCachedByVariables(t *testing.T) {
+	setQueryCostDefaultListSize(t, 10)
+
+	query := `subscription S($limit: Int) { user(limit: $limit) { userId } }`
+	small, _ := AnalyzeQueryCost(query, "S", map[string]interface{}{"limit": float64(2)})
+	large, _ := AnalyzeQueryCost(query, "S", map[string]interface{}{"limit": float64(50)})
+	if large.Cost <= small.Cost {
+		t.Errorf("cost with limit 50 (%d) should be higher than with limit 2 (%d)", large.Cost, small.Cost)
+	}
+
+	// the cost is not computed again for the same query (the list size change is not seen)
+	unbounded := `subscription S { user { userId } }`
+	cached, _ := AnalyzeQueryCost(unbounded, "S", nil)
+	queryCostDefaultListSize = 100
+	if again, _ := AnalyzeQueryCost(unbounded, "S", nil); again != cached {
+		t.Errorf("expected the cached cost %+v, got %+v", cached, again)
+	}
+}
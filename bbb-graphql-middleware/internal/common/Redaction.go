@@ -0,0 +1,77 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+)
+
+const RedactedValue = "[REDACTED]"
+
+// Redaction hides the values of the fields matching the glob patterns (e.g. "*token*"), at any level of the data
+// It's shared by the audit log, the tap and the capture, so the rules are the same everywhere
+type Redaction struct {
+	RedactFields []string                  // replaced by [REDACTED]
+	HashFields   []string                  // replaced by Hash of the value (as JSON)
+	Hash         func(value string) string // required when there are HashFields
+}
+
+// RedactValue returns a copy of the value (decoded from JSON) with the matching fields redacted or hashed
+func (r Redaction) RedactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, fieldValue := range v {
+			switch {
+			case matchesAnyField(r.RedactFields, key):
+				redacted[key] = RedactedValue
+			case matchesAnyField(r.HashFields, key):
+				fieldValueJson, _ := json.Marshal(fieldValue)
+				redacted[key] = "sha256:" + r.Hash(string(fieldValueJson))
+			default:
+				redacted[key] = r.RedactValue(fieldValue)
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = r.RedactValue(item)
+		}
+		return redacted
+	default:
+		return v
+	}
+}
+
+// RedactMessage replaces the session token anywhere in the message and redacts its fields
+// A message that is not JSON is returned as a JSON string, so it can be embedded in JSON records
+func (r Redaction) RedactMessage(message []byte, sessionToken string) json.RawMessage {
+	if sessionToken != "" {
+		message = bytes.ReplaceAll(message, []byte(sessionToken), []byte(RedactedValue))
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(message, &decoded); err != nil {
+		redactedJson, _ := json.Marshal(string(message))
+		return redactedJson
+	}
+	if len(r.RedactFields) == 0 && len(r.HashFields) == 0 {
+		return message
+	}
+
+	redactedJson, err := json.Marshal(r.RedactValue(decoded))
+	if err != nil {
+		return message
+	}
+	return redactedJson
+}
+
+func matchesAnyField(patterns []string, fieldName string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, fieldName); matched {
+			return true
+		}
+	}
+	return false
+}
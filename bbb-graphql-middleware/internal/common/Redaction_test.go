@@ -0,0 +1,82 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRedactValue(t *testing.T) {
+	redaction := Redaction{
+		RedactFields: []string{"password", "*Token*"},
+		HashFields:   []string{"email"},
+		Hash:         func(value string) string { return "hash(" + value + ")" },
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{
+			name:     "top level",
+			value:    `{"password":"secret","name":"John"}`,
+			expected: `{"password":"[REDACTED]","name":"John"}`,
+		},
+		{
+			name:     "nested objects and lists",
+			value:    `{"user":{"sessionToken":"abc","contacts":[{"email":"a@b.c","phone":"1"}]}}`,
+			expected: `{"user":{"sessionToken":"[REDACTED]","contacts":[{"email":"sha256:hash(\"a@b.c\")","phone":"1"}]}}`,
+		},
+		{
+			name:     "the whole value of a matching field",
+			value:    `{"authToken":{"value":"abc"},"email":["a@b.c"]}`,
+			expected: `{"authToken":"[REDACTED]","email":"sha256:hash([\"a@b.c\"])"}`,
+		},
+		{
+			name:     "glob is case sensitive",
+			value:    `{"token":"abc","Password":"secret"}`,
+			expected: `{"token":"abc","Password":"secret"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var value interface{}
+			_ = json.Unmarshal([]byte(test.value), &value)
+			original, _ := json.Marshal(value)
+
+			redactedJson, _ := json.Marshal(redaction.RedactValue(value))
+			assertSameJson(t, test.expected, redactedJson)
+
+			if valueJson, _ := json.Marshal(value); string(valueJson) != string(original) {
+				t.Errorf("the original value was changed: %s", valueJson)
+			}
+		})
+	}
+}
+
+func TestRedactMessage(t *testing.T) {
+	redaction := Redaction{RedactFields: []string{"password"}}
+
+	redacted := redaction.RedactMessage([]byte(`{"type":"connection_init","payload":{"headers":{"X-Session-Token":"tok123"},"password":"p"}}`), "tok123")
+	assertSameJson(t, `{"type":"connection_init","payload":{"headers":{"X-Session-Token":"[REDACTED]"},"password":"[REDACTED]"}}`, redacted)
+
+	// the token is replaced even when the message is not JSON, which is returned as a string
+	redacted = redaction.RedactMessage([]byte(`not json tok123`), "tok123")
+	assertSameJson(t, `"not json [REDACTED]"`, redacted)
+}
+
+func assertSameJson(t *testing.T, expected string, actual []byte) {
+	t.Helper()
+
+	var expectedValue, actualValue interface{}
+	_ = json.Unmarshal([]byte(expected), &expectedValue)
+	if err := json.Unmarshal(actual, &actualValue); err != nil {
+		t.Fatalf("invalid json %s: %v", actual, err)
+	}
+	expectedJson, _ := json.Marshal(expectedValue)
+	actualJson, _ := json.Marshal(actualValue)
+	if string(expectedJson) != string(actualJson) {
+		t.Errorf("expected %s, got %s", expectedJson, actualJson)
+	}
+}
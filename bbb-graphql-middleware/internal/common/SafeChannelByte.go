@@ -2,13 +2,16 @@ package common
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type SafeChannelByte struct {
 	ch         chan []byte
+	info       chan SentValueInfo // info of each value sent (only in timed channels)
 	closed     bool
 	mux        sync.Mutex
-	freezeFlag bool
+	freezeFlag atomic.Bool
 }
 
 func NewSafeChannelByte(size int) *SafeChannelByte {
@@ -17,17 +20,54 @@ func NewSafeChannelByte(size int) *SafeChannelByte {
 	}
 }
 
+// SentValueInfo is kept by timed channels along with each value
+type SentValueInfo struct {
+	ReceivedAt  time.Time // when the value was received by the middleware, to measure the delivery delay
+	OnDelivered func()    // called once the value is delivered (when not nil)
+}
+
+// NewTimedSafeChannelByte creates a channel that keeps the info of each value sent
+func NewTimedSafeChannelByte(size int) *SafeChannelByte {
+	return &SafeChannelByte{
+		ch:   make(chan []byte, size),
+		info: make(chan SentValueInfo, size+1),
+	}
+}
+
 func (s *SafeChannelByte) Send(value []byte) bool {
+	return s.SendReceivedAt(value, time.Now())
+}
+
+// SendReceivedAt sends the value informing when it was received by the middleware (e.g. read from Hasura)
+func (s *SafeChannelByte) SendReceivedAt(value []byte, receivedAt time.Time) bool {
+	return s.SendWithInfo(value, SentValueInfo{ReceivedAt: receivedAt})
+}
+
+// SendWithInfo sends the value along with its info (ignored when the channel is not timed)
+func (s *SafeChannelByte) SendWithInfo(value []byte, info SentValueInfo) bool {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
 	if s.closed {
 		return false
 	}
+	if s.info != nil {
+		s.info <- info
+	}
 	s.ch <- value
 	return true
 }
 
+// SentInfo returns the info of the last value taken from a timed channel
+func (s *SafeChannelByte) SentInfo() (SentValueInfo, bool) {
+	select {
+	case info := <-s.info:
+		return info, true
+	default:
+		return SentValueInfo{}, false
+	}
+}
+
 func (s *SafeChannelByte) Receive() ([]byte, bool) {
 	val, ok := <-s.ch
 	return val, ok
@@ -37,6 +77,11 @@ func (s *SafeChannelByte) ReceiveChannel() <-chan []byte {
 	return s.ch
 }
 
+// Len returns the number of values waiting in the channel
+func (s *SafeChannelByte) Len() int {
+	return len(s.ch)
+}
+
 func (s *SafeChannelByte) Closed() bool {
 	s.mux.Lock()
 	defer s.mux.Unlock()
@@ -59,19 +104,18 @@ func (s *SafeChannelByte) Close() {
 }
 
 func (s *SafeChannelByte) Frozen() bool {
-	return s.freezeFlag
+	return s.freezeFlag.Load()
 }
 
 func (s *SafeChannelByte) FreezeChannel() {
-	if !s.freezeFlag {
+	if !s.freezeFlag.Load() {
 		s.mux.Lock()
-		s.freezeFlag = true
+		s.freezeFlag.Store(true)
 	}
 }
 
 func (s *SafeChannelByte) UnfreezeChannel() {
-	if s.freezeFlag {
+	if s.freezeFlag.CompareAndSwap(true, false) {
 		s.mux.Unlock()
-		s.freezeFlag = false
 	}
 }
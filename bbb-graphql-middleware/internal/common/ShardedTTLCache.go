@@ -0,0 +1,201 @@
+package common
+
+import (
+	"container/list"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+// ShardedTTLCache is a cache bounded by number of entries and bytes, split in shards to reduce lock contention
+// Entries expire after the ttl (removed by a background sweeper) and the least recently used
+// entries of a shard are evicted when the shard reaches its limits
+type ShardedTTLCache[V any] struct {
+	ttl    time.Duration
+	sizeOf func(V) int
+	shards []*cacheShard[V]
+	locks  *CacheLocks // avoid two routines computing the same entry at the same time
+
+	// metrics of this cache, resolved once as they are updated on every request
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	entries   prometheus.Gauge
+	bytes     prometheus.Gauge
+	evictions map[string]prometheus.Counter // by reason
+}
+
+type cacheShard[V any] struct {
+	mutex      sync.Mutex
+	entries    map[uint32]*list.Element
+	lru        *list.List // most recently used in the front
+	bytes      int
+	maxEntries int // 0 means unlimited
+	maxBytes   int // 0 means unlimited
+}
+
+type cacheEntry[V any] struct {
+	key       uint32
+	value     V
+	size      int
+	expiresAt time.Time
+}
+
+func NewShardedTTLCache[V any](
+	name string,
+	numOfShards int,
+	maxEntries int,
+	maxBytes int,
+	ttl time.Duration,
+	sizeOf func(V) int) *ShardedTTLCache[V] {
+
+	if numOfShards < 1 {
+		numOfShards = 1
+	}
+
+	c := &ShardedTTLCache[V]{
+		ttl:    ttl,
+		sizeOf: sizeOf,
+		shards: make([]*cacheShard[V], numOfShards),
+		locks:  NewCacheLocks(),
+
+		hits:      CacheRequestsCounter.With(prometheus.Labels{"cache": name, "result": "hit"}),
+		misses:    CacheRequestsCounter.With(prometheus.Labels{"cache": name, "result": "miss"}),
+		entries:   CacheEntriesGauge.With(prometheus.Labels{"cache": name}),
+		bytes:     CacheBytesGauge.With(prometheus.Labels{"cache": name}),
+		evictions: make(map[string]prometheus.Counter),
+	}
+	for _, reason := range []string{"expired", "max_entries", "max_bytes"} {
+		c.evictions[reason] = CacheEvictionsCounter.With(prometheus.Labels{"cache": name, "reason": reason})
+	}
+
+	for i := range c.shards {
+		c.shards[i] = &cacheShard[V]{
+			entries:    make(map[uint32]*list.Element),
+			lru:        list.New(),
+			maxEntries: shardLimit(maxEntries, numOfShards),
+			maxBytes:   shardLimit(maxBytes, numOfShards),
+		}
+	}
+
+	go c.sweepExpiredEntries()
+
+	return c
+}
+
+// shardLimit splits a limit between the shards, at least 1 each (as 0 means unlimited)
+func shardLimit(limit int, numOfShards int) int {
+	if limit <= 0 {
+		return 0
+	}
+	return max(limit/numOfShards, 1)
+}
+
+func (c *ShardedTTLCache[V]) shard(key uint32) *cacheShard[V] {
+	return c.shards[key%uint32(len(c.shards))]
+}
+
+func (c *ShardedTTLCache[V]) Get(key uint32) (V, bool) {
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, exists := s.entries[key]; exists {
+		entry := element.Value.(*cacheEntry[V])
+		if time.Now().Before(entry.expiresAt) {
+			s.lru.MoveToFront(element)
+			c.hits.Inc()
+			return entry.value, true
+		}
+
+		c.removeElement(s, element, "expired")
+	}
+
+	c.misses.Inc()
+	var zero V
+	return zero, false
+}
+
+func (c *ShardedTTLCache[V]) Set(key uint32, value V) {
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, exists := s.entries[key]; exists {
+		c.removeElement(s, element, "replaced")
+	}
+
+	entry := &cacheEntry[V]{
+		key:       key,
+		value:     value,
+		size:      c.sizeOf(value),
+		expiresAt: time.Now().Add(c.ttl),
+	}
+	s.entries[key] = s.lru.PushFront(entry)
+	s.bytes += entry.size
+	c.entries.Inc()
+	c.bytes.Add(float64(entry.size))
+
+	//Evict the least recently used entries until the shard fits its limits (the new entry is always kept)
+	for s.lru.Len() > 1 {
+		if s.maxEntries > 0 && s.lru.Len() > s.maxEntries {
+			c.removeElement(s, s.lru.Back(), "max_entries")
+		} else if s.maxBytes > 0 && s.bytes > s.maxBytes {
+			c.removeElement(s, s.lru.Back(), "max_bytes")
+		} else {
+			break
+		}
+	}
+}
+
+// GetOrCompute returns the cached value or computes and stores it
+// Concurrent calls for the same key wait for the first one, so the value is computed only once
+func (c *ShardedTTLCache[V]) GetOrCompute(key uint32, compute func() V) V {
+	c.locks.Lock(key)
+	defer c.locks.Unlock(key)
+
+	if value, exists := c.Get(key); exists {
+		return value
+	}
+
+	value := compute()
+	c.Set(key, value)
+	return value
+}
+
+func (c *ShardedTTLCache[V]) removeElement(s *cacheShard[V], element *list.Element, reason string) {
+	entry := element.Value.(*cacheEntry[V])
+	s.lru.Remove(element)
+	delete(s.entries, entry.key)
+	s.bytes -= entry.size
+
+	c.entries.Dec()
+	c.bytes.Sub(float64(entry.size))
+	if evictions, exists := c.evictions[reason]; exists {
+		evictions.Inc()
+	}
+}
+
+func (c *ShardedTTLCache[V]) sweepExpiredEntries() {
+	sweepInterval := c.ttl / 2
+	if sweepInterval < time.Second {
+		sweepInterval = time.Second
+	}
+
+	for {
+		time.Sleep(sweepInterval)
+
+		now := time.Now()
+		for _, s := range c.shards {
+			s.mutex.Lock()
+			// Entries are not ordered by expiration (Get moves them to the front), so the whole shard is checked
+			for element := s.lru.Back(); element != nil; {
+				previous := element.Prev()
+				if now.After(element.Value.(*cacheEntry[V]).expiresAt) {
+					c.removeElement(s, element, "expired")
+				}
+				element = previous
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestCache(numOfShards int, maxEntries int, maxBytes int, ttl time.Duration) *ShardedTTLCache[string] {
+	return NewShardedTTLCache[string]("test", numOfShards, maxEntries, maxBytes, ttl, func(value string) int {
+		return len(value)
+	})
+}
+
+func TestShardedTTLCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTestCache(1, 2, 0, time.Minute)
+
+	cache.Set(1, "a")
+	cache.Set(2, "b")
+	if _, exists := cache.Get(1); !exists {
+		t.Fatalf("entry 1 not found")
+	}
+	cache.Set(3, "c")
+
+	if _, exists := cache.Get(2); exists {
+		t.Errorf("least recently used entry 2 was not evicted")
+	}
+	for _, key := range []uint32{1, 3} {
+		if _, exists := cache.Get(key); !exists {
+			t.Errorf("entry %d was evicted", key)
+		}
+	}
+}
+
+func TestShardedTTLCacheEvictsOverByteLimit(t *testing.T) {
+	cache := newTestCache(1, 0, 10, time.Minute)
+
+	cache.Set(1, "12345")
+	cache.Set(2, "12345")
+	cache.Set(3, "123")
+
+	if _, exists := cache.Get(1); exists {
+		t.Errorf("entry 1 was not evicted when the cache exceeded 10 bytes")
+	}
+	for _, key := range []uint32{2, 3} {
+		if _, exists := cache.Get(key); !exists {
+			t.Errorf("entry %d was evicted", key)
+		}
+	}
+
+	// an entry larger than the limit is kept alone
+	cache.Set(4, "12345678901")
+	if _, exists := cache.Get(4); !exists {
+		t.Errorf("entry larger than the limit was not kept")
+	}
+	if _, exists := cache.Get(3); exists {
+		t.Errorf("entry 3 was not evicted by the larger entry")
+	}
+}
+
+func TestShardedTTLCacheExpiresEntries(t *testing.T) {
+	cache := newTestCache(1, 0, 0, 50*time.Millisecond)
+
+	cache.Set(1, "a")
+	if _, exists := cache.Get(1); !exists {
+		t.Fatalf("entry not found before the ttl")
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if _, exists := cache.Get(1); exists {
+		t.Errorf("entry found after the ttl")
+	}
+}
+
+func TestShardedTTLCacheLimitsSmallerThanShards(t *testing.T) {
+	cache := newTestCache(32, 10, 10, time.Minute)
+
+	for _, s := range cache.shards {
+		if s.maxEntries != 1 || s.maxBytes != 1 {
+			t.Fatalf("expected limits of 1 per shard, got %d entries and %d bytes", s.maxEntries, s.maxBytes)
+		}
+	}
+
+	// keys in the same shard replace each other
+	cache.Set(1, "a")
+	cache.Set(33, "b")
+	if _, exists := cache.Get(1); exists {
+		t.Errorf("shard kept more entries than its limit")
+	}
+
+	unlimited := newTestCache(32, 0, 0, time.Minute)
+	if unlimited.shards[0].maxEntries != 0 || unlimited.shards[0].maxBytes != 0 {
+		t.Errorf("0 should keep the shards unlimited")
+	}
+}
+
+func TestShardedTTLCacheGetOrComputeComputesOnce(t *testing.T) {
+	cache := newTestCache(4, 0, 0, time.Minute)
+
+	computed := 0
+	for i := 0; i < 3; i++ {
+		value := cache.GetOrCompute(7, func() string {
+			computed++
+			return "value"
+		})
+		if value != "value" {
+			t.Fatalf("expected value, got %q", value)
+		}
+	}
+	if computed != 1 {
+		t.Errorf("expected the value computed once, computed %d times", computed)
+	}
+}
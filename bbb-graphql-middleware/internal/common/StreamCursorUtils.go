@@ -3,94 +3,343 @@ package common
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/printer"
+	"github.com/graphql-go/graphql/language/source"
 	log "github.com/sirupsen/logrus"
 	"hash/crc32"
-	"regexp"
+	"math"
+	"reflect"
 	"strconv"
-	"strings"
 )
 
-func GetStreamCursorPropsFromBrowserMessage(browserMessage BrowserSubscribeMessage) (string, string, interface{}) {
-	streamCursorField := ""
-	streamCursorVariableName := ""
-	var streamCursorInitialValue interface{}
+// StreamCursor is the cursor of one streamed root field (`xxx_stream`) of a subscription
+type StreamCursor struct {
+	ResponseKey string              // alias (or name) of the root field, it's the key of the list in the received data
+	Ordering    string              // ASC or DESC
+	Fields      []StreamCursorField // cursor columns (more than one when it's a composite cursor)
+}
 
-	cursorInitialValueRePattern := regexp.MustCompile(`cursor:\s*\{\s*initial_value\s*:\s*\{\s*([^:]+):\s*([^}]+)\s*}\s*}`)
-	matches := cursorInitialValueRePattern.FindStringSubmatch(browserMessage.Payload.Query)
-	if matches != nil {
-		streamCursorField = matches[1]
-		if strings.HasPrefix(matches[2], "$") {
-			streamCursorVariableName, _ = strings.CutPrefix(matches[2], "$")
-			if targetVariableValue, okTargetVariableValue := browserMessage.Payload.Variables[streamCursorVariableName]; okTargetVariableValue {
-				streamCursorInitialValue = targetVariableValue
-			}
-		} else {
-			streamCursorInitialValue = matches[2]
-		}
+type StreamCursorField struct {
+	Name         string      // name of the cursor column
+	VariablePath []string    // path inside the variables when the value is set through variables (empty when inline)
+	CurrValue    interface{} // initial value, updated with the last value received
+}
+
+// StreamCursorValues contains the last cursor values received, by root field response key and cursor column
+type StreamCursorValues map[string]map[string]interface{}
+
+func ParseGraphQlQuery(query string) (*ast.Document, error) {
+	src := source.NewSource(&source.Source{
+		Body: []byte(query),
+		Name: "GraphQL query",
+	})
+	astDoc, err := parser.Parse(parser.ParseParams{
+		Source: src,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse query: %v", err)
 	}
 
-	return streamCursorField, streamCursorVariableName, streamCursorInitialValue
+	return astDoc, nil
 }
 
-func GetLastStreamCursorValueFromReceivedMessage(message []byte, streamCursorField string) interface{} {
-	dataChecksum := crc32.ChecksumIEEE(message)
-	GlobalCacheLocks.Lock(dataChecksum)
+func getFieldResponseKey(field *ast.Field) string {
+	if field.Alias != nil && field.Alias.Value != "" {
+		return field.Alias.Value
+	}
+	return field.Name.Value
+}
 
-	if streamCursorValueCache, streamCursorValueCacheExists := GetStreamCursorValueCache(dataChecksum); streamCursorValueCacheExists {
-		//Unlock immediately once the cache was already created by other routine
-		GlobalCacheLocks.Unlock(dataChecksum)
-		return streamCursorValueCache
-	} else {
-		//It will create the cache and then Unlock (others will wait to benefit from this cache)
-		defer GlobalCacheLocks.Unlock(dataChecksum)
+func getFieldArgument(field *ast.Field, argumentName string) *ast.Argument {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value == argumentName {
+			return argument
+		}
+	}
+	return nil
+}
+
+// getStreamedRootFields returns the root fields of the operation that have a stream cursor
+// (including the ones selected through fragments, they are the same nodes so they can be patched)
+func getStreamedRootFields(astDoc *ast.Document, op *ast.OperationDefinition) []*ast.Field {
+	var streamedFields []*ast.Field
+	if op == nil || op.SelectionSet == nil {
+		return streamedFields
 	}
 
-	var lastStreamCursorValue interface{}
+	for _, field := range CollectRootFields(astDoc, op) {
+		if isStreamedField(field) {
+			streamedFields = append(streamedFields, field)
+		}
+	}
+
+	return streamedFields
+}
 
-	var messageAsMap map[string]interface{}
-	err := json.Unmarshal(message, &messageAsMap)
+func GetStreamCursorsFromBrowserMessage(browserMessage BrowserSubscribeMessage) []StreamCursor {
+	astDoc, err := ParseGraphQlQuery(browserMessage.Payload.Query)
 	if err != nil {
-		log.Errorf("failed to unmarshal message: %v", err)
-		//return
-	}
-
-	if payload, okPayload := messageAsMap["payload"].(map[string]interface{}); okPayload {
-		if data, okData := payload["data"].(map[string]interface{}); okData {
-			//Data will have only one prop, `range` because its name is unknown
-			for _, dataItem := range data {
-				currentDataProp, okCurrentDataProp := dataItem.([]interface{})
-				if okCurrentDataProp && len(currentDataProp) > 0 {
-					// Get the last item directly (once it will contain the last cursor value)
-					lastItemOfMessage := currentDataProp[len(currentDataProp)-1]
-					if lastItemOfMessageAsMap, currDataOk := lastItemOfMessage.(map[string]interface{}); currDataOk {
-						if lastItemValue, okLastItemValue := lastItemOfMessageAsMap[streamCursorField]; okLastItemValue {
-							lastStreamCursorValue = lastItemValue
-						}
-					}
+		log.Errorf("failed to get stream cursors: %v", err)
+		return nil
+	}
+
+	op, _ := SelectOperationDefinition(astDoc, browserMessage.Payload.OperationName)
+
+	var streamCursors []StreamCursor
+	for _, field := range getStreamedRootFields(astDoc, op) {
+		streamCursor := StreamCursor{
+			ResponseKey: getFieldResponseKey(field),
+			Ordering:    "ASC",
+		}
+		readCursorFromAst(getFieldArgument(field, "cursor").Value, browserMessage.Payload.Variables, &streamCursor)
+
+		if len(streamCursor.Fields) > 0 {
+			streamCursors = append(streamCursors, streamCursor)
+		}
+	}
+
+	return streamCursors
+}
+
+// readCursorFromAst reads the cursor argument: {initial_value: {...}, ordering: ASC} (or a list of them)
+func readCursorFromAst(value ast.Value, variables map[string]interface{}, streamCursor *StreamCursor) {
+	switch v := value.(type) {
+	case *ast.ListValue:
+		for _, item := range v.Values {
+			readCursorFromAst(item, variables, streamCursor)
+		}
+	case *ast.ObjectValue:
+		for _, objectField := range v.Fields {
+			switch objectField.Name.Value {
+			case "ordering":
+				if ordering, ok := objectField.Value.GetValue().(string); ok {
+					streamCursor.Ordering = ordering
 				}
+			case "initial_value":
+				readInitialValueFromAst(objectField.Value, variables, streamCursor)
+			}
+		}
+	case *ast.Variable:
+		readCursorFromVariable(variables[v.Name.Value], []string{v.Name.Value}, streamCursor)
+	}
+}
+
+func readInitialValueFromAst(value ast.Value, variables map[string]interface{}, streamCursor *StreamCursor) {
+	switch v := value.(type) {
+	case *ast.ObjectValue:
+		for _, objectField := range v.Fields {
+			cursorField := StreamCursorField{
+				Name: objectField.Name.Value,
 			}
+			if variable, isVariable := objectField.Value.(*ast.Variable); isVariable {
+				cursorField.VariablePath = []string{variable.Name.Value}
+				cursorField.CurrValue = variables[variable.Name.Value]
+			} else {
+				cursorField.CurrValue = getAstLiteralValue(objectField.Value)
+			}
+			streamCursor.Fields = append(streamCursor.Fields, cursorField)
 		}
+	case *ast.Variable:
+		readInitialValueFromVariable(variables[v.Name.Value], []string{v.Name.Value}, streamCursor)
 	}
+}
 
-	StoreStreamCursorValueCache(dataChecksum, lastStreamCursorValue)
-	return lastStreamCursorValue
+func readCursorFromVariable(value interface{}, variablePath []string, streamCursor *StreamCursor) {
+	switch v := value.(type) {
+	case []interface{}:
+		for i, item := range v {
+			readCursorFromVariable(item, appendPath(variablePath, strconv.Itoa(i)), streamCursor)
+		}
+	case map[string]interface{}:
+		if ordering, ok := v["ordering"].(string); ok {
+			streamCursor.Ordering = ordering
+		}
+		readInitialValueFromVariable(v["initial_value"], appendPath(variablePath, "initial_value"), streamCursor)
+	}
 }
 
-func PatchQueryIncludingCursorField(originalQuery string, cursorField string) string {
-	if cursorField == "" {
+func readInitialValueFromVariable(value interface{}, variablePath []string, streamCursor *StreamCursor) {
+	if initialValue, ok := value.(map[string]interface{}); ok {
+		for fieldName, fieldValue := range initialValue {
+			streamCursor.Fields = append(streamCursor.Fields, StreamCursorField{
+				Name:         fieldName,
+				VariablePath: appendPath(variablePath, fieldName),
+				CurrValue:    fieldValue,
+			})
+		}
+	}
+}
+
+func appendPath(path []string, key string) []string {
+	newPath := make([]string, len(path), len(path)+1)
+	copy(newPath, path)
+	return append(newPath, key)
+}
+
+func getAstLiteralValue(value ast.Value) interface{} {
+	switch v := value.(type) {
+	case *ast.IntValue:
+		if asInt, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+			return asInt
+		}
+		return v.Value
+	case *ast.FloatValue:
+		if asFloat, err := strconv.ParseFloat(v.Value, 64); err == nil {
+			return asFloat
+		}
+		return v.Value
+	default:
+		return value.GetValue()
+	}
+}
+
+func getAstValueFromLiteral(value interface{}) ast.Value {
+	switch v := value.(type) {
+	case string:
+		return ast.NewStringValue(&ast.StringValue{Value: v})
+	case bool:
+		return ast.NewBooleanValue(&ast.BooleanValue{Value: v})
+	case int:
+		return ast.NewIntValue(&ast.IntValue{Value: strconv.Itoa(v)})
+	case int64:
+		return ast.NewIntValue(&ast.IntValue{Value: strconv.FormatInt(v, 10)})
+	case float32:
+		return getAstValueFromLiteral(float64(v))
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return ast.NewIntValue(&ast.IntValue{Value: strconv.FormatInt(int64(v), 10)})
+		}
+		return ast.NewFloatValue(&ast.FloatValue{Value: strconv.FormatFloat(v, 'f', -1, 64)})
+	default:
+		return nil
+	}
+}
+
+// PatchQueryIncludingCursorFields assures the cursor fields will return in the result of the query
+// so it's possible to store the last received cursor value of each streamed root field
+func PatchQueryIncludingCursorFields(originalQuery string, operationName string, streamCursors []StreamCursor) string {
+	if len(streamCursors) == 0 {
 		return originalQuery
 	}
 
-	lastIndexOfTypename := LastButOneIndex(originalQuery, "}")
-	if lastIndexOfTypename == -1 {
+	astDoc, err := ParseGraphQlQuery(originalQuery)
+	if err != nil {
 		return originalQuery
 	}
 
-	// It will include the cursorField at the end of the list of fields
-	// It's not a problem if the field be duplicated in the list, Hasura just ignore the second occurrence
-	return originalQuery[:lastIndexOfTypename] + "  " + cursorField + "\n  " + originalQuery[lastIndexOfTypename:]
+	op, _ := SelectOperationDefinition(astDoc, operationName)
+
+	queryChanged := false
+	for _, field := range getStreamedRootFields(astDoc, op) {
+		if field.SelectionSet == nil {
+			continue
+		}
+
+		for _, streamCursor := range streamCursors {
+			if streamCursor.ResponseKey != getFieldResponseKey(field) {
+				continue
+			}
+
+			for _, cursorField := range streamCursor.Fields {
+				if !selectionSetContainsField(field.SelectionSet, cursorField.Name) {
+					field.SelectionSet.Selections = append(field.SelectionSet.Selections, ast.NewField(&ast.Field{
+						Name: ast.NewName(&ast.Name{Value: cursorField.Name}),
+					}))
+					queryChanged = true
+				}
+			}
+		}
+	}
+
+	if !queryChanged {
+		return originalQuery
+	}
+
+	if printedQuery, ok := printer.Print(astDoc).(string); ok {
+		return printedQuery
+	}
+	return originalQuery
+}
+
+// selectionSetContainsField checks if the field is selected without alias (so it will be returned with its own name)
+func selectionSetContainsField(selectionSet *ast.SelectionSet, fieldName string) bool {
+	for _, selection := range selectionSet.Selections {
+		if field, ok := selection.(*ast.Field); ok && getFieldResponseKey(field) == fieldName && field.Name.Value == fieldName {
+			return true
+		}
+	}
+	return false
+}
+
+func GetLastStreamCursorValuesFromReceivedMessage(message []byte, streamCursors []StreamCursor) StreamCursorValues {
+	//The values depend on the cursors (response keys and columns), so they are part of the key
+	cacheKey := crc32.ChecksumIEEE(message)
+	for _, streamCursor := range streamCursors {
+		cacheKey = crc32.Update(cacheKey, crc32.IEEETable, []byte("\x00"+streamCursor.ResponseKey))
+		for _, cursorField := range streamCursor.Fields {
+			cacheKey = crc32.Update(cacheKey, crc32.IEEETable, []byte("\x01"+cursorField.Name))
+		}
+	}
+
+	//Other routines receiving the same message will wait to benefit from this cache
+	return StreamCursorValueCache.GetOrCompute(cacheKey, func() StreamCursorValues {
+		return getLastStreamCursorValues(message, streamCursors)
+	})
+}
+
+func getLastStreamCursorValues(message []byte, streamCursors []StreamCursor) StreamCursorValues {
+	lastStreamCursorValues := make(StreamCursorValues)
+
+	var hasuraMessage HasuraMessage
+	err := json.Unmarshal(message, &hasuraMessage)
+	if err != nil {
+		log.Errorf("failed to unmarshal message: %v", err)
+		return lastStreamCursorValues
+	}
+
+	for _, streamCursor := range streamCursors {
+		var dataItems []map[string]interface{}
+		if err := json.Unmarshal(hasuraMessage.Payload.Data[streamCursor.ResponseKey], &dataItems); err != nil || len(dataItems) == 0 {
+			continue
+		}
+
+		// Get the last item directly (once it will contain the last cursor value)
+		lastItemOfMessage := dataItems[len(dataItems)-1]
+		lastStreamCursorValues[streamCursor.ResponseKey] = make(map[string]interface{})
+		for _, cursorField := range streamCursor.Fields {
+			if lastItemValue, okLastItemValue := lastItemOfMessage[cursorField.Name]; okLastItemValue && lastItemValue != nil {
+				lastStreamCursorValues[streamCursor.ResponseKey][cursorField.Name] = lastItemValue
+			}
+		}
+	}
+
+	return lastStreamCursorValues
+}
+
+// UpdateStreamCursorValues returns a copy of the cursors with the last values received (and if any value changed)
+func UpdateStreamCursorValues(streamCursors []StreamCursor, lastValues StreamCursorValues) ([]StreamCursor, bool) {
+	changed := false
+	updatedStreamCursors := make([]StreamCursor, len(streamCursors))
+	for i, streamCursor := range streamCursors {
+		updatedStreamCursors[i] = streamCursor
+		updatedStreamCursors[i].Fields = make([]StreamCursorField, len(streamCursor.Fields))
+		for j, cursorField := range streamCursor.Fields {
+			if lastValue, exists := lastValues[streamCursor.ResponseKey][cursorField.Name]; exists &&
+				!reflect.DeepEqual(lastValue, cursorField.CurrValue) {
+				cursorField.CurrValue = lastValue
+				changed = true
+			}
+			updatedStreamCursors[i].Fields[j] = cursorField
+		}
+	}
+
+	return updatedStreamCursors, changed
 }
 
+// PatchQuerySettingLastCursorValue sets the last received cursor values as initial values
+// so a retransmitted stream subscription continues from where it stopped
 func PatchQuerySettingLastCursorValue(subscription GraphQlSubscription) []byte {
 	var browserMessage BrowserSubscribeMessage
 	err := json.Unmarshal(subscription.Message, &browserMessage)
@@ -99,54 +348,53 @@ func PatchQuerySettingLastCursorValue(subscription GraphQlSubscription) []byte {
 		return subscription.Message
 	}
 
-	if subscription.StreamCursorVariableName != "" {
-		/**** This stream has its cursor value set through variables ****/
-		if browserMessage.Payload.Variables[subscription.StreamCursorVariableName] == subscription.StreamCursorCurrValue {
-			return subscription.Message
-		}
-		browserMessage.Payload.Variables[subscription.StreamCursorVariableName] = subscription.StreamCursorCurrValue
-	} else {
-		/**** This stream has its cursor value set through inline value (not variables) ****/
-		cursorInitialValueRePattern := regexp.MustCompile(`cursor:\s*\{\s*initial_value\s*:\s*\{\s*([^:]+:\s*[^}]+)\s*}\s*}`)
-		newValue := ""
+	astDoc, err := ParseGraphQlQuery(browserMessage.Payload.Query)
+	if err != nil {
+		log.Errorf("failed to set last cursor value: %v", err)
+		return subscription.Message
+	}
 
-		replaceInitialValueFunc := func(match string) string {
-			switch v := subscription.StreamCursorCurrValue.(type) {
-			case string:
-				newValue = v
+	messageChanged := false
+	queryChanged := false
+	op, _ := SelectOperationDefinition(astDoc, browserMessage.Payload.OperationName)
+	streamedFields := getStreamedRootFields(astDoc, op)
+	for _, streamCursor := range subscription.StreamCursors {
+		for _, cursorField := range streamCursor.Fields {
+			if cursorField.CurrValue == nil {
+				continue
+			}
 
-				//Append quotes if it is missing, it will be necessary when appending to the query
-				if !strings.HasPrefix(v, "\"") {
-					newValue = "\"" + newValue
+			if len(cursorField.VariablePath) > 0 {
+				/**** This stream has its cursor value set through variables ****/
+				if setVariableValue(browserMessage.Payload.Variables, cursorField.VariablePath, cursorField.CurrValue) {
+					messageChanged = true
 				}
-				if !strings.HasSuffix(v, "\"") {
-					newValue = newValue + "\""
+				continue
+			}
+
+			/**** This stream has its cursor value set through inline value (not variables) ****/
+			newValue := getAstValueFromLiteral(cursorField.CurrValue)
+			if newValue == nil {
+				continue
+			}
+			for _, field := range streamedFields {
+				if getFieldResponseKey(field) == streamCursor.ResponseKey &&
+					setInlineCursorValue(getFieldArgument(field, "cursor").Value, cursorField.Name, newValue) {
+					queryChanged = true
 				}
-			case int:
-				newValue = strconv.Itoa(v)
-			case float32:
-				myFloat64 := float64(v)
-				newValue = strconv.FormatFloat(myFloat64, 'f', -1, 32)
-			case float64:
-				newValue = strconv.FormatFloat(v, 'f', -1, 64)
-			default:
-				newValue = ""
-			}
-
-			if newValue != "" {
-				replacement := subscription.StreamCursorField + ": " + newValue
-				return fmt.Sprintf("cursor: {initial_value: {%s}}", replacement)
-			} else {
-				return match
 			}
 		}
+	}
 
-		newQuery := cursorInitialValueRePattern.ReplaceAllStringFunc(browserMessage.Payload.Query, replaceInitialValueFunc)
-		if browserMessage.Payload.Query == newQuery {
-			return subscription.Message
+	if queryChanged {
+		if printedQuery, ok := printer.Print(astDoc).(string); ok {
+			browserMessage.Payload.Query = printedQuery
+			messageChanged = true
 		}
+	}
 
-		browserMessage.Payload.Query = newQuery
+	if !messageChanged {
+		return subscription.Message
 	}
 
 	newMessageJson, _ := json.Marshal(browserMessage)
@@ -154,11 +402,72 @@ func PatchQuerySettingLastCursorValue(subscription GraphQlSubscription) []byte {
 	return newMessageJson
 }
 
-func LastButOneIndex(s, substr string) int {
-	last := strings.LastIndex(s, substr)
-	if last == -1 {
-		return -1
+func setInlineCursorValue(cursorValue ast.Value, cursorFieldName string, newValue ast.Value) bool {
+	switch v := cursorValue.(type) {
+	case *ast.ListValue:
+		changed := false
+		for _, item := range v.Values {
+			if setInlineCursorValue(item, cursorFieldName, newValue) {
+				changed = true
+			}
+		}
+		return changed
+	case *ast.ObjectValue:
+		for _, objectField := range v.Fields {
+			if objectField.Name.Value != "initial_value" {
+				continue
+			}
+			if initialValue, ok := objectField.Value.(*ast.ObjectValue); ok {
+				for _, initialValueField := range initialValue.Fields {
+					if initialValueField.Name.Value == cursorFieldName &&
+						!reflect.DeepEqual(initialValueField.Value.GetValue(), newValue.GetValue()) {
+						initialValueField.Value = newValue
+						return true
+					}
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func setVariableValue(variables map[string]interface{}, variablePath []string, value interface{}) bool {
+	if variables == nil || len(variablePath) == 0 {
+		return false
+	}
+
+	var current interface{} = variables
+	for i, key := range variablePath {
+		isLast := i == len(variablePath)-1
+
+		switch container := current.(type) {
+		case map[string]interface{}:
+			if isLast {
+				if reflect.DeepEqual(container[key], value) {
+					return false
+				}
+				container[key] = value
+				return true
+			}
+			current = container[key]
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(container) {
+				return false
+			}
+			if isLast {
+				if reflect.DeepEqual(container[index], value) {
+					return false
+				}
+				container[index] = value
+				return true
+			}
+			current = container[index]
+		default:
+			return false
+		}
 	}
 
-	return strings.LastIndex(s[:last], substr)
+	return false
 }
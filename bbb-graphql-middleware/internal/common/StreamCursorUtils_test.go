@@ -0,0 +1,182 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func subscribeMessage(operationName string, query string, variables map[string]interface{}) BrowserSubscribeMessage {
+	var message BrowserSubscribeMessage
+	message.Type = "subscribe"
+	message.ID = "1"
+	message.Payload.OperationName = operationName
+	message.Payload.Query = query
+	message.Payload.Variables = variables
+	return message
+}
+
+func assertStreamCursors(t *testing.T, expected []StreamCursor, actual []StreamCursor) {
+	t.Helper()
+
+	if !reflect.DeepEqual(expected, actual) {
+		expectedJson, _ := json.Marshal(expected)
+		actualJson, _ := json.Marshal(actual)
+		t.Fatalf("expected cursors %s, got %s", expectedJson, actualJson)
+	}
+}
+
+func TestStreamCursorsOfAliasedRootFields(t *testing.T) {
+	cursors := GetStreamCursorsFromBrowserMessage(subscribeMessage("chatMessages", `subscription chatMessages {
+		messages: chat_message_public_stream(batch_size: 10, cursor: {initial_value: {createdAt: "2024-01-01"}, ordering: DESC}) {
+			message
+		}
+	}`, nil))
+
+	assertStreamCursors(t, []StreamCursor{{
+		ResponseKey: "messages",
+		Ordering:    "DESC",
+		Fields:      []StreamCursorField{{Name: "createdAt", CurrValue: "2024-01-01"}},
+	}}, cursors)
+}
+
+func TestStreamCursorsOfMultipleRootFields(t *testing.T) {
+	cursors := GetStreamCursorsFromBrowserMessage(subscribeMessage("streams", `subscription streams {
+		chat_message_public_stream(batch_size: 10, cursor: {initial_value: {createdAt: "2024-01-01"}}) { message }
+		user { userId }
+		pres_annotation_curr_stream(batch_size: 10, cursor: {initial_value: {annotationId: 5}}) { annotationInfo }
+	}`, nil))
+
+	assertStreamCursors(t, []StreamCursor{{
+		ResponseKey: "chat_message_public_stream",
+		Ordering:    "ASC",
+		Fields:      []StreamCursorField{{Name: "createdAt", CurrValue: "2024-01-01"}},
+	}, {
+		ResponseKey: "pres_annotation_curr_stream",
+		Ordering:    "ASC",
+		Fields:      []StreamCursorField{{Name: "annotationId", CurrValue: int64(5)}},
+	}}, cursors)
+}
+
+func TestStreamCursorsInlineAndThroughVariables(t *testing.T) {
+	query := `subscription streams($createdAt: timestamptz, $cursor: [annotation_stream_cursor_input]!) {
+		chat_message_public_stream(batch_size: 10, cursor: {initial_value: {createdAt: $createdAt}}) { message }
+		pres_annotation_curr_stream(batch_size: 10, cursor: $cursor) { annotationInfo }
+	}`
+	variables := map[string]interface{}{
+		"createdAt": "2024-01-01",
+		"cursor": []interface{}{
+			map[string]interface{}{"initial_value": map[string]interface{}{"annotationId": float64(5)}, "ordering": "DESC"},
+		},
+	}
+
+	cursors := GetStreamCursorsFromBrowserMessage(subscribeMessage("streams", query, variables))
+	assertStreamCursors(t, []StreamCursor{{
+		ResponseKey: "chat_message_public_stream",
+		Ordering:    "ASC",
+		Fields:      []StreamCursorField{{Name: "createdAt", VariablePath: []string{"createdAt"}, CurrValue: "2024-01-01"}},
+	}, {
+		ResponseKey: "pres_annotation_curr_stream",
+		Ordering:    "DESC",
+		Fields: []StreamCursorField{{
+			Name:         "annotationId",
+			VariablePath: []string{"cursor", "0", "initial_value", "annotationId"},
+			CurrValue:    float64(5),
+		}},
+	}}, cursors)
+
+	// the last values are set in the variables, the query is kept
+	cursors[0].Fields[0].CurrValue = "2024-02-02"
+	cursors[1].Fields[0].CurrValue = float64(9)
+	subscribeJson, _ := json.Marshal(subscribeMessage("streams", query, variables))
+	patchedJson := PatchQuerySettingLastCursorValue(GraphQlSubscription{Message: subscribeJson, StreamCursors: cursors})
+
+	var patched BrowserSubscribeMessage
+	_ = json.Unmarshal(patchedJson, &patched)
+	if patched.Payload.Query != query {
+		t.Errorf("query changed although the cursors are variables: %s", patched.Payload.Query)
+	}
+	if patched.Payload.Variables["createdAt"] != "2024-02-02" {
+		t.Errorf("expected createdAt variable 2024-02-02, got %v", patched.Payload.Variables["createdAt"])
+	}
+	annotationCursor := patched.Payload.Variables["cursor"].([]interface{})[0].(map[string]interface{})
+	if annotationId := annotationCursor["initial_value"].(map[string]interface{})["annotationId"]; annotationId != float64(9) {
+		t.Errorf("expected annotationId variable 9, got %v", annotationId)
+	}
+}
+
+func TestStreamCursorsThroughFragments(t *testing.T) {
+	query := `subscription streams {
+		...ChatStream
+		... on subscription_root {
+			annotations: pres_annotation_curr_stream(batch_size: 10, cursor: {initial_value: {annotationId: 5}}) { annotationInfo }
+		}
+	}
+	fragment ChatStream on subscription_root {
+		chat_message_public_stream(batch_size: 10, cursor: {initial_value: {createdAt: "2024-01-01"}}) { message }
+	}`
+
+	cursors := GetStreamCursorsFromBrowserMessage(subscribeMessage("streams", query, nil))
+	assertStreamCursors(t, []StreamCursor{{
+		ResponseKey: "chat_message_public_stream",
+		Ordering:    "ASC",
+		Fields:      []StreamCursorField{{Name: "createdAt", CurrValue: "2024-01-01"}},
+	}, {
+		ResponseKey: "annotations",
+		Ordering:    "ASC",
+		Fields:      []StreamCursorField{{Name: "annotationId", CurrValue: int64(5)}},
+	}}, cursors)
+
+	// the cursor fields are selected inside the fragments
+	patchedQuery := PatchQueryIncludingCursorFields(query, "streams", cursors)
+	for _, expected := range []string{"message\n    createdAt", "annotationInfo\n      annotationId"} {
+		if !strings.Contains(patchedQuery, expected) {
+			t.Errorf("cursor field not selected (%q) in %s", expected, patchedQuery)
+		}
+	}
+
+	// and the last values are set inside them
+	cursors[0].Fields[0].CurrValue = "2024-02-02"
+	cursors[1].Fields[0].CurrValue = int64(9)
+	subscribeJson, _ := json.Marshal(subscribeMessage("streams", query, nil))
+	var patched BrowserSubscribeMessage
+	_ = json.Unmarshal(PatchQuerySettingLastCursorValue(GraphQlSubscription{Message: subscribeJson, StreamCursors: cursors}), &patched)
+
+	assertStreamCursors(t, []StreamCursor{{
+		ResponseKey: "chat_message_public_stream",
+		Ordering:    "ASC",
+		Fields:      []StreamCursorField{{Name: "createdAt", CurrValue: "2024-02-02"}},
+	}, {
+		ResponseKey: "annotations",
+		Ordering:    "ASC",
+		Fields:      []StreamCursorField{{Name: "annotationId", CurrValue: int64(9)}},
+	}}, GetStreamCursorsFromBrowserMessage(patched))
+}
+
+func TestLastStreamCursorValuesDependOnTheCursors(t *testing.T) {
+	message := []byte(`{"id":"1","type":"next","payload":{"data":{"messages":[{"createdAt":"2024-01-01","messageId":"m1"},{"createdAt":"2024-01-02","messageId":"m2"}]}}}`)
+
+	byCreatedAt := GetLastStreamCursorValuesFromReceivedMessage(message, []StreamCursor{{
+		ResponseKey: "messages",
+		Fields:      []StreamCursorField{{Name: "createdAt"}},
+	}})
+	composite := GetLastStreamCursorValuesFromReceivedMessage(message, []StreamCursor{{
+		ResponseKey: "messages",
+		Fields:      []StreamCursorField{{Name: "createdAt"}, {Name: "messageId"}},
+	}})
+	otherKey := GetLastStreamCursorValuesFromReceivedMessage(message, []StreamCursor{{
+		ResponseKey: "other",
+		Fields:      []StreamCursorField{{Name: "createdAt"}},
+	}})
+
+	if !reflect.DeepEqual(byCreatedAt, StreamCursorValues{"messages": {"createdAt": "2024-01-02"}}) {
+		t.Errorf("unexpected values %v", byCreatedAt)
+	}
+	if !reflect.DeepEqual(composite, StreamCursorValues{"messages": {"createdAt": "2024-01-02", "messageId": "m2"}}) {
+		t.Errorf("values cached for another cursor returned for the composite cursor: %v", composite)
+	}
+	if len(otherKey) != 0 {
+		t.Errorf("values cached for another response key returned: %v", otherKey)
+	}
+}
@@ -0,0 +1,26 @@
+package common
+
+// AddSubscriberId registers the id of an operation started by the browser,
+// it returns false when there's already an active operation with this id
+func AddSubscriberId(browserConnection *BrowserConnection, id string) bool {
+	browserConnection.Lock()
+	defer browserConnection.Unlock()
+
+	if browserConnection.SubscriberIds == nil {
+		browserConnection.SubscriberIds = make(map[string]bool)
+	}
+	if browserConnection.SubscriberIds[id] {
+		return false
+	}
+	browserConnection.SubscriberIds[id] = true
+	return true
+}
+
+// RemoveSubscriberId releases the id once the operation was completed (by the browser or the server),
+// so the browser can use it again
+func RemoveSubscriberId(browserConnection *BrowserConnection, id string) {
+	browserConnection.Lock()
+	defer browserConnection.Unlock()
+
+	delete(browserConnection.SubscriberIds, id)
+}
@@ -0,0 +1,136 @@
+package common
+
+import (
+	"bbb-graphql-middleware/config"
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var backoffConfig = config.GetConfig().UpstreamBackoff
+
+// UpstreamBackoff is the delay between the attempts of a connection to (re)connect with an upstream,
+// exponential after failures and with jitter so the connections don't retry all at the same time
+type UpstreamBackoff struct {
+	upstream     string
+	initialDelay time.Duration
+
+	mutex     sync.Mutex
+	failures  int
+	succeeded bool
+}
+
+// NewUpstreamBackoff creates the backoff of the upstream, the delay starts at initialDelayMs
+// (upstream_backoff.initial_delay_ms when not informed)
+func NewUpstreamBackoff(upstream string, initialDelayMs int) *UpstreamBackoff {
+	if initialDelayMs <= 0 {
+		initialDelayMs = backoffConfig.InitialDelayMs
+	}
+	initialDelay := time.Duration(initialDelayMs) * time.Millisecond
+	if initialDelay <= 0 {
+		initialDelay = 100 * time.Millisecond
+	}
+	return &UpstreamBackoff{upstream: upstream, initialDelay: initialDelay}
+}
+
+// Success indicates the current attempt was established (e.g. Hasura sent `connection_ack`),
+// so when it ends the next attempt waits only the initial delay
+func (b *UpstreamBackoff) Success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.succeeded = true
+}
+
+// Wait sleeps before the next attempt, it returns false if the context was cancelled meanwhile
+// Every attempt that didn't succeed (including the first one after a success) increases the delay
+func (b *UpstreamBackoff) Wait(ctx context.Context) bool {
+	b.mutex.Lock()
+	if b.succeeded {
+		b.failures = 0
+	} else {
+		b.failures++
+	}
+	b.succeeded = false
+	delay := b.delay(rand.Float64())
+	inBackoff := b.failures > 0
+	b.mutex.Unlock()
+
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if inBackoff {
+		UpstreamBackoffGauge.With(prometheus.Labels{"upstream": b.upstream}).Inc()
+		defer UpstreamBackoffGauge.With(prometheus.Labels{"upstream": b.upstream}).Dec()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// delay returns initial_delay * multiplier^failures (up to max_delay_ms), reduced by up to `jitter`
+// (1 = full jitter, any value in [0, delay)) according to random, in [0, 1)
+func (b *UpstreamBackoff) delay(random float64) time.Duration {
+	maxDelay := time.Duration(backoffConfig.MaxDelayMs) * time.Millisecond
+	if maxDelay < b.initialDelay {
+		maxDelay = b.initialDelay
+	}
+	multiplier := backoffConfig.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	delay := float64(b.initialDelay) * math.Pow(multiplier, float64(b.failures))
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+
+	//Jitter on every attempt, a restart of the upstream closes all the connections at the same moment
+	if backoffConfig.Jitter > 0 {
+		delay -= delay * math.Min(backoffConfig.Jitter, 1) * random
+	}
+	return time.Duration(delay)
+}
+
+var upstreamDials = newUpstreamDialsSemaphore()
+
+func newUpstreamDialsSemaphore() chan struct{} {
+	if backoffConfig.MaxConcurrentDials <= 0 {
+		return nil
+	}
+	return make(chan struct{}, backoffConfig.MaxConcurrentDials)
+}
+
+// AcquireUpstreamDial waits until less than upstream_backoff.max_concurrent_dials dials are in progress,
+// the function returned must be called once the dial is finished
+func AcquireUpstreamDial(ctx context.Context) (func(), error) {
+	if upstreamDials == nil {
+		return func() {}, nil
+	}
+
+	UpstreamDialsWaitingGauge.Inc()
+	defer UpstreamDialsWaitingGauge.Dec()
+
+	select {
+	case upstreamDials <- struct{}{}:
+		UpstreamDialsInProgressGauge.Inc()
+		return func() {
+			UpstreamDialsInProgressGauge.Dec()
+			<-upstreamDials
+		}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
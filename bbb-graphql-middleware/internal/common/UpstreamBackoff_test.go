@@ -0,0 +1,87 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func setBackoffConfig(t *testing.T, initialDelayMs int, maxDelayMs int, multiplier float64, jitter float64) {
+	t.Helper()
+
+	previous := backoffConfig
+	t.Cleanup(func() { backoffConfig = previous })
+	backoffConfig.InitialDelayMs = initialDelayMs
+	backoffConfig.MaxDelayMs = maxDelayMs
+	backoffConfig.Multiplier = multiplier
+	backoffConfig.Jitter = jitter
+}
+
+func TestUpstreamBackoffDelaySequence(t *testing.T) {
+	setBackoffConfig(t, 100, 1000, 2, 1)
+	backoff := NewUpstreamBackoff("hasura", 0)
+
+	expected := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for failures, expectedDelay := range expected {
+		backoff.failures = failures
+		expectedDelay *= time.Millisecond
+
+		if delay := backoff.delay(0); delay != expectedDelay {
+			t.Errorf("failures %d: expected delay %v without jitter, got %v", failures, expectedDelay, delay)
+		}
+		if delay := backoff.delay(0.5); delay != expectedDelay/2 {
+			t.Errorf("failures %d: expected delay %v with half jitter, got %v", failures, expectedDelay/2, delay)
+		}
+		if delay := backoff.delay(0.999); delay < 0 || delay >= expectedDelay {
+			t.Errorf("failures %d: expected delay in [0, %v), got %v", failures, expectedDelay, delay)
+		}
+	}
+}
+
+func TestUpstreamBackoffPartialJitter(t *testing.T) {
+	setBackoffConfig(t, 100, 1000, 2, 0.5)
+	backoff := NewUpstreamBackoff("hasura", 0)
+
+	//The first attempt also has jitter, so the connections dropped together don't retry together
+	if delay := backoff.delay(0.999); delay > 51*time.Millisecond || delay < 50*time.Millisecond {
+		t.Errorf("expected delay reduced by up to 50%%, got %v", delay)
+	}
+}
+
+func TestUpstreamBackoffCountsFailedAttempts(t *testing.T) {
+	setBackoffConfig(t, 100, 1000, 2, 1)
+	backoff := NewUpstreamBackoff("hasura", 0)
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expectFailures := func(expected int) {
+		t.Helper()
+		if backoff.Wait(cancelledCtx) {
+			t.Fatal("expected Wait to return false when the context is cancelled")
+		}
+		if backoff.failures != expected {
+			t.Fatalf("expected %d failures, got %d", expected, backoff.failures)
+		}
+	}
+
+	expectFailures(1)
+	expectFailures(2)
+
+	backoff.Success()
+	expectFailures(0)
+
+	//The first attempt failing after a success is counted
+	expectFailures(1)
+}
+
+func TestUpstreamBackoffInitialDelay(t *testing.T) {
+	setBackoffConfig(t, 100, 30000, 2, 1)
+
+	if delay := NewUpstreamBackoff("hasura", 0).delay(0); delay != 100*time.Millisecond {
+		t.Errorf("expected the initial delay of the config, got %v", delay)
+	}
+	if delay := NewUpstreamBackoff("graphql-actions", 1000).delay(0); delay != time.Second {
+		t.Errorf("expected the initial delay informed, got %v", delay)
+	}
+}
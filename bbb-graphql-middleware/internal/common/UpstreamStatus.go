@@ -0,0 +1,120 @@
+package common
+
+import (
+	"bbb-graphql-middleware/config"
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type UpstreamStatus string
+
+const (
+	UpstreamReconnecting UpstreamStatus = "reconnecting" // the connection with the upstream was lost, subscribes are queued
+	UpstreamFailing      UpstreamStatus = "failing"      // the reconnection is failing repeatedly
+	UpstreamRestored     UpstreamStatus = "restored"     // reconnected, the queued subscribes were sent
+)
+
+var upstreamStatusConfig = config.GetConfig().UpstreamStatus
+
+// NotifyUpstreamLost starts the timer that informs the browser the connection with the upstream is down when it's
+// not restored within upstream_status.notify_after_ms (e.g. reconnections forced by the session invalidation are
+// not notified). The timer starts on the first loss, so hanging dials or long backoffs don't delay the notification
+func NotifyUpstreamLost(browserConnection *BrowserConnection, upstream string) {
+	if !upstreamStatusConfig.Enabled {
+		return
+	}
+
+	browserConnection.UpstreamStatusMutex.Lock()
+	defer browserConnection.UpstreamStatusMutex.Unlock()
+
+	if !browserConnection.UpstreamLostAt.IsZero() {
+		return
+	}
+	lostAt := time.Now()
+	browserConnection.UpstreamLostAt = lostAt
+
+	notifyAfter := time.Duration(upstreamStatusConfig.NotifyAfterMs) * time.Millisecond
+	time.AfterFunc(notifyAfter, func() {
+		browserConnection.UpstreamStatusMutex.Lock()
+		defer browserConnection.UpstreamStatusMutex.Unlock()
+
+		//Restored (or lost again) meanwhile
+		if !browserConnection.UpstreamLostAt.Equal(lostAt) || browserConnection.Context.Err() != nil {
+			return
+		}
+		if GetUpstreamStatus(browserConnection) == "" {
+			sendUpstreamStatus(browserConnection, upstream, UpstreamReconnecting, "connection_lost")
+		}
+	})
+}
+
+// NotifyUpstreamDialFailed informs the browser the reconnection is failing after upstream_status.failing_after_attempts
+func NotifyUpstreamDialFailed(browserConnection *BrowserConnection, upstream string, failedDials int) {
+	if !upstreamStatusConfig.Enabled || upstreamStatusConfig.FailingAfterAttempts <= 0 ||
+		failedDials < upstreamStatusConfig.FailingAfterAttempts {
+		return
+	}
+
+	browserConnection.UpstreamStatusMutex.Lock()
+	defer browserConnection.UpstreamStatusMutex.Unlock()
+
+	if browserConnection.UpstreamLostAt.IsZero() || browserConnection.Context.Err() != nil {
+		return
+	}
+	if GetUpstreamStatus(browserConnection) != UpstreamFailing {
+		sendUpstreamStatus(browserConnection, upstream, UpstreamFailing, upstream+"_unreachable")
+	}
+}
+
+// NotifyUpstreamRestored cancels the pending notification and informs the browser when it was told the upstream was down
+func NotifyUpstreamRestored(browserConnection *BrowserConnection, upstream string) {
+	browserConnection.UpstreamStatusMutex.Lock()
+	defer browserConnection.UpstreamStatusMutex.Unlock()
+
+	browserConnection.UpstreamLostAt = time.Time{}
+	if GetUpstreamStatus(browserConnection) != "" {
+		sendUpstreamStatus(browserConnection, upstream, UpstreamRestored, "")
+	}
+}
+
+// sendUpstreamStatus informs the browser about the state of the connection with the upstream,
+// as a graphql-transport-ws `ping` (clients not handling its payload just answer with `pong`)
+// It's called holding the UpstreamStatusMutex, so the browser receives the statuses in order
+func sendUpstreamStatus(browserConnection *BrowserConnection, upstream string, status UpstreamStatus, reason string) {
+	browserConnection.Lock()
+	if status == UpstreamRestored {
+		browserConnection.UpstreamStatus = ""
+	} else {
+		browserConnection.UpstreamStatus = status
+	}
+	browserConnection.StatusPingsWaitingPong++
+	browserConnection.Unlock()
+
+	upstreamStatus := map[string]interface{}{
+		"upstream": upstream,
+		"status":   status,
+	}
+	if reason != "" {
+		upstreamStatus["reason"] = reason
+	}
+	jsonStatus, _ := json.Marshal(map[string]interface{}{
+		"type": "ping",
+		"payload": map[string]interface{}{
+			"upstreamStatus": upstreamStatus,
+		},
+	})
+
+	browserConnection.Logger.Infof("notifying browser that %s is %s (%s)", upstream, status, reason)
+	UpstreamStatusNotificationCounter.With(prometheus.Labels{"upstream": upstream, "status": string(status)}).Inc()
+	browserConnection.FromHasuraToBrowserChannel.Send(jsonStatus)
+}
+
+// GetUpstreamStatus returns the last status sent to the browser, empty when the upstream is healthy
+func GetUpstreamStatus(browserConnection *BrowserConnection) UpstreamStatus {
+	browserConnection.RLock()
+	defer browserConnection.RUnlock()
+
+	return browserConnection.UpstreamStatus
+}
@@ -1,12 +1,15 @@
 package common
 
 import (
+	"bbb-graphql-middleware/internal/capture"
+	"bbb-graphql-middleware/internal/msgencoding"
 	"context"
 	"encoding/json"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/time/rate"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"nhooyr.io/websocket"
@@ -27,42 +30,63 @@ type GraphQlSubscription struct {
 	Message                    []byte
 	Type                       QueryType
 	OperationName              string
-	StreamCursorField          string
-	StreamCursorVariableName   string
-	StreamCursorCurrValue      interface{}
+	StreamCursors              []StreamCursor // cursor of each streamed root field (when Type is Streaming)
 	LastReceivedData           HasuraMessage
 	LastReceivedDataChecksum   uint32
-	JsonPatchSupported         bool   // indicate if client support Json Patch for this subscription
-	LastSeenOnHasuraConnection string // id of the hasura connection that this query was active
+	DeliveredData              HasuraMessage  // last data written to the browser (LastReceivedData may still be queued)
+	DeliveredDataChecksum      uint32         // checksum of DeliveredData
+	DeliveredStreamCursors     []StreamCursor // cursors of the last stream items written to the browser
+	JsonPatchSupported         bool           // indicate if client support Json Patch for this subscription
+	LastSeenOnHasuraConnection string         // id of the hasura connection that this query was active
+	SentToHasuraAt             time.Time      // when the subscribe was sent to Hasura (zero once the first data is received)
 }
 
 type BrowserConnection struct {
 	sync.RWMutex
-	Id                                 string             // browser connection id
-	Websocket                          *websocket.Conn    // websocket of browser connection
-	SessionToken                       string             // session token of this connection
-	MeetingId                          string             // auth info provided by bbb-web
-	UserId                             string             // auth info provided by bbb-web
-	BBBWebSessionVariables             map[string]string  // graphql session variables provided by akka-apps
-	ClientSessionUUID                  string             // self-generated unique id for this client
-	Context                            context.Context    // browser connection context
-	ContextCancelFunc                  context.CancelFunc // function to cancel the browser context (and so, the browser connection)
+	Id                                 string               // browser connection id
+	Websocket                          *websocket.Conn      // websocket of browser connection
+	WebsocketCompression               string               // compression negotiated with the browser (none or permessage-deflate)
+	FrameWriter                        FrameWriter          // set when the compressed frames are shared with other connections (no_context_takeover)
+	WireEncoding                       msgencoding.Encoding // encoding of the frames exchanged with the browser (json, msgpack or cbor)
+	SessionToken                       string               // session token of this connection
+	MeetingId                          string               // auth info provided by bbb-web
+	UserId                             string               // auth info provided by bbb-web
+	BBBWebSessionVariables             map[string]string    // graphql session variables provided by akka-apps
+	ClientSessionUUID                  string               // self-generated unique id for this client
+	ClientType                         string               // type of the client (X-ClientType header of connection_init)
+	ClientIsMobile                     bool                 // indicate if the client is a mobile device (X-ClientIsMobile header)
+	Context                            context.Context      // browser connection context
+	ContextCancelFunc                  context.CancelFunc   // function to cancel the browser context (and so, the browser connection)
 	BrowserRequestCookies              []*http.Cookie
-	ActiveSubscriptions                map[string]GraphQlSubscription // active subscriptions of this connection (start, but no stop)
-	ActiveSubscriptionsMutex           sync.RWMutex                   // mutex to control the map usage
-	ConnectionInitMessage              []byte                         // init message received in this connection (to be used on hasura reconnect)
-	HasuraConnection                   *HasuraConnection              // associated hasura connection
-	Disconnected                       bool                           // indicate if the connection is gone
-	ConnAckSentToBrowser               bool                           // indicate if `connection_ack` msg was already sent to the browser
-	GraphqlActionsContext              context.Context                // graphql actions context
-	GraphqlActionsContextCancel        context.CancelFunc             // function to cancel the graphql actions context
-	FromBrowserToHasuraChannel         *SafeChannelByte               // channel to transmit messages from Browser to Hasura
-	FromBrowserToHasuraRateLimiter     *rate.Limiter                  // rate limiter to transmit messages from Browser to Hasura
-	FromBrowserToGqlActionsChannel     *SafeChannelByte               // channel to transmit messages from Browser to Graphq-Actions
-	FromBrowserToGqlActionsRateLimiter *rate.Limiter                  // rate limiter to transmit messages from Browser to Graphq-Actions
-	FromHasuraToBrowserChannel         *SafeChannelByte               // channel to transmit messages from Hasura/GqlActions to Browser
-	LastBrowserMessageTime             time.Time                      // stores the time of the last message to control browser idleness
-	Logger                             *logrus.Entry                  // connection logger populated with connection info
+	ActiveSubscriptions                map[string]GraphQlSubscription   // active subscriptions of this connection (start, but no stop)
+	ActiveSubscriptionsMutex           sync.RWMutex                     // mutex to control the map usage
+	SubscriberIds                      map[string]bool                  // ids of the operations started by the browser and not completed yet (protected by the connection mutex)
+	ConnectionInitMessage              []byte                           // init message received in this connection (to be used on hasura reconnect)
+	HasuraConnection                   *HasuraConnection                // associated hasura connection
+	HasuraBackoff                      *UpstreamBackoff                 // delay between the connection attempts with Hasura (reset on connection_ack)
+	Disconnected                       bool                             // indicate if the connection is gone
+	ConnAckSentToBrowser               atomic.Bool                      // indicate if `connection_ack` msg was already sent to the browser
+	ResumeToken                        string                           // token the browser can present to resume this session after a reconnect
+	Capabilities                       *ClientCapabilities              // capabilities accepted in connection_init (nil when the client didn't declare them)
+	UpstreamStatus                     UpstreamStatus                   // last upstream status sent to the browser (reconnecting or failing), empty when healthy
+	UpstreamStatusMutex                sync.Mutex                       // serializes the upstream status notifications (protects UpstreamLostAt)
+	UpstreamLostAt                     time.Time                        // when the connection with the upstream was lost, zero once it's acknowledged again
+	Resumed                            bool                             // indicate if the subscriptions were resumed from a previous connection
+	GraphqlActionsContext              context.Context                  // graphql actions context
+	GraphqlActionsContextCancel        context.CancelFunc               // function to cancel the graphql actions context
+	FromBrowserToHasuraChannel         *SafeChannelByte                 // channel to transmit messages from Browser to Hasura
+	FromBrowserToHasuraRateLimiter     *rate.Limiter                    // rate limiter to transmit messages from Browser to Hasura
+	FromBrowserToGqlActionsChannel     *SafeChannelByte                 // channel to transmit messages from Browser to Graphq-Actions
+	FromBrowserToGqlActionsRateLimiter *rate.Limiter                    // rate limiter to transmit messages from Browser to Graphq-Actions
+	FromHasuraToBrowserChannel         *SafeChannelByte                 // channel to transmit messages from Hasura/GqlActions to Browser
+	LastBrowserMessageTime             time.Time                        // stores the time of the last message to control browser idleness
+	PingId                             int64                            // id of the last keepalive ping sent to the browser (in its payload)
+	PingSentAt                         time.Time                        // when the ping waiting for a pong was sent to the browser (zero when answered)
+	StatusPingsWaitingPong             int                              // upstream status pings not answered yet (their pongs are not keepalive ones)
+	LastPongTime                       time.Time                        // time of the last pong received from the browser
+	PingRtt                            time.Duration                    // smoothed round trip time of the keepalive pings answered by the browser
+	Logger                             *logrus.Entry                    // connection logger populated with connection info
+	Capture                            atomic.Pointer[capture.Recorder] // traffic capture (nil when this connection is not being captured)
 }
 
 type HasuraConnection struct {
@@ -72,6 +96,7 @@ type HasuraConnection struct {
 	WebsocketCloseError *websocket.CloseError // closeError received from Hasura
 	Context             context.Context       // hasura connection context (child of browser connection context)
 	ContextCancelFunc   context.CancelFunc    // function to cancel the hasura context (and so, the hasura connection)
+	Logger              *logrus.Entry         // browser connection logger populated with hasura connection info
 }
 
 type HasuraMessage struct {
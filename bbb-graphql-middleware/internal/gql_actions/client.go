@@ -2,17 +2,23 @@ package gql_actions
 
 import (
 	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/audit"
 	"bbb-graphql-middleware/internal/common"
+	"bbb-graphql-middleware/internal/hasura/schema"
+	"bbb-graphql-middleware/internal/tracing"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/graphql-go/graphql/language/ast"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io/ioutil"
 	"net/http"
-	"regexp"
-	"strings"
+	"strconv"
 	"time"
 )
 
@@ -45,70 +51,7 @@ RangeLoop:
 				}
 
 				if browserMessage.Type == "subscribe" {
-					var mutationFuncName string
-
-					if config.GetConfig().Server.MaxMutationLength > 0 {
-						mutationLength := len(browserMessage.Payload.Query)
-						if mutationLength > config.GetConfig().Server.MaxMutationLength {
-							sendErrorMessage(
-								browserConnection,
-								browserMessage.ID,
-								fmt.Sprintf(
-									"Mutation %s is not valid with length %d and the max allowed is %d",
-									browserMessage.Payload.OperationName,
-									mutationLength, config.GetConfig().Server.MaxMutationLength))
-							continue
-						}
-					}
-
-					//Rate limiter from config max_connection_mutations_per_minute
-					ctxRateLimiter, _ := context.WithTimeout(browserConnection.Context, 30*time.Second)
-					if err := browserConnection.FromBrowserToGqlActionsRateLimiter.Wait(ctxRateLimiter); err != nil {
-						sendErrorMessage(
-							browserConnection,
-							browserMessage.ID,
-							fmt.Sprintf("Rate limit exceeded: Maximum %d mutations per minute allowed. Please try again later.", config.GetConfig().Server.MaxConnectionMutationsPerMinute),
-						)
-
-						continue
-					}
-
-					if strings.HasPrefix(browserMessage.Payload.Query, "mutation") {
-						if funcName, inputs, err := parseGraphQLMutation(browserMessage.Payload.Query, browserMessage.Payload.Variables); err == nil {
-							mutationFuncName = funcName
-							if err = SendGqlActionsRequest(funcName, inputs, browserConnection.BBBWebSessionVariables, browserConnection.Logger); err == nil {
-								//Add Prometheus Metrics
-								common.GqlMutationsCounter.With(prometheus.Labels{"operationName": browserMessage.Payload.OperationName}).Inc()
-							} else {
-								sendErrorMessage(browserConnection, browserMessage.ID, fmt.Sprintf("It was not able to send the request to Graphql Actions: %s", err.Error()))
-								continue
-							}
-						} else {
-							sendErrorMessage(browserConnection, browserMessage.ID, fmt.Sprintf("It was not able to parse graphQL query: %s", err.Error()))
-							continue
-						}
-					}
-
-					//Action sent successfully, return data msg to client
-					browserResponseData := map[string]interface{}{
-						"id":   browserMessage.ID,
-						"type": "next",
-						"payload": map[string]interface{}{
-							"data": map[string]interface{}{
-								mutationFuncName: true,
-							},
-						},
-					}
-					jsonDataNext, _ := json.Marshal(browserResponseData)
-					browserConnection.FromHasuraToBrowserChannel.Send(jsonDataNext)
-
-					//Return complete msg to client
-					browserResponseComplete := map[string]interface{}{
-						"id":   browserMessage.ID,
-						"type": "complete",
-					}
-					jsonDataComplete, _ := json.Marshal(browserResponseComplete)
-					browserConnection.FromHasuraToBrowserChannel.Send(jsonDataComplete)
+					handleMutationMessage(browserConnection, browserMessage)
 				}
 
 				//Fallback to Hasura was disabled (keeping the code temporarily)
@@ -120,7 +63,132 @@ RangeLoop:
 	return nil
 }
 
-func SendGqlActionsRequest(funcName string, inputs map[string]interface{}, sessionVariables map[string]string, bcLogger *log.Entry) error {
+// handleMutationMessage sends the mutation to graphql-actions and records it in the audit log
+func handleMutationMessage(browserConnection *common.BrowserConnection, browserMessage common.BrowserSubscribeMessage) {
+	var mutationFuncName string
+
+	//Every mutation is recorded, including the ones rejected by the middleware
+	auditRecord := audit.NewRecord(audit.Mutation, browserConnection)
+	auditRecord.OperationName = browserMessage.Payload.OperationName
+	auditRecord.Outcome = audit.Rejected
+	traceCtx := tracing.DequeueOperation(browserConnection.Id, browserMessage.ID)
+	defer func() {
+		audit.Log(auditRecord)
+
+		var err error
+		if auditRecord.Outcome != audit.Success {
+			err = errors.New(auditRecord.Error)
+		}
+		tracing.EndOperation(browserConnection.Id, browserMessage.ID, err)
+	}()
+	sendError := func(errorMessage string) {
+		auditRecord.Error = errorMessage
+		common.SendErrorMessage(browserConnection, browserMessage.ID, errorMessage)
+	}
+
+	if config.GetConfig().Server.MaxMutationLength > 0 {
+		mutationLength := len(browserMessage.Payload.Query)
+		if mutationLength > config.GetConfig().Server.MaxMutationLength {
+			sendError(
+				fmt.Sprintf(
+					"Mutation %s is not valid with length %d and the max allowed is %d",
+					browserMessage.Payload.OperationName,
+					mutationLength, config.GetConfig().Server.MaxMutationLength))
+			return
+		}
+	}
+
+	//Rate limiter from config max_connection_mutations_per_minute
+	_, rateLimiterSpan := tracing.StartSpan(traceCtx, "rate_limiter")
+	ctxRateLimiter, cancelRateLimiter := context.WithTimeout(browserConnection.Context, 30*time.Second)
+	errRateLimiter := browserConnection.FromBrowserToGqlActionsRateLimiter.Wait(ctxRateLimiter)
+	cancelRateLimiter()
+	tracing.EndSpan(rateLimiterSpan, errRateLimiter)
+	if errRateLimiter != nil {
+		sendError(
+			fmt.Sprintf("Rate limit exceeded: Maximum %d mutations per minute allowed. Please try again later.", config.GetConfig().Server.MaxConnectionMutationsPerMinute),
+		)
+
+		return
+	}
+
+	if validationErrors := schema.ValidateOperation(browserMessage.Payload.Query, browserConnection.BBBWebSessionVariables["x-hasura-role"]); validationErrors != nil {
+		auditRecord.Error = validationErrors[0].Message
+		common.SendValidationErrors(browserConnection, browserMessage.ID, browserMessage.Payload.OperationName, validationErrors)
+		return
+	}
+
+	operation := common.ClassifyOperation(browserMessage.Payload.Query, browserMessage.Payload.OperationName)
+
+	//Mutations can't be held, so the policy either allows or denies them
+	if policyDecision := common.EvaluateOperationPolicy(browserConnection, operation); policyDecision.Effect != common.PolicyAllow {
+		reason := policyDecision.Reason
+		if reason == "" {
+			reason = "operation not allowed"
+		}
+		sendError(
+			fmt.Sprintf("Operation %s denied by policy %s: %s", operation.OperationName, policyDecision.Rule, reason))
+		return
+	}
+
+	if operation.Type == common.Mutation {
+		if funcName, inputs, err := parseGraphQLMutation(browserMessage.Payload.Query, browserMessage.Payload.OperationName, browserMessage.Payload.Variables); err == nil {
+			mutationFuncName = funcName
+			auditRecord.Action = funcName
+			auditRecord.Inputs = audit.RedactInputs(inputs)
+
+			startedAt := time.Now()
+			err = SendGqlActionsRequest(traceCtx, funcName, inputs, browserConnection.BBBWebSessionVariables, browserConnection.Logger)
+			auditRecord.LatencyMs = float64(time.Since(startedAt).Microseconds()) / 1000
+			common.GqlMutationDuration.With(prometheus.Labels{"operationName": browserMessage.Payload.OperationName}).Observe(time.Since(startedAt).Seconds())
+			if err == nil {
+				auditRecord.Outcome = audit.Success
+				//Add Prometheus Metrics
+				common.GqlMutationsCounter.With(prometheus.Labels{"operationName": browserMessage.Payload.OperationName}).Inc()
+			} else {
+				auditRecord.Outcome = audit.Failed
+				sendError(fmt.Sprintf("It was not able to send the request to Graphql Actions: %s", err.Error()))
+				return
+			}
+		} else {
+			sendError(fmt.Sprintf("It was not able to parse graphQL query: %s", err.Error()))
+			return
+		}
+	}
+
+	//Action sent successfully, return data msg to client
+	browserResponseData := map[string]interface{}{
+		"id":   browserMessage.ID,
+		"type": "next",
+		"payload": map[string]interface{}{
+			"data": map[string]interface{}{
+				mutationFuncName: true,
+			},
+		},
+	}
+	jsonDataNext, _ := json.Marshal(browserResponseData)
+	browserConnection.FromHasuraToBrowserChannel.Send(jsonDataNext)
+
+	//Return complete msg to client
+	browserResponseComplete := map[string]interface{}{
+		"id":   browserMessage.ID,
+		"type": "complete",
+	}
+	jsonDataComplete, _ := json.Marshal(browserResponseComplete)
+	browserConnection.FromHasuraToBrowserChannel.Send(jsonDataComplete)
+}
+
+// SendGqlActionsRequest posts the action to graphql-actions, ctx is the context of the traced mutation (or nil)
+func SendGqlActionsRequest(ctx context.Context, funcName string, inputs map[string]interface{}, sessionVariables map[string]string, bcLogger *log.Entry) error {
+	ctx, span := tracing.StartSpan(ctx, "graphql-actions",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("bbb.action", funcName)))
+	err := sendGqlActionsRequest(ctx, funcName, inputs, sessionVariables, bcLogger)
+	tracing.EndSpan(span, err)
+	return err
+}
+
+func sendGqlActionsRequest(ctx context.Context, funcName string, inputs map[string]interface{}, sessionVariables map[string]string, bcLogger *log.Entry) error {
 	logger := bcLogger.WithField("funcName", funcName).WithField("inputs", inputs)
 
 	data := GqlActionsRequestBody{
@@ -142,7 +210,14 @@ func SendGqlActionsRequest(funcName string, inputs map[string]interface{}, sessi
 
 	startedAt := time.Now()
 
-	response, err := http.Post(graphqlActionsUrl, "application/json", bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, "POST", graphqlActionsUrl, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	tracing.InjectHeaders(ctx, request.Header)
+
+	response, err := http.DefaultClient.Do(request)
 	if err != nil {
 		return err
 	}
@@ -187,70 +262,62 @@ type GqlActionsAction struct {
 	Name string `json:"name"`
 }
 
-func parseGraphQLMutation(query string, variables map[string]interface{}) (string, map[string]interface{}, error) {
-	// Extract the function name from the query
-	reFuncName := regexp.MustCompile(`\{\s*(\w+)`)
-	funcNameMatch := reFuncName.FindStringSubmatch(query)
-	if len(funcNameMatch) < 2 {
+func parseGraphQLMutation(query string, operationName string, variables map[string]interface{}) (string, map[string]interface{}, error) {
+	astDoc, err := common.ParseGraphQlQuery(query)
+	if err != nil {
+		return "", nil, err
+	}
+
+	op, err := common.SelectOperationDefinition(astDoc, operationName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The function name is the (first) root field of the mutation
+	rootFields := common.CollectRootFields(astDoc, op)
+	if len(rootFields) == 0 {
 		return "", nil, fmt.Errorf("failed to extract function name from query")
 	}
-	funcName := funcNameMatch[1]
+	funcName := rootFields[0].Name.Value
 
-	// Prepare to extract and parse parameters
+	// Extract the params, handling variable substitution
 	queryParams := make(map[string]interface{})
-	reParams := regexp.MustCompile(`\{[^(]+\(([^)]+)\)`)
-	paramsMatch := reParams.FindStringSubmatch(query)
-	if len(paramsMatch) >= 2 {
-		paramSplitRegex := regexp.MustCompile("[,\n]")
-		params := paramSplitRegex.Split(paramsMatch[1], -1)
-
-		for _, param := range params {
-			cleanedParam := strings.ReplaceAll(param, " ", "")
-			if cleanedParam == "" {
-				continue
-			}
-			paramParts := strings.Split(cleanedParam, ":")
-			if len(paramParts) != 2 {
-				continue // Skip invalid params
-			}
-			paramName, paramValue := strings.Trim(paramParts[0], " \t"), paramParts[1]
-
-			// Handle variable substitution
-			if strings.HasPrefix(paramValue, "$") {
-				varName := strings.TrimPrefix(paramValue, "$")
-				if value, ok := variables[varName]; ok {
-					queryParams[paramName] = value
-				}
-			} else {
-				queryParams[paramName] = paramValue
-			}
-		}
+	for _, argument := range rootFields[0].Arguments {
+		queryParams[argument.Name.Value] = getArgumentValue(argument.Value, variables)
 	}
 
 	return funcName, queryParams, nil
 }
 
-func sendErrorMessage(browserConnection *common.BrowserConnection, messageId string, errorMessage string) {
-	browserConnection.Logger.Errorf(errorMessage)
-
-	//Error on sending action, return error msg to client
-	browserResponseData := map[string]interface{}{
-		"id":   messageId,
-		"type": "error",
-		"payload": []interface{}{
-			map[string]interface{}{
-				"message": errorMessage,
-			},
-		},
-	}
-	jsonDataError, _ := json.Marshal(browserResponseData)
-	browserConnection.FromHasuraToBrowserChannel.Send(jsonDataError)
-
-	//Return complete msg to client
-	browserResponseComplete := map[string]interface{}{
-		"id":   messageId,
-		"type": "complete",
+func getArgumentValue(value ast.Value, variables map[string]interface{}) interface{} {
+	switch v := value.(type) {
+	case *ast.Variable:
+		return variables[v.Name.Value]
+	case *ast.IntValue:
+		if asInt, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+			return asInt
+		}
+		return v.Value
+	case *ast.FloatValue:
+		if asFloat, err := strconv.ParseFloat(v.Value, 64); err == nil {
+			return asFloat
+		}
+		return v.Value
+	case *ast.ListValue:
+		list := make([]interface{}, 0, len(v.Values))
+		for _, item := range v.Values {
+			list = append(list, getArgumentValue(item, variables))
+		}
+		return list
+	case *ast.ObjectValue:
+		object := make(map[string]interface{}, len(v.Fields))
+		for _, objectField := range v.Fields {
+			object[objectField.Name.Value] = getArgumentValue(objectField.Value, variables)
+		}
+		return object
+	case nil:
+		return nil
+	default:
+		return value.GetValue()
 	}
-	jsonDataComplete, _ := json.Marshal(browserResponseComplete)
-	browserConnection.FromHasuraToBrowserChannel.Send(jsonDataComplete)
 }
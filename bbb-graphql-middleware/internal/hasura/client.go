@@ -1,7 +1,6 @@
 package hasura
 
 import (
-	"bbb-graphql-middleware/config"
 	"bbb-graphql-middleware/internal/hasura/conn/reader"
 	"bbb-graphql-middleware/internal/hasura/conn/writer"
 	"context"
@@ -11,26 +10,30 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 	"sync"
+	"sync/atomic"
 
 	"bbb-graphql-middleware/internal/common"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/xerrors"
 	"nhooyr.io/websocket"
 )
 
-var lastHasuraConnectionId int
-var hasuraEndpoint = config.GetConfig().Hasura.Url
+var lastHasuraConnectionId atomic.Int64
 
 // Hasura client connection
 func HasuraClient(
 	browserConnection *common.BrowserConnection) error {
 
 	// Obtain id for this connection
-	lastHasuraConnectionId++
-	hasuraConnectionId := "HC" + fmt.Sprintf("%010d", lastHasuraConnectionId)
+	hasuraConnectionId := "HC" + fmt.Sprintf("%010d", lastHasuraConnectionId.Add(1))
 
-	browserConnection.Logger = browserConnection.Logger.WithField("hasuraConnectionId", hasuraConnectionId)
+	hasuraEndpoint := selectEndpoint(browserConnection)
 
-	defer browserConnection.Logger.Debugf("finished")
+	//The browser connection logger is shared with other routines, so the hasura connection has its own
+	logger := browserConnection.Logger.WithField("hasuraConnectionId", hasuraConnectionId).
+		WithField("hasuraEndpoint", hasuraEndpoint.url)
+
+	defer logger.Debugf("finished")
 
 	// Add sub-protocol
 	var dialOptions websocket.DialOptions
@@ -41,7 +44,7 @@ func HasuraClient(
 	if err != nil {
 		return xerrors.Errorf("failed to create cookie jar: %w", err)
 	}
-	parsedURL, err := url.Parse(hasuraEndpoint)
+	parsedURL, err := url.Parse(hasuraEndpoint.url)
 	if err != nil {
 		return xerrors.Errorf("failed to parse url: %w", err)
 	}
@@ -64,13 +67,14 @@ func HasuraClient(
 		BrowserConn:       browserConnection,
 		Context:           hasuraConnectionContext,
 		ContextCancelFunc: hasuraConnectionContextCancel,
+		Logger:            logger,
 	}
 
 	browserConnection.HasuraConnection = &thisConnection
 	defer func() {
 		//When Hasura sends an CloseError, it will forward the error to the browser and close the connection
 		if thisConnection.WebsocketCloseError != nil {
-			browserConnection.Logger.Infof("Closing browser connection because Hasura connection was closed, reason: %s", thisConnection.WebsocketCloseError.Reason)
+			logger.Infof("Closing browser connection because Hasura connection was closed, reason: %s", thisConnection.WebsocketCloseError.Reason)
 			browserConnection.Websocket.Close(thisConnection.WebsocketCloseError.Code, thisConnection.WebsocketCloseError.Reason)
 			browserConnection.ContextCancelFunc()
 		}
@@ -82,19 +86,50 @@ func HasuraClient(
 		browserConnection.FromBrowserToHasuraChannel.FreezeChannel()
 	}()
 
-	// Make the connection
-	hasuraWsConn, _, err := websocket.Dial(hasuraConnectionContext, hasuraEndpoint, &dialOptions)
+	// Make the connection (limited by upstream_backoff.max_concurrent_dials)
+	releaseDial, err := common.AcquireUpstreamDial(hasuraConnectionContext)
+	if err != nil {
+		return xerrors.Errorf("waiting to connect to hasura: %w", err)
+	}
+	hasuraWsConn, _, err := websocket.Dial(hasuraConnectionContext, hasuraEndpoint.url, &dialOptions)
+	releaseDial()
 	if err != nil {
+		//The health is decided only by the health check, a failed dial is retried (the backoff selects the endpoint again)
 		return xerrors.Errorf("error connecting to hasura: %v", err)
 	}
 	defer hasuraWsConn.Close(websocket.StatusInternalError, "the sky is falling")
 
 	hasuraWsConn.SetReadLimit(math.MaxInt64 - 1)
 
+	common.HasuraConnectionGauge.Inc()
+	defer common.HasuraConnectionGauge.Dec()
+	hasuraEndpoint.connections.Add(1)
+	common.HasuraEndpointConnectionsGauge.With(prometheus.Labels{"endpoint": hasuraEndpoint.url}).Inc()
+	defer func() {
+		hasuraEndpoint.connections.Add(-1)
+		common.HasuraEndpointConnectionsGauge.With(prometheus.Labels{"endpoint": hasuraEndpoint.url}).Dec()
+	}()
+	if browserConnection.ConnAckSentToBrowser.Load() {
+		common.HasuraReconnectionCounter.Inc()
+	}
+
 	thisConnection.Websocket = hasuraWsConn
 
 	// Log the connection success
-	browserConnection.Logger.Info("connected with Hasura")
+	logger.Info("connected with Hasura")
+
+	// Move to a healthy endpoint when this one fails (the subscriptions are retransmitted by the next connection)
+	go func() {
+		select {
+		case <-hasuraConnectionContext.Done():
+		case <-hasuraEndpoint.failedChannel():
+			if len(healthyEndpoints()) > 0 {
+				logger.Infof("Hasura endpoint is unhealthy, moving the connection to a healthy one")
+				common.HasuraEndpointFailoverCounter.With(prometheus.Labels{"endpoint": hasuraEndpoint.url}).Inc()
+				hasuraConnectionContextCancel()
+			}
+		}
+	}()
 
 	// Configure the wait group
 	var wg sync.WaitGroup
@@ -1,9 +1,12 @@
 package reader
 
 import (
+	"bbb-graphql-middleware/internal/capture"
 	"bbb-graphql-middleware/internal/common"
 	"bbb-graphql-middleware/internal/hasura/retransmiter"
 	"bbb-graphql-middleware/internal/msgpatch"
+	"bbb-graphql-middleware/internal/tap"
+	"bbb-graphql-middleware/internal/tracing"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -13,26 +16,28 @@ import (
 	"hash/crc32"
 	"nhooyr.io/websocket"
 	"sync"
+	"time"
 )
 
 // HasuraConnectionReader consumes messages from Hasura connection and add send to the browser channel
 func HasuraConnectionReader(hc *common.HasuraConnection, wg *sync.WaitGroup) {
-	defer hc.BrowserConn.Logger.Debugf("finished")
-	hc.BrowserConn.Logger.Debugf("starting")
+	defer hc.Logger.Debugf("finished")
+	hc.Logger.Debugf("starting")
 
 	defer wg.Done()
 	defer hc.ContextCancelFunc()
 
 	for {
 		messageType, message, err := hc.Websocket.Read(hc.Context)
+		receivedAt := time.Now()
 		var closeError *websocket.CloseError
 
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
-				hc.BrowserConn.Logger.Debugf("Closing Hasura ws connection as Context was cancelled!")
+				hc.Logger.Debugf("Closing Hasura ws connection as Context was cancelled!")
 			} else if errors.As(err, &closeError) {
 				hc.WebsocketCloseError = closeError
-				hc.BrowserConn.Logger.Debug("Hasura WebSocket connection closed: status = %v, reason = %s", closeError.Code, closeError.Reason)
+				hc.Logger.Debugf("Hasura WebSocket connection closed: status = %v, reason = %s", closeError.Code, closeError.Reason)
 				//TODO check if it should send {"type":"connection_error","payload":"Authentication hook unauthorized this request"}
 			} else {
 				if websocket.CloseStatus(err) == -1 {
@@ -45,25 +50,28 @@ func HasuraConnectionReader(hc *common.HasuraConnection, wg *sync.WaitGroup) {
 					}
 				}
 
-				hc.BrowserConn.Logger.Debugf("Error reading message from Hasura: %v", err)
+				hc.Logger.Debugf("Error reading message from Hasura: %v", err)
 			}
 			return
 		}
 
 		if messageType != websocket.MessageText {
-			hc.BrowserConn.Logger.Warnf("received non-text message: %v", messageType)
+			hc.Logger.Warnf("received non-text message: %v", messageType)
 			continue
 		}
 
-		hc.BrowserConn.Logger.Tracef("received from hasura: %s", string(message))
+		hc.Logger.Tracef("received from hasura: %s", string(message))
 
-		handleMessageReceivedFromHasura(hc, message)
+		hc.BrowserConn.Capture.Load().Record(capture.HasuraToMiddleware, hc.Id, message)
+		tap.Mirror(hc.BrowserConn, capture.HasuraToMiddleware, hc.Id, message)
+
+		handleMessageReceivedFromHasura(hc, message, receivedAt)
 	}
 }
 
 var QueryIdPlaceholderInBytes = []byte("--------------QUERY-ID--------------") //36 chars
 
-func handleMessageReceivedFromHasura(hc *common.HasuraConnection, message []byte) {
+func handleMessageReceivedFromHasura(hc *common.HasuraConnection, message []byte, receivedAt time.Time) {
 	type HasuraMessageInfo struct {
 		Type string `json:"type"`
 		ID   string `json:"id"`
@@ -71,11 +79,12 @@ func handleMessageReceivedFromHasura(hc *common.HasuraConnection, message []byte
 	var hasuraMessageInfo HasuraMessageInfo
 	err := json.Unmarshal(message, &hasuraMessageInfo)
 	if err != nil {
-		hc.BrowserConn.Logger.Errorf("failed to unmarshal message: %v", err)
+		hc.Logger.Errorf("failed to unmarshal message: %v", err)
 		return
 	}
 
 	queryIdReplacementApplied := false
+	var onDelivered func() //advances the state delivered to the browser once the message is written
 	queryIdInBytes := []byte(hasuraMessageInfo.ID)
 
 	//Check if subscription is still active!
@@ -84,10 +93,18 @@ func handleMessageReceivedFromHasura(hc *common.HasuraConnection, message []byte
 		subscription, ok := hc.BrowserConn.ActiveSubscriptions[hasuraMessageInfo.ID]
 		hc.BrowserConn.ActiveSubscriptionsMutex.RUnlock()
 		if !ok {
-			hc.BrowserConn.Logger.Debugf("Subscription with Id %s doesn't exist anymore, skipping response.", hasuraMessageInfo.ID)
+			hc.Logger.Debugf("Subscription with Id %s doesn't exist anymore, skipping response.", hasuraMessageInfo.ID)
 			return
 		}
 
+		//The span of the operation ends once Hasura answers it
+		switch hasuraMessageInfo.Type {
+		case "next", "complete":
+			tracing.EndOperation(hc.BrowserConn.Id, hasuraMessageInfo.ID, nil)
+		case "error":
+			tracing.EndOperation(hc.BrowserConn.Id, hasuraMessageInfo.ID, errors.New("error returned by Hasura"))
+		}
+
 		//When Hasura send msg type "complete", this query is finished
 		if hasuraMessageInfo.Type == "complete" {
 			handleCompleteMessage(hc, hasuraMessageInfo.ID)
@@ -99,6 +116,10 @@ func handleMessageReceivedFromHasura(hc *common.HasuraConnection, message []byte
 					"type":          string(subscription.Type),
 					"operationName": subscription.OperationName}).
 				Inc()
+
+			if !subscription.SentToHasuraAt.IsZero() {
+				handleFirstDataMessage(hc, &subscription, hasuraMessageInfo.ID, receivedAt)
+			}
 		}
 
 		if hasuraMessageInfo.Type == "next" &&
@@ -108,7 +129,8 @@ func handleMessageReceivedFromHasura(hc *common.HasuraConnection, message []byte
 			message = bytes.Replace(message, queryIdInBytes, QueryIdPlaceholderInBytes, 1)
 			queryIdReplacementApplied = true
 
-			isDifferentFromPreviousMessage := handleSubscriptionMessage(hc, &message, subscription, hasuraMessageInfo.ID)
+			var isDifferentFromPreviousMessage bool
+			isDifferentFromPreviousMessage, onDelivered = handleSubscriptionMessage(hc, &message, subscription, hasuraMessageInfo.ID)
 
 			//Stop processing case it is the same message (probably is a reconnection with Hasura)
 			if !isDifferentFromPreviousMessage {
@@ -121,7 +143,7 @@ func handleMessageReceivedFromHasura(hc *common.HasuraConnection, message []byte
 			//Remove queryId from message
 			messageWithoutId := bytes.Replace(message, queryIdInBytes, QueryIdPlaceholderInBytes, 1)
 
-			handleStreamingMessage(hc, messageWithoutId, subscription, hasuraMessageInfo.ID)
+			onDelivered = handleStreamingMessage(hc, messageWithoutId, subscription, hasuraMessageInfo.ID)
 		}
 	}
 
@@ -129,23 +151,34 @@ func handleMessageReceivedFromHasura(hc *common.HasuraConnection, message []byte
 	// this is useful in case of a connection invalidation
 	if hasuraMessageInfo.Type == "connection_ack" {
 		handleConnectionAckMessage(hc, message)
+	} else if hasuraMessageInfo.Type == "ping" {
+		//The browser pings are answered by the middleware, so are the Hasura ones
+		if err := hc.Websocket.Write(hc.Context, websocket.MessageText, []byte(`{"type":"pong"}`)); err != nil {
+			hc.Logger.Debugf("error answering the Hasura ping: %v", err)
+		}
+	} else if hasuraMessageInfo.Type == "pong" {
+		//Browser pings are not sent to Hasura anymore, nothing to forward
 	} else {
 		if queryIdReplacementApplied {
 			message = bytes.Replace(message, QueryIdPlaceholderInBytes, queryIdInBytes, 1)
 		}
 
 		// Forward the message to browser
-		hc.BrowserConn.FromHasuraToBrowserChannel.Send(message)
+		hc.BrowserConn.FromHasuraToBrowserChannel.SendWithInfo(message, common.SentValueInfo{
+			ReceivedAt:  receivedAt,
+			OnDelivered: onDelivered,
+		})
 	}
 }
 
-func handleSubscriptionMessage(hc *common.HasuraConnection, message *[]byte, subscription common.GraphQlSubscription, queryId string) bool {
-	dataChecksum, messageDataKey, messageData := getHasuraMessage(*message, subscription, hc.BrowserConn.Logger)
+// handleSubscriptionMessage returns false when the data didn't change, otherwise the function to call once it's delivered
+func handleSubscriptionMessage(hc *common.HasuraConnection, message *[]byte, subscription common.GraphQlSubscription, queryId string) (bool, func()) {
+	dataChecksum, messageDataKey, messageData := getHasuraMessage(*message, subscription, hc.Logger)
 
 	//Check whether ReceivedData is different from the LastReceivedData
 	//Otherwise stop forwarding this message
 	if subscription.LastReceivedDataChecksum == dataChecksum {
-		return false
+		return false, nil
 	}
 
 	lastDataChecksumWas := subscription.LastReceivedDataChecksum
@@ -153,32 +186,73 @@ func handleSubscriptionMessage(hc *common.HasuraConnection, message *[]byte, sub
 	cacheKey := mergeUint32(subscription.LastReceivedDataChecksum, dataChecksum)
 
 	//Store LastReceivedData Checksum
-	subscription.LastReceivedData = messageData
-	subscription.LastReceivedDataChecksum = dataChecksum
-	hc.BrowserConn.ActiveSubscriptionsMutex.Lock()
-	hc.BrowserConn.ActiveSubscriptions[queryId] = subscription
-	hc.BrowserConn.ActiveSubscriptionsMutex.Unlock()
+	updateActiveSubscription(hc.BrowserConn, queryId, func(activeSubscription *common.GraphQlSubscription) {
+		activeSubscription.LastReceivedData = messageData
+		activeSubscription.LastReceivedDataChecksum = dataChecksum
+	})
 
 	//Apply msg patch when it supports it
 	if subscription.JsonPatchSupported {
-		*message = msgpatch.GetPatchedMessage(*message, messageDataKey, lastReceivedDataWas, messageData, cacheKey, lastDataChecksumWas, dataChecksum)
+		*message = msgpatch.GetPatchedMessage(*message, subscription.OperationName, messageDataKey, lastReceivedDataWas, messageData, cacheKey, lastDataChecksumWas, dataChecksum)
 	}
 
-	return true
+	return true, func() {
+		updateActiveSubscription(hc.BrowserConn, queryId, func(activeSubscription *common.GraphQlSubscription) {
+			activeSubscription.DeliveredData = messageData
+			activeSubscription.DeliveredDataChecksum = dataChecksum
+		})
+	}
+}
+
+// updateActiveSubscription changes the subscription, unless it was finished meanwhile
+// Only the fields updated are written, as the reader and the browser writer update different ones
+func updateActiveSubscription(bc *common.BrowserConnection, queryId string, update func(activeSubscription *common.GraphQlSubscription)) {
+	bc.ActiveSubscriptionsMutex.Lock()
+	defer bc.ActiveSubscriptionsMutex.Unlock()
+
+	if activeSubscription, exists := bc.ActiveSubscriptions[queryId]; exists {
+		update(&activeSubscription)
+		bc.ActiveSubscriptions[queryId] = activeSubscription
+	}
+}
+
+// handleFirstDataMessage records how long Hasura took to send the first data of the subscription
+func handleFirstDataMessage(hc *common.HasuraConnection, subscription *common.GraphQlSubscription, queryId string, receivedAt time.Time) {
+	common.GqlFirstDataDuration.
+		With(prometheus.Labels{
+			"type":          string(subscription.Type),
+			"operationName": subscription.OperationName}).
+		Observe(receivedAt.Sub(subscription.SentToHasuraAt).Seconds())
+
+	subscription.SentToHasuraAt = time.Time{}
+	hc.BrowserConn.ActiveSubscriptionsMutex.Lock()
+	if activeSubscription, exists := hc.BrowserConn.ActiveSubscriptions[queryId]; exists {
+		activeSubscription.SentToHasuraAt = time.Time{}
+		hc.BrowserConn.ActiveSubscriptions[queryId] = activeSubscription
+	}
+	hc.BrowserConn.ActiveSubscriptionsMutex.Unlock()
 }
 
 func mergeUint32(a, b uint32) uint32 {
 	return (a << 16) | (b >> 16)
 }
 
-func handleStreamingMessage(hc *common.HasuraConnection, message []byte, subscription common.GraphQlSubscription, queryId string) {
-	lastCursor := common.GetLastStreamCursorValueFromReceivedMessage(message, subscription.StreamCursorField)
-	if lastCursor != nil && subscription.StreamCursorCurrValue != lastCursor {
-		subscription.StreamCursorCurrValue = lastCursor
+// handleStreamingMessage returns the function to call once the message is delivered (nil when the cursors didn't change)
+func handleStreamingMessage(hc *common.HasuraConnection, message []byte, subscription common.GraphQlSubscription, queryId string) func() {
+	lastCursorValues := common.GetLastStreamCursorValuesFromReceivedMessage(message, subscription.StreamCursors)
+	updatedStreamCursors, changed := common.UpdateStreamCursorValues(subscription.StreamCursors, lastCursorValues)
+	if !changed {
+		return nil
+	}
+
+	updateActiveSubscription(hc.BrowserConn, queryId, func(activeSubscription *common.GraphQlSubscription) {
+		activeSubscription.StreamCursors = updatedStreamCursors
+	})
 
-		hc.BrowserConn.ActiveSubscriptionsMutex.Lock()
-		hc.BrowserConn.ActiveSubscriptions[queryId] = subscription
-		hc.BrowserConn.ActiveSubscriptionsMutex.Unlock()
+	return func() {
+		updateActiveSubscription(hc.BrowserConn, queryId, func(activeSubscription *common.GraphQlSubscription) {
+			activeSubscription.DeliveredStreamCursors = updatedStreamCursors
+		})
 	}
 }
 
@@ -188,33 +262,81 @@ func handleCompleteMessage(hc *common.HasuraConnection, queryId string) {
 	operationName := hc.BrowserConn.ActiveSubscriptions[queryId].OperationName
 	delete(hc.BrowserConn.ActiveSubscriptions, queryId)
 	hc.BrowserConn.ActiveSubscriptionsMutex.Unlock()
-	hc.BrowserConn.Logger.Debugf("%s (%s) with Id %s finished by Hasura.", queryType, operationName, queryId)
+	hc.Logger.Debugf("%s (%s) with Id %s finished by Hasura.", queryType, operationName, queryId)
 }
 
 func handleConnectionAckMessage(hc *common.HasuraConnection, message []byte) {
-	hc.BrowserConn.Logger.Debugf("Received connection_ack")
+	hc.Logger.Debugf("Received connection_ack")
 	//Hasura connection was initialized, now it's able to send new messages to Hasura
 	hc.BrowserConn.FromBrowserToHasuraChannel.UnfreezeChannel()
 
+	//The next reconnection (if this connection drops) waits only the initial delay
+	if hc.BrowserConn.HasuraBackoff != nil {
+		hc.BrowserConn.HasuraBackoff.Success()
+	}
+
 	//Avoid to send `connection_ack` to the browser when it's a reconnection
-	if hc.BrowserConn.ConnAckSentToBrowser == false {
+	if !hc.BrowserConn.ConnAckSentToBrowser.Load() {
+		ackInfo := make(map[string]interface{})
+		if hc.BrowserConn.ResumeToken != "" {
+			ackInfo["resumeToken"] = hc.BrowserConn.ResumeToken
+			ackInfo["resumed"] = hc.BrowserConn.Resumed
+		}
+		if hc.BrowserConn.Capabilities != nil {
+			ackInfo["capabilities"] = hc.BrowserConn.Capabilities
+		}
+		if len(ackInfo) > 0 {
+			message = addInfoToConnectionAck(message, ackInfo)
+		}
+		//Set before sending, as the browser can subscribe as soon as it receives it
+		hc.BrowserConn.ConnAckSentToBrowser.Store(true)
 		hc.BrowserConn.FromHasuraToBrowserChannel.Send(message)
-		hc.BrowserConn.ConnAckSentToBrowser = true
 	}
 
+	//Cancels the pending notification, or informs the browser that was told Hasura was down
+	common.NotifyUpstreamRestored(hc.BrowserConn, "hasura")
+
 	go retransmiter.RetransmitSubscriptionStartMessages(hc)
 }
 
+// addInfoToConnectionAck adds the info of the session to the payload of the `connection_ack`:
+// the token to resume this session and if the subscriptions of the previous connection were resumed
+// (so it doesn't need to subscribe again), and the capabilities accepted
+func addInfoToConnectionAck(message []byte, info map[string]interface{}) []byte {
+	var connectionAck map[string]interface{}
+	if err := json.Unmarshal(message, &connectionAck); err != nil {
+		return message
+	}
+
+	payload, _ := connectionAck["payload"].(map[string]interface{})
+	if payload == nil {
+		payload = make(map[string]interface{})
+	}
+	for key, value := range info {
+		payload[key] = value
+	}
+	connectionAck["payload"] = payload
+
+	connectionAckJson, err := json.Marshal(connectionAck)
+	if err != nil {
+		return message
+	}
+	return connectionAckJson
+}
+
 func getHasuraMessage(message []byte, subscription common.GraphQlSubscription, logger *logrus.Entry) (uint32, string, common.HasuraMessage) {
 	dataChecksum := crc32.ChecksumIEEE(message)
 
-	common.GlobalCacheLocks.Lock(dataChecksum)
-	defer common.GlobalCacheLocks.Unlock(dataChecksum)
+	cachedHasuraMessage := common.HasuraMessageCache.GetOrCompute(dataChecksum, func() common.CachedHasuraMessage {
+		return parseHasuraMessage(message, subscription, logger)
+	})
 
-	dataKey, hasuraMessage, dataMapExists := common.GetHasuraMessageCache(dataChecksum)
-	if dataMapExists {
-		return dataChecksum, dataKey, hasuraMessage
-	}
+	return dataChecksum, cachedHasuraMessage.DataKey, cachedHasuraMessage.Message
+}
+
+func parseHasuraMessage(message []byte, subscription common.GraphQlSubscription, logger *logrus.Entry) common.CachedHasuraMessage {
+	var dataKey string
+	var hasuraMessage common.HasuraMessage
 
 	err := json.Unmarshal(message, &hasuraMessage)
 	if err != nil {
@@ -226,8 +348,6 @@ func getHasuraMessage(message []byte, subscription common.GraphQlSubscription, l
 		break
 	}
 
-	common.StoreHasuraMessageCache(dataChecksum, dataKey, hasuraMessage)
-
 	//Add Prometheus metrics only once for each dataChecksum
 	dataSize := len(string(message))
 	common.GqlReceivedDataPayloadSize.
@@ -253,5 +373,8 @@ func getHasuraMessage(message []byte, subscription common.GraphQlSubscription, l
 		}
 	}
 
-	return dataChecksum, dataKey, hasuraMessage
+	return common.CachedHasuraMessage{
+		DataKey: dataKey,
+		Message: hasuraMessage,
+	}
 }
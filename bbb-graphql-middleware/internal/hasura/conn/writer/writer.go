@@ -2,36 +2,24 @@ package writer
 
 import (
 	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/capture"
 	"bbb-graphql-middleware/internal/common"
+	"bbb-graphql-middleware/internal/hasura/schema"
+	"bbb-graphql-middleware/internal/tap"
+	"bbb-graphql-middleware/internal/tracing"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/graphql-go/graphql/language/ast"
-	"github.com/graphql-go/graphql/language/parser"
-	"github.com/graphql-go/graphql/language/source"
 	"github.com/prometheus/client_golang/prometheus"
 	"nhooyr.io/websocket"
-	"slices"
 	"strings"
 	"sync"
 	"time"
 )
 
-var allowedSubscriptions []string
-var deniedSubscriptions []string
 var jsonPatchDisabled = config.GetConfig().Server.JsonPatchDisabled
 
-func init() {
-	if config.GetConfig().Server.SubscriptionAllowedList != "" {
-		allowedSubscriptions = strings.Split(config.GetConfig().Server.SubscriptionAllowedList, ",")
-	}
-
-	if config.GetConfig().Server.SubscriptionsDeniedList != "" {
-		deniedSubscriptions = strings.Split(config.GetConfig().Server.SubscriptionsDeniedList, ",")
-	}
-}
-
 // HasuraConnectionWriter
 // process messages (middleware to hasura)
 func HasuraConnectionWriter(hc *common.HasuraConnection, wg *sync.WaitGroup, initMessage []byte) {
@@ -39,19 +27,21 @@ func HasuraConnectionWriter(hc *common.HasuraConnection, wg *sync.WaitGroup, ini
 
 	defer wg.Done()
 	defer hc.ContextCancelFunc()
-	defer hc.BrowserConn.Logger.Debugf("finished")
+	defer hc.Logger.Debugf("finished")
 
 	//Send authentication (init) message at first
 	//It will not use the channel (fromBrowserToHasuraChannel) because this msg must bypass ChannelFreeze
 	if initMessage == nil {
-		hc.BrowserConn.Logger.Errorf("it can't start Hasura Connection because initMessage is null")
+		hc.Logger.Errorf("it can't start Hasura Connection because initMessage is null")
 		return
 	}
 
 	//Send init connection message to Hasura to start
+	hc.BrowserConn.Capture.Load().Record(capture.MiddlewareToHasura, hc.Id, initMessage)
+	tap.Mirror(hc.BrowserConn, capture.MiddlewareToHasura, hc.Id, initMessage)
 	err := hc.Websocket.Write(hc.Context, websocket.MessageText, initMessage)
 	if err != nil {
-		hc.BrowserConn.Logger.Errorf("error on write authentication (init) message (we're disconnected from hasura): %v", err)
+		hc.Logger.Errorf("error on write authentication (init) message (we're disconnected from hasura): %v", err)
 		return
 	}
 
@@ -71,16 +61,24 @@ RangeLoop:
 				var browserMessage common.BrowserSubscribeMessage
 				err := json.Unmarshal(fromBrowserMessage, &browserMessage)
 				if err != nil {
-					hc.BrowserConn.Logger.Errorf("failed to unmarshal message: %v", err)
+					hc.Logger.Errorf("failed to unmarshal message: %v", err)
 					return
 				}
 
+				//Subscriptions on hold are kept in ActiveSubscriptions (to be retransmitted once allowed) but not sent now
+				holdUntilAllowed := false
+
 				if browserMessage.Type == "subscribe" {
 					var queryId = browserMessage.ID
+					traceCtx := tracing.DequeueOperation(browserConnection.Id, queryId)
 
 					//Rate limiter from config max_connection_queries_per_minute
-					ctxRateLimiter, _ := context.WithTimeout(hc.Context, 30*time.Second)
-					if err := hc.BrowserConn.FromBrowserToHasuraRateLimiter.Wait(ctxRateLimiter); err != nil {
+					_, rateLimiterSpan := tracing.StartSpan(traceCtx, "rate_limiter")
+					ctxRateLimiter, cancelRateLimiter := context.WithTimeout(hc.Context, 30*time.Second)
+					errRateLimiter := hc.BrowserConn.FromBrowserToHasuraRateLimiter.Wait(ctxRateLimiter)
+					cancelRateLimiter()
+					tracing.EndSpan(rateLimiterSpan, errRateLimiter)
+					if errRateLimiter != nil {
 						sendErrorMessage(
 							browserConnection,
 							queryId,
@@ -90,144 +88,155 @@ RangeLoop:
 						continue
 					}
 
-					//Identify type based on query string
-					messageType := common.Query
 					var lastReceivedDataChecksum uint32
-					streamCursorField := ""
-					streamCursorVariableName := ""
-					var streamCursorInitialValue interface{}
+					var streamCursors []common.StreamCursor
 
 					query := browserMessage.Payload.Query
 
-					if config.GetConfig().Server.MaxQueryDepth > 0 {
-						queryDepth, _ := calculateQueryDepth(query)
-						if queryDepth > config.GetConfig().Server.MaxQueryDepth {
+					if config.GetConfig().Server.MaxQueryLength > 0 {
+						queryLength := len(query)
+						if queryLength > config.GetConfig().Server.MaxQueryLength {
 							sendErrorMessage(
 								browserConnection,
 								queryId,
-								fmt.Sprintf("Query %s is not valid with depth %d and the max allowed is %d", browserMessage.Payload.OperationName, queryDepth, config.GetConfig().Server.MaxQueryDepth))
+								fmt.Sprintf("Query %s is not valid with length %d and the max allowed is %d", browserMessage.Payload.OperationName, queryLength, config.GetConfig().Server.MaxQueryLength))
 							continue
 						}
 					}
 
-					if config.GetConfig().Server.MaxQueryLength > 0 {
-						queryLength := len(query)
-						if queryLength > config.GetConfig().Server.MaxQueryLength {
+					//Identify type based on the parsed operation (the one chosen by operationName)
+					operation := common.ClassifyOperation(query, browserMessage.Payload.OperationName)
+					if operation.Error != nil {
+						sendErrorMessage(
+							browserConnection,
+							queryId,
+							fmt.Sprintf("Query %s is not valid: %s", browserMessage.Payload.OperationName, operation.Error.Error()))
+						continue
+					}
+					messageType := operation.Type
+
+					//Reject locally what Hasura would reject (unknown fields, wrong argument or variable types)
+					if validationErrors := schema.ValidateOperation(query, browserConnection.BBBWebSessionVariables["x-hasura-role"]); validationErrors != nil {
+						tracing.EndOperation(browserConnection.Id, queryId, errors.New(validationErrors[0].Message))
+						common.SendValidationErrors(browserConnection, queryId, browserMessage.Payload.OperationName, validationErrors)
+						continue
+					}
+
+					queryCost, _ := common.AnalyzeQueryCost(query, browserMessage.Payload.OperationName, browserMessage.Payload.Variables)
+
+					if config.GetConfig().Server.MaxQueryDepth > 0 {
+						if queryCost.Depth > config.GetConfig().Server.MaxQueryDepth {
 							sendErrorMessage(
 								browserConnection,
 								queryId,
-								fmt.Sprintf("Query %s is not valid with length %d and the max allowed is %d", browserMessage.Payload.OperationName, queryLength, config.GetConfig().Server.MaxQueryLength))
+								fmt.Sprintf("Query %s is not valid with depth %d and the max allowed is %d", browserMessage.Payload.OperationName, queryCost.Depth, config.GetConfig().Server.MaxQueryDepth))
 							continue
 						}
 					}
 
-					if query != "" {
-						if strings.HasPrefix(query, "subscription") {
-							if config.GetConfig().Server.MaxConnectionConcurrentSubscriptions > 0 {
-								browserConnection.ActiveSubscriptionsMutex.RLock()
-								totalOfActiveSubscriptions := len(browserConnection.ActiveSubscriptions)
-								browserConnection.ActiveSubscriptionsMutex.RUnlock()
-
-								if totalOfActiveSubscriptions >= config.GetConfig().Server.MaxConnectionConcurrentSubscriptions {
-									sendErrorMessage(
-										browserConnection,
-										queryId,
-										fmt.Sprintf("Limit exceeded: Maximum %d concurrent subscriptions allowed.", config.GetConfig().Server.MaxConnectionConcurrentSubscriptions),
-									)
-
-									continue
-								}
-							}
-
-							//Validate if subscription is allowed
-							if len(allowedSubscriptions) > 0 {
-								subscriptionAllowed := false
-								for _, s := range allowedSubscriptions {
-									if s == browserMessage.Payload.OperationName {
-										subscriptionAllowed = true
-										break
-									}
-								}
-
-								if !subscriptionAllowed {
-									hc.BrowserConn.Logger.Infof("Subscription %s not allowed!", browserMessage.Payload.OperationName)
-									continue
-								}
-							}
-
-							//Validate if subscription is allowed
-							if len(deniedSubscriptions) > 0 {
-								subscriptionAllowed := true
-								for _, s := range deniedSubscriptions {
-									if s == browserMessage.Payload.OperationName {
-										subscriptionAllowed = false
-										break
-									}
-								}
-
-								if !subscriptionAllowed {
-									hc.BrowserConn.Logger.Infof("Subscription %s not allowed!", browserMessage.Payload.OperationName)
-									continue
-								}
-							}
+					if maxQueryCost := getMaxQueryCost(hc.BrowserConn); maxQueryCost > 0 {
+						if queryCost.Cost > maxQueryCost {
+							sendErrorMessage(
+								browserConnection,
+								queryId,
+								fmt.Sprintf("Query %s is not valid with cost %d and the max allowed is %d (fields: %d, aliases: %d, root fields: %d, depth: %d)",
+									browserMessage.Payload.OperationName,
+									queryCost.Cost,
+									maxQueryCost,
+									queryCost.Fields,
+									queryCost.Aliases,
+									queryCost.RootFields,
+									queryCost.Depth))
+							continue
+						}
+					}
 
-							messageType = common.Subscription
+					//Check if the operation is allowed for this user (role, client type, mobile and in-meeting status)
+					policyDecision := common.EvaluateOperationPolicy(hc.BrowserConn, operation)
+					if policyDecision.Effect == common.PolicyDeny {
+						sendErrorMessage(
+							browserConnection,
+							queryId,
+							fmt.Sprintf("Operation %s denied by policy %s: %s", operation.OperationName, policyDecision.Rule, policyDecision.Reason))
+						continue
+					}
+					holdUntilAllowed = policyDecision.Effect == common.PolicyHold
 
+					if operation.IsSubscription() {
+						if config.GetConfig().Server.MaxConnectionConcurrentSubscriptions > 0 {
 							browserConnection.ActiveSubscriptionsMutex.RLock()
-							existingSubscriptionData, queryIdExists := browserConnection.ActiveSubscriptions[queryId]
+							totalOfActiveSubscriptions := len(browserConnection.ActiveSubscriptions)
 							browserConnection.ActiveSubscriptionsMutex.RUnlock()
-							if queryIdExists {
-								lastReceivedDataChecksum = existingSubscriptionData.LastReceivedDataChecksum
-								streamCursorField = existingSubscriptionData.StreamCursorField
-								streamCursorVariableName = existingSubscriptionData.StreamCursorVariableName
-								streamCursorInitialValue = existingSubscriptionData.StreamCursorCurrValue
-							}
 
-							if strings.Contains(query, "_stream(") && strings.Contains(query, "cursor: {") {
-								messageType = common.Streaming
-								if !queryIdExists {
-									streamCursorField, streamCursorVariableName, streamCursorInitialValue = common.GetStreamCursorPropsFromBrowserMessage(browserMessage)
+							if totalOfActiveSubscriptions >= config.GetConfig().Server.MaxConnectionConcurrentSubscriptions {
+								sendErrorMessage(
+									browserConnection,
+									queryId,
+									fmt.Sprintf("Limit exceeded: Maximum %d concurrent subscriptions allowed.", config.GetConfig().Server.MaxConnectionConcurrentSubscriptions),
+								)
 
-									//It's necessary to assure the cursor field will return in the result of the query
-									//To be able to store the last received cursor value
-									browserMessage.Payload.Query = common.PatchQueryIncludingCursorField(query, streamCursorField)
-
-									newMessageJson, _ := json.Marshal(browserMessage)
-									fromBrowserMessage = newMessageJson
-								}
+								continue
 							}
+						}
 
-							if strings.Contains(query, "_aggregate") && strings.Contains(query, "aggregate {") {
-								messageType = common.SubscriptionAggregate
-							}
+						browserConnection.ActiveSubscriptionsMutex.RLock()
+						existingSubscriptionData, queryIdExists := browserConnection.ActiveSubscriptions[queryId]
+						browserConnection.ActiveSubscriptionsMutex.RUnlock()
+						if queryIdExists {
+							lastReceivedDataChecksum = existingSubscriptionData.LastReceivedDataChecksum
+							streamCursors = existingSubscriptionData.StreamCursors
 						}
 
-						if strings.HasPrefix(query, "mutation") {
-							messageType = common.Mutation
+						if messageType == common.Streaming && !queryIdExists {
+							streamCursors = common.GetStreamCursorsFromBrowserMessage(browserMessage)
+
+							//It's necessary to assure the cursor fields will return in the result of the query
+							//To be able to store the last received cursor values
+							browserMessage.Payload.Query = common.PatchQueryIncludingCursorFields(query, browserMessage.Payload.OperationName, streamCursors)
+
+							newMessageJson, _ := json.Marshal(browserMessage)
+							fromBrowserMessage = newMessageJson
 						}
 					}
 
 					//Identify if the client that requested this subscription expects to receive json-patch
-					//Client append `Patched_` to the query operationName to indicate that it supports
+					//Client negotiates it in connection_init capabilities, or (legacy) append `Patched_`
+					//to the query operationName to indicate that it supports
 					jsonPatchSupported := false
-					if !jsonPatchDisabled && strings.HasPrefix(browserMessage.Payload.OperationName, "Patched_") {
+					if browserConnection.Capabilities != nil && browserConnection.Capabilities.SupportsPatchFormat(common.PatchFormatJsonPatch) {
+						jsonPatchSupported = true
+					} else if !jsonPatchDisabled && strings.HasPrefix(browserMessage.Payload.OperationName, "Patched_") {
 						jsonPatchSupported = true
 					}
 
-					browserConnection.ActiveSubscriptionsMutex.Lock()
-					browserConnection.ActiveSubscriptions[queryId] = common.GraphQlSubscription{
+					//Subscriptions on hold will be sent (and so timed) when retransmitted
+					var sentToHasuraAt time.Time
+					if !holdUntilAllowed {
+						sentToHasuraAt = time.Now()
+					}
+
+					subscription := common.GraphQlSubscription{
 						Id:                         queryId,
 						Message:                    fromBrowserMessage,
 						OperationName:              browserMessage.Payload.OperationName,
-						StreamCursorField:          streamCursorField,
-						StreamCursorVariableName:   streamCursorVariableName,
-						StreamCursorCurrValue:      streamCursorInitialValue,
+						StreamCursors:              streamCursors,
+						DeliveredStreamCursors:     streamCursors,
 						LastSeenOnHasuraConnection: hc.Id,
 						JsonPatchSupported:         jsonPatchSupported,
 						Type:                       messageType,
 						LastReceivedDataChecksum:   lastReceivedDataChecksum,
+						SentToHasuraAt:             sentToHasuraAt,
 					}
-					// hc.BrowserConn.Logger.Tracef("Current queries: %v", browserConnection.ActiveSubscriptions)
+
+					browserConnection.ActiveSubscriptionsMutex.Lock()
+					//Keep what was delivered to the browser (it may have advanced while retransmitting)
+					if existingSubscriptionData, exists := browserConnection.ActiveSubscriptions[queryId]; exists {
+						subscription.DeliveredData = existingSubscriptionData.DeliveredData
+						subscription.DeliveredDataChecksum = existingSubscriptionData.DeliveredDataChecksum
+						subscription.DeliveredStreamCursors = existingSubscriptionData.DeliveredStreamCursors
+					}
+					browserConnection.ActiveSubscriptions[queryId] = subscription
+					// hc.Logger.Tracef("Current queries: %v", browserConnection.ActiveSubscriptions)
 					browserConnection.ActiveSubscriptionsMutex.Unlock()
 
 					//Add Prometheus Metrics
@@ -247,8 +256,10 @@ RangeLoop:
 					//Remove subscriptions from ActivitiesOverview here once Hasura-Reader will ignore "complete" msg for them
 					browserConnection.ActiveSubscriptionsMutex.Lock()
 					delete(browserConnection.ActiveSubscriptions, browserMessage.ID)
-					// hc.BrowserConn.Logger.Tracef("Current queries: %v", browserConnection.ActiveSubscriptions)
+					// hc.Logger.Tracef("Current queries: %v", browserConnection.ActiveSubscriptions)
 					browserConnection.ActiveSubscriptionsMutex.Unlock()
+
+					tracing.EndOperation(browserConnection.Id, browserMessage.ID, nil)
 				}
 
 				if browserMessage.Type == "connection_init" {
@@ -257,24 +268,22 @@ RangeLoop:
 					continue
 				}
 
-				//Check if user is in meeting and avoid sending to Hasura subscriptions that user doesn't have permission
-				userCurrentlyInMeeting := false
-				if hasuraRole, exists := hc.BrowserConn.BBBWebSessionVariables["x-hasura-role"]; exists {
-					userCurrentlyInMeeting = hasuraRole == "bbb_client"
-				}
-
-				if !userCurrentlyInMeeting &&
-					browserMessage.Type == "subscribe" &&
-					!slices.Contains(config.AllowedSubscriptionsForNotInMeetingUsers, browserMessage.Payload.OperationName) {
-					hc.BrowserConn.Logger.Debugf("Not sending to Hasura %s because the user is not in meeting", browserMessage.Payload.OperationName)
+				if holdUntilAllowed {
+					hc.Logger.Debugf("Not sending to Hasura %s because it's on hold by the policy", browserMessage.Payload.OperationName)
+					tracing.EndOperation(browserConnection.Id, browserMessage.ID, nil)
 					continue
 				} else {
 					//Sending to Hasura
-					hc.BrowserConn.Logger.Tracef("sending to hasura: %s", string(fromBrowserMessage))
+					hc.Logger.Tracef("sending to hasura: %s", string(fromBrowserMessage))
+					hc.BrowserConn.Capture.Load().Record(capture.MiddlewareToHasura, hc.Id, fromBrowserMessage)
+					tap.Mirror(hc.BrowserConn, capture.MiddlewareToHasura, hc.Id, fromBrowserMessage)
+					if browserMessage.Type == "subscribe" {
+						tracing.StartHasuraRoundTrip(browserConnection.Id, hc.Id, browserMessage.ID)
+					}
 					errWrite := hc.Websocket.Write(hc.Context, websocket.MessageText, fromBrowserMessage)
 					if errWrite != nil {
 						if !errors.Is(errWrite, context.Canceled) {
-							hc.BrowserConn.Logger.Errorf("error on write (we're disconnected from hasura): %v", errWrite)
+							hc.Logger.Errorf("error on write (we're disconnected from hasura): %v", errWrite)
 						}
 						return
 					}
@@ -305,79 +314,18 @@ RangeLoop:
 //	}
 //}
 
-func calculateQueryDepth(query string) (int, error) {
-	src := source.NewSource(&source.Source{
-		Body: []byte(query),
-		Name: "GraphQL query",
-	})
-	astDoc, err := parser.Parse(parser.ParseParams{
-		Source: src,
-	})
-	if err != nil {
-		return 0, fmt.Errorf("failed to parse query: %v", err)
-	}
-
-	maxDepth := 0
-	for _, def := range astDoc.Definitions {
-		if op, ok := def.(*ast.OperationDefinition); ok {
-			depth := traverseSelectionSet(op.SelectionSet, 0)
-			if depth > maxDepth {
-				maxDepth = depth
-			}
-		}
-	}
-
-	return maxDepth, nil
-}
-
-func traverseSelectionSet(selectionSet *ast.SelectionSet, currentDepth int) int {
-	if selectionSet == nil {
-		return currentDepth
-	}
-
-	currentDepth++
-	maxDepth := currentDepth
-
-	for _, selection := range selectionSet.Selections {
-		var depth int
-		switch sel := selection.(type) {
-		case *ast.Field:
-			depth = traverseSelectionSet(sel.SelectionSet, currentDepth)
-		case *ast.InlineFragment:
-			depth = traverseSelectionSet(sel.SelectionSet, currentDepth)
-		case *ast.FragmentSpread:
-			// Without a schema, we cannot resolve fragment spreads
-			continue
-		}
-		if depth > maxDepth {
-			maxDepth = depth
+// getMaxQueryCost returns the max query cost configured for the role of the user (or the default one)
+func getMaxQueryCost(browserConnection *common.BrowserConnection) int {
+	maxQueryCost := config.GetConfig().Server.MaxQueryCost
+	if hasuraRole, exists := browserConnection.BBBWebSessionVariables["x-hasura-role"]; exists {
+		if maxQueryCostForRole, existsForRole := maxQueryCost[hasuraRole]; existsForRole {
+			return maxQueryCostForRole
 		}
 	}
-
-	return maxDepth
+	return maxQueryCost["default"]
 }
 
 func sendErrorMessage(browserConnection *common.BrowserConnection, messageId string, errorMessage string) {
-	browserConnection.Logger.Errorf(errorMessage)
-
-	//Error on sending action, return error msg to client
-	browserResponseData := map[string]interface{}{
-		"id":   messageId,
-		"type": "error",
-		"payload": []interface{}{
-			map[string]interface{}{
-				"message": errorMessage,
-			},
-		},
-	}
-	jsonDataError, _ := json.Marshal(browserResponseData)
-	browserConnection.FromHasuraToBrowserChannel.Send(jsonDataError)
-
-	//Return complete msg to client
-	browserResponseComplete := map[string]interface{}{
-		"id":   messageId,
-		"type": "complete",
-	}
-	jsonDataComplete, _ := json.Marshal(browserResponseComplete)
-	browserConnection.FromHasuraToBrowserChannel.Send(jsonDataComplete)
+	tracing.EndOperation(browserConnection.Id, messageId, errors.New(errorMessage))
+	common.SendErrorMessage(browserConnection, messageId, errorMessage)
 }
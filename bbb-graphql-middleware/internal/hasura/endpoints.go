@@ -0,0 +1,221 @@
+package hasura
+
+import (
+	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/common"
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"nhooyr.io/websocket"
+)
+
+// endpoint is a Hasura instance the browser connections are balanced across
+type endpoint struct {
+	url         string
+	connections atomic.Int64
+
+	mutex        sync.Mutex
+	healthy      bool
+	failed       chan struct{} // closed when the endpoint becomes unhealthy
+	failedChecks int           // consecutive failed health checks
+}
+
+var endpoints = newEndpoints()
+var balancing = config.GetConfig().Hasura.Balancing
+var lastRoundRobinIndex atomic.Uint64
+
+var endpointCheckTimeout = 3 * time.Second
+var healthCheckFailuresThreshold = newHealthCheckFailuresThreshold()
+
+func newHealthCheckFailuresThreshold() int {
+	if threshold := config.GetConfig().Hasura.HealthCheckFailuresThreshold; threshold > 0 {
+		return threshold
+	}
+	return 1
+}
+
+func newEndpoints() []*endpoint {
+	urls := config.GetConfig().Hasura.Endpoints
+	if len(urls) == 0 {
+		urls = []string{config.GetConfig().Hasura.Url}
+	}
+
+	newEndpoints := make([]*endpoint, 0, len(urls))
+	for _, url := range urls {
+		newEndpoints = append(newEndpoints, &endpoint{
+			url:     url,
+			healthy: true,
+			failed:  make(chan struct{}),
+		})
+		common.HasuraEndpointHealthyGauge.With(prometheus.Labels{"endpoint": url}).Set(1)
+	}
+	return newEndpoints
+}
+
+func init() {
+	switch balancing {
+	case "", "round_robin", "least_connections", "meeting_hash":
+	default:
+		log.Fatalf("invalid hasura.balancing %q (round_robin, least_connections or meeting_hash)", balancing)
+	}
+}
+
+func (e *endpoint) isHealthy() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.healthy
+}
+
+// failedChannel returns a channel closed when the endpoint becomes unhealthy
+func (e *endpoint) failedChannel() <-chan struct{} {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.failed
+}
+
+// checked records the result of a health check, the endpoint becomes unhealthy only after
+// hasura.health_check_failures_threshold consecutive failures (so a transient error doesn't move all its connections)
+func (e *endpoint) checked(err error) {
+	e.mutex.Lock()
+	if err == nil {
+		e.failedChecks = 0
+	} else {
+		e.failedChecks++
+	}
+	failedChecks := e.failedChecks
+	e.mutex.Unlock()
+
+	if err == nil {
+		e.setHealthy(true, nil)
+	} else if failedChecks >= healthCheckFailuresThreshold {
+		e.setHealthy(false, err)
+	} else {
+		log.WithField("_routine", "HasuraEndpoints").Debugf("Hasura endpoint %s failed the health check (%d): %v", e.url, failedChecks, err)
+	}
+}
+
+func (e *endpoint) setHealthy(healthy bool, err error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.healthy == healthy {
+		return
+	}
+	e.healthy = healthy
+
+	if healthy {
+		e.failed = make(chan struct{})
+		common.HasuraEndpointHealthyGauge.With(prometheus.Labels{"endpoint": e.url}).Set(1)
+		log.WithField("_routine", "HasuraEndpoints").Infof("Hasura endpoint %s is healthy again", e.url)
+	} else {
+		close(e.failed)
+		common.HasuraEndpointHealthyGauge.With(prometheus.Labels{"endpoint": e.url}).Set(0)
+		log.WithField("_routine", "HasuraEndpoints").Warnf("Hasura endpoint %s is unhealthy: %v", e.url, err)
+	}
+}
+
+func healthyEndpoints() []*endpoint {
+	healthy := make([]*endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		if e.isHealthy() {
+			healthy = append(healthy, e)
+		}
+	}
+	return healthy
+}
+
+// selectEndpoint chooses the Hasura endpoint of a new connection according to hasura.balancing
+func selectEndpoint(browserConnection *common.BrowserConnection) *endpoint {
+	candidates := healthyEndpoints()
+	if len(candidates) == 0 {
+		// None is healthy, try them all anyway (the health check may be outdated)
+		candidates = endpoints
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	switch balancing {
+	case "least_connections":
+		selected := candidates[0]
+		for _, e := range candidates[1:] {
+			if e.connections.Load() < selected.connections.Load() {
+				selected = e
+			}
+		}
+		return selected
+	case "meeting_hash":
+		if browserConnection.MeetingId != "" {
+			// Rendezvous hashing: when an endpoint fails only its meetings are moved
+			var selected *endpoint
+			var selectedScore uint64
+			for _, e := range candidates {
+				h := fnv.New64a()
+				_, _ = h.Write([]byte(e.url))
+				_, _ = h.Write([]byte(browserConnection.MeetingId))
+				if score := h.Sum64(); selected == nil || score > selectedScore {
+					selected = e
+					selectedScore = score
+				}
+			}
+			return selected
+		}
+	}
+
+	return candidates[(lastRoundRobinIndex.Add(1)-1)%uint64(len(candidates))]
+}
+
+// HealthyEndpoints returns the urls of the Hasura endpoints healthy and unhealthy
+func HealthyEndpoints() (healthy []string, unhealthy []string) {
+	for _, e := range endpoints {
+		if e.isHealthy() {
+			healthy = append(healthy, e.url)
+		} else {
+			unhealthy = append(unhealthy, e.url)
+		}
+	}
+	return healthy, unhealthy
+}
+
+// StartEndpointsHealthChecker opens (and closes) a graphql-transport-ws connection with each endpoint periodically
+func StartEndpointsHealthChecker() {
+	interval := time.Duration(config.GetConfig().Hasura.HealthCheckIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	for {
+		var wg sync.WaitGroup
+		for _, e := range endpoints {
+			wg.Add(1)
+			go func(e *endpoint) {
+				defer wg.Done()
+				e.checked(checkEndpoint(e.url))
+			}(e)
+		}
+		wg.Wait()
+
+		time.Sleep(interval)
+	}
+}
+
+func checkEndpoint(url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), endpointCheckTimeout)
+	defer cancel()
+
+	hasuraWsConn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+		Subprotocols: []string{"graphql-transport-ws"},
+	})
+	if err != nil {
+		return err
+	}
+	_ = hasuraWsConn.Close(websocket.StatusNormalClosure, "health check")
+	return nil
+}
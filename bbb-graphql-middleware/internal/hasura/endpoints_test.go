@@ -0,0 +1,156 @@
+package hasura
+
+import (
+	"bbb-graphql-middleware/internal/common"
+	"bbb-graphql-middleware/internal/testsupport"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if reexecuted, exitCode := testsupport.RunWithTestConfig("../../config/config.yml", map[string]interface{}{
+		"log_level": "warn",
+	}); reexecuted {
+		os.Exit(exitCode)
+	}
+
+	os.Exit(m.Run())
+}
+
+func setEndpoints(t *testing.T, balancingMode string, total int) []*endpoint {
+	t.Helper()
+
+	previousEndpoints, previousBalancing := endpoints, balancing
+	t.Cleanup(func() { endpoints, balancing = previousEndpoints, previousBalancing })
+
+	endpoints = make([]*endpoint, 0, total)
+	for i := 0; i < total; i++ {
+		endpoints = append(endpoints, &endpoint{
+			url:     fmt.Sprintf("ws://hasura-%d/v1/graphql", i),
+			healthy: true,
+			failed:  make(chan struct{}),
+		})
+	}
+	balancing = balancingMode
+	return endpoints
+}
+
+func browserOfMeeting(meetingId string) *common.BrowserConnection {
+	return &common.BrowserConnection{MeetingId: meetingId}
+}
+
+func TestSelectEndpointRoundRobin(t *testing.T) {
+	testEndpoints := setEndpoints(t, "round_robin", 3)
+	testEndpoints[1].setHealthy(false, errors.New("down"))
+
+	selections := make(map[string]int)
+	previous := selectEndpoint(browserOfMeeting("meeting1"))
+	selections[previous.url]++
+	for i := 0; i < 5; i++ {
+		selected := selectEndpoint(browserOfMeeting("meeting1"))
+		if selected == previous {
+			t.Fatalf("expected round robin to alternate the endpoints, %s was selected twice", selected.url)
+		}
+		selections[selected.url]++
+		previous = selected
+	}
+
+	if selections[testEndpoints[1].url] != 0 {
+		t.Errorf("unhealthy endpoint was selected %d times", selections[testEndpoints[1].url])
+	}
+	if selections[testEndpoints[0].url] != 3 || selections[testEndpoints[2].url] != 3 {
+		t.Errorf("expected the healthy endpoints to be selected 3 times each, got %v", selections)
+	}
+}
+
+func TestSelectEndpointLeastConnections(t *testing.T) {
+	testEndpoints := setEndpoints(t, "least_connections", 3)
+	testEndpoints[0].connections.Store(5)
+	testEndpoints[1].connections.Store(0)
+	testEndpoints[2].connections.Store(3)
+
+	if selected := selectEndpoint(browserOfMeeting("meeting1")); selected != testEndpoints[1] {
+		t.Fatalf("expected the endpoint with fewer connections, got %s", selected.url)
+	}
+
+	testEndpoints[1].setHealthy(false, errors.New("down"))
+	if selected := selectEndpoint(browserOfMeeting("meeting1")); selected != testEndpoints[2] {
+		t.Fatalf("expected the healthy endpoint with fewer connections, got %s", selected.url)
+	}
+}
+
+func TestSelectEndpointMeetingHash(t *testing.T) {
+	setEndpoints(t, "meeting_hash", 4)
+
+	meetingEndpoints := make(map[string]*endpoint)
+	for i := 0; i < 20; i++ {
+		meetingId := fmt.Sprintf("meeting%d", i)
+		meetingEndpoints[meetingId] = selectEndpoint(browserOfMeeting(meetingId))
+
+		for j := 0; j < 3; j++ {
+			if selected := selectEndpoint(browserOfMeeting(meetingId)); selected != meetingEndpoints[meetingId] {
+				t.Fatalf("expected the users of %s to share the endpoint %s, got %s", meetingId, meetingEndpoints[meetingId].url, selected.url)
+			}
+		}
+	}
+
+	//Only the meetings of the failed endpoint are moved
+	failedEndpoint := meetingEndpoints["meeting0"]
+	failedEndpoint.setHealthy(false, errors.New("down"))
+	for meetingId, previous := range meetingEndpoints {
+		selected := selectEndpoint(browserOfMeeting(meetingId))
+		if previous == failedEndpoint && selected == failedEndpoint {
+			t.Errorf("%s kept the unhealthy endpoint", meetingId)
+		}
+		if previous != failedEndpoint && selected != previous {
+			t.Errorf("%s was moved from the healthy endpoint %s to %s", meetingId, previous.url, selected.url)
+		}
+	}
+}
+
+func TestSelectEndpointAllUnhealthy(t *testing.T) {
+	for _, balancingMode := range []string{"round_robin", "least_connections", "meeting_hash"} {
+		testEndpoints := setEndpoints(t, balancingMode, 2)
+		for _, e := range testEndpoints {
+			e.setHealthy(false, errors.New("down"))
+		}
+
+		//The health check may be outdated, so all of them are tried
+		if selected := selectEndpoint(browserOfMeeting("meeting1")); selected == nil {
+			t.Errorf("%s: expected an endpoint when all of them are unhealthy", balancingMode)
+		}
+	}
+}
+
+func TestEndpointUnhealthyAfterConsecutiveFailedChecks(t *testing.T) {
+	previousThreshold := healthCheckFailuresThreshold
+	t.Cleanup(func() { healthCheckFailuresThreshold = previousThreshold })
+	healthCheckFailuresThreshold = 2
+
+	testEndpoint := setEndpoints(t, "round_robin", 1)[0]
+	failed := testEndpoint.failedChannel()
+
+	testEndpoint.checked(errors.New("timeout"))
+	testEndpoint.checked(nil)
+	testEndpoint.checked(errors.New("timeout"))
+	if !testEndpoint.isHealthy() {
+		t.Fatal("expected the endpoint to stay healthy after non-consecutive failures")
+	}
+
+	testEndpoint.checked(errors.New("timeout"))
+	if testEndpoint.isHealthy() {
+		t.Fatal("expected the endpoint to be unhealthy after consecutive failures")
+	}
+	select {
+	case <-failed:
+	default:
+		t.Fatal("expected the failed channel to be closed")
+	}
+
+	testEndpoint.checked(nil)
+	if !testEndpoint.isHealthy() {
+		t.Fatal("expected the endpoint to be healthy after a successful check")
+	}
+}
@@ -1,37 +1,33 @@
 package retransmiter
 
 import (
-	"bbb-graphql-middleware/config"
 	"bbb-graphql-middleware/internal/common"
-	"slices"
 )
 
 func RetransmitSubscriptionStartMessages(hc *common.HasuraConnection) {
 	hc.BrowserConn.ActiveSubscriptionsMutex.RLock()
 	defer hc.BrowserConn.ActiveSubscriptionsMutex.RUnlock()
 
-	userCurrentlyInMeeting := false
-	if hasuraRole, exists := hc.BrowserConn.BBBWebSessionVariables["x-hasura-role"]; exists {
-		userCurrentlyInMeeting = hasuraRole == "bbb_client"
-	}
-
 	for _, subscription := range hc.BrowserConn.ActiveSubscriptions {
 		//Not retransmitting Mutations
 		if subscription.Type == common.Mutation {
 			continue
 		}
 
-		//When user left the meeting, Retransmit only Presence Manager subscriptions
-		if !userCurrentlyInMeeting &&
-			!slices.Contains(config.AllowedSubscriptionsForNotInMeetingUsers, subscription.OperationName) {
-			hc.BrowserConn.Logger.Debugf("Skipping retransmit %s because the user is not in meeting", subscription.OperationName)
+		//The user session may have changed (e.g. the user left the meeting), retransmit only what the policy allows now
+		policyDecision := common.EvaluateOperationPolicy(hc.BrowserConn, common.OperationInfo{
+			Type:          subscription.Type,
+			OperationName: subscription.OperationName,
+		})
+		if policyDecision.Effect != common.PolicyAllow {
+			hc.Logger.Debugf("Skipping retransmit %s because it's not allowed by the policy (%s)", subscription.OperationName, policyDecision.Effect)
 			continue
 		}
 
 		if subscription.LastSeenOnHasuraConnection != hc.Id {
-			hc.BrowserConn.Logger.Tracef("retransmiting subscription start: %v", string(subscription.Message))
+			hc.Logger.Tracef("retransmiting subscription start: %v", string(subscription.Message))
 
-			if subscription.Type == common.Streaming && subscription.StreamCursorCurrValue != nil {
+			if subscription.Type == common.Streaming {
 				hc.BrowserConn.FromBrowserToHasuraChannel.Send(common.PatchQuerySettingLastCursorValue(subscription))
 			} else {
 				hc.BrowserConn.FromBrowserToHasuraChannel.Send(subscription.Message)
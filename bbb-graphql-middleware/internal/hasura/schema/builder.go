@@ -0,0 +1,298 @@
+package schema
+
+import (
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"strings"
+)
+
+var builtInScalars = map[string]*graphql.Scalar{
+	"Int":     graphql.Int,
+	"Float":   graphql.Float,
+	"String":  graphql.String,
+	"Boolean": graphql.Boolean,
+	"ID":      graphql.ID,
+}
+
+var builtInDirectives = map[string]*graphql.Directive{
+	"include":    graphql.IncludeDirective,
+	"skip":       graphql.SkipDirective,
+	"deprecated": graphql.DeprecatedDirective,
+}
+
+// schemaBuilder creates a graphql-go schema (without resolvers) that is used only for validation
+type schemaBuilder struct {
+	introspection *introspectionSchema
+	definitions   map[string]introspectionType
+	types         map[string]graphql.Type
+	err           error
+}
+
+func buildSchema(introspection *introspectionSchema) (*graphql.Schema, error) {
+	if introspection.QueryType == nil {
+		return nil, fmt.Errorf("schema does not define the query type")
+	}
+
+	b := &schemaBuilder{
+		introspection: introspection,
+		definitions:   make(map[string]introspectionType),
+		types:         make(map[string]graphql.Type),
+	}
+
+	for _, definition := range introspection.Types {
+		if !strings.HasPrefix(definition.Name, "__") {
+			b.definitions[definition.Name] = definition
+		}
+	}
+
+	var allTypes []graphql.Type
+	for name := range b.definitions {
+		allTypes = append(allTypes, b.namedType(name))
+	}
+
+	schemaConfig := graphql.SchemaConfig{
+		Query:      b.objectType(introspection.QueryType.Name),
+		Types:      allTypes,
+		Directives: b.directives(),
+	}
+	if introspection.MutationType != nil {
+		schemaConfig.Mutation = b.objectType(introspection.MutationType.Name)
+	}
+	if introspection.SubscriptionType != nil {
+		schemaConfig.Subscription = b.objectType(introspection.SubscriptionType.Name)
+	}
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	schema, err := graphql.NewSchema(schemaConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	//Type references are resolved lazily (thunks) during NewSchema
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	return &schema, nil
+}
+
+func (b *schemaBuilder) objectType(name string) *graphql.Object {
+	if objectType, ok := b.namedType(name).(*graphql.Object); ok {
+		return objectType
+	}
+	b.fail(fmt.Errorf("type %s is not an object type", name))
+	return nil
+}
+
+func (b *schemaBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// namedType creates (once) the type by its name, the fields are defined by thunks to allow cyclic references
+func (b *schemaBuilder) namedType(name string) graphql.Type {
+	if builtInScalar, isBuiltIn := builtInScalars[name]; isBuiltIn {
+		return builtInScalar
+	}
+
+	if existingType, exists := b.types[name]; exists {
+		return existingType
+	}
+
+	definition, exists := b.definitions[name]
+	if !exists {
+		b.fail(fmt.Errorf("unknown type %s", name))
+		return graphql.String
+	}
+
+	var newType graphql.Type
+	switch definition.Kind {
+	case "OBJECT":
+		newType = graphql.NewObject(graphql.ObjectConfig{
+			Name: name,
+			Fields: graphql.FieldsThunk(func() graphql.Fields {
+				return b.fields(definition.Fields)
+			}),
+			Interfaces: graphql.InterfacesThunk(func() []*graphql.Interface {
+				var interfaces []*graphql.Interface
+				for _, typeRef := range definition.Interfaces {
+					if iface, ok := b.namedType(typeRef.Name).(*graphql.Interface); ok {
+						interfaces = append(interfaces, iface)
+					}
+				}
+				return interfaces
+			}),
+		})
+	case "INTERFACE":
+		newType = graphql.NewInterface(graphql.InterfaceConfig{
+			Name: name,
+			Fields: graphql.FieldsThunk(func() graphql.Fields {
+				return b.fields(definition.Fields)
+			}),
+		})
+	case "UNION":
+		newType = graphql.NewUnion(graphql.UnionConfig{
+			Name: name,
+			Types: graphql.UnionTypesThunk(func() []*graphql.Object {
+				var possibleTypes []*graphql.Object
+				for _, typeRef := range definition.PossibleTypes {
+					if objectType, ok := b.namedType(typeRef.Name).(*graphql.Object); ok {
+						possibleTypes = append(possibleTypes, objectType)
+					}
+				}
+				return possibleTypes
+			}),
+		})
+	case "ENUM":
+		values := graphql.EnumValueConfigMap{}
+		for _, enumValue := range definition.EnumValues {
+			values[enumValue.Name] = &graphql.EnumValueConfig{Value: enumValue.Name}
+		}
+		newType = graphql.NewEnum(graphql.EnumConfig{
+			Name:   name,
+			Values: values,
+		})
+	case "INPUT_OBJECT":
+		newType = graphql.NewInputObject(graphql.InputObjectConfig{
+			Name: name,
+			Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+				fields := graphql.InputObjectConfigFieldMap{}
+				for _, inputField := range definition.InputFields {
+					fields[inputField.Name] = &graphql.InputObjectFieldConfig{
+						Type:         b.inputValueType(inputField),
+						DefaultValue: defaultValue(inputField),
+					}
+				}
+				return fields
+			}),
+		})
+	case "SCALAR":
+		newType = customScalar(name)
+	default:
+		b.fail(fmt.Errorf("type %s has an unknown kind %s", name, definition.Kind))
+		newType = graphql.String
+	}
+
+	b.types[name] = newType
+	return newType
+}
+
+func (b *schemaBuilder) fields(fieldDefinitions []introspectionField) graphql.Fields {
+	fields := graphql.Fields{}
+	for _, fieldDefinition := range fieldDefinitions {
+		fieldType, ok := b.typeRef(fieldDefinition.Type).(graphql.Output)
+		if !ok {
+			b.fail(fmt.Errorf("field %s is not an output type", fieldDefinition.Name))
+			continue
+		}
+
+		fields[fieldDefinition.Name] = &graphql.Field{
+			Name: fieldDefinition.Name,
+			Type: fieldType,
+			Args: b.arguments(fieldDefinition.Args),
+		}
+	}
+	return fields
+}
+
+func (b *schemaBuilder) arguments(inputValues []introspectionInputValue) graphql.FieldConfigArgument {
+	arguments := graphql.FieldConfigArgument{}
+	for _, inputValue := range inputValues {
+		arguments[inputValue.Name] = &graphql.ArgumentConfig{
+			Type:         b.inputValueType(inputValue),
+			DefaultValue: defaultValue(inputValue),
+		}
+	}
+	return arguments
+}
+
+// inputValueType returns the type of the argument (or input field)
+// Non-null ones with default value are turned nullable, as graphql-go would require them to be provided
+func (b *schemaBuilder) inputValueType(inputValue introspectionInputValue) graphql.Input {
+	typeRef := inputValue.Type
+	if inputValue.DefaultValue != nil && typeRef.Kind == "NON_NULL" && typeRef.OfType != nil {
+		typeRef = *typeRef.OfType
+	}
+
+	inputType, ok := b.typeRef(typeRef).(graphql.Input)
+	if !ok {
+		b.fail(fmt.Errorf("argument %s is not an input type", inputValue.Name))
+		return graphql.String
+	}
+	return inputType
+}
+
+// defaultValue avoids a typed nil, that graphql-go would take as a default value
+func defaultValue(inputValue introspectionInputValue) interface{} {
+	if inputValue.DefaultValue == nil {
+		return nil
+	}
+	return *inputValue.DefaultValue
+}
+
+func (b *schemaBuilder) typeRef(typeRef introspectionTypeRef) graphql.Type {
+	switch typeRef.Kind {
+	case "NON_NULL":
+		if typeRef.OfType == nil {
+			b.fail(fmt.Errorf("non-null type without ofType"))
+			return graphql.String
+		}
+		return graphql.NewNonNull(b.typeRef(*typeRef.OfType))
+	case "LIST":
+		if typeRef.OfType == nil {
+			b.fail(fmt.Errorf("list type without ofType"))
+			return graphql.String
+		}
+		return graphql.NewList(b.typeRef(*typeRef.OfType))
+	default:
+		return b.namedType(typeRef.Name)
+	}
+}
+
+func (b *schemaBuilder) directives() []*graphql.Directive {
+	var directives []*graphql.Directive
+	definedDirectives := make(map[string]bool)
+
+	for _, directive := range b.introspection.Directives {
+		definedDirectives[directive.Name] = true
+		if builtInDirective, isBuiltIn := builtInDirectives[directive.Name]; isBuiltIn {
+			directives = append(directives, builtInDirective)
+			continue
+		}
+
+		directives = append(directives, graphql.NewDirective(graphql.DirectiveConfig{
+			Name:      directive.Name,
+			Locations: directive.Locations,
+			Args:      b.arguments(directive.Args),
+		}))
+	}
+
+	for _, builtInDirective := range graphql.SpecifiedDirectives {
+		if !definedDirectives[builtInDirective.Name] {
+			directives = append(directives, builtInDirective)
+		}
+	}
+
+	return directives
+}
+
+// customScalar accepts any literal, the type of custom scalars (uuid, jsonb, timestamptz...) is checked by Hasura
+func customScalar(name string) *graphql.Scalar {
+	return graphql.NewScalar(graphql.ScalarConfig{
+		Name: name,
+		Serialize: func(value interface{}) interface{} {
+			return value
+		},
+		ParseValue: func(value interface{}) interface{} {
+			return value
+		},
+		ParseLiteral: func(valueAST ast.Value) interface{} {
+			return valueAST
+		},
+	})
+}
@@ -0,0 +1,183 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const introspectionQuery = `
+query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives {
+      name
+      locations
+      args { ...InputValue }
+    }
+  }
+}
+fragment FullType on __Type {
+  kind
+  name
+  fields(includeDeprecated: true) {
+    name
+    args { ...InputValue }
+    type { ...TypeRef }
+  }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) { name }
+  possibleTypes { ...TypeRef }
+}
+fragment InputValue on __InputValue {
+  name
+  type { ...TypeRef }
+  defaultValue
+}
+fragment TypeRef on __Type {
+  kind
+  name
+  ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } } }
+}`
+
+// introspectionSchema is the `__schema` of the introspection result (only what's needed to validate operations)
+type introspectionSchema struct {
+	QueryType        *introspectionTypeRef    `json:"queryType"`
+	MutationType     *introspectionTypeRef    `json:"mutationType"`
+	SubscriptionType *introspectionTypeRef    `json:"subscriptionType"`
+	Types            []introspectionType      `json:"types"`
+	Directives       []introspectionDirective `json:"directives"`
+}
+
+type introspectionType struct {
+	Kind          string                    `json:"kind"`
+	Name          string                    `json:"name"`
+	Fields        []introspectionField      `json:"fields"`
+	InputFields   []introspectionInputValue `json:"inputFields"`
+	Interfaces    []introspectionTypeRef    `json:"interfaces"`
+	EnumValues    []introspectionEnumValue  `json:"enumValues"`
+	PossibleTypes []introspectionTypeRef    `json:"possibleTypes"`
+}
+
+type introspectionField struct {
+	Name string                    `json:"name"`
+	Args []introspectionInputValue `json:"args"`
+	Type introspectionTypeRef      `json:"type"`
+}
+
+type introspectionInputValue struct {
+	Name         string               `json:"name"`
+	Type         introspectionTypeRef `json:"type"`
+	DefaultValue *string              `json:"defaultValue"`
+}
+
+type introspectionEnumValue struct {
+	Name string `json:"name"`
+}
+
+type introspectionTypeRef struct {
+	Kind   string                `json:"kind"`
+	Name   string                `json:"name"`
+	OfType *introspectionTypeRef `json:"ofType"`
+}
+
+type introspectionDirective struct {
+	Name      string                    `json:"name"`
+	Locations []string                  `json:"locations"`
+	Args      []introspectionInputValue `json:"args"`
+}
+
+// parseIntrospectionResult accepts the response of the introspection query (`{"data":{"__schema":...}}`) or only its data
+func parseIntrospectionResult(content []byte) (*introspectionSchema, error) {
+	var result struct {
+		Data *struct {
+			Schema *introspectionSchema `json:"__schema"`
+		} `json:"data"`
+		Schema *introspectionSchema `json:"__schema"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	if err := json.Unmarshal(content, &result); err != nil {
+		return nil, fmt.Errorf("invalid introspection result: %v", err)
+	}
+
+	if len(result.Errors) > 0 {
+		return nil, fmt.Errorf("introspection failed: %s", result.Errors[0].Message)
+	}
+
+	if result.Data != nil && result.Data.Schema != nil {
+		return result.Data.Schema, nil
+	}
+	if result.Schema != nil {
+		return result.Schema, nil
+	}
+
+	return nil, fmt.Errorf("introspection result does not contain __schema")
+}
+
+// fetchIntrospection runs the introspection query on Hasura with the configured role and headers
+func fetchIntrospection(introspectionUrl string, role string, headers map[string]string) (*introspectionSchema, error) {
+	requestBody, _ := json.Marshal(map[string]interface{}{
+		"operationName": "IntrospectionQuery",
+		"query":         introspectionQuery,
+	})
+
+	req, err := http.NewRequest("POST", introspectionUrl, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bbb-graphql-middleware")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if role != "" {
+		req.Header.Set("x-hasura-role", role)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection request failed: %s", resp.Status)
+	}
+
+	return parseIntrospectionResult(respBody)
+}
+
+// httpUrlFromWebsocketUrl obtains the Hasura http endpoint from the websocket one (ws:// -> http://, wss:// -> https://)
+func httpUrlFromWebsocketUrl(websocketUrl string) (string, error) {
+	parsedURL, err := url.Parse(websocketUrl)
+	if err != nil {
+		return "", err
+	}
+
+	switch parsedURL.Scheme {
+	case "ws":
+		parsedURL.Scheme = "http"
+	case "wss":
+		parsedURL.Scheme = "https"
+	}
+
+	return parsedURL.String(), nil
+}
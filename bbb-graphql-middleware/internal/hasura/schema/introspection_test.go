@@ -0,0 +1,141 @@
+package schema
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testIntrospectionResult is the response of Hasura to the introspection query (reduced to a few types)
+const testIntrospectionResult = `{"data":{"__schema":{
+  "queryType":{"name":"query_root"},"mutationType":null,"subscriptionType":null,
+  "types":[
+    {"kind":"OBJECT","name":"query_root","fields":[
+      {"name":"hello","args":[
+        {"name":"name","type":{"kind":"NON_NULL","name":null,"ofType":{"kind":"SCALAR","name":"String","ofType":null}},"defaultValue":"\"world\""},
+        {"name":"at","type":{"kind":"SCALAR","name":"timestamptz","ofType":null},"defaultValue":null}
+      ],"type":{"kind":"SCALAR","name":"String","ofType":null}}
+    ],"inputFields":null,"interfaces":[],"enumValues":null,"possibleTypes":null},
+    {"kind":"SCALAR","name":"String","fields":null,"inputFields":null,"interfaces":null,"enumValues":null,"possibleTypes":null},
+    {"kind":"SCALAR","name":"timestamptz","fields":null,"inputFields":null,"interfaces":null,"enumValues":null,"possibleTypes":null},
+    {"kind":"OBJECT","name":"__Type","fields":[],"inputFields":null,"interfaces":[],"enumValues":null,"possibleTypes":null}
+  ],
+  "directives":[
+    {"name":"include","locations":["FIELD","FRAGMENT_SPREAD","INLINE_FRAGMENT"],"args":[
+      {"name":"if","type":{"kind":"NON_NULL","name":null,"ofType":{"kind":"SCALAR","name":"Boolean","ofType":null}},"defaultValue":null}
+    ]},
+    {"name":"cached","locations":["QUERY"],"args":[
+      {"name":"ttl","type":{"kind":"NON_NULL","name":null,"ofType":{"kind":"SCALAR","name":"Int","ofType":null}},"defaultValue":"60"}
+    ]}
+  ]
+}}}`
+
+func TestParseIntrospectionResult(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		expectedError bool
+	}{
+		{"response of the query", testIntrospectionResult, false},
+		{"only the data", `{"__schema":{"queryType":{"name":"query_root"},"types":[]}}`, false},
+		{"errors", `{"errors":[{"message":"field '__schema' not found in type: 'query_root'"}]}`, true},
+		{"without __schema", `{"data":{}}`, true},
+		{"invalid json", `<html>`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			introspection, err := parseIntrospectionResult([]byte(tt.content))
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if introspection.QueryType == nil || introspection.QueryType.Name != "query_root" {
+				t.Errorf("unexpected query type %+v", introspection.QueryType)
+			}
+		})
+	}
+}
+
+func TestBuildSchemaFromIntrospection(t *testing.T) {
+	introspection, err := parseIntrospectionResult([]byte(testIntrospectionResult))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := buildSchema(introspection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		valid bool
+	}{
+		{`query { hello }`, true}, // non-null argument with default value
+		{`query { hello(name: "you", at: "2024-01-01") }`, true},
+		{`query @cached(ttl: 120) { hello }`, true},
+		{`query { hello @include(if: true) }`, true},
+		{`query { hello(name: 1) }`, false},
+		{`query { hello(unknown: 1) }`, false},
+		{`query { __typename hello }`, true},
+	}
+
+	for _, tt := range tests {
+		result := validate(schema, 0, tt.query)
+		if valid := len(result.errors) == 0; valid != tt.valid {
+			t.Errorf("%s: expected valid=%v, got %v", tt.query, tt.valid, result.errors)
+		}
+	}
+}
+
+func TestFetchIntrospection(t *testing.T) {
+	var receivedRole, receivedSecret, receivedOperation string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedRole = r.Header.Get("x-hasura-role")
+		receivedSecret = r.Header.Get("x-hasura-admin-secret")
+		var body struct {
+			OperationName string `json:"operationName"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		receivedOperation = body.OperationName
+		_, _ = w.Write([]byte(testIntrospectionResult))
+	}))
+	defer server.Close()
+
+	introspection, err := fetchIntrospection(server.URL, "bbb_client", map[string]string{"x-hasura-admin-secret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(introspection.Types) != 4 {
+		t.Errorf("expected 4 types, got %d", len(introspection.Types))
+	}
+	if receivedRole != "bbb_client" || receivedSecret != "secret" || receivedOperation != "IntrospectionQuery" {
+		t.Errorf("unexpected request: role %q, secret %q, operation %q", receivedRole, receivedSecret, receivedOperation)
+	}
+
+	failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer failingServer.Close()
+	if _, err := fetchIntrospection(failingServer.URL, "bbb_client", nil); err == nil {
+		t.Errorf("expected an error when Hasura refuses the request")
+	}
+}
+
+func TestHttpUrlFromWebsocketUrl(t *testing.T) {
+	for websocketUrl, expected := range map[string]string{
+		"ws://127.0.0.1:8085/v1/graphql":   "http://127.0.0.1:8085/v1/graphql",
+		"wss://hasura.example/v1/graphql":  "https://hasura.example/v1/graphql",
+		"http://127.0.0.1:8085/v1/graphql": "http://127.0.0.1:8085/v1/graphql",
+	} {
+		if httpUrl, err := httpUrlFromWebsocketUrl(websocketUrl); err != nil || httpUrl != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", websocketUrl, expected, httpUrl, err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package schema
+
+import (
+	"fmt"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/printer"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// introspectionFromSDL converts a schema in SDL (as exported from Hasura) to the introspection format
+// so both sources are built the same way
+func introspectionFromSDL(sdl []byte) (*introspectionSchema, error) {
+	astDoc, err := parser.Parse(parser.ParseParams{
+		Source: &source.Source{
+			Body: sdl,
+			Name: "GraphQL SDL",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &introspectionSchema{}
+	definedTypes := make(map[string]bool)
+
+	for _, def := range astDoc.Definitions {
+		switch def := def.(type) {
+		case *ast.SchemaDefinition:
+			for _, operationType := range def.OperationTypes {
+				typeRef := &introspectionTypeRef{Kind: "OBJECT", Name: operationType.Type.Name.Value}
+				switch operationType.Operation {
+				case ast.OperationTypeQuery:
+					result.QueryType = typeRef
+				case ast.OperationTypeMutation:
+					result.MutationType = typeRef
+				case ast.OperationTypeSubscription:
+					result.SubscriptionType = typeRef
+				}
+			}
+		case *ast.ObjectDefinition:
+			objectType := introspectionType{
+				Kind:   "OBJECT",
+				Name:   def.Name.Value,
+				Fields: fieldsFromSDL(def.Fields),
+			}
+			for _, iface := range def.Interfaces {
+				objectType.Interfaces = append(objectType.Interfaces, introspectionTypeRef{Kind: "INTERFACE", Name: iface.Name.Value})
+			}
+			result.Types = append(result.Types, objectType)
+		case *ast.InterfaceDefinition:
+			result.Types = append(result.Types, introspectionType{
+				Kind:   "INTERFACE",
+				Name:   def.Name.Value,
+				Fields: fieldsFromSDL(def.Fields),
+			})
+		case *ast.UnionDefinition:
+			unionType := introspectionType{
+				Kind: "UNION",
+				Name: def.Name.Value,
+			}
+			for _, possibleType := range def.Types {
+				unionType.PossibleTypes = append(unionType.PossibleTypes, introspectionTypeRef{Kind: "OBJECT", Name: possibleType.Name.Value})
+			}
+			result.Types = append(result.Types, unionType)
+		case *ast.EnumDefinition:
+			enumType := introspectionType{
+				Kind: "ENUM",
+				Name: def.Name.Value,
+			}
+			for _, value := range def.Values {
+				enumType.EnumValues = append(enumType.EnumValues, introspectionEnumValue{Name: value.Name.Value})
+			}
+			result.Types = append(result.Types, enumType)
+		case *ast.InputObjectDefinition:
+			result.Types = append(result.Types, introspectionType{
+				Kind:        "INPUT_OBJECT",
+				Name:        def.Name.Value,
+				InputFields: inputValuesFromSDL(def.Fields),
+			})
+		case *ast.ScalarDefinition:
+			result.Types = append(result.Types, introspectionType{
+				Kind: "SCALAR",
+				Name: def.Name.Value,
+			})
+		case *ast.DirectiveDefinition:
+			directive := introspectionDirective{
+				Name: def.Name.Value,
+				Args: inputValuesFromSDL(def.Arguments),
+			}
+			for _, location := range def.Locations {
+				directive.Locations = append(directive.Locations, location.Value)
+			}
+			result.Directives = append(result.Directives, directive)
+		}
+	}
+
+	for _, definedType := range result.Types {
+		definedTypes[definedType.Name] = true
+	}
+
+	//Without the `schema` definition, the root types are found by their conventional names
+	if result.QueryType == nil {
+		for _, name := range []string{"query_root", "Query"} {
+			if definedTypes[name] {
+				result.QueryType = &introspectionTypeRef{Kind: "OBJECT", Name: name}
+				break
+			}
+		}
+	}
+	if result.MutationType == nil {
+		for _, name := range []string{"mutation_root", "Mutation"} {
+			if definedTypes[name] {
+				result.MutationType = &introspectionTypeRef{Kind: "OBJECT", Name: name}
+				break
+			}
+		}
+	}
+	if result.SubscriptionType == nil {
+		for _, name := range []string{"subscription_root", "Subscription"} {
+			if definedTypes[name] {
+				result.SubscriptionType = &introspectionTypeRef{Kind: "OBJECT", Name: name}
+				break
+			}
+		}
+	}
+
+	if result.QueryType == nil {
+		return nil, fmt.Errorf("schema does not define the query type")
+	}
+
+	return result, nil
+}
+
+func fieldsFromSDL(fieldDefinitions []*ast.FieldDefinition) []introspectionField {
+	fields := make([]introspectionField, 0, len(fieldDefinitions))
+	for _, fieldDefinition := range fieldDefinitions {
+		fields = append(fields, introspectionField{
+			Name: fieldDefinition.Name.Value,
+			Args: inputValuesFromSDL(fieldDefinition.Arguments),
+			Type: typeRefFromSDL(fieldDefinition.Type),
+		})
+	}
+	return fields
+}
+
+func inputValuesFromSDL(inputValueDefinitions []*ast.InputValueDefinition) []introspectionInputValue {
+	inputValues := make([]introspectionInputValue, 0, len(inputValueDefinitions))
+	for _, inputValueDefinition := range inputValueDefinitions {
+		inputValue := introspectionInputValue{
+			Name: inputValueDefinition.Name.Value,
+			Type: typeRefFromSDL(inputValueDefinition.Type),
+		}
+		if inputValueDefinition.DefaultValue != nil {
+			defaultValue := fmt.Sprintf("%v", printer.Print(inputValueDefinition.DefaultValue))
+			inputValue.DefaultValue = &defaultValue
+		}
+		inputValues = append(inputValues, inputValue)
+	}
+	return inputValues
+}
+
+func typeRefFromSDL(astType ast.Type) introspectionTypeRef {
+	switch astType := astType.(type) {
+	case *ast.NonNull:
+		ofType := typeRefFromSDL(astType.Type)
+		return introspectionTypeRef{Kind: "NON_NULL", OfType: &ofType}
+	case *ast.List:
+		ofType := typeRefFromSDL(astType.Type)
+		return introspectionTypeRef{Kind: "LIST", OfType: &ofType}
+	case *ast.Named:
+		return introspectionTypeRef{Name: astType.Name.Value}
+	}
+	return introspectionTypeRef{}
+}
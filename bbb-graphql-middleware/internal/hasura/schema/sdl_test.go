@@ -0,0 +1,166 @@
+package schema
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"testing"
+)
+
+func findType(introspection *introspectionSchema, name string) *introspectionType {
+	for i := range introspection.Types {
+		if introspection.Types[i].Name == name {
+			return &introspection.Types[i]
+		}
+	}
+	return nil
+}
+
+func findField(fields []introspectionField, name string) *introspectionField {
+	for i := range fields {
+		if fields[i].Name == name {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+func TestIntrospectionFromSDL(t *testing.T) {
+	introspection, err := introspectionFromSDL([]byte(testSDL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if introspection.QueryType.Name != "query_root" ||
+		introspection.MutationType.Name != "mutation_root" ||
+		introspection.SubscriptionType.Name != "subscription_root" {
+		t.Errorf("root types not found by their names: %+v %+v %+v",
+			introspection.QueryType, introspection.MutationType, introspection.SubscriptionType)
+	}
+
+	kinds := map[string]string{
+		"timestamptz":           "SCALAR",
+		"order_by":              "ENUM",
+		"user_bool_exp":         "INPUT_OBJECT",
+		"user":                  "OBJECT",
+		"subscription_root":     "OBJECT",
+		"String_comparison_exp": "INPUT_OBJECT",
+	}
+	for name, kind := range kinds {
+		if definition := findType(introspection, name); definition == nil || definition.Kind != kind {
+			t.Errorf("expected %s to be %s, got %+v", name, kind, definition)
+		}
+	}
+
+	// [user!]!
+	userField := findField(findType(introspection, "query_root").Fields, "user")
+	typeRef := userField.Type
+	if typeRef.Kind != "NON_NULL" || typeRef.OfType.Kind != "LIST" ||
+		typeRef.OfType.OfType.Kind != "NON_NULL" || typeRef.OfType.OfType.OfType.Name != "user" {
+		t.Errorf("unexpected type of query_root.user: %+v", typeRef)
+	}
+	if len(userField.Args) != 3 || userField.Args[2].Name != "limit" || userField.Args[2].DefaultValue != nil {
+		t.Errorf("unexpected arguments of query_root.user: %+v", userField.Args)
+	}
+
+	subscriptionUser := findField(findType(introspection, "subscription_root").Fields, "user")
+	if limit := subscriptionUser.Args[1]; limit.DefaultValue == nil || *limit.DefaultValue != "10" {
+		t.Errorf("expected the default value 10, got %+v", limit.DefaultValue)
+	}
+
+	if orderBy := findType(introspection, "order_by"); len(orderBy.EnumValues) != 2 || orderBy.EnumValues[1].Name != "desc" {
+		t.Errorf("unexpected enum values: %+v", orderBy.EnumValues)
+	}
+}
+
+func TestIntrospectionFromSDLRootTypes(t *testing.T) {
+	tests := []struct {
+		name          string
+		sdl           string
+		query         string
+		subscription  string
+		expectedError bool
+	}{
+		{"schema definition", `schema { query: Q subscription: S } type Q { a: Int } type S { b: Int }`, "Q", "S", false},
+		{"conventional names", `type Query { a: Int } type Subscription { b: Int }`, "Query", "Subscription", false},
+		{"hasura names first", `type Query { a: Int } type query_root { a: Int }`, "query_root", "", false},
+		{"without query type", `type Other { a: Int }`, "", "", true},
+		{"syntax error", `type Query {`, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			introspection, err := introspectionFromSDL([]byte(tt.sdl))
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if introspection.QueryType.Name != tt.query {
+				t.Errorf("expected the query type %s, got %s", tt.query, introspection.QueryType.Name)
+			}
+			subscription := ""
+			if introspection.SubscriptionType != nil {
+				subscription = introspection.SubscriptionType.Name
+			}
+			if subscription != tt.subscription {
+				t.Errorf("expected the subscription type %q, got %q", tt.subscription, subscription)
+			}
+		})
+	}
+}
+
+func TestBuildSchemaCustomScalars(t *testing.T) {
+	introspection, err := introspectionFromSDL([]byte(testSDL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := buildSchema(introspection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"timestamptz", "jsonb"} {
+		scalar, isScalar := schema.Type(name).(*graphql.Scalar)
+		if !isScalar {
+			t.Fatalf("custom scalar %s not defined", name)
+		}
+		// any literal is accepted, Hasura checks the value
+		for _, literal := range []ast.Value{&ast.StringValue{Value: "2024-01-01"}, &ast.IntValue{Value: "42"}, &ast.ObjectValue{}} {
+			if scalar.ParseLiteral(literal) == nil {
+				t.Errorf("%s: literal %T rejected", name, literal)
+			}
+		}
+	}
+
+	// the built-in scalars are shared with graphql-go, not redefined
+	if schema.Type("String").Name() != "String" || schema.Type("Int").Name() != "Int" {
+		t.Errorf("built-in scalars missing")
+	}
+}
+
+func TestBuildSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		sdl  string
+	}{
+		{"unknown type", `type Query { a: Missing }`},
+		{"mutation is not an object", `schema { query: Query mutation: In } input In { a: Int } type Query { a: Int }`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			introspection, err := introspectionFromSDL([]byte(tt.sdl))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := buildSchema(introspection); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package schema
+
+import (
+	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/common"
+	"encoding/json"
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"hash/crc32"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var schemaValidationConfig = config.GetConfig().Hasura.SchemaValidation
+
+var currentSchema *graphql.Schema
+var currentSchemaVersion uint32 // checksum of the loaded schema, it invalidates the cached results when it changes
+var currentSchemaMutex sync.RWMutex
+
+type validationResult struct {
+	query         string
+	schemaVersion uint32
+	errors        []gqlerrors.FormattedError
+}
+
+var cacheConfig = config.GetConfig().Cache
+
+// validationResultCache stores the validation errors of the queries, by query checksum
+var validationResultCache = common.NewShardedTTLCache[validationResult](
+	"schema_validation",
+	cacheConfig.Shards,
+	cacheConfig.MaxEntries,
+	cacheConfig.MaxBytes,
+	time.Duration(cacheConfig.TtlSeconds)*time.Second,
+	func(result validationResult) int {
+		size := len(result.query)
+		for _, validationError := range result.errors {
+			size += len(validationError.Message)
+		}
+		return size
+	})
+
+func ValidationEnabled() bool {
+	return schemaValidationConfig.Enabled
+}
+
+// StartSchemaLoader loads the Hasura schema and refreshes it periodically
+// While the schema is not loaded the operations are forwarded to Hasura without validation
+func StartSchemaLoader() {
+	log := log.WithField("_routine", "StartSchemaLoader")
+
+	if !ValidationEnabled() {
+		return
+	}
+
+	refreshInterval := time.Duration(schemaValidationConfig.RefreshIntervalSeconds) * time.Second
+
+	for {
+		if err := loadSchema(); err != nil {
+			log.Errorf("failed to load the Hasura schema (from %s): %v", schemaValidationConfig.Source, err)
+			common.SchemaLoadCounter.With(prometheus.Labels{"result": "error"}).Inc()
+		} else {
+			common.SchemaLoadCounter.With(prometheus.Labels{"result": "success"}).Inc()
+		}
+
+		if refreshInterval <= 0 {
+			currentSchemaMutex.RLock()
+			loaded := currentSchema != nil
+			currentSchemaMutex.RUnlock()
+			if loaded {
+				return
+			}
+
+			//Keep trying until it's loaded at least once
+			refreshInterval = 30 * time.Second
+		}
+
+		time.Sleep(refreshInterval)
+	}
+}
+
+func loadSchema() error {
+	var introspection *introspectionSchema
+	var err error
+
+	switch schemaValidationConfig.Source {
+	case "file":
+		var content []byte
+		content, err = os.ReadFile(schemaValidationConfig.File)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasSuffix(schemaValidationConfig.File, ".json") {
+			introspection, err = parseIntrospectionResult(content)
+		} else {
+			introspection, err = introspectionFromSDL(content)
+		}
+	case "introspection", "":
+		introspectionUrl := schemaValidationConfig.IntrospectionUrl
+		if introspectionUrl == "" {
+			hasuraUrl := config.GetConfig().Hasura.Url
+			if hasuraEndpoints := config.GetConfig().Hasura.Endpoints; len(hasuraEndpoints) > 0 {
+				hasuraUrl = hasuraEndpoints[0]
+			}
+			introspectionUrl, err = httpUrlFromWebsocketUrl(hasuraUrl)
+			if err != nil {
+				return err
+			}
+		}
+
+		introspection, err = fetchIntrospection(
+			introspectionUrl,
+			schemaValidationConfig.IntrospectionRole,
+			schemaValidationConfig.IntrospectionHeaders)
+	default:
+		return fmt.Errorf("unknown schema source %s", schemaValidationConfig.Source)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	schema, err := buildSchema(introspection)
+	if err != nil {
+		return err
+	}
+
+	schemaVersion := introspectionChecksum(introspection)
+
+	currentSchemaMutex.Lock()
+	defer currentSchemaMutex.Unlock()
+
+	if currentSchemaVersion != schemaVersion {
+		log.Infof("Hasura schema loaded with %d types", len(schema.TypeMap()))
+	}
+	currentSchema = schema
+	currentSchemaVersion = schemaVersion
+
+	return nil
+}
+
+// introspectionChecksum is computed from the JSON, as the types are linked by pointers (that %v would print)
+func introspectionChecksum(introspection *introspectionSchema) uint32 {
+	introspectionJson, _ := json.Marshal(introspection)
+	return crc32.ChecksumIEEE(introspectionJson)
+}
+
+// ValidateOperation runs the standard GraphQL validation of the query against the Hasura schema
+// It returns the errors in the format of the spec (message and locations), or nil when it's valid
+// or the schema is not loaded
+// The schema is the one of `introspection_role`, so the operations of other roles (that can access other
+// fields) are forwarded without validation
+func ValidateOperation(query string, hasuraRole string) []gqlerrors.FormattedError {
+	if !ValidationEnabled() {
+		return nil
+	}
+
+	if hasuraRole != schemaValidationConfig.IntrospectionRole {
+		common.SchemaValidationCounter.With(prometheus.Labels{"result": "skipped"}).Inc()
+		return nil
+	}
+
+	currentSchemaMutex.RLock()
+	schema := currentSchema
+	schemaVersion := currentSchemaVersion
+	currentSchemaMutex.RUnlock()
+
+	if schema == nil {
+		common.SchemaValidationCounter.With(prometheus.Labels{"result": "skipped"}).Inc()
+		return nil
+	}
+
+	cacheKey := crc32.ChecksumIEEE([]byte(query))
+	result := validationResultCache.GetOrCompute(cacheKey, func() validationResult {
+		return validate(schema, schemaVersion, query)
+	})
+
+	//Avoid using a result of another query (checksum collision) or of an outdated schema
+	if result.query != query || result.schemaVersion != schemaVersion {
+		result = validate(schema, schemaVersion, query)
+		validationResultCache.Set(cacheKey, result)
+	}
+
+	if len(result.errors) > 0 {
+		common.SchemaValidationCounter.With(prometheus.Labels{"result": "invalid"}).Inc()
+		return result.errors
+	}
+
+	common.SchemaValidationCounter.With(prometheus.Labels{"result": "valid"}).Inc()
+	return nil
+}
+
+func validate(schema *graphql.Schema, schemaVersion uint32, query string) validationResult {
+	result := validationResult{
+		query:         query,
+		schemaVersion: schemaVersion,
+	}
+
+	astDoc, err := common.ParseGraphQlQuery(query)
+	if err != nil {
+		result.errors = []gqlerrors.FormattedError{gqlerrors.FormatError(err)}
+	} else if validation := graphql.ValidateDocument(schema, astDoc, graphql.SpecifiedRules); !validation.IsValid {
+		result.errors = validation.Errors
+	}
+
+	for i := range result.errors {
+		result.errors[i].Extensions = map[string]interface{}{"code": "validation-failed"}
+	}
+
+	return result
+}
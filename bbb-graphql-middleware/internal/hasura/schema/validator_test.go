@@ -0,0 +1,191 @@
+package schema
+
+import (
+	"bbb-graphql-middleware/internal/testsupport"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if reexecuted, exitCode := testsupport.RunWithTestConfig("../../../config/config.yml", map[string]interface{}{
+		"log_level":                                   "warn",
+		"hasura.schema_validation.enabled":            true,
+		"hasura.schema_validation.source":             "file",
+		"hasura.schema_validation.introspection_role": "bbb_client",
+	}); reexecuted {
+		os.Exit(exitCode)
+	}
+
+	os.Exit(m.Run())
+}
+
+// testSDL is a small schema in the format exported from Hasura (root types by their conventional names)
+const testSDL = `
+scalar timestamptz
+scalar jsonb
+
+enum order_by {
+  asc
+  desc
+}
+
+input String_comparison_exp {
+  _eq: String
+  _in: [String!]
+}
+
+input user_bool_exp {
+  _and: [user_bool_exp!]
+  userId: String_comparison_exp
+}
+
+input user_order_by {
+  name: order_by
+}
+
+input user_stream_cursor_input {
+  initial_value: user_stream_cursor_value_input!
+  ordering: order_by
+}
+
+input user_stream_cursor_value_input {
+  joinedAt: timestamptz
+}
+
+type user {
+  userId: String!
+  name: String
+  joinedAt: timestamptz
+  customData(path: String): jsonb
+}
+
+type query_root {
+  user(where: user_bool_exp, order_by: [user_order_by!], limit: Int): [user!]!
+  user_by_pk(userId: String!): user
+}
+
+type mutation_root {
+  userSetAway(away: Boolean!, at: timestamptz): Boolean
+}
+
+type subscription_root {
+  user(where: user_bool_exp, limit: Int! = 10): [user!]!
+  user_stream(batch_size: Int!, cursor: [user_stream_cursor_input]!): [user!]!
+}
+`
+
+// loadTestSchema loads the schema from an SDL file, as configured with `source: file`
+func loadTestSchema(t *testing.T, sdl string) {
+	t.Helper()
+
+	schemaValidationConfig.File = filepath.Join(t.TempDir(), "hasura-schema.graphql")
+	if err := os.WriteFile(schemaValidationConfig.File, []byte(sdl), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSchema(); err != nil {
+		t.Fatalf("failed to load the schema: %v", err)
+	}
+}
+
+func TestValidateOperation(t *testing.T) {
+	loadTestSchema(t, testSDL)
+
+	tests := []struct {
+		name          string
+		query         string
+		expectedError string // empty when valid
+	}{
+		{"query", `query { user(where: {userId: {_eq: "1"}}, order_by: [{name: asc}]) { userId name } }`, ""},
+		{"subscription", `subscription { user(where: {_and: [{userId: {_in: ["1", "2"]}}]}) { userId name } }`, ""},
+		{"default value of non-null argument", `subscription { user { userId } }`, ""},
+		{"stream", `subscription { user_stream(batch_size: 10, cursor: [{initial_value: {joinedAt: "2024-01-01"}}]) { userId } }`, ""},
+		{"custom scalar literal", `mutation { userSetAway(away: true, at: "2024-01-01T00:00:00Z") }`, ""},
+		{"custom scalar of any kind", `mutation { userSetAway(away: true, at: 42) }`, ""},
+		{"custom scalar output", `query { user { joinedAt customData(path: "$.a") } }`, ""},
+		{"custom scalar variable", `mutation Set($at: timestamptz) { userSetAway(away: true, at: $at) }`, ""},
+		{"unknown field", `subscription { user { userId unknownField } }`, `Cannot query field "unknownField" on type "user".`},
+		{"wrong argument type", `query { user(limit: "ten") { userId } }`, `Argument "limit" has invalid value "ten".`},
+		{"unknown enum value", `query { user(order_by: [{name: sideways}]) { userId } }`, `Argument "order_by" has invalid value`},
+		{"missing required argument", `query { user_by_pk { userId } }`, `Field "user_by_pk" argument "userId" of type "String!" is required`},
+		{"unknown type of variable", `query Q($id: uuid) { user_by_pk(userId: $id) { userId } }`, `Unknown type "uuid".`},
+		{"syntax error", `query { user {`, `Syntax Error`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errors := ValidateOperation(tt.query, "bbb_client")
+			if tt.expectedError == "" {
+				if errors != nil {
+					t.Errorf("expected valid, got %v", errors)
+				}
+				return
+			}
+
+			if len(errors) == 0 {
+				t.Fatalf("expected %q, got valid", tt.expectedError)
+			}
+			if !strings.Contains(errors[0].Message, tt.expectedError) {
+				t.Errorf("expected %q, got %q", tt.expectedError, errors[0].Message)
+			}
+			if errors[0].Extensions["code"] != "validation-failed" {
+				t.Errorf("expected the code validation-failed, got %v", errors[0].Extensions)
+			}
+		})
+	}
+}
+
+func TestOtherRolesAreNotValidated(t *testing.T) {
+	loadTestSchema(t, testSDL)
+
+	// the fields of the other roles are not in the schema of introspection_role
+	query := `subscription { user_not_in_meeting { userId } }`
+	if errors := ValidateOperation(query, "bbb_client"); errors == nil {
+		t.Errorf("expected the query to be invalid for bbb_client")
+	}
+	if errors := ValidateOperation(query, "bbb_client_not_in_meeting"); errors != nil {
+		t.Errorf("expected the query of another role to be forwarded without validation, got %v", errors)
+	}
+}
+
+func TestCachedResultsAreInvalidatedByTheSchema(t *testing.T) {
+	query := `subscription { user { userId away } }`
+
+	loadTestSchema(t, strings.Replace(testSDL, "name: String\n", "name: String\n  away: Boolean\n", 1))
+	versionWithAway := currentSchemaVersion
+	if errors := ValidateOperation(query, "bbb_client"); errors != nil {
+		t.Fatalf("expected valid, got %v", errors)
+	}
+
+	loadTestSchema(t, testSDL)
+	if currentSchemaVersion == versionWithAway {
+		t.Fatalf("the schema version didn't change")
+	}
+	if errors := ValidateOperation(query, "bbb_client"); errors == nil {
+		t.Errorf("the result of the previous schema was used")
+	}
+
+	// loading the same schema again keeps the cached results
+	versionWithoutAway := currentSchemaVersion
+	loadTestSchema(t, testSDL)
+	if currentSchemaVersion != versionWithoutAway {
+		t.Errorf("the schema version changed without change of the schema")
+	}
+}
+
+func TestSchemaNotLoaded(t *testing.T) {
+	currentSchemaMutex.Lock()
+	previousSchema := currentSchema
+	currentSchema = nil
+	currentSchemaMutex.Unlock()
+	defer func() {
+		currentSchemaMutex.Lock()
+		currentSchema = previousSchema
+		currentSchemaMutex.Unlock()
+	}()
+
+	if errors := ValidateOperation(`query { unknown }`, "bbb_client"); errors != nil {
+		t.Errorf("expected the operation to be forwarded while the schema is not loaded, got %v", errors)
+	}
+}
@@ -0,0 +1,110 @@
+package health
+
+import (
+	"bbb-graphql-middleware/internal/common"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type capacity struct {
+	Connections    int `json:"connections"`
+	MaxConnections int `json:"maxConnections"`
+	Headroom       int `json:"headroom"` // connections accepted until the readiness limit (max_connections_ratio)
+}
+
+type status struct {
+	Status        string        `json:"status"`
+	Reasons       []string      `json:"reasons,omitempty"`
+	UptimeSeconds int64         `json:"uptimeSeconds"`
+	Draining      bool          `json:"draining"`
+	Capacity      capacity      `json:"capacity"`
+	Checks        []CheckResult `json:"checks"`
+}
+
+// HealthzHandler reports the middleware is alive (always 200), including the state of the dependencies
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	currentStatus, _ := getStatus()
+	currentStatus.Status = "ok"
+	writeStatus(w, http.StatusOK, currentStatus)
+}
+
+// ReadyzHandler returns 503 when the middleware should not receive new connections:
+// a dependency is not healthy, it's draining or the connections are close to max_connections
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	currentStatus, ready := getStatus()
+	if ready {
+		writeStatus(w, http.StatusOK, currentStatus)
+	} else {
+		writeStatus(w, http.StatusServiceUnavailable, currentStatus)
+	}
+}
+
+// DrainHandler starts (POST) or stops (DELETE) draining, new browser connections are refused while draining
+func DrainHandler(w http.ResponseWriter, r *http.Request) {
+	if !common.IsAdminRequest(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		common.SetDraining(true)
+	case http.MethodDelete:
+		common.SetDraining(false)
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]bool{"draining": common.IsDraining()})
+}
+
+func getStatus() (status, bool) {
+	currentStatus := status{
+		Status:        "ready",
+		UptimeSeconds: int64(time.Since(startedAt).Seconds()),
+		Draining:      common.IsDraining(),
+		Checks:        GetResults(),
+	}
+
+	maxConnections := common.GetMaxConnectionsGlobal()
+	connectionsLimit := int(float64(maxConnections) * healthConfig.MaxConnectionsRatio)
+	if healthConfig.MaxConnectionsRatio <= 0 {
+		connectionsLimit = maxConnections
+	}
+	currentStatus.Capacity = capacity{
+		Connections:    common.GetGlobalConnectionsCount(),
+		MaxConnections: maxConnections,
+	}
+	currentStatus.Capacity.Headroom = max(connectionsLimit-currentStatus.Capacity.Connections, 0)
+
+	if len(currentStatus.Checks) == 0 {
+		currentStatus.Reasons = append(currentStatus.Reasons, "dependencies not checked yet")
+	}
+	for _, result := range currentStatus.Checks {
+		if !result.Healthy {
+			currentStatus.Reasons = append(currentStatus.Reasons, result.Name+" is not healthy")
+		}
+	}
+	if currentStatus.Draining {
+		currentStatus.Reasons = append(currentStatus.Reasons, "draining")
+	}
+	if currentStatus.Capacity.Headroom == 0 {
+		currentStatus.Reasons = append(currentStatus.Reasons, "no capacity for new connections")
+	}
+
+	ready := len(currentStatus.Reasons) == 0
+	if !ready {
+		currentStatus.Status = "not_ready"
+	}
+	return currentStatus, ready
+}
+
+func writeStatus(w http.ResponseWriter, statusCode int, currentStatus status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(currentStatus)
+}
@@ -0,0 +1,154 @@
+// Package health checks the dependencies of the middleware, reported by /healthz and /readyz
+package health
+
+import (
+	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/hasura"
+	"bbb-graphql-middleware/internal/websrv"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckResult is the last result of the check of a dependency
+type CheckResult struct {
+	Name      string    `json:"name"`
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs float64   `json:"latencyMs"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+type check struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+var healthConfig = config.GetConfig().Health
+
+var resultsMutex sync.RWMutex
+var results []CheckResult
+
+var startedAt = time.Now()
+
+var checks = []check{
+	{name: "redis", run: checkRedis},
+	{name: "hasura", run: checkHasura},
+	{name: "graphql_actions", run: func(ctx context.Context) error {
+		return checkHttpReachable(ctx, config.GetConfig().GraphqlActions.Url)
+	}},
+	{name: "auth_hook", run: func(ctx context.Context) error {
+		return checkHttpReachable(ctx, config.GetConfig().AuthHook.Url)
+	}},
+}
+
+// StartHealthChecker runs the checks periodically, the endpoints report the last results
+func StartHealthChecker() {
+	log := log.WithField("_routine", "HealthChecker")
+
+	interval := time.Duration(healthConfig.CheckIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	for {
+		newResults := runChecks()
+		for _, result := range newResults {
+			if !result.Healthy {
+				log.Warnf("%s is not healthy: %s", result.Name, result.Error)
+			}
+		}
+
+		resultsMutex.Lock()
+		results = newResults
+		resultsMutex.Unlock()
+
+		time.Sleep(interval)
+	}
+}
+
+func runChecks() []CheckResult {
+	timeout := time.Duration(healthConfig.CheckTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+
+	checkResults := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			checkStartedAt := time.Now()
+			err := c.run(ctx)
+			checkResults[i] = CheckResult{
+				Name:      c.name,
+				Healthy:   err == nil,
+				LatencyMs: float64(time.Since(checkStartedAt).Microseconds()) / 1000,
+				CheckedAt: checkStartedAt,
+			}
+			if err != nil {
+				checkResults[i].Error = err.Error()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	return checkResults
+}
+
+// GetResults returns the last results of the checks (empty before the first run)
+func GetResults() []CheckResult {
+	resultsMutex.RLock()
+	defer resultsMutex.RUnlock()
+
+	return append([]CheckResult(nil), results...)
+}
+
+// checkRedis verifies the connection with Redis and the subscription to the akka-apps channel
+func checkRedis(ctx context.Context) error {
+	if err := websrv.GetRedisConn().Ping(ctx).Err(); err != nil {
+		return err
+	}
+	if !websrv.RedisListenerConnected() {
+		return fmt.Errorf("not subscribed to the akka-apps channel")
+	}
+	return nil
+}
+
+// checkHasura verifies at least one of the Hasura endpoints is healthy
+func checkHasura(ctx context.Context) error {
+	healthy, unhealthy := hasura.HealthyEndpoints()
+	if len(healthy) == 0 {
+		return fmt.Errorf("no healthy Hasura endpoint (unhealthy: %s)", strings.Join(unhealthy, ", "))
+	}
+	return nil
+}
+
+// checkHttpReachable verifies the service answers (any status, as the request doesn't have the params of a real one)
+func checkHttpReachable(ctx context.Context, url string) error {
+	if url == "" {
+		return fmt.Errorf("url not set")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "bbb-graphql-middleware")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
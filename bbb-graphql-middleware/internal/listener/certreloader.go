@@ -0,0 +1,110 @@
+package listener
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certReloader keeps the certificate of a TLS listener, loading it again when the files change
+type certReloader struct {
+	listenerName string
+	certFile     string
+	keyFile      string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func newCertReloader(listenerName string, certFile string, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{
+		listenerName: listenerName,
+		certFile:     certFile,
+		keyFile:      keyFile,
+	}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.certificate, nil
+}
+
+func (c *certReloader) load() error {
+	certModTime, keyModTime, err := c.modTimes()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.certificate = &certificate
+	c.certModTime = certModTime
+	c.keyModTime = keyModTime
+	return nil
+}
+
+func (c *certReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// watch reloads the certificate when the files change, the current one is kept if the new files are invalid
+// (e.g. the cert was replaced but not the key yet)
+func (c *certReloader) watch(intervalSeconds int) {
+	log := log.WithField("_routine", "CertReloader").WithField("listener", c.listenerName)
+
+	interval := time.Duration(intervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 60 * time.Second
+	}
+
+	for {
+		time.Sleep(interval)
+		c.reloadIfChanged(log)
+	}
+}
+
+// reloadIfChanged loads the certificate again when the modification time of its files changed
+func (c *certReloader) reloadIfChanged(log *log.Entry) {
+	certModTime, keyModTime, err := c.modTimes()
+	if err != nil {
+		log.Errorf("error checking the certificate files: %v", err)
+		return
+	}
+
+	c.mutex.RLock()
+	changed := !certModTime.Equal(c.certModTime) || !keyModTime.Equal(c.keyModTime)
+	c.mutex.RUnlock()
+	if !changed {
+		return
+	}
+
+	if err := c.load(); err != nil {
+		log.Errorf("error reloading the certificate, keeping the current one: %v", err)
+		return
+	}
+	log.Infof("certificate reloaded from %s", c.certFile)
+}
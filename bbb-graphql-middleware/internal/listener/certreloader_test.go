@@ -0,0 +1,125 @@
+package listener
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// writeCertificate writes a self-signed certificate for the common name, modified at the given time
+func writeCertificate(t *testing.T, certFile string, keyFile string, commonName string, modTime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setModTime(t, modTime, certFile, keyFile)
+}
+
+func setModTime(t *testing.T, modTime time.Time, files ...string) {
+	t.Helper()
+
+	for _, file := range files {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func servedCommonName(t *testing.T, reloader *certReloader) string {
+	t.Helper()
+
+	certificate, _ := reloader.getCertificate(&tls.ClientHelloInfo{})
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	firstModTime := time.Now().Add(-time.Hour)
+	writeCertificate(t, certFile, keyFile, "first", firstModTime)
+
+	reloader, err := newCertReloader("test", certFile, keyFile)
+	if err != nil {
+		t.Fatalf("failed to load the certificate: %v", err)
+	}
+	logEntry := log.WithField("_routine", "TestCertReload")
+
+	// same modification time, the files are not loaded again
+	writeCertificate(t, certFile, keyFile, "unchanged", firstModTime)
+	reloader.reloadIfChanged(logEntry)
+	if commonName := servedCommonName(t, reloader); commonName != "first" {
+		t.Errorf("certificate reloaded without change of the files, serving %s", commonName)
+	}
+
+	writeCertificate(t, certFile, keyFile, "second", firstModTime.Add(time.Minute))
+	reloader.reloadIfChanged(logEntry)
+	if commonName := servedCommonName(t, reloader); commonName != "second" {
+		t.Errorf("certificate not reloaded after the files changed, serving %s", commonName)
+	}
+
+	// the cert replaced but not the key yet
+	writeCertificate(t, certFile, filepath.Join(dir, "other-key.pem"), "third", firstModTime.Add(2*time.Minute))
+	reloader.reloadIfChanged(logEntry)
+	if commonName := servedCommonName(t, reloader); commonName != "second" {
+		t.Errorf("the current certificate should be kept when the files don't match, serving %s", commonName)
+	}
+
+	_ = os.Remove(keyFile)
+	reloader.reloadIfChanged(logEntry)
+	if commonName := servedCommonName(t, reloader); commonName != "second" {
+		t.Errorf("the current certificate should be kept when the files are missing, serving %s", commonName)
+	}
+}
+
+func TestCertReloaderRequiresValidFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	if _, err := newCertReloader("test", certFile, keyFile); err == nil {
+		t.Errorf("missing files accepted")
+	}
+
+	_ = os.WriteFile(certFile, []byte("not a certificate"), 0600)
+	_ = os.WriteFile(keyFile, []byte("not a key"), 0600)
+	if _, err := newCertReloader("test", certFile, keyFile); err == nil {
+		t.Errorf("invalid files accepted")
+	}
+}
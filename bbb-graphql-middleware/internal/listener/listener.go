@@ -0,0 +1,185 @@
+// Package listener serves the endpoints on the addresses of `listeners` (TCP, TLS or unix sockets)
+package listener
+
+import (
+	"bbb-graphql-middleware/config"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ServeAll starts the listeners serving the handlers registered in http.DefaultServeMux,
+// it returns when any of them fails
+func ServeAll() error {
+	cfg := config.GetConfig()
+
+	listenersConfig := cfg.Listeners
+	if len(listenersConfig) == 0 {
+		listenersConfig = []config.ListenerConfig{{
+			Name:    "default",
+			Network: "tcp",
+			Address: fmt.Sprintf("%v:%v", cfg.Server.Host, cfg.Server.Port),
+		}}
+	}
+
+	servers := make([]func() error, 0, len(listenersConfig))
+	for _, listenerConfig := range listenersConfig {
+		serve, err := newServer(listenerConfig)
+		if err != nil {
+			log.Fatalf("invalid listener %s: %v", listenerConfig.Name, err)
+		}
+		servers = append(servers, serve)
+	}
+
+	errs := make(chan error, len(servers))
+	for _, serve := range servers {
+		go func(serve func() error) {
+			errs <- serve()
+		}(serve)
+	}
+	return <-errs
+}
+
+func newServer(listenerConfig config.ListenerConfig) (func() error, error) {
+	log := log.WithField("_routine", "Listener").WithField("listener", listenerConfig.Name)
+
+	var tlsConfig *tls.Config
+	if listenerConfig.Tls.CertFile != "" || listenerConfig.Tls.KeyFile != "" {
+		var err error
+		if tlsConfig, err = newTlsConfig(listenerConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	netListener, err := listen(listenerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Handler:   pathsHandler(listenerConfig.Paths),
+		TLSConfig: tlsConfig,
+	}
+
+	return func() error {
+		log.Infof("listening on %s %s (tls=%v, paths=%v)", netListener.Addr().Network(), listenerConfig.Address, tlsConfig != nil, listenerConfig.Paths)
+		if tlsConfig != nil {
+			return server.ServeTLS(netListener, "", "")
+		}
+		return server.Serve(netListener)
+	}, nil
+}
+
+func listen(listenerConfig config.ListenerConfig) (net.Listener, error) {
+	switch listenerConfig.Network {
+	case "", "tcp":
+		return net.Listen("tcp", listenerConfig.Address)
+	case "unix":
+		if err := removeStaleSocket(listenerConfig.Address); err != nil {
+			return nil, err
+		}
+
+		netListener, err := net.Listen("unix", listenerConfig.Address)
+		if err != nil {
+			return nil, err
+		}
+		if listenerConfig.SocketMode != "" {
+			mode, err := strconv.ParseUint(listenerConfig.SocketMode, 8, 32)
+			if err != nil {
+				_ = netListener.Close()
+				return nil, fmt.Errorf("invalid socket_mode %q", listenerConfig.SocketMode)
+			}
+			if err := os.Chmod(listenerConfig.Address, os.FileMode(mode)); err != nil {
+				_ = netListener.Close()
+				return nil, err
+			}
+		}
+		return netListener, nil
+	default:
+		return nil, fmt.Errorf("invalid network %q (tcp or unix)", listenerConfig.Network)
+	}
+}
+
+// removeStaleSocket removes the socket left by a previous execution that didn't stop cleanly
+// A socket still accepting connections belongs to a running instance, so it's kept and the listener fails
+func removeStaleSocket(address string) error {
+	fileInfo, err := os.Stat(address)
+	if err != nil || fileInfo.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+
+	if conn, err := net.DialTimeout("unix", address, time.Second); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is in use by another process", address)
+	}
+
+	return os.Remove(address)
+}
+
+func newTlsConfig(listenerConfig config.ListenerConfig) (*tls.Config, error) {
+	if listenerConfig.Tls.CertFile == "" || listenerConfig.Tls.KeyFile == "" {
+		return nil, errors.New("tls requires cert_file and key_file")
+	}
+
+	reloader, err := newCertReloader(listenerConfig.Name, listenerConfig.Tls.CertFile, listenerConfig.Tls.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.watch(listenerConfig.Tls.ReloadIntervalSeconds)
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+
+	switch listenerConfig.Tls.ClientCert {
+	case "", "none":
+		tlsConfig.ClientAuth = tls.NoClientCert
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "required":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid client_cert %q (none, optional or required)", listenerConfig.Tls.ClientCert)
+	}
+
+	if tlsConfig.ClientAuth != tls.NoClientCert {
+		if listenerConfig.Tls.ClientCaFile == "" {
+			return nil, errors.New("client_cert requires client_ca_file")
+		}
+		caPem, err := os.ReadFile(listenerConfig.Tls.ClientCaFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf("no certificate found in %s", listenerConfig.Tls.ClientCaFile)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// pathsHandler serves only the endpoints listed (all of them when the list is empty)
+func pathsHandler(paths []string) http.Handler {
+	if len(paths) == 0 {
+		return http.DefaultServeMux
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := http.DefaultServeMux.Handler(r); !slices.Contains(paths, pattern) {
+			http.NotFound(w, r)
+			return
+		}
+		http.DefaultServeMux.ServeHTTP(w, r)
+	})
+}
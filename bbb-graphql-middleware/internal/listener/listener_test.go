@@ -0,0 +1,178 @@
+package listener
+
+import (
+	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/testsupport"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if reexecuted, exitCode := testsupport.RunWithTestConfig("../../config/config.yml", map[string]interface{}{
+		"log_level": "warn",
+	}); reexecuted {
+		os.Exit(exitCode)
+	}
+
+	http.HandleFunc("/listener-test/public", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("public"))
+	})
+	http.HandleFunc("/listener-test/internal", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("internal"))
+	})
+
+	os.Exit(m.Run())
+}
+
+func unixClient(socketPath string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+}
+
+func get(t *testing.T, client *http.Client, path string) (int, string) {
+	t.Helper()
+
+	response, err := client.Get("http://middleware" + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	return response.StatusCode, string(body)
+}
+
+func TestUnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "middleware.sock")
+
+	netListener, err := listen(config.ListenerConfig{Network: "unix", Address: socketPath, SocketMode: "0660"})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &http.Server{Handler: pathsHandler([]string{"/listener-test/public"})}
+	go server.Serve(netListener)
+	defer server.Close()
+
+	fileInfo, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("socket not created: %v", err)
+	}
+	if fileInfo.Mode()&os.ModeSocket == 0 || fileInfo.Mode().Perm() != 0660 {
+		t.Errorf("expected a socket with mode 0660, got %v", fileInfo.Mode())
+	}
+
+	if status, body := get(t, unixClient(socketPath), "/listener-test/public"); status != http.StatusOK || body != "public" {
+		t.Errorf("expected the public endpoint to be served, got %d %s", status, body)
+	}
+}
+
+func TestInvalidSocketMode(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "middleware.sock")
+
+	if _, err := listen(config.ListenerConfig{Network: "unix", Address: socketPath, SocketMode: "rw"}); err == nil {
+		t.Fatalf("invalid socket_mode accepted")
+	}
+	if _, err := listen(config.ListenerConfig{Network: "udp", Address: socketPath}); err == nil {
+		t.Fatalf("invalid network accepted")
+	}
+}
+
+func TestStaleSocketIsRemoved(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "middleware.sock")
+
+	// a previous execution that didn't stop cleanly leaves its socket behind
+	previous, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	previous.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = previous.Close()
+	if _, err := os.Stat(socketPath); err != nil {
+		t.Fatalf("stale socket not left behind: %v", err)
+	}
+
+	netListener, err := listen(config.ListenerConfig{Network: "unix", Address: socketPath})
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	defer netListener.Close()
+}
+
+func TestSocketInUseIsKept(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "middleware.sock")
+
+	running, err := listen(config.ListenerConfig{Network: "unix", Address: socketPath})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &http.Server{Handler: pathsHandler(nil)}
+	go server.Serve(running)
+	defer server.Close()
+
+	if netListener, err := listen(config.ListenerConfig{Network: "unix", Address: socketPath}); err == nil {
+		_ = netListener.Close()
+		t.Fatalf("the socket of the running instance was replaced")
+	}
+
+	if status, _ := get(t, unixClient(socketPath), "/listener-test/public"); status != http.StatusOK {
+		t.Errorf("the running instance is no longer reachable, got %d", status)
+	}
+}
+
+func TestRegularFileIsNotRemoved(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "middleware.sock")
+	if err := os.WriteFile(filePath, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if netListener, err := listen(config.ListenerConfig{Network: "unix", Address: filePath}); err == nil {
+		_ = netListener.Close()
+		t.Fatalf("listening on a regular file")
+	}
+	if content, err := os.ReadFile(filePath); err != nil || string(content) != "data" {
+		t.Errorf("regular file changed: %v", err)
+	}
+}
+
+func TestPathsHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		paths    []string
+		path     string
+		expected int
+	}{
+		{"all paths served when empty", nil, "/listener-test/internal", http.StatusOK},
+		{"listed path", []string{"/listener-test/public"}, "/listener-test/public", http.StatusOK},
+		{"path not listed", []string{"/listener-test/public"}, "/listener-test/internal", http.StatusNotFound},
+		{"sub path not listed", []string{"/listener-test/public"}, "/listener-test/public/more", http.StatusNotFound},
+		{"unknown path", []string{"/listener-test/public"}, "/unknown", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			netListener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := &http.Server{Handler: pathsHandler(tt.paths)}
+			go server.Serve(netListener)
+			defer server.Close()
+
+			response, err := http.Get("http://" + netListener.Addr().String() + tt.path)
+			if err != nil {
+				t.Fatalf("GET %s: %v", tt.path, err)
+			}
+			_ = response.Body.Close()
+			if response.StatusCode != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, response.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package msgencoding
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+// Encoding is the wire format used with the browser
+// Hasura and the internal channels always use JSON, so binary encodings are applied only
+// when writing to (or reading from) the browser websocket
+type Encoding string
+
+const (
+	JSON        Encoding = "json"
+	MessagePack Encoding = "msgpack"
+	CBOR        Encoding = "cbor"
+)
+
+const (
+	SubprotocolJSON        = "graphql-transport-ws"
+	SubprotocolMessagePack = "graphql-transport-ws.msgpack"
+	SubprotocolCBOR        = "graphql-transport-ws.cbor"
+)
+
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	BigIntDec:      cbor.BigIntDecodePointer, // *big.Int is marshalled to JSON as a number
+}.DecMode()
+
+// FromSubprotocol returns the encoding negotiated through the websocket subprotocol
+func FromSubprotocol(subprotocol string) Encoding {
+	switch subprotocol {
+	case SubprotocolMessagePack:
+		return MessagePack
+	case SubprotocolCBOR:
+		return CBOR
+	default:
+		return JSON
+	}
+}
+
+// FromJSON re-encodes a JSON message (received from Hasura or created by the middleware) to the given encoding
+func FromJSON(message []byte, encoding Encoding) ([]byte, error) {
+	if encoding == JSON {
+		return message, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	var messageAsInterface interface{}
+	if err := decoder.Decode(&messageAsInterface); err != nil {
+		return nil, err
+	}
+	messageAsInterface = convertNumbers(messageAsInterface, encoding == CBOR)
+
+	switch encoding {
+	case MessagePack:
+		return marshalMsgpack(messageAsInterface)
+	case CBOR:
+		return cbor.Marshal(messageAsInterface)
+	default:
+		return nil, fmt.Errorf("unknown encoding %s", encoding)
+	}
+}
+
+// marshalMsgpack encodes the integers in the smallest type that fits them (msgpack.Marshal always uses 9 bytes for an int64)
+func marshalMsgpack(value interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := msgpack.NewEncoder(&buffer)
+	encoder.UseCompactInts(true)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// ToJSON decodes a binary message (received from the browser) to JSON
+func ToJSON(message []byte, encoding Encoding) ([]byte, error) {
+	var messageAsInterface interface{}
+
+	switch encoding {
+	case JSON:
+		return message, nil
+	case MessagePack:
+		if err := msgpack.Unmarshal(message, &messageAsInterface); err != nil {
+			return nil, err
+		}
+	case CBOR:
+		if err := cborDecMode.Unmarshal(message, &messageAsInterface); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding %s", encoding)
+	}
+
+	return json.Marshal(messageAsInterface)
+}
+
+// convertNumbers replaces json.Number by int64 or uint64 (when possible) or float64
+// so integers are encoded in their compact binary form
+// Larger integers are kept exact as CBOR bignums, MessagePack has no integer type for them so they become float64
+func convertNumbers(value interface{}, bigInts bool) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if asInt, err := v.Int64(); err == nil {
+			return asInt
+		}
+		if asUint, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return asUint
+		}
+		if asBigInt, isInt := new(big.Int).SetString(v.String(), 10); isInt && bigInts {
+			return asBigInt
+		}
+		asFloat, _ := v.Float64()
+		return asFloat
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = convertNumbers(item, bigInts)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertNumbers(item, bigInts)
+		}
+	}
+
+	return value
+}
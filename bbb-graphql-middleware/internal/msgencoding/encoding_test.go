@@ -0,0 +1,134 @@
+package msgencoding
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// canonicalJson sorts the keys of the message and keeps its numbers as written, so integers are compared exactly
+func canonicalJson(t *testing.T, message []byte) string {
+	t.Helper()
+
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatalf("invalid json %s: %v", message, err)
+	}
+	canonical, _ := json.Marshal(value)
+	return string(canonical)
+}
+
+func roundTrip(t *testing.T, message string, encoding Encoding) []byte {
+	t.Helper()
+
+	encoded, err := FromJSON([]byte(message), encoding)
+	if err != nil {
+		t.Fatalf("%s: failed to encode %s: %v", encoding, message, err)
+	}
+	decoded, err := ToJSON(encoded, encoding)
+	if err != nil {
+		t.Fatalf("%s: failed to decode %s: %v", encoding, message, err)
+	}
+	return decoded
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+	}{
+		{"next", `{"id":"1","type":"next","payload":{"data":{"user":[{"userId":"u1","name":"Jöhn","away":false,"color":null}]}}}`},
+		{"empty", `{"type":"connection_ack","payload":{},"list":[]}`},
+		{"nested", `{"a":[[{"b":[1,2,{"c":"d"}]}]]}`},
+		{"integers", `{"numbers":[0,-1,127,255,65536,-2147483649,9223372036854775807,-9223372036854775808]}`},
+		{"unsigned", `{"numbers":[9223372036854775808,18446744073709551615]}`},
+	}
+
+	for _, encoding := range []Encoding{JSON, MessagePack, CBOR} {
+		for _, tt := range tests {
+			t.Run(string(encoding)+"/"+tt.name, func(t *testing.T) {
+				decoded := roundTrip(t, tt.message, encoding)
+				if expected, actual := canonicalJson(t, []byte(tt.message)), canonicalJson(t, decoded); expected != actual {
+					t.Errorf("expected %s, got %s", expected, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestFractionalNumbers(t *testing.T) {
+	message := `{"numbers":[0.5,-1.25,3.141592653589793,1e-7,1.5e300,2.0]}`
+	expected := []float64{0.5, -1.25, 3.141592653589793, 1e-7, 1.5e300, 2}
+
+	for _, encoding := range []Encoding{MessagePack, CBOR} {
+		var decoded struct {
+			Numbers []float64 `json:"numbers"`
+		}
+		if err := json.Unmarshal(roundTrip(t, message, encoding), &decoded); err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		for i, number := range expected {
+			if decoded.Numbers[i] != number {
+				t.Errorf("%s: expected %v, got %v", encoding, number, decoded.Numbers[i])
+			}
+		}
+	}
+}
+
+func TestIntegersBeyond64Bits(t *testing.T) {
+	message := `{"big":-123456789012345678901234567890}`
+
+	if decoded := roundTrip(t, message, CBOR); string(decoded) != message {
+		t.Errorf("cbor should keep the integer exact (as a bignum), got %s", decoded)
+	}
+
+	// MessagePack has no integer type for them, so they are as precise as a float64
+	var decoded struct {
+		Big float64 `json:"big"`
+	}
+	_ = json.Unmarshal(roundTrip(t, message, MessagePack), &decoded)
+	if decoded.Big != -123456789012345678901234567890.0 {
+		t.Errorf("msgpack: expected %v, got %v", -123456789012345678901234567890.0, decoded.Big)
+	}
+}
+
+func TestIntegersAreEncodedCompactly(t *testing.T) {
+	// fixmap(1), fixstr "a" (2 bytes), positive fixint 1
+	if encoded, _ := FromJSON([]byte(`{"a":1}`), MessagePack); len(encoded) != 4 {
+		t.Errorf("msgpack: expected 1 encoded as a fixint, got % x", encoded)
+	}
+	// map(1), text "a" (2 bytes), unsigned 1
+	if encoded, _ := FromJSON([]byte(`{"a":1}`), CBOR); len(encoded) != 4 {
+		t.Errorf("cbor: expected 1 encoded in one byte, got % x", encoded)
+	}
+}
+
+func TestInvalidMessages(t *testing.T) {
+	if _, err := FromJSON([]byte(`{"type":`), MessagePack); err == nil {
+		t.Errorf("invalid json accepted")
+	}
+	if _, err := FromJSON([]byte(`{"type":"ping"}`), Encoding("xml")); err == nil {
+		t.Errorf("unknown encoding accepted")
+	}
+	if _, err := ToJSON([]byte{0xc1}, MessagePack); err == nil {
+		t.Errorf("invalid msgpack accepted")
+	}
+	if _, err := ToJSON([]byte{0xff}, CBOR); err == nil {
+		t.Errorf("invalid cbor accepted")
+	}
+}
+
+func TestFromSubprotocol(t *testing.T) {
+	for subprotocol, expected := range map[string]Encoding{
+		SubprotocolJSON:        JSON,
+		SubprotocolMessagePack: MessagePack,
+		SubprotocolCBOR:        CBOR,
+		"":                     JSON,
+	} {
+		if encoding := FromSubprotocol(subprotocol); encoding != expected {
+			t.Errorf("%q: expected %s, got %s", subprotocol, expected, encoding)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package msgpatch
+
+import (
+	"bbb-graphql-middleware/internal/common"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// DebugHandler returns the operations with the most expensive json-patch processing
+// Query params: sort (duration, messages, ratio or fallbacks) and limit (default 20)
+// Requires the admin token, as it's served by the same listeners as the browsers
+func DebugHandler(w http.ResponseWriter, r *http.Request) {
+	if !common.IsAdminRequest(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sortBy := r.URL.Query().Get("sort")
+	switch sortBy {
+	case "":
+		sortBy = "duration"
+	case "duration", "messages", "ratio", "fallbacks":
+	default:
+		http.Error(w, "invalid sort, expected duration, messages, ratio or fallbacks", http.StatusBadRequest)
+		return
+	}
+
+	limit := 20
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitParam); err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"sort":       sortBy,
+		"operations": common.GetJsonPatchTopOffenders(sortBy, limit),
+	})
+}
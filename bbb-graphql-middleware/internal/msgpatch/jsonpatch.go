@@ -2,10 +2,11 @@ package msgpatch
 
 import (
 	"bbb-graphql-middleware/internal/common"
+	"bytes"
 	"encoding/json"
 	"github.com/mattbaird/jsonpatch"
 	log "github.com/sirupsen/logrus"
-	"strconv"
+	"time"
 )
 
 var minLengthToPatch = 250    //250 chars
@@ -13,6 +14,7 @@ var minShrinkToUsePatch = 0.5 //50% percent
 
 func GetPatchedMessage(
 	receivedMessage []byte,
+	operationName string,
 	dataKey string,
 	lastHasuraMessage common.HasuraMessage,
 	hasuraMessage common.HasuraMessage,
@@ -20,21 +22,26 @@ func GetPatchedMessage(
 	lastDataChecksum uint32,
 	currDataChecksum uint32) []byte {
 
-	if lastDataChecksum != 0 {
-		common.JsonPatchBenchmarkingStarted(strconv.Itoa(int(cacheKey)))
-		defer common.JsonPatchBenchmarkingCompleted(strconv.Itoa(int(cacheKey)))
-	}
+	//Other routines processing the same message will wait to benefit from this cache
+	cacheHit := true
+	patchedMessage := common.PatchedMessageCache.GetOrCompute(cacheKey, func() []byte {
+		cacheHit = false
+		return createPatchedMessage(receivedMessage, operationName, dataKey, lastHasuraMessage, hasuraMessage, lastDataChecksum, currDataChecksum)
+	})
 
-	//Lock to avoid other routines from processing the same message
-	common.GlobalCacheLocks.Lock(cacheKey)
-	if patchedMessageCache, patchedMessageCacheExists := common.GetPatchedMessageCache(cacheKey); patchedMessageCacheExists {
-		//Unlock immediately once the cache was already created by other routine
-		common.GlobalCacheLocks.Unlock(cacheKey)
-		return patchedMessageCache
-	} else {
-		//It will create the cache and then Unlock (others will wait to benefit from this cache)
-		defer common.GlobalCacheLocks.Unlock(cacheKey)
-	}
+	common.JsonPatchMessageProcessed(operationName, cacheHit, !bytes.Equal(patchedMessage, receivedMessage))
+
+	return patchedMessage
+}
+
+func createPatchedMessage(
+	receivedMessage []byte,
+	operationName string,
+	dataKey string,
+	lastHasuraMessage common.HasuraMessage,
+	hasuraMessage common.HasuraMessage,
+	lastDataChecksum uint32,
+	currDataChecksum uint32) []byte {
 
 	var jsonDiffPatch []byte
 
@@ -42,26 +49,33 @@ func GetPatchedMessage(
 		//Content didn't change, set message as null to avoid sending it to the browser
 		//This case is usual when the middleware reconnects with Hasura and receives the data again
 		jsonData, _ := json.Marshal(nil)
-		common.StorePatchedMessageCache(cacheKey, jsonData)
 		return jsonData
 	} else {
 		//Content was changed, creating json patch
 		//If data is small (< minLengthToPatch) it's not worth creating the patch
 		if len(hasuraMessage.Payload.Data[dataKey]) > minLengthToPatch {
 			if string(lastHasuraMessage.Payload.Data[dataKey]) != "" {
+				startedAt := time.Now()
+				dataSize := len(hasuraMessage.Payload.Data[dataKey])
+				defer func() {
+					common.JsonPatchGenerated(operationName, time.Since(startedAt), dataSize, len(jsonDiffPatch))
+				}()
+
 				var shouldUseCustomJsonPatch bool
-				if shouldUseCustomJsonPatch, jsonDiffPatch = common.ValidateIfShouldUseCustomJsonPatch(
+				shouldUseCustomJsonPatch, jsonDiffPatch = common.ValidateIfShouldUseCustomJsonPatch(
 					lastHasuraMessage.Payload.Data[dataKey],
 					hasuraMessage.Payload.Data[dataKey],
-					"userId"); shouldUseCustomJsonPatch {
-					common.StorePatchedMessageCache(cacheKey, jsonDiffPatch)
-				} else if diffPatch, diffPatchErr := jsonpatch.CreatePatch(lastHasuraMessage.Payload.Data[dataKey], hasuraMessage.Payload.Data[dataKey]); diffPatchErr == nil {
-					var err error
-					if jsonDiffPatch, err = json.Marshal(diffPatch); err != nil {
-						log.Errorf("Error marshaling patch array: %v", err)
+					"userId",
+					operationName)
+				if !shouldUseCustomJsonPatch {
+					if diffPatch, diffPatchErr := jsonpatch.CreatePatch(lastHasuraMessage.Payload.Data[dataKey], hasuraMessage.Payload.Data[dataKey]); diffPatchErr == nil {
+						var err error
+						if jsonDiffPatch, err = json.Marshal(diffPatch); err != nil {
+							log.Errorf("Error marshaling patch array: %v", err)
+						}
+					} else {
+						log.Errorf("Error creating JSON patch: %v\n%v", diffPatchErr, string(hasuraMessage.Payload.Data[dataKey]))
 					}
-				} else {
-					log.Errorf("Error creating JSON patch: %v\n%v", diffPatchErr, string(hasuraMessage.Payload.Data[dataKey]))
 				}
 			}
 		}
@@ -80,6 +94,5 @@ func GetPatchedMessage(
 		receivedMessage = hasuraMessageJson
 	}
 
-	common.StorePatchedMessageCache(cacheKey, receivedMessage)
 	return receivedMessage
 }
@@ -0,0 +1,92 @@
+package tap
+
+import (
+	"bbb-graphql-middleware/internal/common"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Handler streams (JSON lines) the frames of the connections matching browserConnectionId, sessionToken or userId
+// until `duration` seconds (limited by tap.max_duration_seconds) or the client disconnects
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if !common.IsAdminRequest(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter := Filter{
+		BrowserConnectionId: r.URL.Query().Get("browserConnectionId"),
+		SessionToken:        r.URL.Query().Get("sessionToken"),
+		UserId:              r.URL.Query().Get("userId"),
+	}
+	if filter == (Filter{}) {
+		http.Error(w, "browserConnectionId, sessionToken or userId is required", http.StatusBadRequest)
+		return
+	}
+
+	duration := time.Duration(tapConfig.DefaultDurationSeconds) * time.Second
+	if durationParam := r.URL.Query().Get("duration"); durationParam != "" {
+		durationSeconds, err := strconv.Atoi(durationParam)
+		if err != nil || durationSeconds <= 0 {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		duration = time.Duration(durationSeconds) * time.Second
+	}
+	if maxDuration := time.Duration(tapConfig.MaxDurationSeconds) * time.Second; maxDuration > 0 && duration > maxDuration {
+		duration = maxDuration
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	logger := log.WithField("_routine", "Tap").
+		WithField("browserConnectionId", filter.BrowserConnectionId).
+		WithField("userId", filter.UserId).
+		WithField("remoteAddr", r.RemoteAddr)
+	logger.Infof("tap started for %v", duration)
+
+	ctx, cancel := context.WithTimeout(r.Context(), duration)
+	defer cancel()
+
+	t := Start(filter)
+	defer t.Stop()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case frame := <-t.Frames():
+			if err := encoder.Encode(frame); err != nil {
+				logger.Infof("tap stopped, client is gone: %v", err)
+				return
+			}
+			flusher.Flush()
+		case <-ctx.Done():
+			reason := "timeout"
+			if r.Context().Err() != nil {
+				reason = "client disconnected"
+			}
+			logger.Infof("tap stopped (%s), %d frames dropped", reason, t.Dropped())
+			_ = encoder.Encode(map[string]interface{}{
+				"tapEnded":      true,
+				"reason":        reason,
+				"droppedFrames": t.Dropped(),
+			})
+			flusher.Flush()
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package tap mirrors the frames of a browser connection live to a support engineer (/admin/tap)
+package tap
+
+import (
+	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/capture"
+	"bbb-graphql-middleware/internal/common"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Filter chooses the connections mirrored by the tap (the fields informed must all match)
+type Filter struct {
+	BrowserConnectionId string
+	SessionToken        string
+	UserId              string
+}
+
+func (f Filter) matches(browserConnection *common.BrowserConnection) bool {
+	return (f.BrowserConnectionId == "" || f.BrowserConnectionId == browserConnection.Id) &&
+		(f.SessionToken == "" || f.SessionToken == browserConnection.SessionToken) &&
+		(f.UserId == "" || f.UserId == browserConnection.UserId)
+}
+
+// Tap receives the frames of the connections matching its filter, already redacted
+type Tap struct {
+	filter  Filter
+	frames  chan capture.Frame
+	dropped atomic.Int64
+}
+
+var tapConfig = config.GetConfig().Tap
+
+var tapsMutex sync.RWMutex
+var taps = make(map[*Tap]bool)
+var activeTaps atomic.Int32
+
+// Start registers a tap, it must be stopped once the client is gone
+func Start(filter Filter) *Tap {
+	bufferSize := tapConfig.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = 1000
+	}
+	t := &Tap{
+		filter: filter,
+		frames: make(chan capture.Frame, bufferSize),
+	}
+
+	tapsMutex.Lock()
+	taps[t] = true
+	activeTaps.Store(int32(len(taps)))
+	tapsMutex.Unlock()
+	return t
+}
+
+func (t *Tap) Stop() {
+	tapsMutex.Lock()
+	delete(taps, t)
+	activeTaps.Store(int32(len(taps)))
+	tapsMutex.Unlock()
+}
+
+// Frames returns the channel of the frames mirrored
+func (t *Tap) Frames() <-chan capture.Frame {
+	return t.frames
+}
+
+// Dropped returns the number of frames not mirrored because the client was not reading them fast enough
+func (t *Tap) Dropped() int64 {
+	return t.dropped.Load()
+}
+
+// Mirror sends the frame to the taps of the connection (it returns immediately when there's no tap)
+func Mirror(browserConnection *common.BrowserConnection, direction capture.Direction, hasuraConnectionId string, message []byte) {
+	if activeTaps.Load() == 0 {
+		return
+	}
+
+	tapsMutex.RLock()
+	defer tapsMutex.RUnlock()
+
+	var frame *capture.Frame
+	for t := range taps {
+		if !t.filter.matches(browserConnection) {
+			continue
+		}
+
+		//The frame is redacted only once, for all the taps of the connection
+		if frame == nil {
+			frame = &capture.Frame{
+				Time:                time.Now(),
+				Direction:           direction,
+				BrowserConnectionId: browserConnection.Id,
+				HasuraConnectionId:  hasuraConnectionId,
+				Message:             redaction.RedactMessage(message, browserConnection.SessionToken),
+			}
+		}
+
+		select {
+		case t.frames <- *frame:
+		default:
+			t.dropped.Add(1)
+		}
+	}
+}
+
+// redaction replaces the values of the fields matching tap.redact_fields (the session token is replaced as well)
+var redaction = common.Redaction{RedactFields: tapConfig.RedactFields}
@@ -0,0 +1,87 @@
+package tap
+
+import (
+	"bbb-graphql-middleware/internal/capture"
+	"bbb-graphql-middleware/internal/common"
+	"bbb-graphql-middleware/internal/testsupport"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if reexecuted, exitCode := testsupport.RunWithTestConfig("../../config/config.yml", map[string]interface{}{
+		"log_level":         "warn",
+		"tap.buffer_size":   2,
+		"tap.redact_fields": []string{"password", "*token*"},
+	}); reexecuted {
+		os.Exit(exitCode)
+	}
+
+	os.Exit(m.Run())
+}
+
+func browserConnection(id string, sessionToken string, userId string) *common.BrowserConnection {
+	return &common.BrowserConnection{Id: id, SessionToken: sessionToken, UserId: userId}
+}
+
+func TestFilterMatches(t *testing.T) {
+	bc := browserConnection("bc1", "token1", "user1")
+
+	tests := []struct {
+		filter  Filter
+		matches bool
+	}{
+		{Filter{BrowserConnectionId: "bc1"}, true},
+		{Filter{SessionToken: "token1"}, true},
+		{Filter{UserId: "user1"}, true},
+		{Filter{BrowserConnectionId: "bc1", UserId: "user1"}, true},
+		{Filter{BrowserConnectionId: "bc1", UserId: "user2"}, false},
+		{Filter{SessionToken: "token2"}, false},
+	}
+	for _, test := range tests {
+		if matches := test.filter.matches(bc); matches != test.matches {
+			t.Errorf("filter %+v: expected matches %v, got %v", test.filter, test.matches, matches)
+		}
+	}
+}
+
+func TestMirrorRedactsTheFramesOfTheMatchingConnections(t *testing.T) {
+	tap := Start(Filter{UserId: "user1"})
+	defer tap.Stop()
+
+	Mirror(browserConnection("bc2", "token2", "user2"), capture.BrowserToMiddleware, "", []byte(`{"type":"ping"}`))
+	Mirror(browserConnection("bc1", "token1", "user1"), capture.BrowserToMiddleware, "",
+		[]byte(`{"type":"connection_init","payload":{"headers":{"X-Session-Token":"token1"},"client_token":"abc","password":"secret"}}`))
+
+	frame := <-tap.Frames()
+	if frame.BrowserConnectionId != "bc1" {
+		t.Fatalf("frame of another connection mirrored: %s", frame.BrowserConnectionId)
+	}
+	var message map[string]interface{}
+	_ = json.Unmarshal(frame.Message, &message)
+	payload := message["payload"].(map[string]interface{})
+	if token := payload["headers"].(map[string]interface{})["X-Session-Token"]; token != common.RedactedValue {
+		t.Errorf("session token not redacted: %v", token)
+	}
+	if payload["client_token"] != common.RedactedValue || payload["password"] != common.RedactedValue {
+		t.Errorf("fields not redacted: %v", payload)
+	}
+}
+
+func TestMirrorDropsFramesWhenTheTapIsFull(t *testing.T) {
+	tap := Start(Filter{BrowserConnectionId: "bc3"})
+	defer tap.Stop()
+
+	for i := 0; i < 5; i++ {
+		Mirror(browserConnection("bc3", "token3", "user3"), capture.HasuraToMiddleware, "h1", []byte(`{"type":"next"}`))
+	}
+	if tap.Dropped() != 3 {
+		t.Errorf("expected 3 frames dropped with a buffer of 2, got %d", tap.Dropped())
+	}
+
+	tap.Stop()
+	if activeTaps.Load() != 0 {
+		t.Errorf("tap still active after stop")
+	}
+}
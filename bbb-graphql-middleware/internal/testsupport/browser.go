@@ -0,0 +1,265 @@
+package testsupport
+
+import (
+	"bbb-graphql-middleware/internal/msgencoding"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"nhooyr.io/websocket"
+)
+
+// Browser is a graphql-transport-ws client connected to the middleware
+type Browser struct {
+	conn     *websocket.Conn
+	netConn  net.Conn // socket of the websocket, to drop it without the close handshake
+	encoding msgencoding.Encoding
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	received  chan BrowserMessage
+	closed    chan struct{}
+	closeErr  error
+	paused    chan struct{}
+	pauseOnce sync.Once
+}
+
+// BrowserMessage is a message the middleware sent to the browser
+type BrowserMessage struct {
+	Id      string          `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	Raw     []byte          `json:"-"`
+}
+
+// ConnectBrowser opens the websocket and sends the connection_init with the headers required by the middleware
+func ConnectBrowser(middlewareUrl string, sessionToken string) (*Browser, error) {
+	b, err := DialBrowser(middlewareUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.SendConnectionInit(sessionToken); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// BrowserOptions are the websocket options of the browser
+type BrowserOptions struct {
+	Subprotocol       string                    // graphql-transport-ws when empty (the binary ones encode the frames)
+	CompressionMode   websocket.CompressionMode // permessage-deflate offered in the handshake
+	Capabilities      map[string]interface{}    // capabilities declared in the connection_init (none when nil)
+	ClientSessionUUID string                    // X-ClientSessionUUID (a new one when empty)
+	ResumeToken       string                    // X-Resume-Token, to resume the session of a dropped connection
+}
+
+// ConnectBrowserWithOptions is ConnectBrowser using the options
+func ConnectBrowserWithOptions(middlewareUrl string, sessionToken string, options BrowserOptions) (*Browser, error) {
+	b, err := DialBrowserWithOptions(middlewareUrl, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.sendConnectionInit(sessionToken, options); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// DialBrowser opens the websocket without sending the connection_init
+func DialBrowser(middlewareUrl string) (*Browser, error) {
+	return DialBrowserWithOptions(middlewareUrl, BrowserOptions{})
+}
+
+// DialBrowserWithOptions is DialBrowser using the options
+func DialBrowserWithOptions(middlewareUrl string, options BrowserOptions) (*Browser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	subprotocol := options.Subprotocol
+	if subprotocol == "" {
+		subprotocol = msgencoding.SubprotocolJSON
+	}
+	var netConn net.Conn
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			var err error
+			netConn, err = (&net.Dialer{}).DialContext(ctx, network, address)
+			return netConn, err
+		},
+	}}
+	conn, _, err := websocket.Dial(ctx, middlewareUrl, &websocket.DialOptions{
+		HTTPClient:      httpClient,
+		Subprotocols:    []string{subprotocol},
+		CompressionMode: options.CompressionMode,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	conn.SetReadLimit(9999999)
+
+	b := &Browser{
+		conn:     conn,
+		netConn:  netConn,
+		encoding: msgencoding.FromSubprotocol(conn.Subprotocol()),
+		ctx:      ctx,
+		cancel:   cancel,
+		received: make(chan BrowserMessage, 1000),
+		closed:   make(chan struct{}),
+		paused:   make(chan struct{}),
+	}
+	go b.read()
+	return b, nil
+}
+
+// SendConnectionInit sends the connection_init with the headers required by the middleware
+func (b *Browser) SendConnectionInit(sessionToken string) error {
+	return b.SendConnectionInitWithCapabilities(sessionToken, nil)
+}
+
+// SendConnectionInitWithCapabilities sends the connection_init declaring the capabilities (when not nil)
+func (b *Browser) SendConnectionInitWithCapabilities(sessionToken string, capabilities map[string]interface{}) error {
+	return b.sendConnectionInit(sessionToken, BrowserOptions{Capabilities: capabilities})
+}
+
+func (b *Browser) sendConnectionInit(sessionToken string, options BrowserOptions) error {
+	clientSessionUUID := options.ClientSessionUUID
+	if clientSessionUUID == "" {
+		clientSessionUUID = uuid.New().String()
+	}
+	headers := map[string]string{
+		"X-Session-Token":     sessionToken,
+		"X-ClientSessionUUID": clientSessionUUID,
+		"X-ClientType":        "HTML5",
+		"X-ClientIsMobile":    "false",
+	}
+	if options.ResumeToken != "" {
+		headers["X-Resume-Token"] = options.ResumeToken
+	}
+
+	payload := map[string]interface{}{"headers": headers}
+	if options.Capabilities != nil {
+		payload["capabilities"] = options.Capabilities
+	}
+	return b.Send(map[string]interface{}{
+		"type":    "connection_init",
+		"payload": payload,
+	})
+}
+
+func (b *Browser) read() {
+	defer close(b.closed)
+	for {
+		select {
+		case <-b.paused:
+			<-b.ctx.Done()
+			b.closeErr = b.ctx.Err()
+			return
+		default:
+		}
+
+		_, data, err := b.conn.Read(b.ctx)
+		if err != nil {
+			b.closeErr = err
+			return
+		}
+		if data, err = msgencoding.ToJSON(data, b.encoding); err != nil {
+			continue
+		}
+
+		var message BrowserMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			continue
+		}
+		message.Raw = data
+		b.received <- message
+	}
+}
+
+func (b *Browser) Send(message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if b.encoding != msgencoding.JSON {
+		if data, err = msgencoding.FromJSON(data, b.encoding); err != nil {
+			return err
+		}
+		return b.conn.Write(b.ctx, websocket.MessageBinary, data)
+	}
+	return b.conn.Write(b.ctx, websocket.MessageText, data)
+}
+
+// Subscribe starts the operation with the id
+func (b *Browser) Subscribe(id string, operationName string, query string, variables map[string]interface{}) error {
+	payload := map[string]interface{}{
+		"operationName": operationName,
+		"query":         query,
+	}
+	if variables != nil {
+		payload["variables"] = variables
+	}
+	return b.Send(map[string]interface{}{"id": id, "type": "subscribe", "payload": payload})
+}
+
+// Expect waits for the next message of the type (the messages of other types received meanwhile are discarded)
+func (b *Browser) Expect(messageType string, timeout time.Duration) (BrowserMessage, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case message := <-b.received:
+			if message.Type == messageType {
+				return message, nil
+			}
+		case <-b.closed:
+			// the messages received before the close are still delivered
+			for {
+				select {
+				case message := <-b.received:
+					if message.Type == messageType {
+						return message, nil
+					}
+				default:
+					return BrowserMessage{}, fmt.Errorf("connection closed waiting for %s: %v", messageType, b.closeErr)
+				}
+			}
+		case <-deadline:
+			return BrowserMessage{}, fmt.Errorf("no %s received by the browser after %v", messageType, timeout)
+		}
+	}
+}
+
+// ExpectClose waits for the middleware to close the connection and returns the close status
+func (b *Browser) ExpectClose(timeout time.Duration) (websocket.StatusCode, error) {
+	select {
+	case <-b.closed:
+		return websocket.CloseStatus(b.closeErr), nil
+	case <-time.After(timeout):
+		return -1, fmt.Errorf("connection still open after %v", timeout)
+	}
+}
+
+// PauseReading stops reading once the message being read is received, so the middleware can't write anymore
+// (the socket buffers fill up) until the connection is closed
+func (b *Browser) PauseReading() {
+	b.pauseOnce.Do(func() { close(b.paused) })
+}
+
+// Drop closes the socket without the close handshake, like a browser that lost the network
+func (b *Browser) Drop() {
+	_ = b.netConn.Close()
+	b.cancel()
+}
+
+func (b *Browser) Close() {
+	_ = b.conn.Close(websocket.StatusNormalClosure, "")
+	b.cancel()
+}
@@ -0,0 +1,243 @@
+package testsupport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"nhooyr.io/websocket"
+)
+
+// FakeHasura is a graphql-transport-ws server standing in for Hasura, it answers ping and the handler answers the rest
+// Without handler it's scriptable: it acknowledges connection_init and every other message is delivered to the test
+// (Expect), which answers through the connection that received it (SendNext, SendComplete, Close...)
+type FakeHasura struct {
+	handler  FakeHasuraHandler
+	address  string
+	server   *http.Server
+	received chan HasuraMessage
+
+	mutex            sync.Mutex
+	connections      []*FakeHasuraConnection
+	connectionsCount int
+}
+
+// FakeHasuraHandler answers the messages the middleware sends to the FakeHasura (e.g. replaying a capture or
+// generating data for load tests)
+type FakeHasuraHandler interface {
+	HandleMessage(c *FakeHasuraConnection, message HasuraMessage)
+}
+
+type FakeHasuraConnection struct {
+	Index int // order of the connection, starting from 0
+
+	ctx        context.Context
+	conn       *websocket.Conn
+	writeMutex sync.Mutex
+}
+
+// HasuraMessage is a message the middleware sent to the fake Hasura
+type HasuraMessage struct {
+	Connection *FakeHasuraConnection `json:"-"`
+	Id         string                `json:"id"`
+	Type       string                `json:"type"`
+	Payload    struct {
+		OperationName string                 `json:"operationName"`
+		Query         string                 `json:"query"`
+		Variables     map[string]interface{} `json:"variables"`
+		Headers       map[string]interface{} `json:"headers"`
+	} `json:"payload"`
+	Raw []byte `json:"-"`
+}
+
+// NewFakeHasura returns the fake answering through the handler (scriptable when nil), to be served as http.Handler
+func NewFakeHasura(handler FakeHasuraHandler) *FakeHasura {
+	return &FakeHasura{
+		handler:  handler,
+		received: make(chan HasuraMessage, 1000),
+	}
+}
+
+// StartFakeHasura serves a scriptable fake on the address
+func StartFakeHasura(address string) (*FakeHasura, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	h := NewFakeHasura(nil)
+	h.address = listener.Addr().String()
+	h.serve(listener)
+	return h, nil
+}
+
+func (h *FakeHasura) serve(listener net.Listener) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.server = &http.Server{Handler: h}
+	go h.server.Serve(listener)
+}
+
+func (h *FakeHasura) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.server != nil {
+		_ = h.server.Close()
+	}
+}
+
+// Stop simulates Hasura going down: the connections are dropped and new ones are refused until Restart
+func (h *FakeHasura) Stop() {
+	h.Close()
+
+	// the websocket connections were hijacked, so they are not closed by the server
+	for _, c := range h.Connections() {
+		_ = c.conn.CloseNow()
+	}
+}
+
+// Restart accepts connections again on the same address
+func (h *FakeHasura) Restart() error {
+	listener, err := net.Listen("tcp", h.address)
+	if err != nil {
+		return err
+	}
+	h.serve(listener)
+	return nil
+}
+
+// Connections returns the open connections, in order
+func (h *FakeHasura) Connections() []*FakeHasuraConnection {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]*FakeHasuraConnection(nil), h.connections...)
+}
+
+// ConnectionsCount returns the number of connections received
+func (h *FakeHasura) ConnectionsCount() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.connectionsCount
+}
+
+// Expect waits for the next message of the type (the messages of other types received meanwhile are discarded)
+func (h *FakeHasura) Expect(messageType string, timeout time.Duration) (HasuraMessage, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case message := <-h.received:
+			if message.Type == messageType {
+				return message, nil
+			}
+		case <-deadline:
+			return HasuraMessage{}, fmt.Errorf("no %s received by the fake Hasura after %v", messageType, timeout)
+		}
+	}
+}
+
+// Discard drops the messages received so far
+func (h *FakeHasura) Discard() {
+	for {
+		select {
+		case <-h.received:
+		default:
+			return
+		}
+	}
+}
+
+func (h *FakeHasura) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: []string{"graphql-transport-ws"}})
+	if err != nil {
+		log.Errorf("fake Hasura failed to accept connection: %v", err)
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	conn.SetReadLimit(9999999)
+
+	c := &FakeHasuraConnection{ctx: r.Context(), conn: conn}
+	h.mutex.Lock()
+	c.Index = h.connectionsCount
+	h.connectionsCount++
+	h.connections = append(h.connections, c)
+	h.mutex.Unlock()
+	defer h.removeConnection(c)
+
+	for {
+		_, data, err := conn.Read(c.ctx)
+		if err != nil {
+			return
+		}
+
+		var message HasuraMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			continue
+		}
+		message.Connection = c
+		message.Raw = data
+
+		if message.Type == "ping" {
+			_ = c.Send([]byte(`{"type":"pong"}`))
+		}
+
+		if h.handler != nil {
+			h.handler.HandleMessage(c, message)
+			continue
+		}
+
+		if message.Type == "connection_init" {
+			_ = c.Send([]byte(`{"type":"connection_ack"}`))
+		}
+		select {
+		case h.received <- message:
+		default:
+		}
+	}
+}
+
+func (h *FakeHasura) removeConnection(c *FakeHasuraConnection) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for i, connection := range h.connections {
+		if connection == c {
+			h.connections = append(h.connections[:i], h.connections[i+1:]...)
+			return
+		}
+	}
+}
+
+// Context is done when the connection is closed
+func (c *FakeHasuraConnection) Context() context.Context {
+	return c.ctx
+}
+
+func (c *FakeHasuraConnection) Send(message []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.conn.Write(c.ctx, websocket.MessageText, message)
+}
+
+// SendNext sends the data (marshalled to JSON) of the operation
+func (c *FakeHasuraConnection) SendNext(id string, data interface{}) error {
+	dataJson, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return c.Send([]byte(fmt.Sprintf(`{"id":%q,"type":"next","payload":{"data":%s}}`, id, dataJson)))
+}
+
+func (c *FakeHasuraConnection) SendComplete(id string) error {
+	return c.Send([]byte(fmt.Sprintf(`{"id":%q,"type":"complete"}`, id)))
+}
+
+func (c *FakeHasuraConnection) Close(code websocket.StatusCode, reason string) error {
+	return c.conn.Close(code, reason)
+}
@@ -0,0 +1,168 @@
+package testsupport
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Hooks are stand-ins for the bbb-web auth hook, the akka-apps session variables hook and graphql-actions
+// Any session token is authorized (unless refused), the meeting and user are taken from the token
+// in the format <meetingId>-<userId> (e.g. meeting1-user2)
+type Hooks struct {
+	Role string // x-hasura-role returned by the session variables hook
+
+	mutex              sync.RWMutex
+	unauthorizedTokens map[string]bool   // refused by the auth hook
+	deniedTokens       map[string]string // refused by the session variables hook, session token -> message_id
+	actions            []Action
+
+	AuthRequests           atomic.Int64
+	SessionVarsRequests    atomic.Int64
+	GraphqlActionsRequests atomic.Int64
+}
+
+// only the last actions are kept, so long load tests don't grow the memory
+const maxRecordedActions = 1000
+
+// Action is a request received by the graphql-actions stand-in
+type Action struct {
+	Name             string                 `json:"-"`
+	Input            map[string]interface{} `json:"input"`
+	SessionVariables map[string]string      `json:"session_variables"`
+}
+
+func NewHooks() *Hooks {
+	return &Hooks{
+		Role:               "bbb_client",
+		unauthorizedTokens: make(map[string]bool),
+		deniedTokens:       make(map[string]string),
+	}
+}
+
+// Unauthorize makes the auth hook refuse the session token
+func (h *Hooks) Unauthorize(sessionToken string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.unauthorizedTokens[sessionToken] = true
+}
+
+// Deny makes the session variables hook refuse the session token with the message id (e.g. user_not_found)
+func (h *Hooks) Deny(sessionToken string, messageId string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.deniedTokens[sessionToken] = messageId
+}
+
+func (h *Hooks) Allow(sessionToken string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.unauthorizedTokens, sessionToken)
+	delete(h.deniedTokens, sessionToken)
+}
+
+func (h *Hooks) unauthorized(sessionToken string) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.unauthorizedTokens[sessionToken]
+}
+
+func (h *Hooks) denied(sessionToken string) (string, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	messageId, denied := h.deniedTokens[sessionToken]
+	return messageId, denied
+}
+
+// MeetingAndUserFromToken splits the session token in meeting and user (<meetingId>-<userId>)
+func MeetingAndUserFromToken(sessionToken string) (string, string) {
+	if meetingId, userId, found := strings.Cut(sessionToken, "-"); found {
+		return meetingId, userId
+	}
+	return "standin-meeting", sessionToken
+}
+
+// AuthHookHandler answers like bbb-web checkGraphqlAuthorization
+func (h *Hooks) AuthHookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.AuthRequests.Add(1)
+		sessionToken := r.Header.Get("x-session-token")
+
+		if h.unauthorized(sessionToken) || sessionToken == "" {
+			writeJson(w, map[string]string{"response": "unauthorized"})
+			return
+		}
+
+		meetingId, userId := MeetingAndUserFromToken(sessionToken)
+		writeJson(w, map[string]string{
+			"response":    "authorized",
+			"X-UserId":    userId,
+			"X-MeetingId": meetingId,
+		})
+	})
+}
+
+// SessionVarsHandler answers like akka-apps userInfo
+func (h *Hooks) SessionVarsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.SessionVarsRequests.Add(1)
+		sessionToken := r.Header.Get("x-session-token")
+
+		if messageId, denied := h.denied(sessionToken); denied {
+			writeJson(w, map[string]string{
+				"response":   "unauthorized",
+				"message":    "session denied by the stand-in",
+				"message_id": messageId,
+			})
+			return
+		}
+
+		meetingId, userId := MeetingAndUserFromToken(sessionToken)
+		writeJson(w, map[string]string{
+			"response":           "authorized",
+			"X-Hasura-Role":      h.Role,
+			"X-Hasura-UserId":    userId,
+			"X-Hasura-MeetingId": meetingId,
+		})
+	})
+}
+
+// GraphqlActionsHandler accepts every action
+func (h *Hooks) GraphqlActionsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.GraphqlActionsRequests.Add(1)
+
+		var request struct {
+			Action
+			ActionInfo struct {
+				Name string `json:"name"`
+			} `json:"action"`
+		}
+		if body, err := io.ReadAll(r.Body); err == nil && json.Unmarshal(body, &request) == nil {
+			request.Action.Name = request.ActionInfo.Name
+			h.mutex.Lock()
+			h.actions = append(h.actions, request.Action)
+			if len(h.actions) > maxRecordedActions {
+				h.actions = h.actions[len(h.actions)-maxRecordedActions:]
+			}
+			h.mutex.Unlock()
+		}
+
+		writeJson(w, map[string]interface{}{})
+	})
+}
+
+// Actions returns the requests received by the graphql-actions stand-in
+func (h *Hooks) Actions() []Action {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return append([]Action(nil), h.actions...)
+}
+
+func writeJson(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,237 @@
+package testsupport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// LoadRow is what the LoadHasura returns for any root field
+// TickAt is the time (unix nanos) of the update that changed the row, so clients can measure the fan-out latency
+type LoadRow struct {
+	Id        int64  `json:"id"`
+	CreatedAt string `json:"createdAt"`
+	TickAt    int64  `json:"tickAt"`
+}
+
+// LoadHasura is the FakeHasuraHandler generating data for load tests
+// Subscriptions receive the list of rows, one of them is updated on every tick (so json-patch is effective),
+// streams (`xxx_stream` root fields) receive a new row on every tick and queries receive the rows and complete
+type LoadHasura struct {
+	mutex       sync.Mutex
+	rows        []LoadRow
+	seq         int64
+	connections map[*FakeHasuraConnection]*loadConnection
+
+	Subscribes   atomic.Int64
+	Ticks        atomic.Int64
+	DroppedTicks atomic.Int64 // updates not sent because the connection was too slow to receive the previous ones
+}
+
+type loadConnection struct {
+	*FakeHasuraConnection
+	updates chan loadUpdate
+
+	mutex         sync.Mutex
+	subscriptions map[string]loadSubscription
+}
+
+type loadUpdate struct {
+	rowsJson       []byte
+	streamRowsJson []byte
+}
+
+type loadSubscription struct {
+	responseKey string
+	streaming   bool
+}
+
+func NewLoadHasura(rowsCount int) *LoadHasura {
+	h := &LoadHasura{
+		rows:        make([]LoadRow, max(rowsCount, 1)),
+		connections: make(map[*FakeHasuraConnection]*loadConnection),
+	}
+	now := time.Now()
+	for i := range h.rows {
+		h.rows[i] = LoadRow{Id: int64(i + 1), CreatedAt: now.UTC().Format(time.RFC3339Nano), TickAt: now.UnixNano()}
+	}
+	return h
+}
+
+// StartUpdates updates the data of every active subscription each interval, until the context is done
+func (h *LoadHasura) StartUpdates(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.Tick()
+		}
+	}
+}
+
+// Tick updates one of the rows and sends the new data to every active subscription
+func (h *LoadHasura) Tick() {
+	now := time.Now()
+
+	h.mutex.Lock()
+	h.seq++
+	updatedRow := &h.rows[int(h.seq)%len(h.rows)]
+	updatedRow.TickAt = now.UnixNano()
+	rowsJson, _ := json.Marshal(h.rows)
+	streamRowsJson, _ := json.Marshal([]LoadRow{{Id: h.seq, CreatedAt: now.UTC().Format(time.RFC3339Nano), TickAt: now.UnixNano()}})
+	connections := make([]*loadConnection, 0, len(h.connections))
+	for _, c := range h.connections {
+		connections = append(connections, c)
+	}
+	h.mutex.Unlock()
+
+	h.Ticks.Add(1)
+	for _, c := range connections {
+		select {
+		case c.updates <- loadUpdate{rowsJson: rowsJson, streamRowsJson: streamRowsJson}:
+		default:
+			h.DroppedTicks.Add(1)
+		}
+	}
+}
+
+func (h *LoadHasura) currentRows() []byte {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	rowsJson, _ := json.Marshal(h.rows)
+	return rowsJson
+}
+
+func (h *LoadHasura) HandleMessage(fc *FakeHasuraConnection, message HasuraMessage) {
+	switch message.Type {
+	case "connection_init":
+		c := &loadConnection{
+			FakeHasuraConnection: fc,
+			updates:              make(chan loadUpdate, 64),
+			subscriptions:        make(map[string]loadSubscription),
+		}
+		h.mutex.Lock()
+		h.connections[fc] = c
+		h.mutex.Unlock()
+		context.AfterFunc(fc.Context(), func() {
+			h.mutex.Lock()
+			delete(h.connections, fc)
+			h.mutex.Unlock()
+		})
+		go c.sendUpdates()
+
+		_ = fc.Send([]byte(`{"type":"connection_ack"}`))
+	case "subscribe":
+		h.Subscribes.Add(1)
+		operationType, subscription, err := parseLoadOperation(message.Payload.Query)
+		if err != nil {
+			_ = fc.Send([]byte(fmt.Sprintf(`{"id":%q,"type":"error","payload":[{"message":%q}]}`, message.Id, err.Error())))
+			return
+		}
+
+		if subscription.streaming {
+			// streams only receive the rows created after they started
+			_ = fc.Send(loadNextMessage(message.Id, subscription.responseKey, []byte("[]")))
+		} else {
+			_ = fc.Send(loadNextMessage(message.Id, subscription.responseKey, h.currentRows()))
+		}
+
+		if operationType != ast.OperationTypeSubscription {
+			_ = fc.Send([]byte(fmt.Sprintf(`{"id":%q,"type":"complete"}`, message.Id)))
+			return
+		}
+
+		if c := h.connection(fc); c != nil {
+			c.mutex.Lock()
+			c.subscriptions[message.Id] = subscription
+			c.mutex.Unlock()
+		}
+	case "complete":
+		if c := h.connection(fc); c != nil {
+			c.mutex.Lock()
+			delete(c.subscriptions, message.Id)
+			c.mutex.Unlock()
+		}
+	}
+}
+
+func (h *LoadHasura) connection(fc *FakeHasuraConnection) *loadConnection {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.connections[fc]
+}
+
+// sendUpdates sends the updates in order, so a slow connection doesn't hold the others
+func (c *loadConnection) sendUpdates() {
+	for {
+		select {
+		case <-c.Context().Done():
+			return
+		case u := <-c.updates:
+			c.sendData(u.rowsJson, u.streamRowsJson)
+		}
+	}
+}
+
+func (c *loadConnection) sendData(rowsJson []byte, streamRowsJson []byte) {
+	c.mutex.Lock()
+	subscriptions := make(map[string]loadSubscription, len(c.subscriptions))
+	for id, subscription := range c.subscriptions {
+		subscriptions[id] = subscription
+	}
+	c.mutex.Unlock()
+
+	for id, subscription := range subscriptions {
+		if subscription.streaming {
+			_ = c.Send(loadNextMessage(id, subscription.responseKey, streamRowsJson))
+		} else {
+			_ = c.Send(loadNextMessage(id, subscription.responseKey, rowsJson))
+		}
+	}
+}
+
+func loadNextMessage(id string, responseKey string, dataJson []byte) []byte {
+	return []byte(fmt.Sprintf(`{"id":%q,"type":"next","payload":{"data":{%q:%s}}}`, id, responseKey, dataJson))
+}
+
+// parseLoadOperation returns the type of the operation and the response key of its first root field
+func parseLoadOperation(query string) (string, loadSubscription, error) {
+	document, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return "", loadSubscription{}, err
+	}
+
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok || operation.SelectionSet == nil {
+			continue
+		}
+		for _, selection := range operation.SelectionSet.Selections {
+			field, ok := selection.(*ast.Field)
+			if !ok {
+				continue
+			}
+			responseKey := field.Name.Value
+			if field.Alias != nil && field.Alias.Value != "" {
+				responseKey = field.Alias.Value
+			}
+			return operation.Operation, loadSubscription{
+				responseKey: responseKey,
+				streaming:   strings.HasSuffix(field.Name.Value, "_stream"),
+			}, nil
+		}
+	}
+
+	return "", loadSubscription{}, fmt.Errorf("no operation found")
+}
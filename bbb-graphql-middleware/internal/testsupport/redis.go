@@ -0,0 +1,267 @@
+package testsupport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeRedis speaks enough of the Redis protocol (RESP2) for the middleware: PUBLISH, SUBSCRIBE and XADD
+// Messages published by the tests are delivered to the subscribers and the ones published by the middleware are recorded
+type FakeRedis struct {
+	listener net.Listener
+
+	mutex       sync.Mutex
+	subscribers map[string][]*redisClient // by channel
+	published   map[string][]string       // by channel
+	streams     map[string][]map[string]string
+}
+
+type redisClient struct {
+	conn       net.Conn
+	writeMutex sync.Mutex
+	subscribed int
+}
+
+func StartFakeRedis(address string) (*FakeRedis, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &FakeRedis{
+		listener:    listener,
+		subscribers: make(map[string][]*redisClient),
+		published:   make(map[string][]string),
+		streams:     make(map[string][]map[string]string),
+	}
+	go r.accept()
+	return r, nil
+}
+
+func (r *FakeRedis) Addr() string {
+	return r.listener.Addr().String()
+}
+
+func (r *FakeRedis) Close() {
+	_ = r.listener.Close()
+}
+
+// Publish delivers the message to the subscribers of the channel and returns how many received it
+func (r *FakeRedis) Publish(channel string, message string) int {
+	r.mutex.Lock()
+	r.published[channel] = append(r.published[channel], message)
+	subscribers := append([]*redisClient(nil), r.subscribers[channel]...)
+	r.mutex.Unlock()
+
+	for _, c := range subscribers {
+		c.write(array(bulk("message"), bulk(channel), bulk(message)))
+	}
+	return len(subscribers)
+}
+
+// Published returns the messages published in the channel
+func (r *FakeRedis) Published(channel string) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.published[channel]...)
+}
+
+// StreamEntries returns the entries added (XADD) to the stream
+func (r *FakeRedis) StreamEntries(stream string) []map[string]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]map[string]string(nil), r.streams[stream]...)
+}
+
+// WaitSubscriber waits until someone is subscribed to the channel
+func (r *FakeRedis) WaitSubscriber(channel string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		r.mutex.Lock()
+		subscribers := len(r.subscribers[channel])
+		r.mutex.Unlock()
+		if subscribers > 0 {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("no subscriber to %s after %v", channel, timeout)
+}
+
+func (r *FakeRedis) accept() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.serve(&redisClient{conn: conn})
+	}
+}
+
+func (r *FakeRedis) serve(c *redisClient) {
+	defer c.conn.Close()
+	defer r.unsubscribeAll(c)
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "HELLO":
+			// makes the client fall back to RESP2
+			c.write("-ERR unknown command 'HELLO'\r\n")
+		case "PING":
+			if c.subscribed > 0 {
+				c.write(array(bulk("pong"), bulk("")))
+			} else {
+				c.write("+PONG\r\n")
+			}
+		case "SUBSCRIBE":
+			for _, channel := range args[1:] {
+				r.mutex.Lock()
+				r.subscribers[channel] = append(r.subscribers[channel], c)
+				r.mutex.Unlock()
+				c.subscribed++
+				c.write(array(bulk("subscribe"), bulk(channel), integer(c.subscribed)))
+			}
+		case "UNSUBSCRIBE":
+			for _, channel := range args[1:] {
+				r.unsubscribe(c, channel)
+				c.subscribed--
+				c.write(array(bulk("unsubscribe"), bulk(channel), integer(c.subscribed)))
+			}
+		case "PUBLISH":
+			if len(args) != 3 {
+				c.write("-ERR wrong number of arguments for 'publish' command\r\n")
+				continue
+			}
+			c.write(integer(r.Publish(args[1], args[2])))
+		case "XADD":
+			r.xadd(c, args)
+		default:
+			c.write("+OK\r\n")
+		}
+	}
+}
+
+// xadd records the fields of the entry, the options (MAXLEN, ~, id) are ignored
+func (r *FakeRedis) xadd(c *redisClient, args []string) {
+	if len(args) < 3 {
+		c.write("-ERR wrong number of arguments for 'xadd' command\r\n")
+		return
+	}
+
+	i := 2
+	for i < len(args) && args[i] != "*" {
+		i++
+	}
+	fields := make(map[string]string)
+	for i++; i+1 < len(args); i += 2 {
+		fields[args[i]] = args[i+1]
+	}
+
+	r.mutex.Lock()
+	r.streams[args[1]] = append(r.streams[args[1]], fields)
+	id := fmt.Sprintf("%d-0", len(r.streams[args[1]]))
+	r.mutex.Unlock()
+
+	c.write(bulk(id))
+}
+
+func (r *FakeRedis) unsubscribe(c *redisClient, channel string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	subscribers := r.subscribers[channel]
+	for i, subscriber := range subscribers {
+		if subscriber == c {
+			r.subscribers[channel] = append(subscribers[:i:i], subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *FakeRedis) unsubscribeAll(c *redisClient) {
+	r.mutex.Lock()
+	channels := make([]string, 0, len(r.subscribers))
+	for channel := range r.subscribers {
+		channels = append(channels, channel)
+	}
+	r.mutex.Unlock()
+
+	for _, channel := range channels {
+		r.unsubscribe(c, channel)
+	}
+}
+
+func (c *redisClient) write(reply string) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, _ = io.WriteString(c.conn, reply)
+}
+
+// readCommand reads a command sent as an array of bulk strings (the only format used by the clients)
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid array length: %s", line)
+	}
+
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length: %s", line)
+		}
+		value := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+		args = append(args, string(value[:length]))
+	}
+	return args, nil
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func bulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func integer(value int) string {
+	return fmt.Sprintf(":%d\r\n", value)
+}
+
+func array(items ...string) string {
+	return fmt.Sprintf("*%d\r\n%s", len(items), strings.Join(items, ""))
+}
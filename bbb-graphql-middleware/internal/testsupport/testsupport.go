@@ -0,0 +1,239 @@
+// Package testsupport has in-process fakes of the services the middleware depends on (Hasura, bbb-web auth hook,
+// akka-apps session variables hook, graphql-actions and Redis), so it can be tested end-to-end
+package testsupport
+
+import (
+	"bbb-graphql-middleware/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// upstreamsEnv is set in the process that runs the tests, after the config was prepared
+const upstreamsEnv = "BBB_GRAPHQL_MIDDLEWARE_TEST_UPSTREAMS"
+
+// overridesEnv has the overrides (JSON) of the test run in its own process by RunTestWithConfig
+const overridesEnv = "BBB_GRAPHQL_MIDDLEWARE_TEST_OVERRIDES"
+
+// RunWithTestConfig prepares a config pointing to the fakes and runs the tests again in a child process using it,
+// as the config is read when the packages are initialised (before TestMain).
+// It returns true with the exit code of the child, or false when it's already the child (that must run the tests)
+func RunWithTestConfig(baseConfigPath string, overrides map[string]interface{}) (bool, int) {
+	if os.Getenv(upstreamsEnv) != "" {
+		return false, 0
+	}
+
+	if testOverrides := os.Getenv(overridesEnv); testOverrides != "" {
+		merged := make(map[string]interface{})
+		for key, value := range overrides {
+			merged[key] = value
+		}
+		if err := json.Unmarshal([]byte(testOverrides), &merged); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid test overrides %s: %v\n", testOverrides, err)
+			return true, 1
+		}
+		overrides = merged
+	}
+
+	configPath, err := writeTestConfig(baseConfigPath, overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to prepare the test config: %v\n", err)
+		return true, 1
+	}
+	defer os.RemoveAll(filepath.Dir(configPath))
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		upstreamsEnv+"=1",
+		config.DefaultConfigPathEnv+"="+configPath,
+		config.OverrideConfigPathEnv+"=")
+
+	if err := cmd.Run(); err != nil {
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			return true, exitError.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "failed to run the tests: %v\n", err)
+		return true, 1
+	}
+	return true, 0
+}
+
+// RunTestWithConfig runs the test again in its own process, with fakes and a config that also has the overrides
+// (e.g. to test a feature disabled). It returns true in that process, where the test must go on,
+// and false in the current one, once it finished (its failure fails the test)
+func RunTestWithConfig(t *testing.T, overrides map[string]interface{}) bool {
+	t.Helper()
+
+	if os.Getenv(overridesEnv) != "" {
+		return true
+	}
+
+	overridesJson, err := json.Marshal(overrides)
+	if err != nil {
+		t.Fatalf("invalid overrides: %v", err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, upstreamsEnv+"=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env, overridesEnv+"="+string(overridesJson))
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s failed with config %s: %v\n%s", t.Name(), overridesJson, err, output)
+	}
+	return false
+}
+
+// writeTestConfig writes the base config with the urls of the fakes (on free ports) and the overrides
+// The overrides are keyed by the dotted path of the config (e.g. server.max_connections)
+func writeTestConfig(baseConfigPath string, overrides map[string]interface{}) (string, error) {
+	data, err := os.ReadFile(baseConfigPath)
+	if err != nil {
+		return "", err
+	}
+	var cfg map[string]interface{}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return "", err
+	}
+
+	addresses := make([]string, 3)
+	for i := range addresses {
+		if addresses[i], err = freeAddress(); err != nil {
+			return "", err
+		}
+	}
+	hasuraAddress, hooksAddress, redisAddress := addresses[0], addresses[1], addresses[2]
+	redisHost, redisPortAsString, _ := net.SplitHostPort(redisAddress)
+	redisPort, _ := strconv.Atoi(redisPortAsString)
+
+	values := map[string]interface{}{
+		"hasura.url":            "ws://" + hasuraAddress + "/v1/graphql",
+		"auth_hook.url":         "http://" + hooksAddress + "/auth",
+		"session_vars_hook.url": "http://" + hooksAddress + "/session-vars",
+		"graphql-actions.url":   "http://" + hooksAddress + "/graphql-actions",
+		"redis.host":            redisHost,
+		"redis.port":            redisPort,
+	}
+	for key, value := range overrides {
+		values[key] = value
+	}
+	for key, value := range values {
+		setConfigValue(cfg, strings.Split(key, "."), value)
+	}
+
+	configData, err := yaml.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	directory, err := os.MkdirTemp("", "bbb-graphql-middleware-test")
+	if err != nil {
+		return "", err
+	}
+	configPath := filepath.Join(directory, "config.yml")
+	return configPath, os.WriteFile(configPath, configData, 0600)
+}
+
+func setConfigValue(cfg map[string]interface{}, path []string, value interface{}) {
+	if len(path) == 1 {
+		cfg[path[0]] = value
+		return
+	}
+	child, ok := cfg[path[0]].(map[string]interface{})
+	if !ok {
+		child = make(map[string]interface{})
+		cfg[path[0]] = child
+	}
+	setConfigValue(child, path[1:], value)
+}
+
+func freeAddress() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	return listener.Addr().String(), nil
+}
+
+// Upstreams are the fakes listening on the addresses of the config
+type Upstreams struct {
+	Hasura *FakeHasura
+	Hooks  *Hooks
+	Redis  *FakeRedis
+
+	hooksServer *http.Server
+}
+
+func StartUpstreams() (*Upstreams, error) {
+	cfg := config.GetConfig()
+	u := &Upstreams{Hooks: NewHooks()}
+
+	hasuraUrl, err := url.Parse(cfg.Hasura.Url)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hasura, err = StartFakeHasura(hasuraUrl.Host); err != nil {
+		return nil, err
+	}
+
+	if u.Redis, err = StartFakeRedis(fmt.Sprintf("%s:%d", cfg.Redis.Host, cfg.Redis.Port)); err != nil {
+		u.Close()
+		return nil, err
+	}
+
+	// the hooks are expected to share the address
+	mux := http.NewServeMux()
+	var hooksAddress string
+	for hookUrl, handler := range map[string]http.Handler{
+		cfg.AuthHook.Url:        u.Hooks.AuthHookHandler(),
+		cfg.SessionVarsHook.Url: u.Hooks.SessionVarsHandler(),
+		cfg.GraphqlActions.Url:  u.Hooks.GraphqlActionsHandler(),
+	} {
+		parsedUrl, err := url.Parse(hookUrl)
+		if err != nil {
+			u.Close()
+			return nil, err
+		}
+		hooksAddress = parsedUrl.Host
+		mux.Handle(parsedUrl.Path, handler)
+	}
+
+	listener, err := net.Listen("tcp", hooksAddress)
+	if err != nil {
+		u.Close()
+		return nil, err
+	}
+	u.hooksServer = &http.Server{Handler: mux}
+	go u.hooksServer.Serve(listener)
+
+	return u, nil
+}
+
+func (u *Upstreams) Close() {
+	if u.Hasura != nil {
+		u.Hasura.Close()
+	}
+	if u.Redis != nil {
+		u.Redis.Close()
+	}
+	if u.hooksServer != nil {
+		_ = u.hooksServer.Close()
+	}
+}
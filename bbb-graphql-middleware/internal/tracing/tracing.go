@@ -0,0 +1,239 @@
+package tracing
+
+import (
+	"bbb-graphql-middleware/config"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer creates the spans of the middleware, it's a no-op until Start is called
+var Tracer = otel.Tracer("bbb-graphql-middleware")
+
+var tracingConfig = config.GetConfig().Tracing
+
+func Enabled() bool {
+	return tracingConfig.Enabled
+}
+
+// Start configures the exporter and the W3C trace context propagation, it returns the function to flush the spans
+func Start() func(context.Context) error {
+	log := log.WithField("_routine", "StartTracing")
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch tracingConfig.Exporter {
+	case "otlp", "":
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(tracingConfig.OtlpEndpoint)}
+		if tracingConfig.OtlpInsecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	case "file":
+		var file *os.File
+		if err = os.MkdirAll(filepath.Dir(tracingConfig.File), 0750); err == nil {
+			file, err = os.OpenFile(tracingConfig.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		}
+		if err == nil {
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		}
+	default:
+		err = fmt.Errorf("unknown exporter %s", tracingConfig.Exporter)
+	}
+	if err != nil {
+		log.Fatalf("failed to start tracing: %v", err)
+	}
+
+	serviceName := tracingConfig.ServiceName
+	if serviceName == "" {
+		serviceName = "bbb-graphql-middleware"
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tracingConfig.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	log.Infof("Tracing enabled (exporter: %s)", tracingConfig.Exporter)
+	return provider.Shutdown
+}
+
+// InjectHeaders adds the trace context (traceparent) to the request sent to graphql-actions or the hooks
+func InjectHeaders(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// StartSpan starts a span in the context of the operation, or a no-op span when the operation is not traced (nil context)
+func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if ctx == nil {
+		return context.Background(), trace.SpanFromContext(context.Background())
+	}
+	return Tracer.Start(ctx, name, opts...)
+}
+
+// EndSpan records the error (when there's one) and ends the span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// operation is the span of a subscribe or mutation, from the moment it's read from the browser until it's answered
+type operation struct {
+	ctx       context.Context
+	span      trace.Span
+	queuedAt  time.Time  // sent to the channel of the writer (Hasura) or graphql-actions
+	roundTrip trace.Span // Hasura round trip, from the subscribe until the first answer
+}
+
+// operationShard holds the operations of part of the browser connections, so the connections don't wait for each other
+type operationShard struct {
+	mutex      sync.Mutex
+	operations map[string]map[string]*operation // by browser connection and operation id
+}
+
+var operationShards = newOperationShards(config.GetConfig().Cache.Shards)
+
+func newOperationShards(numOfShards int) []*operationShard {
+	shards := make([]*operationShard, max(numOfShards, 1))
+	for i := range shards {
+		shards[i] = &operationShard{operations: make(map[string]map[string]*operation)}
+	}
+	return shards
+}
+
+func shardOf(browserConnectionId string) *operationShard {
+	return operationShards[crc32.ChecksumIEEE([]byte(browserConnectionId))%uint32(len(operationShards))]
+}
+
+// StartOperation starts the span of the operation read from the browser, that is queued to be processed
+func StartOperation(browserConnectionId string, id string, operationType string, operationName string) {
+	if !Enabled() {
+		return
+	}
+
+	ctx, span := Tracer.Start(context.Background(), fmt.Sprintf("%s %s", operationType, operationName),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("graphql.operation.type", operationType),
+			attribute.String("graphql.operation.name", operationName),
+			attribute.String("graphql.operation.id", id),
+			attribute.String("bbb.browser_connection_id", browserConnectionId),
+		))
+
+	shard := shardOf(browserConnectionId)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if shard.operations[browserConnectionId] == nil {
+		shard.operations[browserConnectionId] = make(map[string]*operation)
+	}
+	if previous, exists := shard.operations[browserConnectionId][id]; exists {
+		previous.end(nil)
+	}
+	shard.operations[browserConnectionId][id] = &operation{ctx: ctx, span: span, queuedAt: time.Now()}
+}
+
+// DequeueOperation records the time the operation waited in the channel and returns its context
+// (to create the spans of the next stages), or nil when the operation is not traced
+func DequeueOperation(browserConnectionId string, id string) context.Context {
+	if !Enabled() {
+		return nil
+	}
+
+	shard := shardOf(browserConnectionId)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	op, exists := shard.operations[browserConnectionId][id]
+	if !exists || op.queuedAt.IsZero() {
+		return nil
+	}
+
+	_, queueSpan := Tracer.Start(op.ctx, "channel_queue", trace.WithTimestamp(op.queuedAt))
+	queueSpan.End()
+	op.queuedAt = time.Time{}
+	return op.ctx
+}
+
+// StartHasuraRoundTrip starts the span of the subscribe sent to Hasura, it ends when Hasura answers it
+func StartHasuraRoundTrip(browserConnectionId string, hasuraConnectionId string, id string) {
+	if !Enabled() {
+		return
+	}
+
+	shard := shardOf(browserConnectionId)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	op, exists := shard.operations[browserConnectionId][id]
+	if !exists || op.roundTrip != nil {
+		return
+	}
+
+	_, op.roundTrip = Tracer.Start(op.ctx, "hasura_round_trip",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("bbb.hasura_connection_id", hasuraConnectionId)))
+}
+
+// EndOperation ends the spans of the operation (when it was rejected, answered by Hasura or graphql-actions)
+func EndOperation(browserConnectionId string, id string, err error) {
+	if !Enabled() {
+		return
+	}
+
+	shard := shardOf(browserConnectionId)
+	shard.mutex.Lock()
+	op, exists := shard.operations[browserConnectionId][id]
+	if exists {
+		delete(shard.operations[browserConnectionId], id)
+	}
+	shard.mutex.Unlock()
+
+	if exists {
+		op.end(err)
+	}
+}
+
+// EndConnectionOperations ends the spans of the operations not answered before the browser disconnected
+func EndConnectionOperations(browserConnectionId string) {
+	if !Enabled() {
+		return
+	}
+
+	shard := shardOf(browserConnectionId)
+	shard.mutex.Lock()
+	connectionOperations := shard.operations[browserConnectionId]
+	delete(shard.operations, browserConnectionId)
+	shard.mutex.Unlock()
+
+	for _, op := range connectionOperations {
+		op.end(fmt.Errorf("browser disconnected"))
+	}
+}
+
+func (op *operation) end(err error) {
+	if op.roundTrip != nil {
+		op.roundTrip.End()
+	}
+	EndSpan(op.span, err)
+}
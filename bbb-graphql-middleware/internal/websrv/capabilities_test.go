@@ -0,0 +1,162 @@
+package websrv_test
+
+import (
+	"bbb-graphql-middleware/internal/msgencoding"
+	"bbb-graphql-middleware/internal/testsupport"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+type connectionAckPayload struct {
+	ResumeToken  string                     `json:"resumeToken"`
+	Resumed      bool                       `json:"resumed"`
+	Capabilities map[string]json.RawMessage `json:"capabilities"`
+}
+
+// connectWithCapabilities connects declaring the capabilities (none when nil) and returns the connection_ack payload
+func connectWithCapabilities(t *testing.T, sessionToken string, options testsupport.BrowserOptions) connectionAckPayload {
+	t.Helper()
+
+	_, payload := connectWithOptions(t, sessionToken, options)
+	return payload
+}
+
+// connectWithOptions connects using the options and returns the browser and the connection_ack payload
+func connectWithOptions(t *testing.T, sessionToken string, options testsupport.BrowserOptions) (*testsupport.Browser, connectionAckPayload) {
+	t.Helper()
+
+	browser, err := testsupport.ConnectBrowserWithOptions(middlewareUrl, sessionToken, options)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(browser.Close)
+
+	message, err := browser.Expect("connection_ack", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payload connectionAckPayload
+	if len(message.Payload) > 0 {
+		if err := json.Unmarshal(message.Payload, &payload); err != nil {
+			t.Fatalf("invalid connection_ack payload %s: %v", message.Payload, err)
+		}
+	}
+	return browser, payload
+}
+
+func assertCapability(t *testing.T, payload connectionAckPayload, capability string, expected string) {
+	t.Helper()
+
+	if payload.Capabilities == nil {
+		t.Fatalf("no capabilities in the connection_ack")
+	}
+	actual, accepted := payload.Capabilities[capability]
+	if expected == "" {
+		if accepted {
+			t.Errorf("expected %s not accepted, got %s", capability, actual)
+		}
+		return
+	}
+	if !accepted {
+		t.Fatalf("expected %s accepted, got %v", capability, payload.Capabilities)
+	}
+	assertJsonEqual(t, expected, actual)
+}
+
+func TestLegacyClientWithoutCapabilities(t *testing.T) {
+	payload := connectWithCapabilities(t, "capabilitiesMeeting-legacy", testsupport.BrowserOptions{})
+
+	if payload.Capabilities != nil {
+		t.Errorf("capabilities echoed to a client that didn't declare them: %v", payload.Capabilities)
+	}
+	if payload.ResumeToken != "" {
+		t.Errorf("resume token sent although the session resume is disabled")
+	}
+
+	// the updates are still patched through the operation name
+	browser := connectBrowser(t, "capabilitiesMeeting-legacyPatch")
+	if err := browser.Subscribe("1", "Patched_getLegacyUsers", "subscription Patched_getLegacyUsers { user { userId name } }", nil); err != nil {
+		t.Fatal(err)
+	}
+	subscribe := expectHasuraSubscribe(t, "Patched_getLegacyUsers")
+
+	users := make([]map[string]string, 10)
+	for i := range users {
+		users[i] = map[string]string{"userId": fmt.Sprintf("user%d", i), "name": fmt.Sprintf("User number %d", i)}
+	}
+	_ = subscribe.Connection.SendNext("1", map[string]interface{}{"user": users})
+	expectData(t, browser, "1")
+
+	users[1]["name"] = "Renamed user"
+	_ = subscribe.Connection.SendNext("1", map[string]interface{}{"user": users})
+	if data := expectData(t, browser, "1"); data["patch"] == nil {
+		t.Errorf("expected a patch, received %s", data)
+	}
+}
+
+func TestJsonPatchCapability(t *testing.T) {
+	payload := connectWithCapabilities(t, "capabilitiesMeeting-patch", testsupport.BrowserOptions{
+		Capabilities: map[string]interface{}{"patchFormats": []string{"json-patch", "merge-patch"}},
+	})
+	assertCapability(t, payload, "patchFormats", `["json-patch"]`)
+}
+
+func TestJsonPatchCapabilityWhenDisabled(t *testing.T) {
+	if !testsupport.RunTestWithConfig(t, map[string]interface{}{"server.json_patch_disabled": true}) {
+		return
+	}
+
+	payload := connectWithCapabilities(t, "capabilitiesMeeting-patchDisabled", testsupport.BrowserOptions{
+		Capabilities: map[string]interface{}{"patchFormats": []string{"json-patch"}},
+	})
+	assertCapability(t, payload, "patchFormats", "")
+}
+
+func TestResumeCapability(t *testing.T) {
+	if !testsupport.RunTestWithConfig(t, map[string]interface{}{"server.session_resume_grace_seconds": 30}) {
+		return
+	}
+
+	notRequested := connectWithCapabilities(t, "capabilitiesMeeting-noResume", testsupport.BrowserOptions{
+		Capabilities: map[string]interface{}{"patchFormats": []string{"json-patch"}},
+	})
+	assertCapability(t, notRequested, "resume", "")
+	if notRequested.ResumeToken != "" {
+		t.Errorf("resume token sent to a client that didn't request resume")
+	}
+
+	requested := connectWithCapabilities(t, "capabilitiesMeeting-resume", testsupport.BrowserOptions{
+		Capabilities: map[string]interface{}{"resume": true},
+	})
+	assertCapability(t, requested, "resume", "true")
+	if requested.ResumeToken == "" {
+		t.Errorf("no resume token sent to a client that requested resume")
+	}
+
+	// legacy clients keep receiving the token
+	legacy := connectWithCapabilities(t, "capabilitiesMeeting-legacyResume", testsupport.BrowserOptions{})
+	if legacy.ResumeToken == "" {
+		t.Errorf("no resume token sent to a legacy client")
+	}
+}
+
+func TestEncodingCapability(t *testing.T) {
+	if !testsupport.RunTestWithConfig(t, map[string]interface{}{"server.binary_encoding_enabled": true}) {
+		return
+	}
+
+	capabilities := map[string]interface{}{"encodings": []string{"msgpack"}}
+
+	jsonSubprotocol := connectWithCapabilities(t, "capabilitiesMeeting-json", testsupport.BrowserOptions{
+		Capabilities: capabilities,
+	})
+	assertCapability(t, jsonSubprotocol, "encodings", "")
+
+	msgpackSubprotocol := connectWithCapabilities(t, "capabilitiesMeeting-msgpack", testsupport.BrowserOptions{
+		Subprotocol:  msgencoding.SubprotocolMessagePack,
+		Capabilities: capabilities,
+	})
+	assertCapability(t, msgpackSubprotocol, "encodings", `["msgpack"]`)
+}
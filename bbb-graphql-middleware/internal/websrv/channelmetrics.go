@@ -0,0 +1,49 @@
+package websrv
+
+import (
+	"bbb-graphql-middleware/internal/common"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+var channelNames = []string{"from_browser_to_hasura", "from_browser_to_gql_actions", "from_hasura_to_browser"}
+
+// UpdateChannelMetricsRoutine updates the gauges of messages waiting in the channels of the browser connections
+// and of channels frozen (waiting for the Hasura connection)
+func UpdateChannelMetricsRoutine() {
+	for {
+		time.Sleep(5 * time.Second)
+
+		queued := make(map[string]int)
+		maxQueued := make(map[string]int)
+		frozen := make(map[string]int)
+
+		BrowserConnectionsMutex.RLock()
+		for _, browserConnection := range BrowserConnections {
+			channels := []*common.SafeChannelByte{
+				browserConnection.FromBrowserToHasuraChannel,
+				browserConnection.FromBrowserToGqlActionsChannel,
+				browserConnection.FromHasuraToBrowserChannel,
+			}
+			for i, channel := range channels {
+				name := channelNames[i]
+				queuedInChannel := channel.Len()
+				queued[name] += queuedInChannel
+				if queuedInChannel > maxQueued[name] {
+					maxQueued[name] = queuedInChannel
+				}
+				if channel.Frozen() {
+					frozen[name]++
+				}
+			}
+		}
+		BrowserConnectionsMutex.RUnlock()
+
+		for _, name := range channelNames {
+			labels := prometheus.Labels{"channel": name}
+			common.ChannelQueuedMessagesGauge.With(labels).Set(float64(queued[name]))
+			common.ChannelMaxQueuedMessagesGauge.With(labels).Set(float64(maxQueued[name]))
+			common.ChannelFrozenGauge.With(labels).Set(float64(frozen[name]))
+		}
+	}
+}
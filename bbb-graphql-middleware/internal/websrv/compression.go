@@ -0,0 +1,243 @@
+package websrv
+
+import (
+	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/common"
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"nhooyr.io/websocket"
+	"sync/atomic"
+	"time"
+)
+
+var compressionModes = map[string]websocket.CompressionMode{
+	"":                    websocket.CompressionDisabled,
+	"disabled":            websocket.CompressionDisabled,
+	"context_takeover":    websocket.CompressionContextTakeover,
+	"no_context_takeover": websocket.CompressionNoContextTakeover,
+}
+
+var compressionMode = websocket.CompressionDisabled
+var compressionThreshold = config.GetConfig().Server.WebsocketCompressionThreshold
+
+func init() {
+	mode, exists := compressionModes[config.GetConfig().Server.WebsocketCompressionMode]
+	if !exists {
+		log.Warnf("Invalid websocket_compression_mode %s, compression will be disabled", config.GetConfig().Server.WebsocketCompressionMode)
+		return
+	}
+	compressionMode = mode
+}
+
+// applyCompressionOptions configures permessage-deflate for the browser websocket
+// It is only used when the browser also supports it (Safari doesn't)
+func applyCompressionOptions(acceptOptions *websocket.AcceptOptions) {
+	acceptOptions.CompressionMode = compressionMode
+	if compressionThreshold > 0 {
+		acceptOptions.CompressionThreshold = compressionThreshold
+	}
+}
+
+// compressionLabel returns the compression negotiated in the handshake response (used as metric label)
+func compressionLabel(responseHeader http.Header) string {
+	if responseHeader.Get("Sec-WebSocket-Extensions") == "" {
+		return "none"
+	}
+	return "permessage-deflate"
+}
+
+// sharedFrameWriter returns the writer of the frames compressed once and shared between connections
+// It's only possible without context takeover, as each message is compressed independently of the previous ones
+func sharedFrameWriter(countingWriter *wireCountingResponseWriter) common.FrameWriter {
+	if compressionMode != websocket.CompressionNoContextTakeover ||
+		compressionLabel(countingWriter.Header()) == "none" ||
+		countingWriter.conn == nil {
+		return nil
+	}
+	return countingWriter.conn
+}
+
+// wireCountingResponseWriter wraps the http.ResponseWriter to count the bytes that are actually
+// written to the socket after the websocket upgrade (frames already compressed)
+type wireCountingResponseWriter struct {
+	http.ResponseWriter
+	conn *wireCountingConn
+}
+
+func (w *wireCountingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.ResponseWriter does not implement http.Hijacker")
+	}
+
+	netConn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := brw.Writer.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	countingConn := newWireCountingConn(netConn, common.WsSentWireBytesCounter.With(prometheus.Labels{
+		"compression": compressionLabel(w.Header()),
+	}))
+
+	w.conn = countingConn
+
+	return countingConn, bufio.NewReadWriter(brw.Reader, bufio.NewWriterSize(countingConn, brw.Writer.Size())), nil
+}
+
+// wireCountingConn is the browser socket, also used to write the shared frames
+// The websocket library may write a frame in several calls (large payloads go around its buffer) and split a
+// compressed message in several frames, so the headers of its frames are parsed to hold the socket until its
+// message is complete, and a shared frame is only written between two messages of the library
+// No frame is written after the close frame of the library (or a failed write)
+type wireCountingConn struct {
+	net.Conn
+	counter prometheus.Counter
+	turn    chan struct{} // held by who is writing: the library (until its message is complete) or WriteFrame
+
+	// state of the frame being written by the library, only used by its writes (that it serializes)
+	holdingTurn    bool
+	header         []byte
+	payloadPending uint64
+	fragmented     bool // only control frames can be sent until the last frame of the message
+
+	closed atomic.Bool
+}
+
+func newWireCountingConn(netConn net.Conn, counter prometheus.Counter) *wireCountingConn {
+	return &wireCountingConn{
+		Conn:    netConn,
+		counter: counter,
+		turn:    make(chan struct{}, 1),
+	}
+}
+
+func (c *wireCountingConn) Write(b []byte) (int, error) {
+	if !c.holdingTurn {
+		c.turn <- struct{}{}
+		c.holdingTurn = true
+	}
+
+	n, err := c.write(b)
+	if err != nil {
+		c.closed.Store(true)
+	}
+	if messageComplete := c.trackLibraryFrames(b[:n]); messageComplete || err != nil {
+		c.holdingTurn = false
+		<-c.turn
+	}
+	return n, err
+}
+
+// trackLibraryFrames follows the frames written by the library, it returns true when the last one is complete
+// (and is not part of a fragmented message)
+func (c *wireCountingConn) trackLibraryFrames(b []byte) bool {
+	for len(b) > 0 {
+		if c.payloadPending > 0 {
+			consumed := min(c.payloadPending, uint64(len(b)))
+			c.payloadPending -= consumed
+			b = b[consumed:]
+			continue
+		}
+
+		c.header = append(c.header, b[0])
+		b = b[1:]
+		headerLength, complete := websocketHeaderLength(c.header)
+		if !complete || len(c.header) < headerLength {
+			continue
+		}
+
+		switch opcode := c.header[0] & 0x0f; {
+		case opcode == 0x8:
+			c.closed.Store(true)
+		case opcode < 0x8: //data frame (0 is the continuation of a fragmented message)
+			c.fragmented = c.header[0]&0x80 == 0
+		}
+		c.payloadPending = websocketPayloadLength(c.header)
+		c.header = c.header[:0]
+	}
+
+	return c.payloadPending == 0 && len(c.header) == 0 && !c.fragmented
+}
+
+// websocketHeaderLength returns the length of the header, once the bytes that define it are known
+func websocketHeaderLength(header []byte) (int, bool) {
+	if len(header) < 2 {
+		return 0, false
+	}
+
+	length := 2
+	switch header[1] & 0x7f {
+	case 126:
+		length += 2
+	case 127:
+		length += 8
+	}
+	if header[1]&0x80 != 0 {
+		length += 4 //masking key
+	}
+	return length, true
+}
+
+func websocketPayloadLength(header []byte) uint64 {
+	switch header[1] & 0x7f {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(header[2:4]))
+	case 127:
+		return binary.BigEndian.Uint64(header[2:10])
+	default:
+		return uint64(header[1] & 0x7f)
+	}
+}
+
+func (c *wireCountingConn) write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.counter.Add(float64(n))
+	return n, err
+}
+
+func (c *wireCountingConn) Close() error {
+	c.closed.Store(true)
+	return c.Conn.Close()
+}
+
+// WriteFrame writes a complete frame, giving up when the context is cancelled
+func (c *wireCountingConn) WriteFrame(ctx context.Context, frame []byte) error {
+	select {
+	case c.turn <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-c.turn }()
+
+	if c.closed.Load() {
+		return net.ErrClosed
+	}
+
+	cancelled := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.Conn.SetWriteDeadline(time.Now())
+		close(cancelled)
+	})
+
+	_, err := c.write(frame)
+
+	if !stop() {
+		<-cancelled
+		_ = c.Conn.SetWriteDeadline(time.Time{})
+	}
+	if err != nil {
+		//Part of the frame may have been written, so nothing else can be sent
+		c.closed.Store(true)
+	}
+	return err
+}
@@ -0,0 +1,280 @@
+package websrv
+
+import (
+	"bbb-graphql-middleware/internal/common"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"nhooyr.io/websocket"
+)
+
+func testCounter() prometheus.Counter {
+	return prometheus.NewCounter(prometheus.CounterOpts{Name: "test_wire_bytes"})
+}
+
+// serveWebsocket accepts the websocket with compression (no context takeover), as the connection handler does
+func serveWebsocket(t *testing.T, handle func(conn *websocket.Conn, frameWriter *wireCountingConn)) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		countingWriter := &wireCountingResponseWriter{ResponseWriter: w}
+		conn, err := websocket.Accept(countingWriter, r, &websocket.AcceptOptions{
+			CompressionMode: websocket.CompressionNoContextTakeover,
+		})
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		handle(conn, countingWriter.conn)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dial(t *testing.T, server *httptest.Server) *websocket.Conn {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &websocket.DialOptions{
+		CompressionMode: websocket.CompressionNoContextTakeover,
+	})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.SetReadLimit(-1)
+	return conn
+}
+
+// testMessage returns the same message for the same arguments, with padding that compresses poorly
+func testMessage(source string, i int, size int) []byte {
+	prefix := fmt.Sprintf(`{"source":%q,"i":%d,"padding":"`, source, i)
+	random := mathrand.New(mathrand.NewSource(int64(i)))
+	padding := make([]byte, size-len(prefix)-2)
+	for j := range padding {
+		padding[j] = byte('a' + random.Intn(26))
+	}
+	return []byte(prefix + string(padding) + `"}`)
+}
+
+func TestSharedFramesBetweenLibraryFrames(t *testing.T) {
+	const libraryMessages, sharedWriters = 20, 4
+	sharedMessages := make([]int, sharedWriters)
+	writeFrameAfterClose := make(chan error, 1)
+
+	server := serveWebsocket(t, func(conn *websocket.Conn, frameWriter *wireCountingConn) {
+		ctx := context.Background()
+		libraryDone := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1 + sharedWriters)
+		go func() {
+			defer wg.Done()
+			defer close(libraryDone)
+			// larger than the buffer of the library, so each message is written in several frames and calls
+			for i := 0; i < libraryMessages; i++ {
+				if err := conn.Write(ctx, websocket.MessageText, testMessage("library", i, 200_000)); err != nil {
+					return //the browser checks what it received
+				}
+			}
+		}()
+		for writer := 0; writer < sharedWriters; writer++ {
+			go func() {
+				defer wg.Done()
+				for ; ; sharedMessages[writer]++ {
+					select {
+					case <-libraryDone:
+						return
+					default:
+					}
+					i := sharedMessages[writer]
+					frame := common.WebsocketFrame(websocket.MessageText, testMessage(fmt.Sprintf("shared%d", writer), i, 1000+i%100))
+					if err := frameWriter.WriteFrame(ctx, frame); err != nil {
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+		writeFrameAfterClose <- frameWriter.WriteFrame(ctx, common.WebsocketFrame(websocket.MessageText, []byte(`{"type":"ping"}`)))
+	})
+
+	client := dial(t, server)
+	defer client.CloseNow()
+
+	received := map[string]int{}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, message, err := client.Read(ctx)
+		cancel()
+		if err != nil {
+			if websocket.CloseStatus(err) != websocket.StatusNormalClosure {
+				t.Fatalf("the stream of frames is corrupted: %v", err)
+			}
+			break
+		}
+
+		var header struct {
+			Source string `json:"source"`
+			I      int    `json:"i"`
+		}
+		if err := json.Unmarshal(message, &header); err != nil {
+			t.Fatalf("message corrupted: %v", err)
+		}
+		size := 1000 + header.I%100
+		if header.Source == "library" {
+			size = 200_000
+		}
+		if !bytes.Equal(message, testMessage(header.Source, header.I, size)) {
+			t.Fatalf("message %s %d corrupted", header.Source, header.I)
+		}
+		if received[header.Source] != header.I {
+			t.Fatalf("expected the %s message %d, got %d", header.Source, received[header.Source], header.I)
+		}
+		received[header.Source]++
+	}
+
+	if received["library"] != libraryMessages {
+		t.Errorf("expected %d library messages, got %d", libraryMessages, received["library"])
+	}
+	for writer, count := range sharedMessages {
+		if source := fmt.Sprintf("shared%d", writer); received[source] != count {
+			t.Errorf("expected %d %s messages, got %d", count, source, received[source])
+		}
+	}
+	if err := <-writeFrameAfterClose; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected no frame to be written after the close frame, got %v", err)
+	}
+}
+
+func TestWriteFrameIsCancelled(t *testing.T) {
+	results := make(chan error, 2)
+
+	server := serveWebsocket(t, func(conn *websocket.Conn, frameWriter *wireCountingConn) {
+		// the browser doesn't read, so the frame doesn't fit in the socket buffers
+		payload := make([]byte, 32<<20)
+		_, _ = rand.Read(payload) //not compressible
+		frame := common.WebsocketFrame(websocket.MessageBinary, payload)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		results <- frameWriter.WriteFrame(ctx, frame)
+
+		// part of the frame was written, nothing else can be sent
+		results <- frameWriter.WriteFrame(context.Background(), common.WebsocketFrame(websocket.MessageText, []byte(`{}`)))
+	})
+
+	client := dial(t, server)
+	defer client.CloseNow()
+
+	select {
+	case err := <-results:
+		if err == nil {
+			t.Fatalf("expected the write to fail when the context is cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the write was not cancelled")
+	}
+	if err := <-results; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected no frame to be written after a failed write, got %v", err)
+	}
+}
+
+func TestLibraryFramesSplitInSeveralWrites(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	go func() { _, _ = io.Copy(io.Discard, clientSide) }()
+
+	c := newWireCountingConn(serverSide, testCounter())
+	frame := append([]byte{0x82, 126, 0x01, 0x00}, bytes.Repeat([]byte{7}, 256)...) //binary, 256 bytes of payload
+	closeFrame := []byte{0x88, 0x02, 0x03, 0xe8}
+
+	writes := [][]byte{frame[:1], frame[1:3], frame[3:100], frame[100:]}
+	for i, b := range writes {
+		if _, err := c.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		if holding := len(c.turn) == 1; holding != (i < len(writes)-1) {
+			t.Fatalf("after write %d: expected the socket to be held only until the frame is complete", i)
+		}
+	}
+
+	// several frames in one write
+	if _, err := c.Write(append(append([]byte{}, frame...), frame[:10]...)); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.turn) != 1 {
+		t.Fatalf("expected the socket to be held by the incomplete frame")
+	}
+	if _, err := c.Write(append(append([]byte{}, frame[10:]...), closeFrame...)); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.turn) != 0 {
+		t.Fatalf("expected the socket to be released")
+	}
+
+	if err := c.WriteFrame(context.Background(), frame); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected no frame to be written after the close frame, got %v", err)
+	}
+}
+
+func TestSharedFrameWaitsForTheFragmentedMessage(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	var written bytes.Buffer
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		_, _ = io.Copy(&written, clientSide)
+	}()
+
+	c := newWireCountingConn(serverSide, testCounter())
+	firstFragment := []byte{0x01, 0x03, 'a', 'b', 'c'} //text, not final
+	ping := []byte{0x89, 0x00}                         //control frames can be sent between the fragments
+	lastFragment := []byte{0x80, 0x03, 'd', 'e', 'f'}  //continuation, final
+	sharedFrame := []byte{0x81, 0x02, '{', '}'}
+
+	if _, err := c.Write(firstFragment); err != nil {
+		t.Fatal(err)
+	}
+
+	sharedWritten := make(chan error, 1)
+	go func() {
+		sharedWritten <- c.WriteFrame(context.Background(), sharedFrame)
+	}()
+
+	if _, err := c.Write(ping); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-sharedWritten:
+		t.Fatalf("shared frame written in the middle of the fragmented message (%v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := c.Write(lastFragment); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-sharedWritten; err != nil {
+		t.Fatal(err)
+	}
+
+	_ = serverSide.Close()
+	<-readDone
+	expected := bytes.Join([][]byte{firstFragment, ping, lastFragment, sharedFrame}, nil)
+	if !bytes.Equal(written.Bytes(), expected) {
+		t.Errorf("expected % x, got % x", expected, written.Bytes())
+	}
+}
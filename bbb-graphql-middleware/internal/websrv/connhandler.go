@@ -4,9 +4,12 @@ import (
 	"bbb-graphql-middleware/config"
 	"bbb-graphql-middleware/internal/akka_apps"
 	"bbb-graphql-middleware/internal/bbb_web"
+	"bbb-graphql-middleware/internal/capture"
 	"bbb-graphql-middleware/internal/common"
 	"bbb-graphql-middleware/internal/gql_actions"
 	"bbb-graphql-middleware/internal/hasura"
+	"bbb-graphql-middleware/internal/msgencoding"
+	"bbb-graphql-middleware/internal/tracing"
 	"bbb-graphql-middleware/internal/websrv/reader"
 	"bbb-graphql-middleware/internal/websrv/writer"
 	"bytes"
@@ -15,6 +18,8 @@ import (
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/time/rate"
 	"net/http"
 	"nhooyr.io/websocket"
@@ -59,16 +64,23 @@ func ConnectionHandler(w http.ResponseWriter, r *http.Request) {
 	browserConnectionContext, browserConnectionContextCancel := context.WithCancel(r.Context())
 	defer browserConnectionContextCancel()
 
-	// Add sub-protocol
+	// Add sub-protocol (binary ones first, so they are preferred when the client offers them)
 	var acceptOptions websocket.AcceptOptions
-	acceptOptions.Subprotocols = append(acceptOptions.Subprotocols, "graphql-transport-ws")
+	if cfg.Server.BinaryEncodingEnabled {
+		acceptOptions.Subprotocols = append(acceptOptions.Subprotocols, msgencoding.SubprotocolMessagePack, msgencoding.SubprotocolCBOR)
+	}
+	acceptOptions.Subprotocols = append(acceptOptions.Subprotocols, msgencoding.SubprotocolJSON)
 
 	//Add Authorized Cross Origin Url
 	if config.GetConfig().Server.AuthorizedCrossOrigin != "" {
 		acceptOptions.OriginPatterns = append(acceptOptions.OriginPatterns, config.GetConfig().Server.AuthorizedCrossOrigin)
 	}
 
-	browserWsConn, err := websocket.Accept(w, r, &acceptOptions)
+	//Add permessage-deflate compression
+	applyCompressionOptions(&acceptOptions)
+
+	countingWriter := &wireCountingResponseWriter{ResponseWriter: w}
+	browserWsConn, err := websocket.Accept(countingWriter, r, &acceptOptions)
 	if err != nil {
 		connectionLogger.Errorf("error: %v", err)
 		http.Error(w, "Closing browser connection, reason: request Origin is not authorized", http.StatusForbidden)
@@ -76,9 +88,14 @@ func ConnectionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	browserWsConn.SetReadLimit(9999999) //10MB
 
+	wireEncoding := msgencoding.FromSubprotocol(browserWsConn.Subprotocol())
+	if wireEncoding != msgencoding.JSON {
+		connectionLogger = connectionLogger.WithField("wireEncoding", wireEncoding)
+	}
+
 	if common.HasReachedMaxGlobalConnections() {
 		common.WsConnectionRejectedCounter.With(prometheus.Labels{"reason": "limit of server connections exceeded"}).Inc()
-		disconnectWithError(
+		common.DisconnectWithError(
 			browserWsConn,
 			browserConnectionContext,
 			browserConnectionContextCancel,
@@ -89,21 +106,37 @@ func ConnectionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if common.IsDraining() {
+		common.WsConnectionRejectedCounter.With(prometheus.Labels{"reason": "server draining"}).Inc()
+		common.DisconnectWithError(
+			browserWsConn,
+			browserConnectionContext,
+			browserConnectionContextCancel,
+			websocket.StatusTryAgainLater,
+			"server_draining",
+			"server is draining, try again later",
+			connectionLogger)
+		return
+	}
+
 	defer browserWsConn.Close(websocket.StatusInternalError, "the sky is falling")
 
 	var thisConnection = common.BrowserConnection{
 		Id:                                 browserConnectionId,
 		Websocket:                          browserWsConn,
+		WebsocketCompression:               compressionLabel(countingWriter.Header()),
+		FrameWriter:                        sharedFrameWriter(countingWriter),
+		WireEncoding:                       wireEncoding,
 		BrowserRequestCookies:              r.Cookies(),
 		ActiveSubscriptions:                make(map[string]common.GraphQlSubscription, 1),
 		Context:                            browserConnectionContext,
 		ContextCancelFunc:                  browserConnectionContextCancel,
-		ConnAckSentToBrowser:               false,
 		FromBrowserToHasuraChannel:         common.NewSafeChannelByte(bufferSize),
 		FromBrowserToHasuraRateLimiter:     rate.NewLimiter(rate.Every(time.Minute/time.Duration(cfg.Server.MaxConnectionQueriesPerMinute)), cfg.Server.MaxConnectionQueriesPerMinute),
 		FromBrowserToGqlActionsChannel:     common.NewSafeChannelByte(bufferSize),
 		FromBrowserToGqlActionsRateLimiter: rate.NewLimiter(rate.Every(time.Minute/time.Duration(cfg.Server.MaxConnectionMutationsPerMinute)), cfg.Server.MaxConnectionMutationsPerMinute),
-		FromHasuraToBrowserChannel:         common.NewSafeChannelByte(bufferSize),
+		FromHasuraToBrowserChannel:         common.NewTimedSafeChannelByte(bufferSize),
+		HasuraBackoff:                      common.NewUpstreamBackoff("hasura", 0),
 		LastBrowserMessageTime:             time.Now(),
 		Logger:                             connectionLogger,
 	}
@@ -118,6 +151,8 @@ func ConnectionHandler(w http.ResponseWriter, r *http.Request) {
 		if bcExists {
 			sessionTokenRemoved := BrowserConnections[browserConnectionId].SessionToken
 			delete(BrowserConnections, browserConnectionId)
+			parkBrowserSession(&thisConnection)
+			thisConnection.Capture.Load().Close()
 
 			if sessionTokenRemoved != "" {
 				go SendUserGraphqlConnectionClosedSysMsg(sessionTokenRemoved, browserConnectionId)
@@ -125,6 +160,8 @@ func ConnectionHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		BrowserConnectionsMutex.Unlock()
 
+		tracing.EndConnectionOperations(browserConnectionId)
+
 		thisConnection.Logger.Infof("browser connection removed")
 	}()
 
@@ -149,15 +186,19 @@ func ConnectionHandler(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	//Check authorization and obtain user session variables from bbb-web
-	if errorOnInitConnection, errorMessageId := connectionInitHandler(&thisConnection); errorOnInitConnection != nil {
+	initCtx, initSpan := tracing.Tracer.Start(browserConnectionContext, "connection_init",
+		trace.WithAttributes(attribute.String("bbb.browser_connection_id", browserConnectionId)))
+	errorOnInitConnection, errorMessageId := connectionInitHandler(initCtx, &thisConnection)
+	tracing.EndSpan(initSpan, errorOnInitConnection)
+	if errorOnInitConnection != nil {
 		common.WsConnectionRejectedCounter.With(prometheus.Labels{"reason": errorOnInitConnection.Error()}).Inc()
-		disconnectWithError(
+		common.DisconnectWithError(
 			browserWsConn,
 			browserConnectionContext,
 			browserConnectionContextCancel,
 			//If the server wishes to reject the connection it is recommended to close the socket with `4403: Forbidden`.
 			//https://github.com/enisdenjo/graphql-ws/blob/63881c3372a3564bf42040e3f572dd74e41b2e49/PROTOCOL.md?plain=1#L36
-			websocket.StatusCode(4403),
+			initErrorCloseCode(errorMessageId),
 			errorMessageId,
 			errorOnInitConnection.Error(),
 			connectionLogger)
@@ -171,6 +212,7 @@ func ConnectionHandler(w http.ResponseWriter, r *http.Request) {
 	// Ensure a hasura client is running while the browser is connected
 	go func() {
 		thisConnection.Logger.Debugf("starting hasura client")
+		failedDials := 0
 
 	BrowserConnectedLoop:
 		for {
@@ -185,9 +227,21 @@ func ConnectionHandler(w http.ResponseWriter, r *http.Request) {
 					BrowserConnectionsMutex.RUnlock()
 					if thisBrowserConnection != nil {
 						thisConnection.Logger.Debugf("created hasura client")
-						hasura.HasuraClient(thisBrowserConnection)
+						if err := hasura.HasuraClient(thisBrowserConnection); err != nil {
+							thisConnection.Logger.Debugf("hasura client failed: %v", err)
+							failedDials++
+						} else {
+							failedDials = 0
+						}
+						//Only the browsers that already received the connection_ack are informed
+						if thisBrowserConnection.ConnAckSentToBrowser.Load() && browserConnectionContext.Err() == nil {
+							common.NotifyUpstreamLost(thisBrowserConnection, "hasura")
+							common.NotifyUpstreamDialFailed(thisBrowserConnection, "hasura", failedDials)
+						}
+					}
+					if !thisConnection.HasuraBackoff.Wait(browserConnectionContext) {
+						break BrowserConnectedLoop
 					}
-					time.Sleep(100 * time.Millisecond)
 				}
 			}
 		}
@@ -196,6 +250,7 @@ func ConnectionHandler(w http.ResponseWriter, r *http.Request) {
 	// Ensure a gql-actions client is running while the browser is connected
 	go func() {
 		thisConnection.Logger.Debugf("starting gql-actions client")
+		backoff := common.NewUpstreamBackoff("graphql-actions", config.GetConfig().UpstreamBackoff.GraphqlActionsInitialDelayMs)
 
 	BrowserConnectedLoop:
 		for {
@@ -215,9 +270,13 @@ func ConnectionHandler(w http.ResponseWriter, r *http.Request) {
 						thisBrowserConnection.GraphqlActionsContext, thisBrowserConnection.GraphqlActionsContextCancel = context.WithCancel(browserConnectionContext)
 						thisBrowserConnection.Unlock()
 
-						gql_actions.GraphqlActionsClient(thisBrowserConnection)
+						if err := gql_actions.GraphqlActionsClient(thisBrowserConnection); err == nil {
+							backoff.Success()
+						}
+					}
+					if !backoff.Wait(browserConnectionContext) {
+						break BrowserConnectedLoop
 					}
-					time.Sleep(1000 * time.Millisecond)
 				}
 			}
 		}
@@ -226,6 +285,9 @@ func ConnectionHandler(w http.ResponseWriter, r *http.Request) {
 	// Reads from fromHasuraToBrowserChannel, writes to browser connection
 	go writer.BrowserConnectionWriter(&thisConnection, &wgAll)
 
+	// Sends pings to the browser, the pongs keep the connection alive
+	go browserKeepaliveRoutine(&thisConnection)
+
 	// Wait until all routines are finished
 	wgAll.Wait()
 }
@@ -266,7 +328,7 @@ func invalidateHasuraConnectionForSessionToken(bc *common.BrowserConnection, ses
 	bc.FromBrowserToHasuraChannel.FreezeChannel()
 
 	//Update variables for Mutations (gql-actions requests)
-	go refreshUserSessionVariables(bc)
+	go refreshUserSessionVariables(bc.Context, bc)
 
 	// Cancel the Hasura connection context to clean up resources.
 	if bc.HasuraConnection != nil && bc.HasuraConnection.ContextCancelFunc != nil {
@@ -288,6 +350,8 @@ func InvalidateSessionTokenBrowserConnections(sessionTokenToInvalidate string, r
 	}
 	BrowserConnectionsMutex.RUnlock()
 
+	discardParkedSessions(sessionTokenToInvalidate)
+
 	var wg sync.WaitGroup
 	for _, browserConnection := range connectionsToProcess {
 		wg.Add(1)
@@ -300,6 +364,11 @@ func InvalidateSessionTokenBrowserConnections(sessionTokenToInvalidate string, r
 }
 
 func invalidateBrowserConnectionForSessionToken(bc *common.BrowserConnection, sessionToken string, reasonMsgId string, reason string) {
+	// A forced disconnection can't be resumed
+	bc.Lock()
+	bc.ResumeToken = ""
+	bc.Unlock()
+
 	bc.RLock()
 	defer bc.RUnlock()
 
@@ -309,7 +378,7 @@ func invalidateBrowserConnectionForSessionToken(bc *common.BrowserConnection, se
 	bc.Logger.Debug("freezing channel fromBrowserToHasuraChannel")
 	bc.FromBrowserToHasuraChannel.FreezeChannel()
 
-	disconnectWithError(
+	common.DisconnectWithError(
 		bc.Websocket,
 		bc.Context,
 		bc.ContextCancelFunc,
@@ -322,9 +391,9 @@ func invalidateBrowserConnectionForSessionToken(bc *common.BrowserConnection, se
 	go SendUserGraphqlDisconnectionForcedEvtMsg(sessionToken)
 }
 
-func refreshUserSessionVariables(browserConnection *common.BrowserConnection) (error, string) {
+func refreshUserSessionVariables(ctx context.Context, browserConnection *common.BrowserConnection) (error, string) {
 	// Check authorization
-	sessionVariables, err, errorId := akka_apps.AkkaAppsGetSessionVariablesFrom(browserConnection.Id, browserConnection.SessionToken, browserConnection.ClientSessionUUID)
+	sessionVariables, err, errorId := akka_apps.AkkaAppsGetSessionVariablesFrom(ctx, browserConnection.Id, browserConnection.SessionToken, browserConnection.ClientSessionUUID)
 	if err != nil {
 		browserConnection.Logger.Error(err)
 		return fmt.Errorf("error on checking sessionToken authorization: %s", err.Error()), errorId
@@ -351,14 +420,40 @@ func refreshUserSessionVariables(browserConnection *common.BrowserConnection) (e
 	return nil, ""
 }
 
-func connectionInitHandler(browserConnection *common.BrowserConnection) (error, string) {
+// initErrorCloseCode returns the close code of the connection_init errors breaking the protocol (4403 for the others)
+func initErrorCloseCode(errorMessageId string) websocket.StatusCode {
+	switch errorMessageId {
+	case "init_timeout":
+		return websocket.StatusCode(4408)
+	case "invalid_init_payload":
+		return websocket.StatusCode(4400)
+	default:
+		return websocket.StatusCode(4403)
+	}
+}
+
+func connectionInitHandler(ctx context.Context, browserConnection *common.BrowserConnection) (error, string) {
+	initTimeout := time.Duration(config.GetConfig().Server.ConnectionInitWaitTimeoutSeconds) * time.Second
+	if initTimeout <= 0 {
+		initTimeout = 10 * time.Second
+	}
+	initTimer := time.NewTimer(initTimeout)
+	defer initTimer.Stop()
+
 	// Intercept the fromBrowserMessage channel to get the sessionToken
 	for {
-		fromBrowserMessage, ok := browserConnection.FromBrowserToHasuraChannel.Receive()
-		if !ok {
-			//Received all messages. Channel is closed
-			return fmt.Errorf("error on receiving init connection"), "param_missing"
+		var fromBrowserMessage []byte
+		select {
+		case <-initTimer.C:
+			return fmt.Errorf("connection initialisation timeout"), "init_timeout"
+		case message, ok := <-browserConnection.FromBrowserToHasuraChannel.ReceiveChannel():
+			if !ok {
+				//Received all messages. Channel is closed
+				return fmt.Errorf("error on receiving init connection"), "param_missing"
+			}
+			fromBrowserMessage = message
 		}
+
 		if bytes.Contains(fromBrowserMessage, []byte("\"connection_init\"")) {
 			var fromBrowserMessageAsMap map[string]interface{}
 			if err := json.Unmarshal(fromBrowserMessage, &fromBrowserMessageAsMap); err != nil {
@@ -366,8 +461,11 @@ func connectionInitHandler(browserConnection *common.BrowserConnection) (error,
 				continue
 			}
 
-			var payloadAsMap = fromBrowserMessageAsMap["payload"].(map[string]interface{})
-			var headersAsMap = payloadAsMap["headers"].(map[string]interface{})
+			payloadAsMap, isPayloadValid := fromBrowserMessageAsMap["payload"].(map[string]interface{})
+			headersAsMap, isHeadersValid := payloadAsMap["headers"].(map[string]interface{})
+			if !isPayloadValid || !isHeadersValid {
+				return fmt.Errorf("invalid connection_init payload, headers are expected"), "invalid_init_payload"
+			}
 			var sessionToken, existsSessionToken = headersAsMap["X-Session-Token"].(string)
 			if !existsSessionToken {
 				return fmt.Errorf("X-Session-Token header missing on init connection"), "param_missing"
@@ -400,7 +498,7 @@ func connectionInitHandler(browserConnection *common.BrowserConnection) (error,
 			// Check authorization
 			var numOfAttempts = 0
 			for {
-				meetingId, userId, errCheckAuthorization = bbb_web.BBBWebCheckAuthorization(browserConnection.Id, sessionToken, clientSessionUUID, browserConnection.BrowserRequestCookies)
+				meetingId, userId, errCheckAuthorization = bbb_web.BBBWebCheckAuthorization(ctx, browserConnection.Id, sessionToken, clientSessionUUID, browserConnection.BrowserRequestCookies)
 				if errCheckAuthorization != nil {
 					browserConnection.Logger.Error(errCheckAuthorization)
 				}
@@ -432,15 +530,51 @@ func connectionInitHandler(browserConnection *common.BrowserConnection) (error,
 			browserConnection.Lock()
 			browserConnection.SessionToken = sessionToken
 			browserConnection.ClientSessionUUID = clientSessionUUID
+			browserConnection.ClientType = clientType
+			browserConnection.ClientIsMobile = strings.ToLower(clientIsMobile) == "true"
 			browserConnection.MeetingId = meetingId
 			browserConnection.UserId = userId
 			browserConnection.ConnectionInitMessage = fromBrowserMessage
 			browserConnection.Unlock()
 
-			if err, errorId := refreshUserSessionVariables(browserConnection); err != nil {
+			if capture.Enabled() {
+				redaction := common.Redaction{RedactFields: config.GetConfig().Capture.RedactFields}
+				redact := func(message []byte) json.RawMessage {
+					return redaction.RedactMessage(message, sessionToken)
+				}
+				if recorder := capture.Start(meetingId, sessionToken, browserConnection.Id, redact, browserConnection.Logger); recorder != nil {
+					//connection_init was received before knowing the meeting, so it's recorded now
+					recorder.Record(capture.BrowserToMiddleware, "", fromBrowserMessage)
+					browserConnection.Capture.Store(recorder)
+				}
+			}
+
+			if err, errorId := refreshUserSessionVariables(ctx, browserConnection); err != nil {
 				return err, errorId
 			}
 
+			//Clients declaring capabilities get only the features they asked for (e.g. no resume token)
+			resumeEnabled := SessionResumeEnabled()
+			if requestedCapabilities, declared := common.ParseClientCapabilities(payloadAsMap); declared {
+				acceptedCapabilities := common.AcceptClientCapabilities(browserConnection, requestedCapabilities, resumeEnabled)
+				resumeEnabled = acceptedCapabilities.Resume
+				browserConnection.Logger.Debugf("client capabilities requested %+v, accepted %+v", requestedCapabilities, acceptedCapabilities)
+
+				browserConnection.Lock()
+				browserConnection.Capabilities = &acceptedCapabilities
+				browserConnection.Unlock()
+			}
+
+			if resumeEnabled {
+				if resumeToken, existsResumeToken := headersAsMap["X-Resume-Token"].(string); existsResumeToken && resumeToken != "" {
+					resumeBrowserSession(browserConnection, resumeToken)
+				}
+
+				browserConnection.Lock()
+				browserConnection.ResumeToken = NewResumeToken()
+				browserConnection.Unlock()
+			}
+
 			go SendUserGraphqlConnectionEstablishedSysMsg(
 				sessionToken,
 				clientSessionUUID,
@@ -456,47 +590,10 @@ func connectionInitHandler(browserConnection *common.BrowserConnection) (error,
 	return nil, ""
 }
 
-func disconnectWithError(
-	browserConnectionWs *websocket.Conn,
-	browserConnectionContext context.Context,
-	browserConnectionContextCancel context.CancelFunc,
-	wsCloseStatusCode websocket.StatusCode,
-	reasonMessageId string,
-	reasonMessage string,
-	logger *logrus.Entry) {
-
-	//Chromium-based browsers can't read websocket close code/reason, so it will send this message before closing conn
-	browserResponseData := map[string]interface{}{
-		"id":   "-1", //The client recognizes this message ID as a signal to terminate the session
-		"type": "error",
-		"payload": []interface{}{
-			map[string]interface{}{
-				"messageId": reasonMessageId,
-				"message":   reasonMessage,
-			},
-		},
-	}
-	jsonData, _ := json.Marshal(browserResponseData)
-
-	logger.Tracef("sending to browser: %s", string(jsonData))
-	logger.Infof("deliberately disconnecting browser with error, reason: %s (%s)", reasonMessage, reasonMessageId)
-
-	err := browserConnectionWs.Write(browserConnectionContext, websocket.MessageText, jsonData)
-	if err != nil {
-		logger.Debugf("Browser is disconnected, skipping writing of ws message: %v", err)
-	}
-
-	errCloseWs := browserConnectionWs.Close(wsCloseStatusCode, reasonMessage)
-	if errCloseWs != nil {
-		logger.Debugf("Error on close websocket: %v", errCloseWs)
-	}
-
-	browserConnectionContextCancel()
-
-	return
-}
-
 var websocketIdleTimeoutSeconds = config.GetConfig().Server.WebsocketIdleTimeoutSeconds
+var websocketPingIntervalSeconds = config.GetConfig().Server.WebsocketPingIntervalSeconds
+var websocketPongTimeoutSeconds = config.GetConfig().Server.WebsocketPongTimeoutSeconds
+var websocketMaxPingRtt = time.Duration(config.GetConfig().Server.WebsocketMaxPingRttMs) * time.Millisecond
 
 func InvalidateIdleBrowserConnectionsRoutine() {
 	for {
@@ -506,8 +603,33 @@ func InvalidateIdleBrowserConnectionsRoutine() {
 		for _, browserConnection := range BrowserConnections {
 			browserConnection.RLock()
 			browserIdleSince := time.Since(browserConnection.LastBrowserMessageTime)
+			var pongWaitingSince time.Duration
+			if !browserConnection.PingSentAt.IsZero() {
+				pongWaitingSince = time.Since(browserConnection.PingSentAt)
+			}
+			pingRtt := browserConnection.PingRtt
 			browserConnection.RUnlock()
 
+			//With pings, quiet connections are kept while they answer them
+			if websocketPingIntervalSeconds > 0 {
+				if pongWaitingSince > time.Duration(websocketPongTimeoutSeconds)*time.Second {
+					browserConnection.Logger.Infof("Closing browser connection, reason: pong not received in %v", pongWaitingSince)
+					common.WsPongTimeoutCounter.Inc()
+					errCloseWs := browserConnection.Websocket.Close(websocket.StatusNormalClosure, "pong timeout")
+					if errCloseWs != nil {
+						browserConnection.Logger.Debugf("Error on close websocket: %v", errCloseWs)
+					}
+				} else if websocketMaxPingRtt > 0 && pingRtt > websocketMaxPingRtt {
+					browserConnection.Logger.Infof("Closing browser connection, reason: ping round trip time %v", pingRtt)
+					common.WsPingRttExceededCounter.Inc()
+					errCloseWs := browserConnection.Websocket.Close(websocket.StatusNormalClosure, "ping round trip time exceeded")
+					if errCloseWs != nil {
+						browserConnection.Logger.Debugf("Error on close websocket: %v", errCloseWs)
+					}
+				}
+				continue
+			}
+
 			if browserIdleSince > time.Duration(websocketIdleTimeoutSeconds)*time.Second {
 				browserConnection.Logger.Info("Closing browser connection, reason: idle timeout")
 				errCloseWs := browserConnection.Websocket.Close(websocket.StatusNormalClosure, "idle timeout")
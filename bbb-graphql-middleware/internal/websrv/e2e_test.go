@@ -0,0 +1,607 @@
+package websrv_test
+
+import (
+	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/capture"
+	"bbb-graphql-middleware/internal/common"
+	"bbb-graphql-middleware/internal/testsupport"
+	"bbb-graphql-middleware/internal/websrv"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	evanphxjsonpatch "github.com/evanphx/json-patch"
+	log "github.com/sirupsen/logrus"
+	"nhooyr.io/websocket"
+)
+
+const timeout = 5 * time.Second
+
+var upstreams *testsupport.Upstreams
+var middlewareUrl string
+
+func TestMain(m *testing.M) {
+	if reexecuted, exitCode := testsupport.RunWithTestConfig("../../config/config.yml", map[string]interface{}{
+		"log_level": "warn",
+		"server.connection_init_wait_timeout_seconds": 1,
+		"server.websocket_compression_mode":           "no_context_takeover",
+	}); reexecuted {
+		os.Exit(exitCode)
+	}
+
+	log.SetLevel(log.WarnLevel)
+
+	var err error
+	if upstreams, err = testsupport.StartUpstreams(); err != nil {
+		log.Fatalf("failed to start the fake upstreams: %v", err)
+	}
+
+	go websrv.StartRedisListener()
+	if err := upstreams.Redis.WaitSubscriber("from-akka-apps-redis-channel", timeout); err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", websrv.ConnectionHandler)
+	server := httptest.NewServer(mux)
+	middlewareUrl = "ws" + strings.TrimPrefix(server.URL, "http") + "/graphql"
+
+	exitCode := m.Run()
+
+	server.Close()
+	upstreams.Close()
+	os.Exit(exitCode)
+}
+
+func connectBrowser(t *testing.T, sessionToken string) *testsupport.Browser {
+	t.Helper()
+
+	browser, err := testsupport.ConnectBrowser(middlewareUrl, sessionToken)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(browser.Close)
+
+	if _, err := browser.Expect("connection_ack", timeout); err != nil {
+		t.Fatal(err)
+	}
+	return browser
+}
+
+// expectHasuraSubscribe waits for the subscribe of the operation (the ones of other tests are ignored)
+func expectHasuraSubscribe(t *testing.T, operationName string) testsupport.HasuraMessage {
+	t.Helper()
+
+	for {
+		message, err := upstreams.Hasura.Expect("subscribe", timeout)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", operationName, err)
+		}
+		if message.Payload.OperationName == operationName {
+			return message
+		}
+	}
+}
+
+func expectData(t *testing.T, browser *testsupport.Browser, id string) map[string]json.RawMessage {
+	t.Helper()
+
+	message, err := browser.Expect("next", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Id != id {
+		t.Fatalf("expected data of %s, received %s", id, message.Raw)
+	}
+
+	var payload struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		t.Fatalf("invalid payload %s: %v", message.Payload, err)
+	}
+	return payload.Data
+}
+
+func forceReconnection(t *testing.T, sessionToken string) {
+	t.Helper()
+	publishToMiddleware(t, "ForceUserGraphqlReconnectionSysMsg", map[string]interface{}{
+		"sessionToken": sessionToken,
+		"reason":       "test",
+	})
+}
+
+func publishToMiddleware(t *testing.T, name string, body map[string]interface{}) {
+	t.Helper()
+
+	message, _ := json.Marshal(map[string]interface{}{
+		"envelope": map[string]interface{}{"name": name},
+		"core": map[string]interface{}{
+			"header": map[string]interface{}{"name": name},
+			"body":   body,
+		},
+	})
+	if upstreams.Redis.Publish("from-akka-apps-redis-channel", string(message)) == 0 {
+		t.Fatalf("the middleware is not subscribed to redis")
+	}
+}
+
+func assertJsonEqual(t *testing.T, expected string, actual []byte) {
+	t.Helper()
+
+	var expectedValue, actualValue interface{}
+	_ = json.Unmarshal([]byte(expected), &expectedValue)
+	if err := json.Unmarshal(actual, &actualValue); err != nil {
+		t.Fatalf("invalid json %s: %v", actual, err)
+	}
+	expectedJson, _ := json.Marshal(expectedValue)
+	actualJson, _ := json.Marshal(actualValue)
+	if string(expectedJson) != string(actualJson) {
+		t.Fatalf("expected %s, received %s", expectedJson, actualJson)
+	}
+}
+
+func TestAuthFailures(t *testing.T) {
+	tests := []struct {
+		name              string
+		sessionToken      string
+		refuse            func(sessionToken string)
+		expectedMessageId string
+	}{
+		{
+			name:              "auth hook refuses the session token",
+			sessionToken:      "authMeeting-unauthorizedUser",
+			refuse:            upstreams.Hooks.Unauthorize,
+			expectedMessageId: "check_authorization_error",
+		},
+		{
+			name:         "session variables hook refuses the session token",
+			sessionToken: "authMeeting-ejectedUser",
+			refuse: func(sessionToken string) {
+				upstreams.Hooks.Deny(sessionToken, "user_ejected")
+			},
+			expectedMessageId: "user_ejected",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.refuse(test.sessionToken)
+			defer upstreams.Hooks.Allow(test.sessionToken)
+
+			browser, err := testsupport.ConnectBrowser(middlewareUrl, test.sessionToken)
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer browser.Close()
+
+			message, err := browser.Expect("error", timeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var errs []struct {
+				MessageId string `json:"messageId"`
+			}
+			_ = json.Unmarshal(message.Payload, &errs)
+			if message.Id != "-1" || len(errs) != 1 || errs[0].MessageId != test.expectedMessageId {
+				t.Fatalf("expected error %s, received %s", test.expectedMessageId, message.Raw)
+			}
+
+			status, err := browser.ExpectClose(timeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != websocket.StatusCode(4403) {
+				t.Fatalf("expected close status 4403, received %d", status)
+			}
+		})
+	}
+}
+
+func TestReconnectionRetransmitsSubscriptions(t *testing.T) {
+	sessionToken := "reconnectionMeeting-user1"
+	browser := connectBrowser(t, sessionToken)
+
+	if err := browser.Subscribe("1", "getUsers", "subscription getUsers { user { userId name } }", nil); err != nil {
+		t.Fatal(err)
+	}
+	subscribe := expectHasuraSubscribe(t, "getUsers")
+	firstConnection := subscribe.Connection
+	_ = firstConnection.SendNext("1", map[string]interface{}{"user": []map[string]string{{"userId": "u1", "name": "Ann"}}})
+	assertJsonEqual(t, `[{"userId":"u1","name":"Ann"}]`, expectData(t, browser, "1")["user"])
+
+	forceReconnection(t, sessionToken)
+
+	subscribe = expectHasuraSubscribe(t, "getUsers")
+	if subscribe.Connection == firstConnection {
+		t.Fatalf("subscription was not retransmitted in a new Hasura connection")
+	}
+	if subscribe.Id != "1" {
+		t.Fatalf("subscription retransmitted with id %s", subscribe.Id)
+	}
+
+	// the data already received by the browser is not sent again
+	_ = subscribe.Connection.SendNext("1", map[string]interface{}{"user": []map[string]string{{"userId": "u1", "name": "Ann"}}})
+	_ = subscribe.Connection.SendNext("1", map[string]interface{}{"user": []map[string]string{{"userId": "u1", "name": "Bob"}}})
+	assertJsonEqual(t, `[{"userId":"u1","name":"Bob"}]`, expectData(t, browser, "1")["user"])
+}
+
+func TestJsonPatchDelivery(t *testing.T) {
+	browser := connectBrowser(t, "patchMeeting-user1")
+
+	if err := browser.Subscribe("1", "Patched_getUsers", "subscription Patched_getUsers { user { userId name color } }", nil); err != nil {
+		t.Fatal(err)
+	}
+	subscribe := expectHasuraSubscribe(t, "Patched_getUsers")
+
+	users := make([]map[string]string, 10)
+	for i := range users {
+		users[i] = map[string]string{"userId": fmt.Sprintf("user%d", i), "name": fmt.Sprintf("User number %d", i), "color": "#0d47a1"}
+	}
+	_ = subscribe.Connection.SendNext("1", map[string]interface{}{"user": users})
+	receivedUsers := expectData(t, browser, "1")["user"]
+	usersJson, _ := json.Marshal(users)
+	assertJsonEqual(t, string(usersJson), receivedUsers)
+
+	users[3]["name"] = "Renamed user"
+	_ = subscribe.Connection.SendNext("1", map[string]interface{}{"user": users})
+	data := expectData(t, browser, "1")
+
+	patchJson, isPatch := data["patch"]
+	if !isPatch {
+		t.Fatalf("expected a patch, received %s", data)
+	}
+	patch, err := evanphxjsonpatch.DecodePatch(patchJson)
+	if err != nil {
+		t.Fatalf("invalid patch %s: %v", patchJson, err)
+	}
+	patchedUsers, err := patch.Apply(receivedUsers)
+	if err != nil {
+		t.Fatalf("failed to apply patch %s: %v", patchJson, err)
+	}
+	usersJson, _ = json.Marshal(users)
+	assertJsonEqual(t, string(usersJson), patchedUsers)
+}
+
+func TestCompressedFramesAreShared(t *testing.T) {
+	users := make([]map[string]string, 50)
+	for i := range users {
+		users[i] = map[string]string{"userId": fmt.Sprintf("user%d", i), "name": fmt.Sprintf("User number %d", i), "color": "#0d47a1"}
+	}
+	usersJson, _ := json.Marshal(users)
+
+	var received [][]byte
+	for _, sessionToken := range []string{"compressedMeeting-user1", "compressedMeeting-user2"} {
+		browser, err := testsupport.ConnectBrowserWithOptions(middlewareUrl, sessionToken, testsupport.BrowserOptions{
+			CompressionMode: websocket.CompressionNoContextTakeover,
+		})
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		t.Cleanup(browser.Close)
+		if _, err := browser.Expect("connection_ack", timeout); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := browser.Subscribe("1", "getCompressedUsers", "subscription getCompressedUsers { user { userId name color } }", nil); err != nil {
+			t.Fatal(err)
+		}
+		subscribe := expectHasuraSubscribe(t, "getCompressedUsers")
+		_ = subscribe.Connection.SendNext("1", map[string]interface{}{"user": users})
+
+		message, err := browser.Expect("next", timeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var payload struct {
+			Data map[string]json.RawMessage `json:"data"`
+		}
+		_ = json.Unmarshal(message.Payload, &payload)
+		assertJsonEqual(t, string(usersJson), payload.Data["user"])
+		received = append(received, message.Raw)
+	}
+
+	if !bytes.Equal(received[0], received[1]) {
+		t.Fatalf("the browsers received different messages: %s and %s", received[0], received[1])
+	}
+	cached, exists := common.PreCompressedFrameCache.Get(crc32.ChecksumIEEE(received[0]))
+	if !exists || !bytes.Equal(cached.Message, received[0]) {
+		t.Fatalf("the compressed frame of the message was not cached")
+	}
+}
+
+// expectUpstreamStatus waits for the ping informing the status of Hasura (other pings are ignored)
+func expectUpstreamStatus(t *testing.T, browser *testsupport.Browser, expectedStatus string) {
+	t.Helper()
+
+	for {
+		ping, err := browser.Expect("ping", timeout)
+		if err != nil {
+			t.Fatalf("waiting for status %s: %v", expectedStatus, err)
+		}
+		var payload struct {
+			UpstreamStatus struct {
+				Upstream string `json:"upstream"`
+				Status   string `json:"status"`
+			} `json:"upstreamStatus"`
+		}
+		_ = json.Unmarshal(ping.Payload, &payload)
+		if payload.UpstreamStatus.Status == expectedStatus && payload.UpstreamStatus.Upstream == "hasura" {
+			return
+		}
+	}
+}
+
+func TestUpstreamStatusWhenHasuraIsDown(t *testing.T) {
+	browser := connectBrowser(t, "upstreamStatusMeeting-user1")
+
+	if err := browser.Subscribe("1", "getUpstreamStatusUsers", "subscription getUpstreamStatusUsers { user { userId } }", nil); err != nil {
+		t.Fatal(err)
+	}
+	expectHasuraSubscribe(t, "getUpstreamStatusUsers")
+
+	upstreams.Hasura.Stop()
+	restarted := false
+	t.Cleanup(func() {
+		if !restarted {
+			_ = upstreams.Hasura.Restart()
+		}
+	})
+
+	expectUpstreamStatus(t, browser, "reconnecting")
+
+	if err := upstreams.Hasura.Restart(); err != nil {
+		t.Fatalf("failed to restart the fake Hasura: %v", err)
+	}
+	restarted = true
+
+	expectUpstreamStatus(t, browser, "restored")
+	expectHasuraSubscribe(t, "getUpstreamStatusUsers")
+}
+
+func TestStreamCursorResume(t *testing.T) {
+	sessionToken := "streamMeeting-user1"
+	browser := connectBrowser(t, sessionToken)
+
+	query := `subscription chatMessages($createdAt: timestamptz) {
+		chat_message_stream(batch_size: 10, cursor: {initial_value: {createdAt: $createdAt}, ordering: ASC}) { messageId message createdAt }
+	}`
+	if err := browser.Subscribe("1", "chatMessages", query, map[string]interface{}{"createdAt": "2020-01-01T00:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	subscribe := expectHasuraSubscribe(t, "chatMessages")
+
+	_ = subscribe.Connection.SendNext("1", map[string]interface{}{"chat_message_stream": []map[string]string{
+		{"messageId": "m1", "message": "hello", "createdAt": "2024-05-01T10:00:00Z"},
+		{"messageId": "m2", "message": "hi", "createdAt": "2024-05-01T10:00:05Z"},
+	}})
+	expectData(t, browser, "1")
+
+	forceReconnection(t, sessionToken)
+
+	// the stream resumes after the last message received
+	subscribe = expectHasuraSubscribe(t, "chatMessages")
+	if createdAt := subscribe.Payload.Variables["createdAt"]; createdAt != "2024-05-01T10:00:05Z" {
+		t.Fatalf("stream resumed from %v", createdAt)
+	}
+}
+
+// expectHasuraSubscribes waits for the subscribes of the operations, in any order (as retransmitted)
+func expectHasuraSubscribes(t *testing.T, operationNames ...string) map[string]testsupport.HasuraMessage {
+	t.Helper()
+
+	subscribes := make(map[string]testsupport.HasuraMessage, len(operationNames))
+	for len(subscribes) < len(operationNames) {
+		message, err := upstreams.Hasura.Expect("subscribe", timeout)
+		if err != nil {
+			t.Fatalf("waiting for %v: %v", operationNames, err)
+		}
+		for _, operationName := range operationNames {
+			if message.Payload.OperationName == operationName {
+				subscribes[operationName] = message
+			}
+		}
+	}
+	return subscribes
+}
+
+// applyData returns the data of the field after the message received by the browser (that may be a patch of it)
+func applyData(t *testing.T, field string, previousData []byte, receivedData map[string]json.RawMessage) []byte {
+	t.Helper()
+
+	patchJson, isPatch := receivedData["patch"]
+	if !isPatch {
+		return receivedData[field]
+	}
+	patch, err := evanphxjsonpatch.DecodePatch(patchJson)
+	if err != nil {
+		t.Fatalf("invalid patch %s: %v", patchJson, err)
+	}
+	patchedData, err := patch.Apply(previousData)
+	if err != nil {
+		t.Fatalf("failed to apply patch %s: %v", patchJson, err)
+	}
+	return patchedData
+}
+
+func TestSessionResumeWithQueuedData(t *testing.T) {
+	if !testsupport.RunTestWithConfig(t, map[string]interface{}{"server.session_resume_grace_seconds": 30}) {
+		return
+	}
+
+	sessionToken := "resumeMeeting-user1"
+	options := testsupport.BrowserOptions{
+		ClientSessionUUID: "resume-client-session",
+		Capabilities:      map[string]interface{}{"resume": true},
+	}
+	browser, ack := connectWithOptions(t, sessionToken, options)
+	if ack.ResumeToken == "" {
+		t.Fatalf("no resume token in the connection_ack")
+	}
+
+	usersQuery := "subscription Patched_getResumedUsers { user { userId name color } }"
+	streamQuery := `subscription resumedChatMessages($createdAt: timestamptz) {
+		chat_message_stream(batch_size: 10, cursor: {initial_value: {createdAt: $createdAt}, ordering: ASC}) { messageId message createdAt }
+	}`
+	_ = browser.Subscribe("1", "Patched_getResumedUsers", usersQuery, nil)
+	_ = browser.Subscribe("2", "resumedChatMessages", streamQuery, map[string]interface{}{"createdAt": "2020-01-01T00:00:00Z"})
+	_ = browser.Subscribe("3", "getResumedPresentation", "subscription getResumedPresentation { pres_page { svg } }", nil)
+	hasuraConnection := expectHasuraSubscribes(t, "Patched_getResumedUsers", "resumedChatMessages", "getResumedPresentation")["getResumedPresentation"].Connection
+
+	users := make([]map[string]string, 10)
+	for i := range users {
+		users[i] = map[string]string{"userId": fmt.Sprintf("user%d", i), "name": fmt.Sprintf("User number %d", i), "color": "#0d47a1"}
+	}
+	usersJson := func() string {
+		data, _ := json.Marshal(users)
+		return string(data)
+	}
+
+	_ = hasuraConnection.SendNext("2", map[string]interface{}{"chat_message_stream": []map[string]string{
+		{"messageId": "m1", "message": "hello", "createdAt": "2024-05-01T10:00:00Z"},
+	}})
+	expectData(t, browser, "2")
+
+	// the browser stops reading after the users, so the next messages stay queued in the middleware
+	browser.PauseReading()
+	_ = hasuraConnection.SendNext("1", map[string]interface{}{"user": users})
+	deliveredUsers := expectData(t, browser, "1")["user"]
+	assertJsonEqual(t, usersJson(), deliveredUsers)
+
+	// larger than the socket buffers, the writer is blocked on it
+	_ = hasuraConnection.SendNext("3", map[string]interface{}{"pres_page": []map[string]string{{"svg": strings.Repeat("x", 8<<20)}}})
+	users[3]["name"] = "Renamed user"
+	_ = hasuraConnection.SendNext("1", map[string]interface{}{"user": users})
+	_ = hasuraConnection.SendNext("2", map[string]interface{}{"chat_message_stream": []map[string]string{
+		{"messageId": "m2", "message": "hi", "createdAt": "2024-05-01T10:00:05Z"},
+	}})
+
+	// the ping is answered once the messages sent before it were read by the middleware
+	_ = hasuraConnection.Send([]byte(`{"type":"ping"}`))
+	if _, err := upstreams.Hasura.Expect("pong", timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	browser.Drop()
+	deadline := time.Now().Add(timeout)
+	for {
+		websrv.ParkedBrowserSessionsMutex.Lock()
+		_, parked := websrv.ParkedBrowserSessions[ack.ResumeToken]
+		websrv.ParkedBrowserSessionsMutex.Unlock()
+		if parked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the session was not parked after %v", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	options.ResumeToken = ack.ResumeToken
+	resumedBrowser, resumedAck := connectWithOptions(t, sessionToken, options)
+	if !resumedAck.Resumed {
+		t.Fatalf("the session was not resumed")
+	}
+
+	// the stream resumes after the last message delivered, not the one queued
+	subscribes := expectHasuraSubscribes(t, "Patched_getResumedUsers", "resumedChatMessages")
+	if createdAt := subscribes["resumedChatMessages"].Payload.Variables["createdAt"]; createdAt != "2024-05-01T10:00:00Z" {
+		t.Fatalf("stream resumed from %v", createdAt)
+	}
+	hasuraConnection = subscribes["Patched_getResumedUsers"].Connection
+
+	// the queued users were not delivered, so they are sent once Hasura sends them again
+	_ = hasuraConnection.SendNext("1", map[string]interface{}{"user": users})
+	resumedUsers := applyData(t, "user", deliveredUsers, expectData(t, resumedBrowser, "1"))
+	assertJsonEqual(t, usersJson(), resumedUsers)
+
+	users[5]["name"] = "Another renamed user"
+	_ = hasuraConnection.SendNext("1", map[string]interface{}{"user": users})
+	data := expectData(t, resumedBrowser, "1")
+	if data["patch"] == nil {
+		t.Fatalf("expected a patch, received %s", data)
+	}
+	assertJsonEqual(t, usersJson(), applyData(t, "user", resumedUsers, data))
+
+	_ = hasuraConnection.SendNext("2", map[string]interface{}{"chat_message_stream": []map[string]string{
+		{"messageId": "m2", "message": "hi", "createdAt": "2024-05-01T10:00:05Z"},
+	}})
+	assertJsonEqual(t, `[{"messageId":"m2","message":"hi","createdAt":"2024-05-01T10:00:05Z"}]`, expectData(t, resumedBrowser, "2")["chat_message_stream"])
+}
+
+func TestForcedDisconnection(t *testing.T) {
+	sessionToken := "disconnectionMeeting-user1"
+	browser := connectBrowser(t, sessionToken)
+
+	publishToMiddleware(t, "ForceUserGraphqlDisconnectionSysMsg", map[string]interface{}{
+		"sessionToken":    sessionToken,
+		"reason":          "user ejected",
+		"reasonMessageId": "user_ejected",
+	})
+
+	message, err := browser.Expect("error", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Id != "-1" || !strings.Contains(string(message.Payload), `"messageId":"user_ejected"`) {
+		t.Fatalf("expected the disconnection error, received %s", message.Raw)
+	}
+
+	status, err := browser.ExpectClose(timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != websocket.StatusCode(4403) {
+		t.Fatalf("expected close status 4403, received %d", status)
+	}
+}
+
+func TestCaptureRedactsTheSessionToken(t *testing.T) {
+	captureDirectory := t.TempDir()
+	sessionToken := "captureMeeting-user1"
+	if !testsupport.RunTestWithConfig(t, map[string]interface{}{
+		"capture.directory":      captureDirectory,
+		"capture.session_tokens": []string{sessionToken},
+	}) {
+		return
+	}
+
+	browser := connectBrowser(t, sessionToken)
+	if err := browser.Subscribe("1", "getCapturedUser", "subscription getCapturedUser { user { userId } }", nil); err != nil {
+		t.Fatal(err)
+	}
+	subscribe := expectHasuraSubscribe(t, "getCapturedUser")
+	_ = subscribe.Connection.SendNext("1", map[string]interface{}{"user": []interface{}{}})
+	expectData(t, browser, "1")
+
+	// the temporary directory of this process is not the one of the config
+	captureFiles, _ := filepath.Glob(filepath.Join(config.GetConfig().Capture.Directory, "captureMeeting", "*.jsonl"))
+	if len(captureFiles) != 1 {
+		t.Fatalf("expected one capture file, found %v", captureFiles)
+	}
+	frames, err := capture.ReadFrames(captureFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connectionInits := 0
+	for _, frame := range frames {
+		if strings.Contains(string(frame.Message), sessionToken) {
+			t.Errorf("session token captured in %s", frame.Message)
+		}
+		if strings.Contains(string(frame.Message), `"connection_init"`) {
+			connectionInits++
+		}
+	}
+	if connectionInits != 2 {
+		t.Errorf("expected the connection_init of the browser and of Hasura captured, found %d", connectionInits)
+	}
+}
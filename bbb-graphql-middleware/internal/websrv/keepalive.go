@@ -0,0 +1,42 @@
+package websrv
+
+import (
+	"bbb-graphql-middleware/internal/common"
+	"fmt"
+	"time"
+)
+
+// browserKeepaliveRoutine sends graphql-transport-ws pings to the browser every websocket_ping_interval_seconds,
+// the pongs (handled by the browser reader) keep the connection alive and measure the round trip time
+func browserKeepaliveRoutine(bc *common.BrowserConnection) {
+	if websocketPingIntervalSeconds <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(websocketPingIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bc.Context.Done():
+			return
+		case <-ticker.C:
+			//The browser only expects pings after the connection_ack
+			if !bc.ConnAckSentToBrowser.Load() {
+				continue
+			}
+
+			//A ping not answered yet keeps its time and id, so the pong timeout counts from it
+			bc.Lock()
+			if bc.PingSentAt.IsZero() {
+				bc.PingSentAt = time.Now()
+				bc.PingId++
+			}
+			pingId := bc.PingId
+			bc.Unlock()
+
+			//The id in the payload (echoed in the pong) tells the keepalive pongs from the ones answering other pings
+			bc.FromHasuraToBrowserChannel.Send([]byte(fmt.Sprintf(`{"type":"ping","payload":{"keepalive":%d}}`, pingId)))
+		}
+	}
+}
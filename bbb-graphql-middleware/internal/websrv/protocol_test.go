@@ -0,0 +1,130 @@
+package websrv_test
+
+import (
+	"bbb-graphql-middleware/internal/testsupport"
+	"testing"
+
+	"nhooyr.io/websocket"
+)
+
+const usersQuery = "subscription getUsers { user { userId name } }"
+
+func dialBrowser(t *testing.T) *testsupport.Browser {
+	t.Helper()
+
+	browser, err := testsupport.DialBrowser(middlewareUrl)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(browser.Close)
+	return browser
+}
+
+func expectCloseStatus(t *testing.T, browser *testsupport.Browser, expectedStatus websocket.StatusCode) {
+	t.Helper()
+
+	status, err := browser.ExpectClose(timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != expectedStatus {
+		t.Fatalf("expected close status %d, received %d", expectedStatus, status)
+	}
+}
+
+func TestProtocolViolations(t *testing.T) {
+	tests := []struct {
+		name           string
+		violate        func(t *testing.T) *testsupport.Browser
+		expectedStatus websocket.StatusCode
+	}{
+		{
+			name: "connection_init not sent",
+			violate: func(t *testing.T) *testsupport.Browser {
+				return dialBrowser(t)
+			},
+			expectedStatus: 4408,
+		},
+		{
+			name: "second connection_init",
+			violate: func(t *testing.T) *testsupport.Browser {
+				browser := connectBrowser(t, "protocolMeeting-user1")
+				_ = browser.SendConnectionInit("protocolMeeting-user1")
+				return browser
+			},
+			expectedStatus: 4429,
+		},
+		{
+			name: "subscribe with the id of an active subscription",
+			violate: func(t *testing.T) *testsupport.Browser {
+				browser := connectBrowser(t, "protocolMeeting-user2")
+				_ = browser.Subscribe("1", "getUsers", usersQuery, nil)
+				_ = browser.Subscribe("1", "getUsers", usersQuery, nil)
+				return browser
+			},
+			expectedStatus: 4409,
+		},
+		{
+			name: "subscribe before connection_init",
+			violate: func(t *testing.T) *testsupport.Browser {
+				browser := dialBrowser(t)
+				_ = browser.Subscribe("1", "getUsers", usersQuery, nil)
+				return browser
+			},
+			expectedStatus: 4401,
+		},
+		{
+			name: "connection_init payload is not an object",
+			violate: func(t *testing.T) *testsupport.Browser {
+				browser := dialBrowser(t)
+				_ = browser.Send(map[string]interface{}{"type": "connection_init", "payload": "protocolMeeting-user3"})
+				return browser
+			},
+			expectedStatus: 4400,
+		},
+		{
+			name: "connection_init without headers",
+			violate: func(t *testing.T) *testsupport.Browser {
+				browser := dialBrowser(t)
+				_ = browser.Send(map[string]interface{}{"type": "connection_init", "payload": map[string]interface{}{}})
+				return browser
+			},
+			expectedStatus: 4400,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			browser := test.violate(t)
+			expectCloseStatus(t, browser, test.expectedStatus)
+		})
+	}
+}
+
+func TestSubscribeIdReusedAfterComplete(t *testing.T) {
+	browser := connectBrowser(t, "protocolMeeting-user4")
+
+	if err := browser.Subscribe("1", "getUsers", usersQuery, nil); err != nil {
+		t.Fatal(err)
+	}
+	subscribe := expectHasuraSubscribe(t, "getUsers")
+
+	// completed by the browser
+	if err := browser.Send(map[string]interface{}{"id": "1", "type": "complete"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := browser.Subscribe("1", "getUsers", usersQuery, nil); err != nil {
+		t.Fatal(err)
+	}
+	subscribe = expectHasuraSubscribe(t, "getUsers")
+
+	// completed by the server
+	_ = subscribe.Connection.SendComplete("1")
+	if _, err := browser.Expect("complete", timeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := browser.Subscribe("1", "getUsers", usersQuery, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectHasuraSubscribe(t, "getUsers")
+}
@@ -0,0 +1,68 @@
+package reader
+
+import (
+	"bbb-graphql-middleware/internal/common"
+	"encoding/json"
+	"time"
+)
+
+// handleKeepaliveMessage answers the pings of the browser and records its pongs (they are not sent to Hasura)
+func handleKeepaliveMessage(browserConnection *common.BrowserConnection, messageType string, message []byte) {
+	switch messageType {
+	case "ping":
+		var ping struct {
+			Payload json.RawMessage `json:"payload,omitempty"`
+		}
+		_ = json.Unmarshal(message, &ping)
+
+		pong, _ := json.Marshal(map[string]interface{}{
+			"type":    "pong",
+			"payload": ping.Payload,
+		})
+		if len(ping.Payload) == 0 {
+			pong = []byte(`{"type":"pong"}`)
+		}
+		browserConnection.FromHasuraToBrowserChannel.Send(pong)
+	case "pong":
+		var pong struct {
+			Payload struct {
+				Keepalive      *int64          `json:"keepalive"`
+				UpstreamStatus json.RawMessage `json:"upstreamStatus"`
+			} `json:"payload"`
+		}
+		_ = json.Unmarshal(message, &pong)
+
+		browserConnection.Lock()
+		defer browserConnection.Unlock()
+
+		browserConnection.LastPongTime = time.Now()
+
+		// Clients echo the payload of the ping, the ones that don't are expected to answer in order
+		var answersKeepalive bool
+		switch {
+		case pong.Payload.Keepalive != nil:
+			answersKeepalive = *pong.Payload.Keepalive == browserConnection.PingId
+		case len(pong.Payload.UpstreamStatus) > 0 || browserConnection.StatusPingsWaitingPong > 0:
+			if browserConnection.StatusPingsWaitingPong > 0 {
+				browserConnection.StatusPingsWaitingPong--
+			}
+		default:
+			answersKeepalive = true
+		}
+
+		if answersKeepalive && !browserConnection.PingSentAt.IsZero() {
+			rtt := time.Since(browserConnection.PingSentAt)
+			browserConnection.PingRtt = smoothedRtt(browserConnection.PingRtt, rtt)
+			browserConnection.PingSentAt = time.Time{}
+			common.WsPingRtt.Observe(rtt.Seconds())
+		}
+	}
+}
+
+// smoothedRtt averages the round trip times as TCP does (RFC 6298), so a single slow pong doesn't count as a slow connection
+func smoothedRtt(previous time.Duration, rtt time.Duration) time.Duration {
+	if previous == 0 {
+		return rtt
+	}
+	return previous - previous/8 + rtt/8
+}
@@ -0,0 +1,78 @@
+package reader
+
+import (
+	"bbb-graphql-middleware/internal/common"
+	"bbb-graphql-middleware/internal/testsupport"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if reexecuted, exitCode := testsupport.RunWithTestConfig("../../../config/config.yml", map[string]interface{}{
+		"log_level": "warn",
+	}); reexecuted {
+		os.Exit(exitCode)
+	}
+
+	os.Exit(m.Run())
+}
+
+func waitingKeepalivePong(pingId int64, statusPings int) *common.BrowserConnection {
+	return &common.BrowserConnection{
+		FromHasuraToBrowserChannel: common.NewSafeChannelByte(10),
+		PingId:                     pingId,
+		PingSentAt:                 time.Now().Add(-50 * time.Millisecond),
+		StatusPingsWaitingPong:     statusPings,
+	}
+}
+
+func TestPongEchoingKeepaliveIdIsMatched(t *testing.T) {
+	bc := waitingKeepalivePong(3, 0)
+
+	handleKeepaliveMessage(bc, "pong", []byte(`{"type":"pong","payload":{"keepalive":2}}`))
+	if bc.PingSentAt.IsZero() || bc.PingRtt != 0 {
+		t.Fatalf("pong of a previous ping answered the current one")
+	}
+
+	handleKeepaliveMessage(bc, "pong", []byte(`{"type":"pong","payload":{"keepalive":3}}`))
+	if !bc.PingSentAt.IsZero() {
+		t.Fatalf("pong echoing the keepalive id didn't answer the ping")
+	}
+	if bc.PingRtt < 50*time.Millisecond {
+		t.Errorf("expected rtt of at least 50ms, got %v", bc.PingRtt)
+	}
+}
+
+func TestPongAnsweringStatusPingIsNotKeepalive(t *testing.T) {
+	bc := waitingKeepalivePong(1, 2)
+
+	// echoing the status payload
+	handleKeepaliveMessage(bc, "pong", []byte(`{"type":"pong","payload":{"upstreamStatus":{"upstream":"hasura","status":"reconnecting"}}}`))
+	// not echoing the payload, answered in order
+	handleKeepaliveMessage(bc, "pong", []byte(`{"type":"pong"}`))
+
+	if bc.PingSentAt.IsZero() || bc.PingRtt != 0 {
+		t.Fatalf("pong of a status ping answered the keepalive ping")
+	}
+	if bc.StatusPingsWaitingPong != 0 {
+		t.Fatalf("expected the status pings answered, %d waiting", bc.StatusPingsWaitingPong)
+	}
+	if bc.LastPongTime.IsZero() {
+		t.Errorf("pong time not recorded")
+	}
+
+	handleKeepaliveMessage(bc, "pong", []byte(`{"type":"pong"}`))
+	if !bc.PingSentAt.IsZero() {
+		t.Fatalf("pong without payload didn't answer the keepalive ping once the status pings were answered")
+	}
+}
+
+func TestSmoothedRtt(t *testing.T) {
+	if rtt := smoothedRtt(0, 80*time.Millisecond); rtt != 80*time.Millisecond {
+		t.Errorf("first rtt should be taken as is, got %v", rtt)
+	}
+	if rtt := smoothedRtt(80*time.Millisecond, 880*time.Millisecond); rtt != 180*time.Millisecond {
+		t.Errorf("a slow pong should move the rtt by 1/8 of the difference, got %v", rtt)
+	}
+}
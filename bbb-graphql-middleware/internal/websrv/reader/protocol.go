@@ -0,0 +1,50 @@
+package reader
+
+import (
+	"bbb-graphql-middleware/internal/common"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"nhooyr.io/websocket"
+)
+
+// checkProtocol enforces the graphql-transport-ws rules, it closes the connection (and returns false)
+// when the browser doesn't follow them
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+func checkProtocol(browserConnection *common.BrowserConnection, browserMessage common.BrowserSubscribeMessage, connectionInitReceived *bool) bool {
+	switch browserMessage.Type {
+	case "connection_init":
+		if *connectionInitReceived {
+			closeWithProtocolError(browserConnection, 4429, "too_many_initialisation_requests", "Too many initialisation requests")
+			return false
+		}
+		*connectionInitReceived = true
+	case "subscribe":
+		if !browserConnection.ConnAckSentToBrowser.Load() {
+			closeWithProtocolError(browserConnection, 4401, "unauthorized", "Unauthorized")
+			return false
+		}
+		if browserMessage.ID == "" {
+			closeWithProtocolError(browserConnection, 4400, "invalid_message", "Invalid message received: subscribe without id")
+			return false
+		}
+		if !common.AddSubscriberId(browserConnection, browserMessage.ID) {
+			closeWithProtocolError(browserConnection, 4409, "subscriber_already_exists", fmt.Sprintf("Subscriber for %s already exists", browserMessage.ID))
+			return false
+		}
+	case "complete":
+		common.RemoveSubscriberId(browserConnection, browserMessage.ID)
+	}
+	return true
+}
+
+func closeWithProtocolError(browserConnection *common.BrowserConnection, code websocket.StatusCode, reasonMessageId string, reasonMessage string) {
+	common.WsProtocolViolationCounter.With(prometheus.Labels{"code": fmt.Sprintf("%d", code)}).Inc()
+	common.DisconnectWithError(
+		browserConnection.Websocket,
+		browserConnection.Context,
+		browserConnection.ContextCancelFunc,
+		code,
+		reasonMessageId,
+		reasonMessage,
+		browserConnection.Logger)
+}
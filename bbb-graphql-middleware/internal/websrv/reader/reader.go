@@ -1,8 +1,11 @@
 package reader
 
 import (
+	"bbb-graphql-middleware/internal/capture"
 	"bbb-graphql-middleware/internal/common"
-	"bytes"
+	"bbb-graphql-middleware/internal/msgencoding"
+	"bbb-graphql-middleware/internal/tap"
+	"bbb-graphql-middleware/internal/tracing"
 	"context"
 	"encoding/json"
 	"errors"
@@ -33,6 +36,7 @@ func BrowserConnectionReader(
 
 	defer browserConnection.ContextCancelFunc()
 
+	connectionInitReceived := false
 	for {
 		messageType, message, err := browserConnection.Websocket.Read(browserConnection.Context)
 		if err != nil {
@@ -56,21 +60,50 @@ func BrowserConnectionReader(
 		browserConnection.Unlock()
 
 		if messageType != websocket.MessageText {
-			browserConnection.Logger.Warnf("received non-text message: %v", messageType)
-			continue
-		}
+			if browserConnection.WireEncoding == msgencoding.JSON {
+				browserConnection.Logger.Warnf("received non-text message: %v", messageType)
+				continue
+			}
 
-		var browserMessageType struct {
-			Type string `json:"type"`
+			//Binary encoding negotiated, the rest of the pipeline works with JSON
+			message, err = msgencoding.ToJSON(message, browserConnection.WireEncoding)
+			if err != nil {
+				browserConnection.Logger.Errorf("failed to decode %s message: %v", browserConnection.WireEncoding, err)
+				continue
+			}
 		}
-		err = json.Unmarshal(message, &browserMessageType)
+
+		browserConnection.Capture.Load().Record(capture.BrowserToMiddleware, "", message)
+		tap.Mirror(browserConnection, capture.BrowserToMiddleware, "", message)
+
+		var browserMessage common.BrowserSubscribeMessage
+		err = json.Unmarshal(message, &browserMessage)
 		if err != nil {
 			browserConnection.Logger.Errorf("failed to unmarshal message: %v", err)
+			closeWithProtocolError(browserConnection, 4400, "invalid_message", "Invalid message received")
+			return
+		}
+
+		//Keepalive is handled by the middleware, it doesn't depend on Hasura
+		if browserMessage.Type == "ping" || browserMessage.Type == "pong" {
+			handleKeepaliveMessage(browserConnection, browserMessage.Type, message)
 			continue
 		}
 
-		if browserMessageType.Type == "subscribe" {
-			if bytes.Contains(message, []byte("\"query\":\"mutation")) {
+		if !checkProtocol(browserConnection, browserMessage, &connectionInitReceived) {
+			return
+		}
+
+		//Mutations are sent to graphql-actions, invalid documents are rejected by the Hasura writer
+		if browserMessage.Type == "subscribe" {
+			operation := common.ClassifyOperation(browserMessage.Payload.Query, browserMessage.Payload.OperationName)
+			if operation.Error == nil {
+				tracing.StartOperation(browserConnection.Id, browserMessage.ID, string(operation.Type), operation.OperationName)
+			} else {
+				tracing.StartOperation(browserConnection.Id, browserMessage.ID, "operation", browserMessage.Payload.OperationName)
+			}
+
+			if operation.Error == nil && operation.Type == common.Mutation {
 				browserConnection.FromBrowserToGqlActionsChannel.Send(message)
 				continue
 			}
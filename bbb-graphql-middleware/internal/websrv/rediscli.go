@@ -2,11 +2,13 @@ package websrv
 
 import (
 	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/audit"
 	"bbb-graphql-middleware/internal/common"
 	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -23,18 +25,32 @@ func GetRedisConn() *redis.Client {
 	return redisClient
 }
 
+// redisListenerConnected indicates the listener is subscribed to the akka-apps channel (reported by /readyz)
+var redisListenerConnected atomic.Bool
+
+func RedisListenerConnected() bool {
+	return redisListenerConnected.Load()
+}
+
 func StartRedisListener() {
 	log := log.WithField("_routine", "StartRedisListener")
 
 	var ctx = context.Background()
 
 	subscriber := GetRedisConn().Subscribe(ctx, "from-akka-apps-redis-channel")
+	if _, err := subscriber.Receive(ctx); err == nil {
+		redisListenerConnected.Store(true)
+	}
 
 	for {
 		msg, err := subscriber.ReceiveMessage(ctx)
 		if err != nil {
 			log.Errorf("error: %v", err)
+			redisListenerConnected.Store(false)
+			time.Sleep(time.Second)
+			continue
 		}
+		redisListenerConnected.Store(true)
 
 		// Skip parsing unnecessary messages
 		if !strings.Contains(msg.Payload, "ForceUserGraphqlReconnectionSysMsg") &&
@@ -61,6 +77,8 @@ func StartRedisListener() {
 			reason := messageBodyAsMap["reason"]
 			log.Infof("Received reconnection request for sessionToken %v (%v)", sessionTokenToInvalidate, reason)
 
+			reasonAsString, _ := reason.(string)
+			auditForcedSessionEvent(audit.ForcedReconnection, sessionTokenToInvalidate.(string), "", reasonAsString)
 			go InvalidateSessionTokenHasuraConnections(sessionTokenToInvalidate.(string))
 		}
 
@@ -72,6 +90,10 @@ func StartRedisListener() {
 			reasonMsgId := messageBodyAsMap["reasonMessageId"]
 			log.Infof("Received disconnection request for sessionToken %v (%s - %s)", sessionTokenToInvalidate, reasonMsgId, reason)
 
+			reasonAsString, _ := reason.(string)
+			reasonMsgIdAsString, _ := reasonMsgId.(string)
+			auditForcedSessionEvent(audit.ForcedDisconnection, sessionTokenToInvalidate.(string), reasonMsgIdAsString, reasonAsString)
+
 			//Not being used yet
 			go InvalidateSessionTokenBrowserConnections(sessionTokenToInvalidate.(string), reasonMsgId.(string), reason.(string))
 		}
@@ -90,6 +112,36 @@ func StartRedisListener() {
 	}
 }
 
+// auditForcedSessionEvent records the forced reconnection/disconnection for each connection of the session token
+func auditForcedSessionEvent(event audit.EventType, sessionToken string, reasonMessageId string, reason string) {
+	if !audit.Enabled() {
+		return
+	}
+
+	BrowserConnectionsMutex.RLock()
+	var records []audit.Record
+	for _, browserConnection := range BrowserConnections {
+		if browserConnection.SessionToken == sessionToken {
+			records = append(records, audit.NewRecord(event, browserConnection))
+		}
+	}
+	BrowserConnectionsMutex.RUnlock()
+
+	//Record the request even if there's no connection for this session token
+	if len(records) == 0 {
+		records = append(records, audit.Record{
+			Event:            event,
+			SessionTokenHash: audit.HashValue(sessionToken),
+		})
+	}
+
+	for _, record := range records {
+		record.Reason = reason
+		record.ReasonMessageId = reasonMessageId
+		audit.Log(record)
+	}
+}
+
 func getCurrTimeInMs() int64 {
 	currentTime := time.Now()
 	milliseconds := currentTime.UnixNano() / int64(time.Millisecond)
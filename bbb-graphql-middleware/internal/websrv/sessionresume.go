@@ -0,0 +1,132 @@
+package websrv
+
+import (
+	"bbb-graphql-middleware/config"
+	"bbb-graphql-middleware/internal/common"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+// ParkedBrowserSession keeps the subscriptions of a dropped browser connection
+// so the client can resume them (receiving only what changed) when it reconnects
+type ParkedBrowserSession struct {
+	SessionToken        string
+	ClientSessionUUID   string
+	BrowserConnectionId string
+	ActiveSubscriptions map[string]common.GraphQlSubscription
+	expirationTimer     *time.Timer
+}
+
+var sessionResumeGracePeriod = time.Duration(config.GetConfig().Server.SessionResumeGraceSeconds) * time.Second
+
+// parked sessions by resume token
+var ParkedBrowserSessions = make(map[string]*ParkedBrowserSession)
+var ParkedBrowserSessionsMutex = &sync.Mutex{}
+
+func SessionResumeEnabled() bool {
+	return sessionResumeGracePeriod > 0
+}
+
+func NewResumeToken() string {
+	return uuid.New().String()
+}
+
+// parkBrowserSession stores the state of a dropped connection during the grace period
+// The data and cursors parked are the ones delivered to the browser, not the ones still queued when it dropped
+func parkBrowserSession(bc *common.BrowserConnection) {
+	bc.RLock()
+	resumeToken := bc.ResumeToken
+	parkedSession := &ParkedBrowserSession{
+		SessionToken:        bc.SessionToken,
+		ClientSessionUUID:   bc.ClientSessionUUID,
+		BrowserConnectionId: bc.Id,
+	}
+	bc.RUnlock()
+
+	if !SessionResumeEnabled() || resumeToken == "" || parkedSession.SessionToken == "" {
+		return
+	}
+
+	bc.ActiveSubscriptionsMutex.RLock()
+	parkedSession.ActiveSubscriptions = make(map[string]common.GraphQlSubscription, len(bc.ActiveSubscriptions))
+	for queryId, subscription := range bc.ActiveSubscriptions {
+		subscription.LastReceivedData = subscription.DeliveredData
+		subscription.LastReceivedDataChecksum = subscription.DeliveredDataChecksum
+		if subscription.Type == common.Streaming {
+			subscription.StreamCursors = subscription.DeliveredStreamCursors
+		}
+		parkedSession.ActiveSubscriptions[queryId] = subscription
+	}
+	bc.ActiveSubscriptionsMutex.RUnlock()
+
+	ParkedBrowserSessionsMutex.Lock()
+	defer ParkedBrowserSessionsMutex.Unlock()
+
+	parkedSession.expirationTimer = time.AfterFunc(sessionResumeGracePeriod, func() {
+		ParkedBrowserSessionsMutex.Lock()
+		defer ParkedBrowserSessionsMutex.Unlock()
+
+		if ParkedBrowserSessions[resumeToken] == parkedSession {
+			delete(ParkedBrowserSessions, resumeToken)
+			common.ParkedBrowserSessionsGauge.Dec()
+			common.BrowserSessionResumeCounter.With(prometheus.Labels{"result": "expired"}).Inc()
+		}
+	})
+	ParkedBrowserSessions[resumeToken] = parkedSession
+	common.ParkedBrowserSessionsGauge.Inc()
+
+	bc.Logger.Debugf("browser session parked with %d subscriptions for %v", len(parkedSession.ActiveSubscriptions), sessionResumeGracePeriod)
+}
+
+// resumeBrowserSession moves the subscriptions of a parked session to the new connection
+// It's only allowed for the same session token and client session UUID that created the parked session
+func resumeBrowserSession(bc *common.BrowserConnection, resumeToken string) bool {
+	ParkedBrowserSessionsMutex.Lock()
+	parkedSession, exists := ParkedBrowserSessions[resumeToken]
+	if !exists ||
+		parkedSession.SessionToken != bc.SessionToken ||
+		parkedSession.ClientSessionUUID != bc.ClientSessionUUID {
+		ParkedBrowserSessionsMutex.Unlock()
+		bc.Logger.Debugf("no parked session found for the resume token")
+		common.BrowserSessionResumeCounter.With(prometheus.Labels{"result": "not_found"}).Inc()
+		return false
+	}
+	parkedSession.expirationTimer.Stop()
+	delete(ParkedBrowserSessions, resumeToken)
+	common.ParkedBrowserSessionsGauge.Dec()
+	ParkedBrowserSessionsMutex.Unlock()
+
+	//Subscriptions will be retransmitted to Hasura once it sends `connection_ack`
+	//keeping the data and cursors delivered, so the browser will receive only what changed since then
+	bc.ActiveSubscriptionsMutex.Lock()
+	bc.ActiveSubscriptions = parkedSession.ActiveSubscriptions
+	bc.ActiveSubscriptionsMutex.Unlock()
+
+	bc.Lock()
+	bc.Resumed = true
+	bc.SubscriberIds = make(map[string]bool, len(parkedSession.ActiveSubscriptions))
+	for queryId := range parkedSession.ActiveSubscriptions {
+		bc.SubscriberIds[queryId] = true
+	}
+	bc.Unlock()
+
+	bc.Logger.Infof("browser session resumed from %s with %d subscriptions", parkedSession.BrowserConnectionId, len(parkedSession.ActiveSubscriptions))
+	common.BrowserSessionResumeCounter.With(prometheus.Labels{"result": "resumed"}).Inc()
+	return true
+}
+
+// discardParkedSessions avoids resuming sessions that were deliberately disconnected
+func discardParkedSessions(sessionToken string) {
+	ParkedBrowserSessionsMutex.Lock()
+	defer ParkedBrowserSessionsMutex.Unlock()
+
+	for resumeToken, parkedSession := range ParkedBrowserSessions {
+		if parkedSession.SessionToken == sessionToken {
+			parkedSession.expirationTimer.Stop()
+			delete(ParkedBrowserSessions, resumeToken)
+			common.ParkedBrowserSessionsGauge.Dec()
+		}
+	}
+}
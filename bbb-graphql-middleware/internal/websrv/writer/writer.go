@@ -1,11 +1,16 @@
 package writer
 
 import (
+	"bbb-graphql-middleware/internal/capture"
 	"bbb-graphql-middleware/internal/common"
+	"bbb-graphql-middleware/internal/msgencoding"
+	"bbb-graphql-middleware/internal/tap"
 	"bytes"
 	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
 	"nhooyr.io/websocket"
 	"sync"
+	"time"
 )
 
 func BrowserConnectionWriter(
@@ -30,13 +35,59 @@ RangeLoop:
 					continue
 				}
 
+				sentInfo, timed := browserConnection.FromHasuraToBrowserChannel.SentInfo()
+
 				browserConnection.Logger.Tracef("sending to browser: %s", string(toBrowserMessage))
-				err := browserConnection.Websocket.Write(browserConnection.Context, websocket.MessageText, toBrowserMessage)
+
+				wsMessageType := websocket.MessageText
+				wsMessage := toBrowserMessage
+				if browserConnection.WireEncoding != msgencoding.JSON {
+					var errEncoding error
+					wsMessageType = websocket.MessageBinary
+					if wsMessage, errEncoding = msgencoding.FromJSON(toBrowserMessage, browserConnection.WireEncoding); errEncoding != nil {
+						browserConnection.Logger.Errorf("failed to encode message as %s: %v", browserConnection.WireEncoding, errEncoding)
+						continue
+					}
+				}
+
+				// The browser can use the id again once the operation is finished by the server (released before it
+				// receives the message, as it may subscribe again right away)
+				if bytes.Contains(toBrowserMessage, []byte(`"complete"`)) || bytes.Contains(toBrowserMessage, []byte(`"error"`)) {
+					var operationMessage struct {
+						Type string `json:"type"`
+						Id   string `json:"id"`
+					}
+					_ = json.Unmarshal(toBrowserMessage, &operationMessage)
+					if (operationMessage.Type == "complete" || operationMessage.Type == "error") && operationMessage.Id != "" {
+						common.RemoveSubscriberId(browserConnection, operationMessage.Id)
+					}
+				}
+
+				var err error
+				if browserConnection.FrameWriter != nil {
+					err = browserConnection.FrameWriter.WriteFrame(browserConnection.Context, common.WebsocketFrame(wsMessageType, wsMessage))
+				} else {
+					err = browserConnection.Websocket.Write(browserConnection.Context, wsMessageType, wsMessage)
+				}
 				if err != nil {
 					browserConnection.Logger.Debugf("Browser is disconnected, skipping writing of ws message: %v", err)
 					return
 				}
 
+				if timed {
+					common.GqlDeliveryDelay.Observe(time.Since(sentInfo.ReceivedAt).Seconds())
+					if sentInfo.OnDelivered != nil {
+						sentInfo.OnDelivered()
+					}
+				}
+
+				browserConnection.Capture.Load().Record(capture.MiddlewareToBrowser, "", toBrowserMessage)
+				tap.Mirror(browserConnection, capture.MiddlewareToBrowser, "", toBrowserMessage)
+
+				common.WsSentRawBytesCounter.
+					With(prometheus.Labels{"compression": browserConnection.WebsocketCompression}).
+					Add(float64(len(wsMessage)))
+
 				// After the error is sent to client, close its connection
 				// Authentication hook unauthorized this request
 				if bytes.Contains(toBrowserMessage, []byte("connection_error")) {